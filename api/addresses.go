@@ -0,0 +1,25 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+)
+
+func (s *server) handleGetAddressesAddrBalance(jc jape.Context) {
+	if s.index == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("the address index is not enabled"))
+		return
+	}
+	var addr types.Address
+	if decodeParam(jc, "addr", &addr) != nil {
+		return
+	}
+	b, err := s.index.Balance(addr)
+	if s.check(jc, "failed to get balance", err) != nil {
+		return
+	}
+	jc.Encode(b)
+}
@@ -0,0 +1,1002 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/node/internal/peers"
+	"go.sia.tech/node/internal/wallet"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// ErrBlockNotFound is returned when a requested block is not in the
+	// chain store.
+	ErrBlockNotFound = errors.New("block not found")
+	// ErrIndexNotFound is returned when there is no block at the requested
+	// height in the best chain.
+	ErrIndexNotFound = errors.New("index not found")
+	// ErrUnknownParent is returned when a submitted block does not build on
+	// a block known to the node.
+	ErrUnknownParent = errors.New("parent block not found")
+	// ErrTransactionNotFound is returned when a transaction is not in the
+	// requested block or the txpool.
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrPeerNotConnected is returned when a requested peer is not connected
+	// to any of the node's syncers.
+	ErrPeerNotConnected = errors.New("peer not connected")
+	// ErrNoSyncer is returned when the node has no syncer that can handle
+	// the request.
+	ErrNoSyncer = errors.New("no syncer available")
+	// ErrStaleBasis is returned when a v2 transaction set's basis is too far
+	// from the current tip for its proofs to be updated.
+	ErrStaleBasis = errors.New("transaction set basis is too old to update; rebuild the set against the current tip")
+)
+
+// An ErrorCode identifies the cause of an Error.
+type ErrorCode string
+
+// Error codes returned by the API.
+const (
+	// ErrorCodeInvalidParameter indicates that a path parameter or query
+	// value is malformed or out of range.
+	ErrorCodeInvalidParameter ErrorCode = "invalid_parameter"
+	// ErrorCodeInvalidRequest indicates that the request body is malformed
+	// or inconsistent.
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrorCodeRequestTooLarge indicates that the request body exceeds the
+	// server's limit.
+	ErrorCodeRequestTooLarge ErrorCode = "request_too_large"
+	// ErrorCodeNotFound indicates that no route matches the request path.
+	ErrorCodeNotFound ErrorCode = "not_found"
+	// ErrorCodeMethodNotAllowed indicates that the route does not support
+	// the request method.
+	ErrorCodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	// ErrorCodeBlockNotFound corresponds to ErrBlockNotFound.
+	ErrorCodeBlockNotFound ErrorCode = "block_not_found"
+	// ErrorCodeIndexNotFound corresponds to ErrIndexNotFound.
+	ErrorCodeIndexNotFound ErrorCode = "index_not_found"
+	// ErrorCodeTransactionNotFound corresponds to ErrTransactionNotFound.
+	ErrorCodeTransactionNotFound ErrorCode = "transaction_not_found"
+	// ErrorCodeUnknownParent corresponds to ErrUnknownParent.
+	ErrorCodeUnknownParent ErrorCode = "unknown_parent"
+	// ErrorCodeInvalidBlock indicates that a submitted block is invalid.
+	ErrorCodeInvalidBlock ErrorCode = "invalid_block"
+	// ErrorCodeInvalidTransaction indicates that a submitted transaction is
+	// invalid, or depends on transactions that are not in the txpool.
+	ErrorCodeInvalidTransaction ErrorCode = "invalid_transaction"
+	// ErrorCodeTxpoolRejected indicates that the txpool rejected a
+	// transaction set.
+	ErrorCodeTxpoolRejected ErrorCode = "txpool_rejected"
+	// ErrorCodeStaleBasis corresponds to ErrStaleBasis.
+	ErrorCodeStaleBasis ErrorCode = "stale_basis"
+	// ErrorCodePeerNotConnected corresponds to ErrPeerNotConnected.
+	ErrorCodePeerNotConnected ErrorCode = "peer_not_connected"
+	// ErrorCodePeerNotPinned indicates that an unpinned peer cannot be
+	// unpinned.
+	ErrorCodePeerNotPinned ErrorCode = "peer_not_pinned"
+	// ErrorCodePeerBanned indicates that a peer cannot be connected to
+	// because it is banned.
+	ErrorCodePeerBanned ErrorCode = "peer_banned"
+	// ErrorCodePeerUnreachable indicates that a peer could not be connected
+	// to or did not respond.
+	ErrorCodePeerUnreachable ErrorCode = "peer_unreachable"
+	// ErrorCodeBanNotFound indicates that an address or subnet is not
+	// banned.
+	ErrorCodeBanNotFound ErrorCode = "ban_not_found"
+	// ErrorCodeInvalidAddress indicates that a peer address or subnet is
+	// malformed.
+	ErrorCodeInvalidAddress ErrorCode = "invalid_address"
+	// ErrorCodeNoSyncer corresponds to ErrNoSyncer.
+	ErrorCodeNoSyncer ErrorCode = "no_syncer"
+	// ErrorCodeNotEnabled indicates that the route requires a feature that
+	// the node was not configured with, such as a peer store.
+	ErrorCodeNotEnabled ErrorCode = "not_enabled"
+	// ErrorCodeOriginNotAllowed indicates that a CORS preflight request came
+	// from an origin that is not allowed.
+	ErrorCodeOriginNotAllowed ErrorCode = "origin_not_allowed"
+	// ErrorCodeUnauthorized indicates that the request did not supply valid
+	// credentials.
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrorCodeForbidden indicates that the request's credentials do not
+	// grant the scope required by the route.
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// ErrorCodeRateLimited indicates that the client has exceeded its rate
+	// limit and should retry after the time given by the Retry-After header.
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+	// ErrorCodeWalletNotFound corresponds to wallet.ErrNotFound.
+	ErrorCodeWalletNotFound ErrorCode = "wallet_not_found"
+	// ErrorCodeWalletExists corresponds to wallet.ErrExists.
+	ErrorCodeWalletExists ErrorCode = "wallet_exists"
+	// ErrorCodeInvalidSeed indicates that a seed phrase is malformed.
+	ErrorCodeInvalidSeed ErrorCode = "invalid_seed"
+	// ErrorCodeReadOnly indicates that the route changes the node's state,
+	// and the API is read-only.
+	ErrorCodeReadOnly ErrorCode = "read_only"
+	// ErrorCodeTimeout indicates that the request took longer than its
+	// route allows.
+	ErrorCodeTimeout ErrorCode = "timeout"
+	// ErrorCodeNotEnoughFunds corresponds to wallet.ErrNotEnoughFunds.
+	ErrorCodeNotEnoughFunds ErrorCode = "not_enough_funds"
+	// ErrorCodeDustChange corresponds to wallet.ErrDustChange.
+	ErrorCodeDustChange ErrorCode = "dust_change"
+	// ErrorCodeInvalidVersion corresponds to wallet.ErrInvalidVersion.
+	ErrorCodeInvalidVersion ErrorCode = "invalid_version"
+	// ErrorCodeDataTooLarge corresponds to wallet.ErrDataTooLarge.
+	ErrorCodeDataTooLarge ErrorCode = "data_too_large"
+	// ErrorCodeNothingToConsolidate corresponds to
+	// wallet.ErrNothingToConsolidate.
+	ErrorCodeNothingToConsolidate ErrorCode = "nothing_to_consolidate"
+	// ErrorCodeUneconomical corresponds to wallet.ErrUneconomical.
+	ErrorCodeUneconomical ErrorCode = "uneconomical"
+	// ErrorCodeNotPending corresponds to wallet.ErrNotPending.
+	ErrorCodeNotPending ErrorCode = "not_pending"
+	// ErrorCodeNotWalletTransaction corresponds to
+	// wallet.ErrNotWalletTransaction.
+	ErrorCodeNotWalletTransaction ErrorCode = "not_wallet_transaction"
+	// ErrorCodeNoChangeOutput corresponds to wallet.ErrNoChangeOutput.
+	ErrorCodeNoChangeOutput ErrorCode = "no_change_output"
+	// ErrorCodeFeeSufficient corresponds to wallet.ErrFeeSufficient.
+	ErrorCodeFeeSufficient ErrorCode = "fee_sufficient"
+	// ErrorCodeOutputNotFound corresponds to wallet.ErrOutputNotFound.
+	ErrorCodeOutputNotFound ErrorCode = "output_not_found"
+	// ErrorCodeOutputReserved corresponds to wallet.ErrOutputReserved.
+	ErrorCodeOutputReserved ErrorCode = "output_reserved"
+	// ErrorCodeReservationLost corresponds to wallet.ErrReservationLost.
+	ErrorCodeReservationLost ErrorCode = "reservation_lost"
+	// ErrorCodeWatchOnly corresponds to wallet.ErrWatchOnly.
+	ErrorCodeWatchOnly ErrorCode = "watch_only"
+	// ErrorCodeNotWatchOnly corresponds to wallet.ErrNotWatchOnly.
+	ErrorCodeNotWatchOnly ErrorCode = "not_watch_only"
+	// ErrorCodeScanInProgress corresponds to wallet.ErrScanInProgress.
+	ErrorCodeScanInProgress ErrorCode = "scan_in_progress"
+	// ErrorCodeInvalidSignature corresponds to wallet.ErrInvalidSignature.
+	ErrorCodeInvalidSignature ErrorCode = "invalid_signature"
+	// ErrorCodeEventNotFound corresponds to wallet.ErrEventNotFound.
+	ErrorCodeEventNotFound ErrorCode = "event_not_found"
+	// ErrorCodeInvalidMultisig corresponds to wallet.ErrInvalidMultisig.
+	ErrorCodeInvalidMultisig ErrorCode = "invalid_multisig"
+	// ErrorCodeNoWalletKey corresponds to wallet.ErrNoWalletKey.
+	ErrorCodeNoWalletKey ErrorCode = "no_wallet_key"
+	// ErrorCodeInvalidGapLimit corresponds to wallet.ErrInvalidGapLimit.
+	ErrorCodeInvalidGapLimit ErrorCode = "invalid_gap_limit"
+	// ErrorCodeInvalidBackup corresponds to wallet.ErrInvalidBackup.
+	ErrorCodeInvalidBackup ErrorCode = "invalid_backup"
+	// ErrorCodeWrongPassphrase corresponds to wallet.ErrWrongPassphrase.
+	ErrorCodeWrongPassphrase ErrorCode = "wrong_passphrase"
+	// ErrorCodeAddressNotFound corresponds to wallet.ErrAddressNotFound.
+	ErrorCodeAddressNotFound ErrorCode = "address_not_found"
+	// ErrorCodeInvalidLabel corresponds to wallet.ErrInvalidLabel.
+	ErrorCodeInvalidLabel ErrorCode = "invalid_label"
+	// ErrorCodeInvalidStrategy corresponds to wallet.ErrInvalidStrategy.
+	ErrorCodeInvalidStrategy ErrorCode = "invalid_strategy"
+	// ErrorCodeWalletLocked corresponds to wallet.ErrLocked.
+	ErrorCodeWalletLocked ErrorCode = "wallet_locked"
+	// ErrorCodeNoPassphrase corresponds to wallet.ErrNoPassphrase.
+	ErrorCodeNoPassphrase ErrorCode = "no_passphrase"
+	// ErrorCodeTooManyAttempts corresponds to wallet.ErrTooManyAttempts.
+	ErrorCodeTooManyAttempts ErrorCode = "too_many_attempts"
+	// ErrorCodeSignerFailed corresponds to wallet.ErrSigner. The request
+	// was valid, but the wallet's external signer did not sign it.
+	ErrorCodeSignerFailed ErrorCode = "signer_failed"
+	// ErrorCodeMiningNotAllowed corresponds to mining.ErrMainnet.
+	ErrorCodeMiningNotAllowed ErrorCode = "mining_not_allowed"
+	// ErrorCodeNoPayoutAddress corresponds to mining.ErrNoPayoutAddress.
+	ErrorCodeNoPayoutAddress ErrorCode = "no_payout_address"
+	// ErrorCodeMining corresponds to mining.ErrMining.
+	ErrorCodeMining ErrorCode = "mining"
+	// ErrorCodeInternal indicates an internal error. Details are logged by
+	// the node rather than returned.
+	ErrorCodeInternal ErrorCode = "internal_error"
+)
+
+// An Error is the response body of every failed request.
+type Error struct {
+	Status  int       `json:"status"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an *Error with the same code, so that errors
+// can be matched with errors.Is regardless of their message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// An UpdateSummary summarizes a block that was applied to or reverted from
+// the best chain, listing the IDs of the elements it created and spent.
+type UpdateSummary struct {
+	BlockID types.BlockID `json:"blockID"`
+	Height  uint64        `json:"height"`
+
+	CreatedSiacoinElements []types.SiacoinOutputID `json:"createdSiacoinElements"`
+	SpentSiacoinElements   []types.SiacoinOutputID `json:"spentSiacoinElements"`
+	CreatedSiafundElements []types.SiafundOutputID `json:"createdSiafundElements"`
+	SpentSiafundElements   []types.SiafundOutputID `json:"spentSiafundElements"`
+}
+
+// ConsensusUpdatesResponse is the response type for [GET]
+// /consensus/updates/:index.
+type ConsensusUpdatesResponse struct {
+	// Reorg is true if the requested index is no longer on the best chain and
+	// Reverted is therefore non-empty.
+	Reorg    bool            `json:"reorg"`
+	Reverted []UpdateSummary `json:"reverted"`
+	Applied  []UpdateSummary `json:"applied"`
+	// Index is the chain index reached after applying every update in the
+	// response. It should be passed as the index of the next request.
+	Index types.ChainIndex `json:"index"`
+}
+
+// ConsensusCheckpointResponse is the response type for [GET]
+// /consensus/checkpoint/:id.
+type ConsensusCheckpointResponse struct {
+	State consensus.State `json:"state"`
+	Block types.Block     `json:"block"`
+}
+
+// ConsensusBlocksBatchRequest is the request type for [POST]
+// /consensus/blocks/batch. Either IDs or a height range beginning at Start
+// must be specified.
+type ConsensusBlocksBatchRequest struct {
+	IDs   []types.BlockID `json:"ids,omitempty"`
+	Start uint64          `json:"start,omitempty"`
+	Limit uint64          `json:"limit,omitempty"`
+}
+
+// A BlockTransaction is a transaction within a block, along with its ID.
+// Exactly one of Transaction and V2Transaction is set.
+type BlockTransaction struct {
+	ID            types.TransactionID  `json:"id"`
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+}
+
+// A TransactionProof is the response type for [GET]
+// /consensus/blocks/:id/proof/:txid. It proves that a transaction is included
+// in the block's header commitment. Siblings are ordered from the leaf to the
+// root. Use VerifyTransactionProof to check it.
+type TransactionProof struct {
+	Header    types.BlockHeader `json:"header"`
+	LeafIndex uint64            `json:"leafIndex"`
+	NumLeaves uint64            `json:"numLeaves"`
+	Siblings  []types.Hash256   `json:"siblings"`
+}
+
+// A ConsensusHeader is a block header on the best chain, along with its ID
+// and height.
+type ConsensusHeader struct {
+	ID     types.BlockID `json:"id"`
+	Height uint64        `json:"height"`
+	types.BlockHeader
+}
+
+// A BlockSummary is the response type for [GET] /consensus/blocks/:id/summary.
+// It contains values derived from a block that would otherwise need to be
+// recomputed by clients.
+type BlockSummary struct {
+	ID               types.BlockID         `json:"id"`
+	Height           uint64                `json:"height"`
+	ParentID         types.BlockID         `json:"parentID"`
+	Timestamp        time.Time             `json:"timestamp"`
+	MinerPayouts     types.Currency        `json:"minerPayouts"`
+	TotalFees        types.Currency        `json:"totalFees"`
+	SiacoinOutflow   types.Currency        `json:"siacoinOutflow"`
+	TransactionCount int                   `json:"transactionCount"`
+	TransactionIDs   []types.TransactionID `json:"transactionIDs"`
+}
+
+// ConsensusValidateRequest is the request type for [POST] /consensus/validate.
+// Either a block or a transaction set may be provided, but not both.
+type ConsensusValidateRequest struct {
+	Block          *types.Block          `json:"block,omitempty"`
+	Transactions   []types.Transaction   `json:"transactions,omitempty"`
+	V2Transactions []types.V2Transaction `json:"v2Transactions,omitempty"`
+}
+
+// A ValidationInput identifies the input of a transaction that failed
+// validation.
+type ValidationInput struct {
+	Type  string `json:"type"` // "siacoin" or "siafund"
+	Index int    `json:"index"`
+}
+
+// ConsensusValidateResponse is the response type for [POST]
+// /consensus/validate. If the block or transaction set is invalid, Error
+// contains the validation error. If the error can be attributed to a specific
+// transaction or input, TransactionID and Input identify it.
+type ConsensusValidateResponse struct {
+	Valid         bool                 `json:"valid"`
+	Error         string               `json:"error,omitempty"`
+	TransactionID *types.TransactionID `json:"transactionID,omitempty"`
+	Input         *ValidationInput     `json:"input,omitempty"`
+}
+
+// A BatchBlock is a single entry in the response to [POST]
+// /consensus/blocks/batch. If the block is not in the chain store, Found is
+// false and Block is omitted.
+type BatchBlock struct {
+	ID    types.BlockID `json:"id"`
+	Found bool          `json:"found"`
+	Block *types.Block  `json:"block,omitempty"`
+}
+
+// A TipEvent is sent to subscribers whenever the tip of the best chain
+// changes. Reorg is true if PreviousTip is no longer on the best chain.
+type TipEvent struct {
+	Reorg       bool             `json:"reorg"`
+	PreviousTip types.ChainIndex `json:"previousTip"`
+	Tip         types.ChainIndex `json:"tip"`
+}
+
+// A TxpoolEvent is sent to subscribers whenever a transaction enters or
+// leaves the txpool. Addresses contains every address whose balance the
+// transaction affects. For removals, Reason is either "confirmed", if the
+// transaction was included in a block, or "invalidated", if it was dropped
+// because a reorg or conflicting transaction made it invalid.
+type TxpoolEvent struct {
+	ID        types.TransactionID `json:"id"`
+	Version   int                 `json:"version"`
+	Addresses []types.Address     `json:"addresses"`
+	Reason    string              `json:"reason,omitempty"`
+}
+
+// A Hardfork describes a consensus hardfork and its activation status
+// relative to the current tip. A hardfork is active once the tip is at or
+// above its height; Remaining is the number of blocks until that happens.
+type Hardfork struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Height      uint64 `json:"height"`
+	Active      bool   `json:"active"`
+	Remaining   uint64 `json:"remaining"`
+}
+
+// ConsensusHardforksResponse is the response type for [GET]
+// /consensus/hardforks.
+type ConsensusHardforksResponse struct {
+	Tip       types.ChainIndex `json:"tip"`
+	Hardforks []Hardfork       `json:"hardforks"`
+}
+
+// ConsensusSupplyResponse is the response type for [GET] /consensus/supply.
+// All figures are theoretical values derived from the network parameters and
+// are valid as of Index.
+type ConsensusSupplyResponse struct {
+	Index             types.ChainIndex `json:"index"`
+	CirculatingSupply types.Currency   `json:"circulatingSupply"`
+	BlockReward       types.Currency   `json:"blockReward"`
+	SiafundPool       types.Currency   `json:"siafundPool"`
+	FoundationSubsidy types.Currency   `json:"foundationSubsidy"`
+}
+
+// ConsensusDifficultyResponse is the response type for [GET]
+// /consensus/difficulty. Hashrate is the estimated network hashrate in
+// hashes per second over the last Window blocks, encoded as a decimal string.
+type ConsensusDifficultyResponse struct {
+	Index      types.ChainIndex `json:"index"`
+	Target     types.BlockID    `json:"target"`
+	Difficulty consensus.Work   `json:"difficulty"`
+	Hashrate   string           `json:"hashrate"`
+	Window     uint64           `json:"window"`
+}
+
+// A SyncerPeerDetail is the response type for [GET] /syncer/peers/:address.
+// Byte counts include protocol overhead. RPCsInFlight counts the RPCs the node
+// has issued to the peer, such as relays and latency probes, that have not
+// returned.
+// SyncedBlocks and SyncDuration are the number of blocks downloaded from the
+// peer while syncing and the time spent downloading them.
+type SyncerPeerDetail struct {
+	SyncerPeer
+	UniqueID      string        `json:"uniqueID"`
+	Synced        bool          `json:"synced"`
+	BytesSent     uint64        `json:"bytesSent"`
+	BytesReceived uint64        `json:"bytesReceived"`
+	RPCsInFlight  int           `json:"rpcsInFlight"`
+	SyncedBlocks  uint64        `json:"syncedBlocks"`
+	SyncDuration  time.Duration `json:"syncDuration"`
+}
+
+// SyncerConnectRequest is the request type for [POST] /syncer/connect.
+type SyncerConnectRequest struct {
+	Address string `json:"address"`
+}
+
+// SyncerBanRequest is the request type for [POST] /syncer/bans and [POST]
+// /syncer/peers/:address/ban. Address is only used by [POST] /syncer/bans
+// and may be an IP, with or without a port, or a CIDR subnet. If Duration is
+// zero, a default duration is used. Bans do not apply to pinned peers, which
+// must be unpinned first.
+type SyncerBanRequest struct {
+	Address  string        `json:"address,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Reason   string        `json:"reason"`
+}
+
+// A SyncerListener describes one of the node's syncer listeners. NetAddress
+// is the address announced to peers in the gateway header. If the listener
+// could not be started, Active is false and Error explains why.
+type SyncerListener struct {
+	Network       string `json:"network"`
+	NetAddress    string `json:"netAddress,omitempty"`
+	ListenAddress string `json:"listenAddress,omitempty"`
+	Active        bool   `json:"active"`
+	Error         string `json:"error,omitempty"`
+}
+
+// SyncerBroadcastBlockRequest is the request type for [POST]
+// /syncer/broadcast/block. Either the ID of a block in the chain store or a
+// full block must be provided.
+type SyncerBroadcastBlockRequest struct {
+	ID    *types.BlockID `json:"id,omitempty"`
+	Block *types.Block   `json:"block,omitempty"`
+}
+
+// SyncerBroadcastTransactionSetRequest is the request type for [POST]
+// /syncer/broadcast/transactionset. If Basis is omitted, the transactions
+// are assumed to be valid at the current tip.
+type SyncerBroadcastTransactionSetRequest struct {
+	Basis          types.ChainIndex      `json:"basis"`
+	Transactions   []types.Transaction   `json:"transactions,omitempty"`
+	V2Transactions []types.V2Transaction `json:"v2Transactions,omitempty"`
+}
+
+// SyncerBroadcastResponse is the response type for the [POST]
+// /syncer/broadcast endpoints. Peers is the number of peers the object was
+// successfully relayed to.
+type SyncerBroadcastResponse struct {
+	Peers int `json:"peers"`
+}
+
+// A SyncerPeer is a peer connected to one of the node's syncers.
+type SyncerPeer struct {
+	Address            string        `json:"address"`
+	ConnAddress        string        `json:"connAddress"`
+	Inbound            bool          `json:"inbound"`
+	Version            string        `json:"version"`
+	ConnectedSince     time.Time     `json:"connectedSince"`
+	ConnectionDuration time.Duration `json:"connectionDuration"`
+	Latency            time.Duration `json:"latency,omitempty"`
+	AverageLatency     time.Duration `json:"averageLatency,omitempty"`
+	ProbeError         string        `json:"probeError,omitempty"`
+	Pinned             bool          `json:"pinned"`
+}
+
+// SyncerStatsResponse is the response type for [GET] /syncer/stats. Dials
+// covers the last hour.
+type SyncerStatsResponse struct {
+	Peers peers.StoreStats `json:"peers"`
+	Dials peers.DialStats  `json:"dials"`
+}
+
+// SyncerPingResponse is the response type for [POST]
+// /syncer/peers/:address/ping.
+type SyncerPingResponse struct {
+	Latency time.Duration `json:"latency"`
+}
+
+// SyncerStatusResponse is the response type for [GET] /syncer/status.
+// PeerHeight is the highest tip height reported by a connected peer, or the
+// node's own height if no peer is ahead. Peers are asked for their height in
+// the background every 30 seconds, so PeerHeight may lag behind the network. BlocksLastMinute is the number of
+// blocks added to the best chain in the last minute, and ETA extrapolates it
+// to estimate the time remaining until the node reaches PeerHeight. ETA is
+// omitted if the node is not making progress.
+type SyncerStatusResponse struct {
+	Synced           bool             `json:"synced"`
+	Tip              types.ChainIndex `json:"tip"`
+	TipTimestamp     time.Time        `json:"tipTimestamp"`
+	PeerHeight       uint64           `json:"peerHeight"`
+	BlocksLastMinute uint64           `json:"blocksLastMinute"`
+	ETA              time.Duration    `json:"eta,omitempty"`
+}
+
+// TxpoolTransactionsResponse is the response type for [GET]
+// /txpool/transactions. The total number of transactions in the pool is
+// returned in the X-Total-Count header.
+type TxpoolTransactionsResponse struct {
+	Transactions   []types.Transaction   `json:"transactions"`
+	V2Transactions []types.V2Transaction `json:"v2Transactions"`
+}
+
+// A TxpoolTransaction is an unconfirmed transaction. Version is 1 or 2,
+// indicating whether Transaction or V2Transaction is set. Size is the
+// transaction's weight, which is used to compute its fee density.
+type TxpoolTransaction struct {
+	ID            types.TransactionID  `json:"id"`
+	Version       int                  `json:"version"`
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+	Fee           types.Currency       `json:"fee"`
+	Size          uint64               `json:"size"`
+}
+
+// TxpoolParentsRequest is the request type for [POST] /txpool/parents.
+// Exactly one of Transaction and V2Transaction must be set. The response is a
+// TxpoolTransactionsResponse containing the unconfirmed ancestors of the
+// transaction in dependency order.
+type TxpoolParentsRequest struct {
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+}
+
+// A TxpoolFeeBucket is a bucket of the txpool's fee density histogram,
+// containing the transactions paying at least Min per byte of weight, but
+// less than the next bucket's Min.
+type TxpoolFeeBucket struct {
+	Min          types.Currency `json:"min"`
+	Transactions int            `json:"transactions"`
+	Weight       uint64         `json:"weight"`
+}
+
+// TxpoolStatsResponse is the response type for [GET] /txpool/stats. Size is
+// the total encoded size of the pool's transactions, and OldestAge is the
+// amount of time the oldest transaction has been in the pool. If the node
+// limits the size of its pool, MaxSize is the limit in bytes and Rejected is
+// the number of transaction sets from peers rejected because the pool was
+// full.
+type TxpoolStatsResponse struct {
+	Transactions int               `json:"transactions"`
+	Size         uint64            `json:"size"`
+	MaxSize      uint64            `json:"maxSize,omitempty"`
+	Rejected     uint64            `json:"rejected,omitempty"`
+	TotalFees    types.Currency    `json:"totalFees"`
+	FeeHistogram []TxpoolFeeBucket `json:"feeHistogram"`
+	OldestAge    time.Duration     `json:"oldestAge"`
+}
+
+// TxpoolBroadcastRequest is the request type for [POST] /txpool/broadcast.
+// Basis is the index at which the v2 transactions' proofs are valid; if it
+// is omitted, the current tip is assumed.
+type TxpoolBroadcastRequest struct {
+	Basis          types.ChainIndex      `json:"basis"`
+	Transactions   []types.Transaction   `json:"transactions,omitempty"`
+	V2Transactions []types.V2Transaction `json:"v2Transactions,omitempty"`
+}
+
+// TxpoolBroadcastResponse is the response type for [POST] /txpool/broadcast.
+// If every transaction was already in the pool, Known is true and the set is
+// not relayed again. Peers is the number of peers the v2 transactions were
+// relayed to.
+type TxpoolBroadcastResponse struct {
+	Known bool `json:"known"`
+	Peers int  `json:"peers"`
+}
+
+// A TxpoolLocalSet is a transaction set broadcast through the node, as
+// returned by [GET] /txpool/local. Its ID is the ID of the last transaction
+// in the set, and Status is "pending", "confirmed" or "abandoned". A
+// confirmed set has ConfirmedHeight set to the height at which its last
+// transaction confirmed, and an abandoned set has Error set to the reason it
+// was abandoned. Basis is the index at which the set's v2 proofs were last
+// updated.
+type TxpoolLocalSet struct {
+	ID              types.TransactionID   `json:"id"`
+	Status          string                `json:"status"`
+	ConfirmedHeight uint64                `json:"confirmedHeight,omitempty"`
+	Error           string                `json:"error,omitempty"`
+	Added           time.Time             `json:"added"`
+	LastBroadcast   time.Time             `json:"lastBroadcast"`
+	Basis           types.ChainIndex      `json:"basis"`
+	Transactions    []types.Transaction   `json:"transactions,omitempty"`
+	V2Transactions  []types.V2Transaction `json:"v2Transactions,omitempty"`
+}
+
+// TxpoolFeeResponse is the response type for [GET] /txpool/fee. All fees are
+// per byte of transaction weight. Recommended is the chain manager's
+// recommended fee. Low, Medium, and High are percentiles of fee density among
+// the pool's transactions, weighted by size. If the pool is empty, they are
+// equal to Recommended.
+type TxpoolFeeResponse struct {
+	Recommended types.Currency `json:"recommended"`
+	Low         types.Currency `json:"low"`
+	Medium      types.Currency `json:"medium"`
+	High        types.Currency `json:"high"`
+	PoolSize    int            `json:"poolSize"`
+	PoolWeight  uint64         `json:"poolWeight"`
+}
+
+// A HealthCheck is the result of a single check performed by [GET] /health.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthResponse is the response type for [GET] /health. Failing lists the
+// names of the checks that did not pass.
+type HealthResponse struct {
+	Healthy bool          `json:"healthy"`
+	Failing []string      `json:"failing"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// BuildState contains information about the node's build.
+type BuildState struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	BuildTime time.Time `json:"buildTime"`
+	GoVersion string    `json:"goVersion"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+}
+
+// StateResponse is the response type for [GET] /state.
+type StateResponse struct {
+	BuildState
+	StartTime time.Time `json:"startTime"`
+	Network   string    `json:"network"`
+	DataDir   string    `json:"dataDir,omitempty"`
+}
+
+// ConsensusNetworkResponse is the response type for [GET] /consensus/network.
+type ConsensusNetworkResponse struct {
+	*consensus.Network
+	GenesisID types.BlockID `json:"genesisID"`
+}
+
+// LogLevelResponse is the response type for [GET] /log/level.
+type LogLevelResponse struct {
+	Level zapcore.Level `json:"level"`
+}
+
+// LogLevelRequest is the request type for [PUT] /log/level. Level accepts
+// the same values as the -log.level flag.
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// WalletCreateRequest is the request type for [PUT] /wallets/:name. Type is
+// either "seed", the default, or "watch". Seed wallets are created from
+// Phrase, or from a newly generated seed phrase if it is empty. Watch-only
+// wallets track Addresses without any keys, and the chain is scanned for
+// their elements from Height. If Passphrase is set, a seed wallet's seed is
+// encrypted with it, and the wallet is locked until it is unlocked with [POST]
+// /wallets/:name/unlock.
+type WalletCreateRequest struct {
+	Type       string          `json:"type,omitempty"`
+	Phrase     string          `json:"phrase,omitempty"`
+	Passphrase string          `json:"passphrase,omitempty"`
+	Addresses  []types.Address `json:"addresses,omitempty"`
+	Height     uint64          `json:"height,omitempty"`
+}
+
+// BackupPassphraseHeader is the request header that holds the passphrase of
+// [GET] /wallets/:name/backup and [POST] /wallets/:name/restore, so that it
+// is not written to access logs along with the URL.
+const BackupPassphraseHeader = "X-Backup-Passphrase"
+
+// WalletCreateResponse is the response type for [PUT] /wallets/:name. Phrase
+// is only set if it was generated, and is not returned again.
+type WalletCreateResponse struct {
+	Phrase string `json:"phrase,omitempty"`
+}
+
+// WalletWatchRequest is the request type for [POST] /wallets/:name/watch.
+// Blocks from Height onward are scanned for the addresses' elements.
+type WalletWatchRequest struct {
+	Addresses []types.Address `json:"addresses"`
+	Height    uint64          `json:"height"`
+}
+
+// WalletAddressCheckRequest is the request type for [POST]
+// /wallets/:name/addresses/check.
+type WalletAddressCheckRequest struct {
+	Addresses []types.Address `json:"addresses"`
+}
+
+// WalletLabelRequest is the request type for [PUT]
+// /wallets/:name/addresses/:addr/label. An empty label removes the address's
+// label.
+type WalletLabelRequest struct {
+	Label string `json:"label"`
+}
+
+// WalletUnlockRequest is the request type for [POST] /wallets/:name/unlock.
+// If Timeout is positive, the wallet locks itself again once it has passed.
+type WalletUnlockRequest struct {
+	Passphrase string        `json:"passphrase"`
+	Timeout    time.Duration `json:"timeout,omitempty"`
+}
+
+// WalletPassphraseRequest is the request type for [PUT]
+// /wallets/:name/passphrase. Old is the wallet's current passphrase, if it
+// has one. An empty Passphrase encrypts the seed with the wallet password
+// again.
+type WalletPassphraseRequest struct {
+	Old        string `json:"old,omitempty"`
+	Passphrase string `json:"passphrase"`
+}
+
+// WalletRescanRequest is the request type for [POST] /wallets/:name/rescan.
+type WalletRescanRequest struct {
+	Height uint64 `json:"height"`
+}
+
+// WalletSweepRequest is the request type for [POST] /wallets/:name/sweep.
+// The chain is scanned from Height for the elements of the first Lookahead
+// addresses derived from Phrase; if Lookahead is zero, a default is used.
+type WalletSweepRequest struct {
+	Phrase    string `json:"phrase"`
+	Lookahead int    `json:"lookahead"`
+	Height    uint64 `json:"height"`
+}
+
+// WalletSweepResponse is the response type for [POST] /wallets/:name/sweep.
+// Siacoins and Siafunds are the value found, including that of the skipped
+// elements, which are worth no more than the fee required to spend them, or,
+// for siafunds, have no siacoins to pay their fee.
+type WalletSweepResponse struct {
+	Siacoins        types.Currency         `json:"siacoins"`
+	Siafunds        uint64                 `json:"siafunds"`
+	Fee             types.Currency         `json:"fee"`
+	TransactionIDs  []types.TransactionID  `json:"transactionIDs"`
+	Skipped         []types.SiacoinElement `json:"skipped"`
+	SkippedSiafunds []types.SiafundElement `json:"skippedSiafunds"`
+	Peers           int                    `json:"peers"`
+}
+
+// WalletConsolidateRequest is the request type for [POST]
+// /wallets/:name/consolidate. Each transaction spends at most MaxInputs of
+// the wallet's smallest outputs, or a default number if it is zero, and is
+// kept below the standard transaction weight. If Target is zero, one
+// transaction is constructed; otherwise transactions are constructed until
+// the wallet would have fewer than Target outputs.
+type WalletConsolidateRequest struct {
+	MaxInputs int `json:"maxInputs"`
+	Target    int `json:"target"`
+}
+
+// WalletConsolidateResponse is the response type for [POST]
+// /wallets/:name/consolidate. Inputs is the number of outputs consolidated,
+// and Remaining the number of spendable outputs the wallet will have once
+// the transactions confirm. Savings is the projected fee saved by spending
+// the consolidated outputs instead of their inputs at FeeRate, the current
+// fee rate. Transactions is only set for previews, which return the unsigned
+// transactions instead of broadcasting them.
+type WalletConsolidateResponse struct {
+	TransactionIDs []types.TransactionID `json:"transactionIDs"`
+	Transactions   []WalletSendResponse  `json:"transactions,omitempty"`
+	Inputs         int                   `json:"inputs"`
+	Remaining      int                   `json:"remaining"`
+	Fee            types.Currency        `json:"fee"`
+	FeeRate        types.Currency        `json:"feeRate"`
+	Savings        types.Currency        `json:"savings"`
+	Peers          int                   `json:"peers"`
+}
+
+// WalletConstructRequest is the request type for [POST]
+// /wallets/:name/transactions/construct. The spent elements are reserved for
+// Duration while the transaction is signed; if it is zero, a default
+// duration is used. Strategy is the coin selection strategy; if it is empty,
+// the wallet's default is used. Memo, if set, is attached to the wallet's
+// record of the transaction.
+type WalletConstructRequest struct {
+	SiacoinOutputs []types.SiacoinOutput `json:"siacoinOutputs"`
+	Duration       time.Duration         `json:"duration"`
+	Strategy       string                `json:"strategy,omitempty"`
+	Memo           string                `json:"memo,omitempty"`
+}
+
+// WalletConstructResponse is the response type for [POST]
+// /wallets/:name/transactions/construct. Exactly one of Transaction or
+// V2Transaction is set, with empty signatures. Inputs describe how to sign
+// each input with the wallet's seed. The spent elements are reserved until
+// Expires. Change is the value returned to the wallet.
+type WalletConstructResponse struct {
+	ID            types.TransactionID   `json:"id"`
+	Basis         types.ChainIndex      `json:"basis"`
+	Transaction   *types.Transaction    `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction  `json:"v2Transaction,omitempty"`
+	Fee           types.Currency        `json:"fee"`
+	Change        types.Currency        `json:"change"`
+	Inputs        []wallet.SigningInput `json:"inputs"`
+	Expires       time.Time             `json:"expires"`
+}
+
+// WalletSubmitRequest is the request type for [POST]
+// /wallets/:name/transactions/submit. Exactly one of Transaction and
+// V2Transaction must be set. Basis is the index at which the v2
+// transaction's proofs are valid.
+type WalletSubmitRequest struct {
+	Basis         types.ChainIndex     `json:"basis"`
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+}
+
+// WalletBumpRequest is the request type for [POST]
+// /wallets/:name/transactions/bump. ID is the pending transaction to bump,
+// and FeeRate the fee per unit of weight that it and its child should pay
+// together; if it is zero, the recommended fee is used.
+type WalletBumpRequest struct {
+	ID      types.TransactionID `json:"id"`
+	FeeRate types.Currency      `json:"feeRate"`
+}
+
+// WalletBumpResponse is the response type for [POST]
+// /wallets/:name/transactions/bump. The child transaction spends the
+// parent's outputs to the wallet, and is broadcast with it. FeeRate is the
+// effective fee rate of the two.
+type WalletBumpResponse struct {
+	ID            types.TransactionID  `json:"id"`
+	Parent        types.TransactionID  `json:"parent"`
+	Basis         types.ChainIndex     `json:"basis"`
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+	Fee           types.Currency       `json:"fee"`
+	FeeRate       types.Currency       `json:"feeRate"`
+	Peers         int                  `json:"peers"`
+}
+
+// WalletSendRequest is the request type for [POST] /wallets/:name/send. If
+// ArbitraryData is set, it is embedded in the transaction. Strategy is the
+// coin selection strategy; if it is empty, the wallet's default is used.
+// Memo, if set, is attached to the wallet's record of the transaction, and
+// never to the chain.
+type WalletSendRequest struct {
+	Address       types.Address  `json:"address"`
+	Amount        types.Currency `json:"amount"`
+	ArbitraryData []byte         `json:"arbitraryData,omitempty"`
+	Strategy      string         `json:"strategy,omitempty"`
+	Memo          string         `json:"memo,omitempty"`
+}
+
+// WalletAnchorRequest is the request type for [POST] /wallets/:name/anchor.
+type WalletAnchorRequest struct {
+	ArbitraryData []byte `json:"arbitraryData"`
+}
+
+// WalletSendSiafundRequest is the request type for [POST]
+// /wallets/:name/send/siafund. Memo, if set, is attached to the wallet's
+// record of the transaction.
+type WalletSendSiafundRequest struct {
+	Address types.Address `json:"address"`
+	Amount  uint64        `json:"amount"`
+	Memo    string        `json:"memo,omitempty"`
+}
+
+// WalletSendResponse is the response type for [POST] /wallets/:name/send,
+// [POST] /wallets/:name/send/siafund, and [POST] /wallets/:name/anchor. Once
+// the transaction is confirmed, its inclusion can be proven with [GET]
+// /consensus/blocks/:id/proof/:txid. Exactly one of Transaction or
+// V2Transaction is set. Basis is the index at which the v2 transaction's
+// proofs are valid. Fee is calculated from FeeRate, per unit of weight.
+// Inputs are the IDs of the wallet elements it spends, and Change is the
+// value it returns to the wallet. For a preview, the transaction is unsigned
+// and has not been broadcast, so Peers is zero.
+type WalletSendResponse struct {
+	ID            types.TransactionID  `json:"id"`
+	Basis         types.ChainIndex     `json:"basis"`
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+	Fee           types.Currency       `json:"fee"`
+	FeeRate       types.Currency       `json:"feeRate"`
+	Inputs        []types.Hash256      `json:"inputs,omitempty"`
+	Change        types.Currency       `json:"change"`
+	Peers         int                  `json:"peers"`
+}
+
+// WalletFundRequest is the request type for [POST] /wallets/:name/fund.
+// Exactly one of Transaction and V2Transaction must be set. Amount is the
+// value of the siacoin inputs to add, including any fee. The inputs are
+// selected by Strategy, or the wallet's default strategy if it is empty, and
+// reserved for Duration; if it is zero, a default duration is used.
+type WalletFundRequest struct {
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+	Amount        types.Currency       `json:"amount"`
+	Duration      time.Duration        `json:"duration"`
+	Strategy      string               `json:"strategy,omitempty"`
+}
+
+// WalletFundResponse is the response type for [POST] /wallets/:name/fund. The
+// funded transaction is returned in the same field it was submitted in, and
+// Parents are its unconfirmed ancestors in the txpool. Basis is only set for
+// v2 transactions, and is the index at which their proofs are valid. Inputs
+// are the IDs of the wallet elements added to the transaction, and Change is
+// the value of the change output added with them, if any.
+type WalletFundResponse struct {
+	Basis         types.ChainIndex           `json:"basis"`
+	Inputs        []types.SiacoinOutputID    `json:"inputs"`
+	Change        types.Currency             `json:"change"`
+	Transaction   *types.Transaction         `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction       `json:"v2Transaction,omitempty"`
+	Parents       TxpoolTransactionsResponse `json:"parents"`
+}
+
+// WalletReleaseRequest is the request type for [POST] /wallets/:name/release.
+// Exactly one of Transaction and V2Transaction must be set.
+type WalletReleaseRequest struct {
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+}
+
+// WalletSignRequest is the request type for [POST] /wallets/:name/sign.
+// Exactly one of Transaction and V2Transaction must be set. ToSign are the
+// parent IDs of the inputs to sign; if it is empty, every input the wallet
+// controls is signed. CoveredFields only applies to v1 transactions, and
+// defaults to the whole transaction.
+type WalletSignRequest struct {
+	Transaction   *types.Transaction   `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction `json:"v2Transaction,omitempty"`
+	ToSign        []types.Hash256      `json:"toSign,omitempty"`
+	CoveredFields *types.CoveredFields `json:"coveredFields,omitempty"`
+}
+
+// WalletSignResponse is the response type for [POST] /wallets/:name/sign. The
+// signed transaction is returned in the same field it was submitted in.
+// Missing are the parent IDs of the requested inputs the wallet does not
+// control, which must be signed by another party. Remaining is the number
+// of signatures each input spending one of the wallet's multisig addresses
+// still requires from its other keys.
+type WalletSignResponse struct {
+	Transaction   *types.Transaction    `json:"transaction,omitempty"`
+	V2Transaction *types.V2Transaction  `json:"v2Transaction,omitempty"`
+	Missing       []types.Hash256       `json:"missing"`
+	Remaining     map[types.Hash256]int `json:"remaining"`
+}
+
+// WalletMultisigRequest is the request type for [POST]
+// /wallets/:name/multisig. The address requires Threshold signatures from
+// PublicKeys, at least one of which must be the wallet's; every co-signer
+// must list them in the same order. V2 addresses are threshold policies,
+// which can only be spent by v2 transactions; otherwise the address is
+// defined by unlock conditions. If Height is at or below the wallet's tip,
+// the chain is rescanned from it for the address's elements.
+type WalletMultisigRequest struct {
+	Threshold  int               `json:"threshold"`
+	PublicKeys []types.PublicKey `json:"publicKeys"`
+	V2         bool              `json:"v2"`
+	Height     uint64            `json:"height"`
+}
+
+// WalletOutputsResponse is the response type for [GET]
+// /wallets/:name/outputs.
+type WalletOutputsResponse struct {
+	SiacoinElements []types.SiacoinElement `json:"siacoinElements"`
+	SiafundElements []types.SiafundElement `json:"siafundElements"`
+}
+
+// WalletReserveRequest is the request type for [POST]
+// /wallets/:name/outputs/reserve. IDs are the IDs of siacoin or siafund
+// elements. If Duration is zero, a default duration is used.
+type WalletReserveRequest struct {
+	IDs      []types.Hash256 `json:"ids"`
+	Duration time.Duration   `json:"duration"`
+}
+
+// WalletReleaseOutputsRequest is the request type for [POST]
+// /wallets/:name/outputs/release.
+type WalletReleaseOutputsRequest struct {
+	IDs []types.Hash256 `json:"ids"`
+}
+
+// MiningStartRequest is the request type for [POST] /mining/start. If Address
+// is omitted, the miner pays its payout address. If Threads is zero, the
+// miner uses one thread per CPU.
+type MiningStartRequest struct {
+	Address types.Address `json:"address"`
+	Threads int           `json:"threads"`
+}
+
+// MineRequest is the request type for [POST] /mine. If Address is omitted,
+// the blocks pay the miner's payout address.
+type MineRequest struct {
+	Blocks  int           `json:"blocks"`
+	Address types.Address `json:"address"`
+}
+
+// MiningPayoutAddress is the request and response type for [GET] and [PUT]
+// /mining/payoutaddress. The void address means no payout address is set.
+type MiningPayoutAddress struct {
+	Address types.Address `json:"address"`
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.sia.tech/jape"
+)
+
+// A Scope is the level of access granted by a credential.
+type Scope string
+
+// The scopes of API credentials. An admin credential can access every route,
+// while a read credential can only access routes that do not change the
+// node's state or reveal sensitive information.
+const (
+	ScopeRead  Scope = "read"
+	ScopeAdmin Scope = "admin"
+
+	// scopePublic marks routes that can be requested without credentials.
+	scopePublic Scope = ""
+)
+
+// allows reports whether a credential with scope s can access a route
+// requiring scope required.
+func (s Scope) allows(required Scope) bool {
+	switch required {
+	case scopePublic:
+		return true
+	case ScopeRead:
+		return s == ScopeRead || s == ScopeAdmin
+	default:
+		return s == ScopeAdmin
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Scope) UnmarshalText(b []byte) error {
+	switch Scope(b) {
+	case ScopeRead, ScopeAdmin:
+		*s = Scope(b)
+		return nil
+	default:
+		return fmt.Errorf("unknown scope %q", b)
+	}
+}
+
+// A Token is a bearer token granting access to the API.
+type Token struct {
+	Token string `json:"token"`
+	Scope Scope  `json:"scope"`
+}
+
+// String implements fmt.Stringer, redacting the token so that it can be
+// logged safely.
+func (t Token) String() string {
+	return fmt.Sprintf("%v:%v…", t.Scope, t.Token[:min(4, len(t.Token))])
+}
+
+// A credential is a password or token, stored as a digest so that
+// comparisons take the same time regardless of length.
+type credential struct {
+	digest   [32]byte
+	scope    Scope
+	redacted string
+}
+
+// authenticate returns the credential supplied by req, either as a bearer
+// token or as a basic auth password.
+func (s *server) authenticate(req *http.Request) (credential, bool) {
+	var secret string
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		secret = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, pass, ok := req.BasicAuth(); ok {
+		secret = pass
+	} else {
+		return credential{}, false
+	}
+	digest := sha256.Sum256([]byte(secret))
+	// compare against every credential, so that the time taken doesn't
+	// reveal which one matched
+	var match credential
+	var found bool
+	for _, c := range s.credentials {
+		if subtle.ConstantTimeCompare(digest[:], c.digest[:]) == 1 {
+			match, found = c, true
+		}
+	}
+	return match, found
+}
+
+// authenticated wraps h, recording the scope of the credential supplied by
+// the request, if it is valid, for the rate limit and authorized. Since
+// credentials are resolved before the rate limit, but only checked after
+// it, requests with wrong credentials are limited like any other.
+func (s *server) authenticated(h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		if c, ok := s.authenticate(jc.Request); ok {
+			if ri, ok := jc.Request.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+				ri.scope, ri.credential = c.scope, c.redacted
+			}
+		}
+		h(jc)
+	}
+}
+
+// authorized wraps the handler of a route requiring scope, rejecting
+// requests without a valid credential with 401 Unauthorized, and requests
+// whose credential has insufficient scope with 403 Forbidden. The
+// credential must have been resolved by authenticated.
+func (s *server) authorized(scope Scope, h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		granted := requestScope(jc.Request)
+		if scope == scopePublic {
+			h(jc)
+			return
+		} else if granted == scopePublic {
+			jc.ResponseWriter.Header().Set("WWW-Authenticate", `Basic realm="noded", charset="UTF-8"`)
+			writeError(jc, http.StatusUnauthorized, ErrorCodeUnauthorized, errors.New("a valid API password or token is required"))
+			return
+		} else if !granted.allows(scope) {
+			writeError(jc, http.StatusForbidden, ErrorCodeForbidden, fmt.Errorf("route requires %v scope", scope))
+			return
+		}
+		h(jc)
+	}
+}
+
+// requestScope returns the scope granted to req, or the empty string if the
+// request was not authenticated.
+func requestScope(req *http.Request) Scope {
+	if ri, ok := req.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+		return ri.scope
+	}
+	return scopePublic
+}
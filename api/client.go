@@ -0,0 +1,669 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/node/internal/index"
+	"go.sia.tech/node/internal/mining"
+	"go.sia.tech/node/internal/wallet"
+)
+
+// A Client provides methods for interacting with the API.
+type Client struct {
+	baseURL  string
+	password string
+	token    string
+	hc       *http.Client
+}
+
+// do performs a request, encoding data as the request body if it is non-nil,
+// and adding header to the request's headers.
+func (c *Client) do(ctx context.Context, method, route string, header http.Header, data any) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		js, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(js)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+route, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.password != "" {
+		req.SetBasicAuth("", c.password)
+	}
+	return c.hc.Do(req)
+}
+
+// req performs a request, decoding the response into resp if it is non-nil.
+// Error responses are returned as an *Error.
+func (c *Client) req(ctx context.Context, method, route string, data, resp any) error {
+	return c.reqHeader(ctx, method, route, nil, data, resp)
+}
+
+// reqHeader is like req, adding header to the request's headers.
+func (c *Client) reqHeader(ctx context.Context, method, route string, header http.Header, data, resp any) error {
+	r, err := c.do(ctx, method, route, header, data)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	defer io.Copy(io.Discard, r.Body)
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		var apiErr Error
+		if err := json.Unmarshal(b, &apiErr); err != nil || apiErr.Code == "" {
+			// not an API error, e.g. from a proxy
+			return fmt.Errorf("%v: %s", r.Status, strings.TrimSpace(string(b)))
+		}
+		return &apiErr
+	} else if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func (c *Client) get(route string, resp any) error {
+	return c.req(context.Background(), http.MethodGet, route, nil, resp)
+}
+
+func (c *Client) post(route string, data, resp any) error {
+	return c.req(context.Background(), http.MethodPost, route, data, resp)
+}
+
+func (c *Client) put(route string, data any) error {
+	return c.req(context.Background(), http.MethodPut, route, data, nil)
+}
+
+func (c *Client) delete(route string) error {
+	return c.req(context.Background(), http.MethodDelete, route, nil, nil)
+}
+
+// State returns the node's build and runtime information.
+func (c *Client) State() (resp StateResponse, err error) {
+	err = c.get("/state", &resp)
+	return
+}
+
+// Health returns the node's health checks. Unlike other methods, it does not
+// return an error if the node is unhealthy.
+func (c *Client) Health() (resp HealthResponse, err error) {
+	r, err := c.do(context.Background(), http.MethodGet, "/health", nil, nil)
+	if err != nil {
+		return HealthResponse{}, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusServiceUnavailable {
+		return HealthResponse{}, fmt.Errorf("unexpected status %v", r.Status)
+	}
+	err = json.NewDecoder(r.Body).Decode(&resp)
+	return
+}
+
+// LogLevel returns the node's log level.
+func (c *Client) LogLevel() (resp LogLevelResponse, err error) {
+	err = c.get("/log/level", &resp)
+	return
+}
+
+// SetLogLevel sets the node's log level.
+func (c *Client) SetLogLevel(level string) error {
+	return c.put("/log/level", LogLevelRequest{Level: level})
+}
+
+// ConsensusNetwork returns the node's network parameters.
+func (c *Client) ConsensusNetwork() (resp ConsensusNetworkResponse, err error) {
+	err = c.get("/consensus/network", &resp)
+	return
+}
+
+// ConsensusTip returns the current tip.
+func (c *Client) ConsensusTip() (resp types.ChainIndex, err error) {
+	err = c.get("/consensus/tip", &resp)
+	return
+}
+
+// ConsensusBlock returns the block with the given ID.
+func (c *Client) ConsensusBlock(id types.BlockID) (resp types.Block, err error) {
+	err = c.get(fmt.Sprintf("/consensus/blocks/%v", id), &resp)
+	return
+}
+
+// ConsensusIndex returns the index of the best chain at the given height.
+func (c *Client) ConsensusIndex(height uint64) (resp types.ChainIndex, err error) {
+	err = c.get(fmt.Sprintf("/consensus/index/%d", height), &resp)
+	return
+}
+
+// ConsensusUpdates returns up to limit chain updates since index.
+func (c *Client) ConsensusUpdates(index types.ChainIndex, limit int) (resp ConsensusUpdatesResponse, err error) {
+	err = c.get(fmt.Sprintf("/consensus/updates/%d::%v?limit=%d", index.Height, index.ID, limit), &resp)
+	return
+}
+
+// ConsensusCheckpoint returns the block and state with the given ID.
+func (c *Client) ConsensusCheckpoint(id types.BlockID) (resp ConsensusCheckpointResponse, err error) {
+	err = c.get(fmt.Sprintf("/consensus/checkpoint/%v", id), &resp)
+	return
+}
+
+// AddBlock adds a block to the chain.
+func (c *Client) AddBlock(b types.Block) error {
+	return c.post("/consensus/blocks", b, nil)
+}
+
+// SyncerPeers returns the connected peers.
+func (c *Client) SyncerPeers() (resp []SyncerPeer, err error) {
+	err = c.get("/syncer/peers", &resp)
+	return
+}
+
+// SyncerStatus returns the sync status of the node.
+func (c *Client) SyncerStatus() (resp SyncerStatusResponse, err error) {
+	err = c.get("/syncer/status", &resp)
+	return
+}
+
+// SyncerConnect connects to the peer at addr.
+func (c *Client) SyncerConnect(addr string) (resp SyncerPeer, err error) {
+	err = c.post("/syncer/connect", SyncerConnectRequest{Address: addr}, &resp)
+	return
+}
+
+// SyncerDisconnect disconnects the peer at addr.
+func (c *Client) SyncerDisconnect(addr string) error {
+	return c.delete("/syncer/peers/" + url.PathEscape(addr))
+}
+
+// TxpoolTransactions returns the transactions in the txpool.
+func (c *Client) TxpoolTransactions() (resp TxpoolTransactionsResponse, err error) {
+	err = c.get("/txpool/transactions", &resp)
+	return
+}
+
+// TxpoolFee returns the recommended fee rates.
+func (c *Client) TxpoolFee() (resp TxpoolFeeResponse, err error) {
+	err = c.get("/txpool/fee", &resp)
+	return
+}
+
+// TxpoolBroadcast adds a transaction set to the txpool and broadcasts it.
+func (c *Client) TxpoolBroadcast(basis types.ChainIndex, txns []types.Transaction, v2txns []types.V2Transaction) (resp TxpoolBroadcastResponse, err error) {
+	err = c.post("/txpool/broadcast", TxpoolBroadcastRequest{
+		Basis:          basis,
+		Transactions:   txns,
+		V2Transactions: v2txns,
+	}, &resp)
+	return
+}
+
+// TxpoolLocal returns the transaction sets broadcast through the node.
+func (c *Client) TxpoolLocal() (resp []TxpoolLocalSet, err error) {
+	err = c.get("/txpool/local", &resp)
+	return
+}
+
+// MiningBlockTemplate returns a block template that pays addr, or the
+// miner's payout address if addr is the void address.
+func (c *Client) MiningBlockTemplate(addr types.Address) (resp mining.Template, err error) {
+	route := "/mining/blocktemplate"
+	if addr != types.VoidAddress {
+		route += "?payoutaddress=" + addr.String()
+	}
+	err = c.get(route, &resp)
+	return
+}
+
+// MiningBlockTemplateTag returns a block template that pays addr, or the
+// miner's payout address if addr is the void address, and carries tag, or
+// the miner's tag if tag is empty.
+func (c *Client) MiningBlockTemplateTag(addr types.Address, tag string) (resp mining.Template, err error) {
+	q := url.Values{"tag": {tag}}
+	if addr != types.VoidAddress {
+		q.Set("payoutaddress", addr.String())
+	}
+	err = c.get("/mining/blocktemplate?"+q.Encode(), &resp)
+	return
+}
+
+// MiningBlockTemplateSince returns a block template that pays addr, or the
+// miner's payout address if addr is the void address, waiting up to timeout
+// for the template's ID to differ from since.
+func (c *Client) MiningBlockTemplateSince(addr types.Address, since types.Hash256, timeout time.Duration) (resp mining.Template, err error) {
+	route := fmt.Sprintf("/mining/blocktemplate?since=%v&timeout=%v", since, timeout)
+	if addr != types.VoidAddress {
+		route += "&payoutaddress=" + addr.String()
+	}
+	err = c.get(route, &resp)
+	return
+}
+
+// MiningPayoutAddress returns the miner's payout address.
+func (c *Client) MiningPayoutAddress() (types.Address, error) {
+	var resp MiningPayoutAddress
+	err := c.get("/mining/payoutaddress", &resp)
+	return resp.Address, err
+}
+
+// SetMiningPayoutAddress sets the miner's payout address.
+func (c *Client) SetMiningPayoutAddress(addr types.Address) error {
+	return c.put("/mining/payoutaddress", MiningPayoutAddress{Address: addr})
+}
+
+// MiningStatus returns the state of the built-in miner.
+func (c *Client) MiningStatus() (resp mining.Status, err error) {
+	err = c.get("/mining/status", &resp)
+	return
+}
+
+// StartMining starts the built-in miner with threads threads, paying addr,
+// or the payout address if addr is the void address.
+func (c *Client) StartMining(addr types.Address, threads int) error {
+	return c.post("/mining/start", MiningStartRequest{Address: addr, Threads: threads}, nil)
+}
+
+// StopMining stops the built-in miner.
+func (c *Client) StopMining() error {
+	return c.post("/mining/stop", nil, nil)
+}
+
+// Mine mines n blocks paying addr, or the payout address if addr is the void
+// address, and returns their IDs.
+func (c *Client) Mine(addr types.Address, n int) (ids []types.BlockID, err error) {
+	err = c.post("/mine", MineRequest{Blocks: n, Address: addr}, &ids)
+	return
+}
+
+// AddressBalance returns the balance of addr from the node's address index.
+func (c *Client) AddressBalance(addr types.Address) (resp index.Balance, err error) {
+	err = c.get(fmt.Sprintf("/addresses/%v/balance", addr), &resp)
+	return
+}
+
+// Wallets returns the status of each of the node's wallets.
+func (c *Client) Wallets() (resp []wallet.Status, err error) {
+	err = c.get("/wallets", &resp)
+	return
+}
+
+// Wallet returns the status of the wallet named name.
+func (c *Client) Wallet(name string) (resp wallet.Status, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s", name), &resp)
+	return
+}
+
+// WalletBalance returns the wallet's balance.
+func (c *Client) WalletBalance(name string) (resp wallet.Balance, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/balance", name), &resp)
+	return
+}
+
+// WalletDetailedBalance returns the wallet's confirmed siacoins, broken down
+// by when they can be spent.
+func (c *Client) WalletDetailedBalance(name string) (resp wallet.DetailedBalance, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/balance/detailed", name), &resp)
+	return
+}
+
+// WalletAddresses returns the wallet's derived addresses.
+func (c *Client) WalletAddresses(name string) (resp []wallet.AddressInfo, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/addresses", name), &resp)
+	return
+}
+
+// AddWalletAddresses returns count new wallet addresses, lookahead addresses
+// first.
+func (c *Client) AddWalletAddresses(name string, count int) (resp []wallet.Address, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/addresses?count=%d", name, count), nil, &resp)
+	return
+}
+
+// WalletSend sends amount siacoins to addr from the wallet. If force is
+// true, dust change is added to the fee rather than rejected.
+func (c *Client) WalletSend(name string, addr types.Address, amount types.Currency, force bool) (resp WalletSendResponse, err error) {
+	return c.WalletSendRequest(name, WalletSendRequest{Address: addr, Amount: amount}, wallet.VersionAuto, force)
+}
+
+// WalletSendData is like WalletSend, but embeds data in a transaction of the
+// given version, and attaches memo to the wallet's record of it.
+func (c *Client) WalletSendData(name string, addr types.Address, amount types.Currency, data []byte, memo string, version int, force bool) (resp WalletSendResponse, err error) {
+	return c.WalletSendRequest(name, WalletSendRequest{Address: addr, Amount: amount, ArbitraryData: data, Memo: memo}, version, force)
+}
+
+// WalletSendRequest sends siacoins from the wallet as described by req, such
+// as with a particular coin selection strategy, in a transaction of the
+// given version.
+func (c *Client) WalletSendRequest(name string, req WalletSendRequest, version int, force bool) (resp WalletSendResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/send?force=%t&version=%d", name, force, version), req, &resp)
+	return
+}
+
+// WalletPreviewSend returns the transaction that WalletSendRequest would
+// send, without signing or broadcasting it or reserving its inputs.
+func (c *Client) WalletPreviewSend(name string, req WalletSendRequest, version int, force bool) (resp WalletSendResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/send?preview=true&force=%t&version=%d", name, force, version), req, &resp)
+	return
+}
+
+// WalletConsolidate consolidates the wallet's smallest outputs, at most
+// maxInputs per transaction, until it has fewer than target outputs, or
+// with a single transaction if target is zero.
+func (c *Client) WalletConsolidate(name string, maxInputs, target int) (resp WalletConsolidateResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/consolidate", name), WalletConsolidateRequest{MaxInputs: maxInputs, Target: target}, &resp)
+	return
+}
+
+// WalletPreviewConsolidate returns the transactions that WalletConsolidate
+// would broadcast, without signing or broadcasting them or reserving their
+// inputs.
+func (c *Client) WalletPreviewConsolidate(name string, maxInputs, target int) (resp WalletConsolidateResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/consolidate?preview=true", name), WalletConsolidateRequest{MaxInputs: maxInputs, Target: target}, &resp)
+	return
+}
+
+// WalletAnchor embeds data in a transaction of the given version sending the
+// wallet's funds back to itself.
+func (c *Client) WalletAnchor(name string, data []byte, version int) (resp WalletSendResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/anchor?version=%d", name, version), WalletAnchorRequest{ArbitraryData: data}, &resp)
+	return
+}
+
+// WalletSendSiafunds sends amount siafunds to addr from the wallet, in a
+// transaction of the given version, attaching memo to the wallet's record of
+// it.
+func (c *Client) WalletSendSiafunds(name string, addr types.Address, amount uint64, memo string, version int, force bool) (resp WalletSendResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/send/siafund?force=%t&version=%d", name, force, version), WalletSendSiafundRequest{Address: addr, Amount: amount, Memo: memo}, &resp)
+	return
+}
+
+// WalletBump raises the fee of the pending wallet transaction with id to
+// feeRate, or the recommended fee if it is zero, by broadcasting a child
+// transaction.
+func (c *Client) WalletBump(name string, id types.TransactionID, feeRate types.Currency) (resp WalletBumpResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/transactions/bump", name), WalletBumpRequest{ID: id, FeeRate: feeRate}, &resp)
+	return
+}
+
+// WalletFund adds wallet inputs worth amount to txn, selected by strategy,
+// or the wallet's default strategy if it is empty, reserving them for d.
+func (c *Client) WalletFund(name string, txn types.Transaction, amount types.Currency, strategy string, d time.Duration) (resp WalletFundResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/fund", name), WalletFundRequest{Transaction: &txn, Amount: amount, Duration: d, Strategy: strategy}, &resp)
+	return
+}
+
+// WalletFundV2 adds wallet inputs worth amount to the v2 transaction txn,
+// like WalletFund.
+func (c *Client) WalletFundV2(name string, txn types.V2Transaction, amount types.Currency, strategy string, d time.Duration) (resp WalletFundResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/fund", name), WalletFundRequest{V2Transaction: &txn, Amount: amount, Duration: d, Strategy: strategy}, &resp)
+	return
+}
+
+// WalletRelease releases the wallet inputs reserved for txn.
+func (c *Client) WalletRelease(name string, txn types.Transaction) error {
+	return c.post(fmt.Sprintf("/wallets/%s/release", name), WalletReleaseRequest{Transaction: &txn}, nil)
+}
+
+// WalletReleaseV2 releases the wallet inputs reserved for the v2 transaction
+// txn.
+func (c *Client) WalletReleaseV2(name string, txn types.V2Transaction) error {
+	return c.post(fmt.Sprintf("/wallets/%s/release", name), WalletReleaseRequest{V2Transaction: &txn}, nil)
+}
+
+// WalletSign signs the inputs of txn in toSign, or every input the wallet
+// controls if toSign is empty, covering the whole transaction.
+func (c *Client) WalletSign(name string, txn types.Transaction, toSign []types.Hash256) (resp WalletSignResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/sign", name), WalletSignRequest{Transaction: &txn, ToSign: toSign}, &resp)
+	return
+}
+
+// WalletSignV2 signs the inputs of the v2 transaction txn in toSign, or
+// every input the wallet controls if toSign is empty.
+func (c *Client) WalletSignV2(name string, txn types.V2Transaction, toSign []types.Hash256) (resp WalletSignResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/sign", name), WalletSignRequest{V2Transaction: &txn, ToSign: toSign}, &resp)
+	return
+}
+
+// WalletEvents returns up to limit of the wallet's events, oldest first,
+// skipping the first offset. If label is not empty, only the events
+// involving an address with that label are returned.
+func (c *Client) WalletEvents(name string, offset, limit int, label string) (resp []wallet.Event, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/events?offset=%d&limit=%d&label=%s", name, offset, limit, url.QueryEscape(label)), &resp)
+	return
+}
+
+// WalletEvent returns the wallet event with id.
+func (c *Client) WalletEvent(name string, id types.Hash256) (resp wallet.Event, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/events/%v", name, id), &resp)
+	return
+}
+
+// WalletUnconfirmedEvents returns the events of the txpool transactions that
+// involve the wallet.
+func (c *Client) WalletUnconfirmedEvents(name string) (resp []wallet.Event, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/unconfirmed", name), &resp)
+	return
+}
+
+// WalletRescan scans the chain from height for the elements of the wallet's
+// addresses.
+func (c *Client) WalletRescan(name string, height uint64) error {
+	return c.post(fmt.Sprintf("/wallets/%s/rescan", name), WalletRescanRequest{Height: height}, nil)
+}
+
+// WalletScanStatus returns the progress of the wallet's scan.
+func (c *Client) WalletScanStatus(name string) (resp wallet.ScanStatus, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/rescan", name), &resp)
+	return
+}
+
+// WalletConstructTransaction constructs an unsigned transaction from the
+// wallet sending outputs, of the given version, selecting its inputs by
+// strategy, reserving them for d while it is signed, and attaching memo to
+// the wallet's record of it.
+func (c *Client) WalletConstructTransaction(name string, outputs []types.SiacoinOutput, strategy, memo string, version int, d time.Duration, force bool) (resp WalletConstructResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/transactions/construct?force=%t&version=%d", name, force, version), WalletConstructRequest{SiacoinOutputs: outputs, Duration: d, Strategy: strategy, Memo: memo}, &resp)
+	return
+}
+
+// WalletSubmitTransaction verifies and broadcasts a transaction constructed
+// by the wallet and signed offline.
+func (c *Client) WalletSubmitTransaction(name string, txn types.Transaction) (resp WalletSendResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/transactions/submit", name), WalletSubmitRequest{Transaction: &txn}, &resp)
+	return
+}
+
+// WalletSubmitV2Transaction verifies and broadcasts a v2 transaction
+// constructed by the wallet and signed offline.
+func (c *Client) WalletSubmitV2Transaction(name string, basis types.ChainIndex, txn types.V2Transaction) (resp WalletSendResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/transactions/submit", name), WalletSubmitRequest{Basis: basis, V2Transaction: &txn}, &resp)
+	return
+}
+
+// WalletSweep sends the elements of the first lookahead addresses derived
+// from phrase, found from height onward, to the wallet, and broadcasts the
+// transactions.
+func (c *Client) WalletSweep(name, phrase string, lookahead int, height uint64) (resp WalletSweepResponse, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/sweep", name), WalletSweepRequest{Phrase: phrase, Lookahead: lookahead, Height: height}, &resp)
+	return
+}
+
+// WalletMultisig returns the wallet's multisig addresses and their confirmed
+// elements.
+func (c *Client) WalletMultisig(name string) (resp []wallet.MultisigInfo, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/multisig", name), &resp)
+	return
+}
+
+// WalletAddMultisig adds the threshold-of-n multisig address of keys to the
+// wallet, scanning the chain for its elements from height if the wallet has
+// already applied it.
+func (c *Client) WalletAddMultisig(name string, threshold int, keys []types.PublicKey, v2 bool, height uint64) (resp wallet.MultisigAddress, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/multisig", name), WalletMultisigRequest{Threshold: threshold, PublicKeys: keys, V2: v2, Height: height}, &resp)
+	return
+}
+
+// WalletAddress returns one of the wallet's addresses.
+func (c *Client) WalletAddress(name string, addr types.Address) (resp wallet.AddressInfo, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/addresses/%v", name, addr), &resp)
+	return
+}
+
+// WalletCheckAddresses reports whether each of addrs is one of the wallet's
+// addresses.
+func (c *Client) WalletCheckAddresses(name string, addrs []types.Address) (resp []wallet.AddressCheck, err error) {
+	err = c.post(fmt.Sprintf("/wallets/%s/addresses/check", name), WalletAddressCheckRequest{Addresses: addrs}, &resp)
+	return
+}
+
+// WalletSetLabel labels one of the wallet's addresses, or removes its label
+// if label is empty.
+func (c *Client) WalletSetLabel(name string, addr types.Address, label string) error {
+	return c.put(fmt.Sprintf("/wallets/%s/addresses/%v/label", name, addr), WalletLabelRequest{Label: label})
+}
+
+// WalletSettings returns the wallet's settings.
+func (c *Client) WalletSettings(name string) (resp wallet.Settings, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/settings", name), &resp)
+	return
+}
+
+// WalletUpdateSettings replaces the wallet's settings.
+func (c *Client) WalletUpdateSettings(name string, settings wallet.Settings) error {
+	return c.put(fmt.Sprintf("/wallets/%s/settings", name), settings)
+}
+
+// WalletOutputs returns the wallet's spendable siacoin and siafund elements.
+func (c *Client) WalletOutputs(name string) (resp WalletOutputsResponse, err error) {
+	err = c.get(fmt.Sprintf("/wallets/%s/outputs", name), &resp)
+	return
+}
+
+// WalletReserveOutputs reserves the wallet elements with ids for d.
+func (c *Client) WalletReserveOutputs(name string, ids []types.Hash256, d time.Duration) error {
+	return c.post(fmt.Sprintf("/wallets/%s/outputs/reserve", name), WalletReserveRequest{IDs: ids, Duration: d}, nil)
+}
+
+// WalletReleaseOutputs releases the reserved wallet elements with ids.
+func (c *Client) WalletReleaseOutputs(name string, ids []types.Hash256) error {
+	return c.post(fmt.Sprintf("/wallets/%s/outputs/release", name), WalletReleaseOutputsRequest{IDs: ids}, nil)
+}
+
+// CreateWallet creates a seed wallet named name from phrase. If phrase is
+// empty, a new one is generated and returned.
+func (c *Client) CreateWallet(name, phrase string) (string, error) {
+	var resp WalletCreateResponse
+	err := c.req(context.Background(), http.MethodPut, fmt.Sprintf("/wallets/%s", name), WalletCreateRequest{Type: wallet.TypeSeed, Phrase: phrase}, &resp)
+	return resp.Phrase, err
+}
+
+// WalletBackup returns a backup of the wallet, encrypted with passphrase.
+func (c *Client) WalletBackup(name, passphrase string) ([]byte, error) {
+	var resp json.RawMessage
+	header := http.Header{BackupPassphraseHeader: {passphrase}}
+	err := c.reqHeader(context.Background(), http.MethodGet, fmt.Sprintf("/wallets/%s/backup", name), header, nil, &resp)
+	return resp, err
+}
+
+// RestoreWallet creates a wallet named name from a backup encrypted with
+// passphrase, scanning the chain for its elements.
+func (c *Client) RestoreWallet(name string, backup []byte, passphrase string) error {
+	header := http.Header{BackupPassphraseHeader: {passphrase}}
+	return c.reqHeader(context.Background(), http.MethodPost, fmt.Sprintf("/wallets/%s/restore", name), header, json.RawMessage(backup), nil)
+}
+
+// CreateLockedWallet creates a seed wallet named name from phrase, like
+// CreateWallet, with its seed encrypted with passphrase. The wallet is locked
+// until it is unlocked with WalletUnlock.
+func (c *Client) CreateLockedWallet(name, phrase, passphrase string) (string, error) {
+	var resp WalletCreateResponse
+	err := c.req(context.Background(), http.MethodPut, fmt.Sprintf("/wallets/%s", name), WalletCreateRequest{Type: wallet.TypeSeed, Phrase: phrase, Passphrase: passphrase}, &resp)
+	return resp.Phrase, err
+}
+
+// WalletUnlock decrypts the wallet's seed with passphrase. If timeout is
+// positive, the wallet locks itself again once it has passed.
+func (c *Client) WalletUnlock(name, passphrase string, timeout time.Duration) error {
+	return c.post(fmt.Sprintf("/wallets/%s/unlock", name), WalletUnlockRequest{Passphrase: passphrase, Timeout: timeout}, nil)
+}
+
+// WalletLock zeroes the wallet's key material until it is unlocked again.
+func (c *Client) WalletLock(name string) error {
+	return c.post(fmt.Sprintf("/wallets/%s/lock", name), nil, nil)
+}
+
+// WalletSetPassphrase encrypts the wallet's seed with passphrase, or with
+// the node's wallet password if it is empty. old is the wallet's current
+// passphrase, if it has one.
+func (c *Client) WalletSetPassphrase(name, old, passphrase string) error {
+	return c.put(fmt.Sprintf("/wallets/%s/passphrase", name), WalletPassphraseRequest{Old: old, Passphrase: passphrase})
+}
+
+// CreateWatchWallet creates a watch-only wallet named name that tracks
+// addrs, scanning for their elements from height.
+func (c *Client) CreateWatchWallet(name string, addrs []types.Address, height uint64) error {
+	return c.put(fmt.Sprintf("/wallets/%s", name), WalletCreateRequest{Type: wallet.TypeWatch, Addresses: addrs, Height: height})
+}
+
+// DeleteWallet deletes the wallet named name, along with its seed and state.
+func (c *Client) DeleteWallet(name string) error {
+	return c.delete(fmt.Sprintf("/wallets/%s", name))
+}
+
+// WalletWatch adds addrs to a watch-only wallet, scanning for their elements
+// from height.
+func (c *Client) WalletWatch(name string, addrs []types.Address, height uint64) error {
+	return c.post(fmt.Sprintf("/wallets/%s/watch", name), WalletWatchRequest{Addresses: addrs, Height: height}, nil)
+}
+
+// NewClient returns a client for the API at addr, e.g.
+// "http://localhost:9980", or "unix:///path/to/node.sock" for an API served
+// on a Unix socket. If password is non-empty, it is sent with every request.
+func NewClient(addr, password string) *Client {
+	c := &Client{
+		baseURL:  strings.TrimSuffix(addr, "/"),
+		password: password,
+		hc:       http.DefaultClient,
+	}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		// the host is ignored, but must be valid
+		c.baseURL = "http://unix"
+		c.hc = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		}
+	}
+	return c
+}
+
+// NewTokenClient returns a client for the API at addr that authenticates with
+// the given bearer token.
+func NewTokenClient(addr, token string) *Client {
+	c := NewClient(addr, "")
+	c.token = token
+	return c
+}
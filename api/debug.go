@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"go.sia.tech/jape"
+)
+
+// DebugStatsResponse is the response type for [GET] /debug/stats.
+type DebugStatsResponse struct {
+	Goroutines   int             `json:"goroutines"`
+	NumGC        int64           `json:"numGC"`
+	LastGC       time.Time       `json:"lastGC"`
+	PauseTotal   time.Duration   `json:"pauseTotal"`
+	RecentPauses []time.Duration `json:"recentPauses"`
+	HeapAlloc    uint64          `json:"heapAlloc"`
+	HeapSys      uint64          `json:"heapSys"`
+	HeapObjects  uint64          `json:"heapObjects"`
+	NextGC       uint64          `json:"nextGC"`
+	Panics       uint64          `json:"panics"`
+	Timeouts     uint64          `json:"timeouts"`
+}
+
+// handleDebugPprof serves the net/http/pprof handlers. They are mounted on
+// the API's own mux, rather than http.DefaultServeMux, so that requests are
+// subject to the API server's timeouts.
+func handleDebugPprof(jc jape.Context) {
+	w, r := jc.ResponseWriter, jc.Request
+	switch jc.PathParam("profile") {
+	case "/cmdline":
+		pprof.Cmdline(w, r)
+	case "/profile":
+		pprof.Profile(w, r)
+	case "/symbol":
+		pprof.Symbol(w, r)
+	case "/trace":
+		pprof.Trace(w, r)
+	default:
+		// Index also serves the named profiles, e.g. /debug/pprof/heap
+		pprof.Index(w, r)
+	}
+}
+
+func (s *server) handleGetDebugStats(jc jape.Context) {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	jc.Encode(DebugStatsResponse{
+		Goroutines:   runtime.NumGoroutine(),
+		NumGC:        gc.NumGC,
+		LastGC:       gc.LastGC,
+		PauseTotal:   gc.PauseTotal,
+		RecentPauses: gc.Pause,
+		HeapAlloc:    ms.HeapAlloc,
+		HeapSys:      ms.HeapSys,
+		HeapObjects:  ms.HeapObjects,
+		NextGC:       ms.NextGC,
+		Panics:       s.panics.Load(),
+		Timeouts:     s.timeouts.Load(),
+	})
+}
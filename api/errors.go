@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go.sia.tech/jape"
+	"go.uber.org/zap"
+)
+
+// writeErrorResponse writes e to w.
+func writeErrorResponse(w http.ResponseWriter, e *Error) {
+	js, _ := json.Marshal(e)
+	js = append(js, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(js)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	w.Write(js)
+}
+
+// writeError writes err to the response as an Error with the given status
+// and code, and returns it.
+func writeError(jc jape.Context, status int, code ErrorCode, err error) error {
+	e := &Error{Status: status, Code: code, Message: err.Error()}
+	writeErrorResponse(jc.ResponseWriter, e)
+	return e
+}
+
+// check is like jape.Context.Check, but only msg is returned to the client;
+// the error itself is logged, since it may reveal details of the host such
+// as file paths.
+func (s *server) check(jc jape.Context, msg string, err error) error {
+	if err == nil {
+		return nil
+	}
+	s.log.Error(msg, zap.String("route", requestRoute(jc.Request)), zap.Error(err))
+	writeErrorResponse(jc.ResponseWriter, &Error{Status: http.StatusInternalServerError, Code: ErrorCodeInternal, Message: msg})
+	return err
+}
+
+// A captureWriter discards the response written by jape's decoding methods,
+// recording only its status, so that the error can be rewritten as an Error.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (cw *captureWriter) WriteHeader(status int)      { cw.status = status }
+func (cw *captureWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+// rewriteError calls fn, rewriting any error it writes as an Error with the
+// given code.
+func rewriteError(jc jape.Context, code ErrorCode, fn func(jape.Context) error) error {
+	cw := &captureWriter{ResponseWriter: jc.ResponseWriter}
+	err := fn(jape.Context{ResponseWriter: cw, Request: jc.Request, PathParams: jc.PathParams})
+	if err == nil {
+		return nil
+	} else if cw.status == http.StatusRequestEntityTooLarge {
+		code = ErrorCodeRequestTooLarge
+	}
+	return writeError(jc, cw.status, code, err)
+}
+
+// decode is jape.Context.Decode, writing errors as an Error.
+func decode(jc jape.Context, v any) error {
+	return rewriteError(jc, ErrorCodeInvalidRequest, func(jc jape.Context) error { return jc.Decode(v) })
+}
+
+// decodeParam is jape.Context.DecodeParam, writing errors as an Error.
+func decodeParam(jc jape.Context, param string, v any) error {
+	return rewriteError(jc, ErrorCodeInvalidParameter, func(jc jape.Context) error { return jc.DecodeParam(param, v) })
+}
+
+// decodeForm is jape.Context.DecodeForm, writing errors as an Error.
+func decodeForm(jc jape.Context, key string, v any) error {
+	return rewriteError(jc, ErrorCodeInvalidParameter, func(jc jape.Context) error { return jc.DecodeForm(key, v) })
+}
+
+// notFound and methodNotAllowed replace the router's plain-text responses.
+var (
+	notFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeErrorResponse(w, &Error{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: "no route matches " + req.URL.Path})
+	})
+	methodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeErrorResponse(w, &Error{Status: http.StatusMethodNotAllowed, Code: ErrorCodeMethodNotAllowed, Message: "method " + req.Method + " is not allowed"})
+	})
+)
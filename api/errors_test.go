@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.sia.tech/node/internal/wallet"
+	"go.uber.org/zap"
+)
+
+// checkError decodes an Error from a response, checking that its shape
+// matches the response's status.
+func checkError(t *testing.T, status int, header http.Header, body []byte, wantStatus int, wantCode ErrorCode) Error {
+	t.Helper()
+	var apiErr Error
+	if status != wantStatus {
+		t.Fatalf("expected %v, got %v: %s", wantStatus, status, body)
+	} else if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON, got %q", ct)
+	} else if err := json.Unmarshal(body, &apiErr); err != nil {
+		t.Fatalf("failed to decode error %q: %v", body, err)
+	} else if apiErr.Status != wantStatus {
+		t.Fatalf("expected status %v in body, got %v", wantStatus, apiErr.Status)
+	} else if apiErr.Code != wantCode {
+		t.Fatalf("expected code %q, got %q (%v)", wantCode, apiErr.Code, apiErr.Message)
+	} else if apiErr.Message == "" {
+		t.Fatal("expected a message")
+	}
+	return apiErr
+}
+
+func TestErrorResponses(t *testing.T) {
+	missingID := types.BlockID{1}.String()
+	tests := []struct {
+		name   string
+		opts   []ServerOption
+		method string
+		path   string
+		body   any
+		header http.Header
+		status int
+		code   ErrorCode
+	}{
+		{name: "unknown route", method: http.MethodGet, path: "/nope", status: http.StatusNotFound, code: ErrorCodeNotFound},
+		{name: "wrong method", method: http.MethodDelete, path: "/consensus/tip", status: http.StatusMethodNotAllowed, code: ErrorCodeMethodNotAllowed},
+		{name: "malformed parameter", method: http.MethodGet, path: "/consensus/blocks/nope", status: http.StatusBadRequest, code: ErrorCodeInvalidParameter},
+		{name: "malformed query", method: http.MethodGet, path: "/consensus/tip?since=nope", status: http.StatusBadRequest, code: ErrorCodeInvalidParameter},
+		{name: "malformed body", method: http.MethodPost, path: "/consensus/blocks", body: "nope", status: http.StatusBadRequest, code: ErrorCodeInvalidRequest},
+		{name: "oversized body", opts: []ServerOption{WithMaxRequestSizes(16, 16)}, method: http.MethodPost, path: "/consensus/blocks", body: types.Block{}, status: http.StatusRequestEntityTooLarge, code: ErrorCodeRequestTooLarge},
+		{name: "missing block", method: http.MethodGet, path: "/consensus/blocks/" + missingID, status: http.StatusNotFound, code: ErrorCodeBlockNotFound},
+		{name: "missing index", method: http.MethodGet, path: "/consensus/index/1000", status: http.StatusNotFound, code: ErrorCodeIndexNotFound},
+		{name: "missing transaction", method: http.MethodGet, path: "/txpool/transactions/" + types.TransactionID{1}.String(), status: http.StatusNotFound, code: ErrorCodeTransactionNotFound},
+		{name: "unknown parent", method: http.MethodPost, path: "/consensus/validate", body: ConsensusValidateRequest{Block: &types.Block{ParentID: types.BlockID{1}}}, status: http.StatusConflict, code: ErrorCodeUnknownParent},
+		{name: "invalid address", method: http.MethodPost, path: "/syncer/connect", body: SyncerConnectRequest{Address: "nope"}, status: http.StatusBadRequest, code: ErrorCodeInvalidAddress},
+		{name: "no syncer", method: http.MethodPost, path: "/syncer/connect", body: SyncerConnectRequest{Address: "127.0.0.1:9981"}, status: http.StatusServiceUnavailable, code: ErrorCodeNoSyncer},
+		{name: "no wallets", method: http.MethodGet, path: "/wallets", status: http.StatusServiceUnavailable, code: ErrorCodeNotEnabled},
+		{name: "read-only", opts: []ServerOption{WithReadOnly(true)}, method: http.MethodPost, path: "/syncer/connect", body: SyncerConnectRequest{Address: "127.0.0.1:9981"}, status: http.StatusMethodNotAllowed, code: ErrorCodeReadOnly},
+		{name: "no credentials", opts: []ServerOption{WithPassword("foo")}, method: http.MethodGet, path: "/consensus/tip", status: http.StatusUnauthorized, code: ErrorCodeUnauthorized},
+		{name: "insufficient scope", opts: []ServerOption{WithTokens([]Token{{Token: "foo", Scope: ScopeRead}})}, method: http.MethodPut, path: "/log/level", body: LogLevelRequest{Level: "debug"}, header: http.Header{"Authorization": {"Bearer foo"}}, status: http.StatusForbidden, code: ErrorCodeForbidden},
+		{name: "refused origin", opts: []ServerOption{WithCORSOrigins([]string{"https://wallet.example"})}, method: http.MethodOptions, path: "/consensus/tip", header: http.Header{"Origin": {"https://evil.example"}, "Access-Control-Request-Method": {http.MethodGet}}, status: http.StatusForbidden, code: ErrorCodeOriginNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, srv := newTestServer(t, test.opts...)
+			resp, body := doRequest(t, test.method, srv.URL+test.path, test.body, test.header)
+			checkError(t, resp.StatusCode, resp.Header, body, test.status, test.code)
+		})
+	}
+}
+
+func TestErrorRateLimited(t *testing.T) {
+	_, srv := newTestServer(t, WithRateLimits(RateLimit{Rate: 0.001, Burst: 1}, RateLimit{}))
+	if resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/tip", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	}
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/tip", nil, nil)
+	checkError(t, resp.StatusCode, resp.Header, body, http.StatusTooManyRequests, ErrorCodeRateLimited)
+}
+
+func TestErrorRateLimitedUnauthorized(t *testing.T) {
+	_, srv := newTestServer(t,
+		WithTokens([]Token{{Token: "admin", Scope: ScopeAdmin}}),
+		WithRateLimits(RateLimit{Rate: 0.001, Burst: 2}, RateLimit{}))
+
+	// requests with wrong credentials are rejected until they exhaust the
+	// limit
+	bad := http.Header{"Authorization": {"Bearer nope"}}
+	for range 2 {
+		resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/tip", nil, bad)
+		checkError(t, resp.StatusCode, resp.Header, body, http.StatusUnauthorized, ErrorCodeUnauthorized)
+	}
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/tip", nil, bad)
+	checkError(t, resp.StatusCode, resp.Header, body, http.StatusTooManyRequests, ErrorCodeRateLimited)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+
+	// admin requests are still not limited
+	admin := http.Header{"Authorization": {"Bearer admin"}}
+	if resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/tip", nil, admin); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	}
+}
+
+func TestWalletErrors(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{wallet.ErrNotFound, http.StatusNotFound, ErrorCodeWalletNotFound},
+		{wallet.ErrInvalidName, http.StatusBadRequest, ErrorCodeInvalidParameter},
+		{wallet.ErrExists, http.StatusConflict, ErrorCodeWalletExists},
+		{wallet.ErrInvalidSeed, http.StatusBadRequest, ErrorCodeInvalidSeed},
+		{wallet.ErrNotEnoughFunds, http.StatusBadRequest, ErrorCodeNotEnoughFunds},
+		{wallet.ErrDustChange, http.StatusBadRequest, ErrorCodeDustChange},
+		{wallet.ErrInvalidVersion, http.StatusBadRequest, ErrorCodeInvalidVersion},
+		{wallet.ErrDataTooLarge, http.StatusBadRequest, ErrorCodeDataTooLarge},
+		{wallet.ErrNothingToConsolidate, http.StatusBadRequest, ErrorCodeNothingToConsolidate},
+		{wallet.ErrUneconomical, http.StatusBadRequest, ErrorCodeUneconomical},
+		{wallet.ErrNotPending, http.StatusNotFound, ErrorCodeNotPending},
+		{wallet.ErrNotWalletTransaction, http.StatusBadRequest, ErrorCodeNotWalletTransaction},
+		{wallet.ErrNoChangeOutput, http.StatusBadRequest, ErrorCodeNoChangeOutput},
+		{wallet.ErrFeeSufficient, http.StatusBadRequest, ErrorCodeFeeSufficient},
+		{wallet.ErrOutputNotFound, http.StatusNotFound, ErrorCodeOutputNotFound},
+		{wallet.ErrOutputReserved, http.StatusConflict, ErrorCodeOutputReserved},
+		{wallet.ErrReservationLost, http.StatusConflict, ErrorCodeReservationLost},
+		{wallet.ErrWatchOnly, http.StatusBadRequest, ErrorCodeWatchOnly},
+		{wallet.ErrNotWatchOnly, http.StatusBadRequest, ErrorCodeNotWatchOnly},
+		{wallet.ErrScanInProgress, http.StatusConflict, ErrorCodeScanInProgress},
+		{wallet.ErrInvalidSignature, http.StatusBadRequest, ErrorCodeInvalidSignature},
+		{wallet.ErrEventNotFound, http.StatusNotFound, ErrorCodeEventNotFound},
+		{wallet.ErrInvalidMultisig, http.StatusBadRequest, ErrorCodeInvalidMultisig},
+		{wallet.ErrInvalidGapLimit, http.StatusBadRequest, ErrorCodeInvalidGapLimit},
+		{wallet.ErrInvalidBackup, http.StatusBadRequest, ErrorCodeInvalidBackup},
+		{wallet.ErrWrongPassphrase, http.StatusBadRequest, ErrorCodeWrongPassphrase},
+		{wallet.ErrAddressNotFound, http.StatusNotFound, ErrorCodeAddressNotFound},
+		{wallet.ErrInvalidLabel, http.StatusBadRequest, ErrorCodeInvalidLabel},
+		{wallet.ErrInvalidStrategy, http.StatusBadRequest, ErrorCodeInvalidStrategy},
+		{wallet.ErrNoWalletKey, http.StatusBadRequest, ErrorCodeNoWalletKey},
+		{wallet.ErrLocked, http.StatusLocked, ErrorCodeWalletLocked},
+		{wallet.ErrNoPassphrase, http.StatusBadRequest, ErrorCodeNoPassphrase},
+		{wallet.ErrTooManyAttempts, http.StatusTooManyRequests, ErrorCodeTooManyAttempts},
+		{wallet.ErrSigner, http.StatusBadGateway, ErrorCodeSignerFailed},
+	}
+	s := &server{log: zap.NewNop()}
+	for _, test := range tests {
+		t.Run(string(test.code), func(t *testing.T) {
+			// wallet errors are usually wrapped with more context
+			err := fmt.Errorf("failed to do something: %w", test.err)
+			rec := httptest.NewRecorder()
+			jc := jape.Context{ResponseWriter: rec, Request: httptest.NewRequest(http.MethodGet, "/", nil)}
+			if !s.walletError(jc, "failed", err) {
+				t.Fatal("expected an error to be written")
+			}
+			apiErr := checkError(t, rec.Code, rec.Header(), rec.Body.Bytes(), test.status, test.code)
+			if apiErr.Message != err.Error() {
+				t.Fatalf("expected message %q, got %q", err, apiErr.Message)
+			}
+		})
+	}
+
+	// other errors are internal, and their details are logged rather than
+	// returned
+	rec := httptest.NewRecorder()
+	jc := jape.Context{ResponseWriter: rec, Request: httptest.NewRequest(http.MethodGet, "/", nil)}
+	s.walletError(jc, "failed to save wallet", errors.New("open /home/user/.sia/wallet.json: permission denied"))
+	apiErr := checkError(t, rec.Code, rec.Header(), rec.Body.Bytes(), http.StatusInternalServerError, ErrorCodeInternal)
+	if strings.Contains(apiErr.Message, "/home") {
+		t.Fatalf("internal error leaked a path: %q", apiErr.Message)
+	}
+}
@@ -0,0 +1,568 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.sia.tech/node/internal/txpool"
+)
+
+// Event types emitted by the event broker.
+const (
+	EventTypeTip   = "tip"
+	EventTypeReorg = "reorg"
+
+	EventTypeTxpoolAdded   = "txpoolAdded"
+	EventTypeTxpoolRemoved = "txpoolRemoved"
+)
+
+// Reasons a transaction was removed from the txpool.
+const (
+	TxpoolRemovedConfirmed   = "confirmed"
+	TxpoolRemovedInvalidated = "invalidated"
+)
+
+const (
+	// subscriberBufferSize is the number of events queued for a subscriber
+	// before it is considered too slow and dropped.
+	subscriberBufferSize = 16
+	// eventHistorySize is the number of recent events kept so that
+	// reconnecting clients can catch up on events they missed.
+	eventHistorySize = 1000
+
+	// wsPingInterval is the interval between keepalive pings sent to
+	// WebSocket subscribers.
+	wsPingInterval = 30 * time.Second
+	// wsWriteTimeout is the maximum amount of time allowed to write a single
+	// message or ping to a WebSocket subscriber.
+	wsWriteTimeout = 10 * time.Second
+	// sseKeepaliveInterval is the interval between keepalive comments sent
+	// to Server-Sent Events subscribers.
+	sseKeepaliveInterval = 30 * time.Second
+
+	// maxConfirmationSearch is the maximum number of blocks searched for
+	// transactions that left the txpool, to determine whether they were
+	// confirmed.
+	maxConfirmationSearch = 1000
+
+	// throughputWindow is the period over which applied blocks are counted
+	// to estimate sync throughput.
+	throughputWindow = time.Minute
+)
+
+type (
+	event struct {
+		id   uint64
+		typ  string
+		data any
+	}
+
+	// appliedBlocks records the number of blocks added to the best chain by
+	// a single reorg.
+	appliedBlocks struct {
+		timestamp time.Time
+		n         uint64
+	}
+
+	subscriber struct {
+		events chan event
+		// dropped is closed when the subscriber is removed because it could
+		// not keep up with events.
+		dropped chan struct{}
+	}
+
+	// eventBroker fans out chain events to subscribers without ever blocking
+	// the chain manager's callbacks. It keeps a bounded history of recent
+	// events so that subscribers can resume from a known event ID.
+	eventBroker struct {
+		chain ChainManager
+
+		mu          sync.Mutex
+		tip         types.ChainIndex
+		nextID      uint64
+		history     []event // ring buffer indexed by event ID
+		subscribers map[*subscriber]struct{}
+		applied     []appliedBlocks // within the last throughputWindow
+
+		// poolMu serializes pool diffs, which are computed without holding
+		// mu since they query the chain manager.
+		poolMu    sync.Mutex
+		pool      map[types.TransactionID]poolEntry
+		poolTip   types.ChainIndex
+		poolStats poolStats // guarded by mu
+	}
+
+	// A poolEntry caches data about a transaction in the pool, so that
+	// transactions are only encoded once no matter how often the pool
+	// changes.
+	poolEntry struct {
+		event  TxpoolEvent
+		size   uint64
+		weight uint64
+		fee    types.Currency
+		added  time.Time
+	}
+
+	poolStats struct {
+		count     int
+		size      uint64
+		fees      types.Currency
+		histogram []TxpoolFeeBucket
+		oldest    time.Time
+	}
+)
+
+// feeHistogramBounds are the lower bounds, in Hastings per byte of weight, of
+// the buckets of the txpool's fee density histogram after the first bucket,
+// which starts at zero.
+var feeHistogramBounds = []types.Currency{
+	types.Siacoins(1).Div64(1e6),
+	types.Siacoins(1).Div64(1e5), // the minimum recommended fee
+	types.Siacoins(1).Div64(1e4),
+	types.Siacoins(1).Div64(1e3),
+	types.Siacoins(1).Div64(1e2),
+}
+
+func (eb *eventBroker) publish(typ string, data any) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.publishLocked(typ, data)
+}
+
+func (eb *eventBroker) publishLocked(typ string, data any) {
+	eb.nextID++
+	ev := event{id: eb.nextID, typ: typ, data: data}
+	eb.history[ev.id%eventHistorySize] = ev
+
+	for sub := range eb.subscribers {
+		select {
+		case sub.events <- ev:
+		default:
+			// drop slow consumers rather than block the publisher
+			delete(eb.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+func (eb *eventBroker) onReorg(tip types.ChainIndex) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	// the previous tip is no longer on the best chain if a reorg occurred
+	prev := eb.tip
+	bestPrev, ok := eb.chain.BestIndex(prev.Height)
+	eb.tip = tip
+	if tip.Height > prev.Height {
+		eb.applied = append(eb.applied, appliedBlocks{time.Now(), tip.Height - prev.Height})
+	}
+	eb.pruneApplied()
+	ev := TipEvent{
+		Reorg:       !ok || bestPrev != prev,
+		PreviousTip: prev,
+		Tip:         tip,
+	}
+	if ev.Reorg {
+		eb.publishLocked(EventTypeReorg, ev)
+	} else {
+		eb.publishLocked(EventTypeTip, ev)
+	}
+}
+
+func v1Addresses(txn types.Transaction) (addrs []types.Address) {
+	for _, sci := range txn.SiacoinInputs {
+		addrs = append(addrs, sci.UnlockConditions.UnlockHash())
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		addrs = append(addrs, sco.Address)
+	}
+	for _, sfi := range txn.SiafundInputs {
+		addrs = append(addrs, sfi.UnlockConditions.UnlockHash(), sfi.ClaimAddress)
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		addrs = append(addrs, sfo.Address)
+	}
+	return dedupAddresses(addrs)
+}
+
+func v2Addresses(txn types.V2Transaction) (addrs []types.Address) {
+	for _, sci := range txn.SiacoinInputs {
+		addrs = append(addrs, sci.Parent.SiacoinOutput.Address)
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		addrs = append(addrs, sco.Address)
+	}
+	for _, sfi := range txn.SiafundInputs {
+		addrs = append(addrs, sfi.Parent.SiafundOutput.Address, sfi.ClaimAddress)
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		addrs = append(addrs, sfo.Address)
+	}
+	return dedupAddresses(addrs)
+}
+
+func dedupAddresses(addrs []types.Address) []types.Address {
+	seen := make(map[types.Address]bool)
+	deduped := make([]types.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			deduped = append(deduped, addr)
+		}
+	}
+	return deduped
+}
+
+// poolSnapshot returns the transactions currently in the pool, in pool
+// order. Entries for transactions in prev are reused.
+func (eb *eventBroker) poolSnapshot(prev map[types.TransactionID]poolEntry) ([]types.TransactionID, map[types.TransactionID]poolEntry) {
+	cs := eb.chain.TipState()
+	txns, v2txns := eb.chain.PoolTransactions(), eb.chain.V2PoolTransactions()
+	ids := make([]types.TransactionID, 0, len(txns)+len(v2txns))
+	pool := make(map[types.TransactionID]poolEntry, len(txns)+len(v2txns))
+	now := time.Now()
+	for _, txn := range txns {
+		id := txn.ID()
+		ids = append(ids, id)
+		if pe, ok := prev[id]; ok {
+			pool[id] = pe
+			continue
+		}
+		pe := poolEntry{
+			event:  TxpoolEvent{ID: id, Version: 1, Addresses: v1Addresses(txn)},
+			size:   txpool.EncodedSize(txn),
+			weight: cs.TransactionWeight(txn),
+			added:  now,
+		}
+		for _, fee := range txn.MinerFees {
+			pe.fee = pe.fee.Add(fee)
+		}
+		pool[id] = pe
+	}
+	for _, txn := range v2txns {
+		id := txn.ID()
+		ids = append(ids, id)
+		if pe, ok := prev[id]; ok {
+			pool[id] = pe
+			continue
+		}
+		pool[id] = poolEntry{
+			event:  TxpoolEvent{ID: id, Version: 2, Addresses: v2Addresses(txn)},
+			size:   txpool.EncodedSize(txn),
+			weight: cs.V2TransactionWeight(txn),
+			fee:    txn.MinerFee,
+			added:  now,
+		}
+	}
+	return ids, pool
+}
+
+func computePoolStats(pool map[types.TransactionID]poolEntry) poolStats {
+	stats := poolStats{
+		count:     len(pool),
+		histogram: make([]TxpoolFeeBucket, len(feeHistogramBounds)+1),
+	}
+	for i, bound := range feeHistogramBounds {
+		stats.histogram[i+1].Min = bound
+	}
+	for _, pe := range pool {
+		stats.size += pe.size
+		stats.fees = stats.fees.Add(pe.fee)
+		if stats.oldest.IsZero() || pe.added.Before(stats.oldest) {
+			stats.oldest = pe.added
+		}
+		density := pe.fee.Div64(max(pe.weight, 1))
+		i := len(feeHistogramBounds)
+		for i > 0 && density.Cmp(feeHistogramBounds[i-1]) < 0 {
+			i--
+		}
+		stats.histogram[i].Transactions++
+		stats.histogram[i].Weight += pe.weight
+	}
+	return stats
+}
+
+// txpoolStats returns the cached statistics for the txpool.
+func (eb *eventBroker) txpoolStats() poolStats {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return eb.poolStats
+}
+
+// confirmedSince returns the IDs of the transactions in blocks that were
+// added to the best chain between prev and tip.
+func (eb *eventBroker) confirmedSince(prev, tip types.ChainIndex) map[types.TransactionID]bool {
+	confirmed := make(map[types.TransactionID]bool)
+	// walk both chains back to their common ancestor
+	for i := 0; tip != prev && i < maxConfirmationSearch; i++ {
+		if tip.Height >= prev.Height {
+			b, ok := eb.chain.Block(tip.ID)
+			if !ok {
+				break
+			}
+			for _, txn := range b.Transactions {
+				confirmed[txn.ID()] = true
+			}
+			for _, txn := range b.V2Transactions() {
+				confirmed[txn.ID()] = true
+			}
+			tip = types.ChainIndex{ID: b.ParentID, Height: tip.Height - 1}
+		} else {
+			b, ok := eb.chain.Block(prev.ID)
+			if !ok {
+				break
+			}
+			prev = types.ChainIndex{ID: b.ParentID, Height: prev.Height - 1}
+		}
+	}
+	return confirmed
+}
+
+func (eb *eventBroker) onPoolChange() {
+	eb.poolMu.Lock()
+	defer eb.poolMu.Unlock()
+
+	ids, pool := eb.poolSnapshot(eb.pool)
+	tip := eb.chain.Tip()
+	var added, removed []TxpoolEvent
+	for _, id := range ids {
+		if _, ok := eb.pool[id]; !ok {
+			added = append(added, pool[id].event)
+		}
+	}
+	var confirmed map[types.TransactionID]bool
+	for id, pe := range eb.pool {
+		if _, ok := pool[id]; ok {
+			continue
+		} else if confirmed == nil {
+			confirmed = eb.confirmedSince(eb.poolTip, tip)
+		}
+		ev := pe.event
+		ev.Reason = TxpoolRemovedInvalidated
+		if confirmed[id] {
+			ev.Reason = TxpoolRemovedConfirmed
+		}
+		removed = append(removed, ev)
+	}
+	eb.pool, eb.poolTip = pool, tip
+	stats := computePoolStats(pool)
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.poolStats = stats
+	for _, ev := range removed {
+		eb.publishLocked(EventTypeTxpoolRemoved, ev)
+	}
+	for _, ev := range added {
+		eb.publishLocked(EventTypeTxpoolAdded, ev)
+	}
+}
+
+func (eb *eventBroker) pruneApplied() {
+	cutoff := time.Now().Add(-throughputWindow)
+	i := 0
+	for i < len(eb.applied) && eb.applied[i].timestamp.Before(cutoff) {
+		i++
+	}
+	eb.applied = eb.applied[i:]
+}
+
+// recentBlocks returns the number of blocks added to the best chain within
+// the last throughputWindow.
+func (eb *eventBroker) recentBlocks() (n uint64) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.pruneApplied()
+	for _, ab := range eb.applied {
+		n += ab.n
+	}
+	return n
+}
+
+// subscribe adds a new subscriber. Any events in the history with an ID
+// greater than lastID are returned so they can be delivered before new
+// events. The returned function must be called to remove the subscriber.
+func (eb *eventBroker) subscribe(lastID uint64) (*subscriber, []event, func()) {
+	sub := &subscriber{
+		events:  make(chan event, subscriberBufferSize),
+		dropped: make(chan struct{}),
+	}
+
+	eb.mu.Lock()
+	var backlog []event
+	if lastID < eb.nextID {
+		oldest := uint64(1)
+		if eb.nextID > eventHistorySize {
+			oldest = eb.nextID - eventHistorySize + 1
+		}
+		for id := max(lastID+1, oldest); id <= eb.nextID; id++ {
+			backlog = append(backlog, eb.history[id%eventHistorySize])
+		}
+	}
+	eb.subscribers[sub] = struct{}{}
+	eb.mu.Unlock()
+
+	return sub, backlog, func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+		if _, ok := eb.subscribers[sub]; ok {
+			delete(eb.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+// newEventBroker returns a broker of cm's events, which stops receiving them
+// when ctx is done.
+func newEventBroker(ctx context.Context, cm ChainManager) *eventBroker {
+	eb := &eventBroker{
+		chain:       cm,
+		tip:         cm.Tip(),
+		history:     make([]event, eventHistorySize),
+		subscribers: make(map[*subscriber]struct{}),
+		poolTip:     cm.Tip(),
+	}
+	_, eb.pool = eb.poolSnapshot(nil)
+	eb.poolStats = computePoolStats(eb.pool)
+	stopReorg := cm.OnReorg(eb.onReorg)
+	stopPool := cm.OnPoolChange(eb.onPoolChange)
+	context.AfterFunc(ctx, func() {
+		stopReorg()
+		stopPool()
+	})
+	return eb
+}
+
+// serveWebSocket upgrades the request to a WebSocket and sends the data of
+// each event whose type is in eventTypes until the client disconnects or falls
+// behind.
+func (s *server) serveWebSocket(jc jape.Context, eventTypes ...string) {
+	// the server's read deadline is inherited by the hijacked connection
+	rc := http.NewResponseController(jc.ResponseWriter)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		s.check(jc, "failed to clear read deadline", err)
+		return
+	}
+
+	conn, err := websocket.Accept(jc.ResponseWriter, jc.Request, nil)
+	if err != nil {
+		return // Accept has already written an error response
+	}
+	defer conn.CloseNow()
+
+	sub, _, unsubscribe := s.events.subscribe(^uint64(0))
+	defer unsubscribe()
+
+	// subscribers only receive events, but reading is required to process
+	// control frames such as pongs and close messages.
+	ctx := conn.CloseRead(jc.Request.Context())
+
+	write := func(fn func(context.Context) error) bool {
+		ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+		defer cancel()
+		return fn(ctx) == nil
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusGoingAway, "")
+			return
+		case <-sub.dropped:
+			conn.Close(websocket.StatusPolicyViolation, "subscriber too slow")
+			return
+		case <-ping.C:
+			if !write(conn.Ping) {
+				return
+			}
+		case ev := <-sub.events:
+			if !slices.Contains(eventTypes, ev.typ) {
+				continue
+			} else if !write(func(ctx context.Context) error { return wsjson.Write(ctx, conn, ev.data) }) {
+				return
+			}
+		}
+	}
+}
+
+func (s *server) handleGetConsensusSubscribe(jc jape.Context) {
+	s.serveWebSocket(jc, EventTypeTip, EventTypeReorg)
+}
+
+func (s *server) handleGetTxpoolSubscribe(jc jape.Context) {
+	s.serveWebSocket(jc, EventTypeTxpoolAdded, EventTypeTxpoolRemoved)
+}
+
+func (s *server) handleGetEvents(jc jape.Context) {
+	var lastID uint64
+	if str := jc.Request.Header.Get("Last-Event-ID"); str != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(str, 10, 64); err != nil {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("invalid Last-Event-ID: %w", err))
+			return
+		}
+	} else {
+		// new clients only receive events from now on
+		lastID = ^uint64(0)
+	}
+
+	sub, backlog, unsubscribe := s.events.subscribe(lastID)
+	defer unsubscribe()
+
+	w := jc.ResponseWriter
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev event) bool {
+		buf, err := json.Marshal(ev.data)
+		if err != nil {
+			panic(err) // should never happen
+		} else if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.id, ev.typ, buf); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-jc.Request.Context().Done():
+			// client disconnected or the server is shutting down
+			return
+		case <-sub.dropped:
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case ev := <-sub.events:
+			if !send(ev) {
+				return
+			}
+		}
+	}
+}
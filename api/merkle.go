@@ -0,0 +1,90 @@
+package api
+
+import (
+	"math/bits"
+
+	"go.sia.tech/core/blake2b"
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// commitmentLeaves returns the leaves of the Merkle tree committed to by the
+// block's header. For v1 blocks, the leaves are the miner payouts followed by
+// the transactions. For v2 blocks, the leaves are the parent state followed
+// by the v1 and v2 transactions.
+func commitmentLeaves(parent consensus.State, b types.Block) []types.Hash256 {
+	var leaves []types.Hash256
+	if b.V2 == nil {
+		h := types.NewHasher()
+		for _, mp := range b.MinerPayouts {
+			h.Reset()
+			h.E.WriteUint8(0) // leaf hash prefix
+			types.V1SiacoinOutput(mp).EncodeTo(h.E)
+			leaves = append(leaves, h.Sum())
+		}
+	} else {
+		leaves = append(leaves, parent.MerkleLeafHash(b.MinerPayouts[0].Address))
+	}
+	for i := range b.Transactions {
+		leaves = append(leaves, b.Transactions[i].MerkleLeafHash())
+	}
+	for i := range b.V2Transactions() {
+		leaves = append(leaves, b.V2.Transactions[i].MerkleLeafHash())
+	}
+	return leaves
+}
+
+// merkleRoot returns the root of the Merkle tree with the given leaves.
+func merkleRoot(leaves []types.Hash256) types.Hash256 {
+	var acc blake2b.Accumulator
+	for _, leaf := range leaves {
+		acc.AddLeaf(leaf)
+	}
+	return acc.Root()
+}
+
+// merkleProof returns the sibling hashes, ordered from the leaf to the root,
+// needed to prove the inclusion of the leaf at index i. The tree is split in
+// the same way as RFC 6962, which matches the shape of blake2b.Accumulator.
+func merkleProof(leaves []types.Hash256, i int) []types.Hash256 {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	// split at the largest power of two smaller than the number of leaves
+	k := 1 << (bits.Len(uint(len(leaves)-1)) - 1)
+	if i < k {
+		return append(merkleProof(leaves[:k], i), merkleRoot(leaves[k:]))
+	}
+	return append(merkleProof(leaves[k:], i-k), merkleRoot(leaves[:k]))
+}
+
+// VerifyTransactionProof reports whether proof demonstrates that a transaction
+// with the given Merkle leaf hash is included in the block with the given ID.
+// The leaf hash is returned by the MerkleLeafHash method of both
+// types.Transaction and types.V2Transaction.
+func VerifyTransactionProof(blockID types.BlockID, leafHash types.Hash256, proof TransactionProof) bool {
+	if proof.Header.ID() != blockID || proof.LeafIndex >= proof.NumLeaves {
+		return false
+	}
+
+	// RFC 9162, section 2.1.3.2
+	fn, sn := proof.LeafIndex, proof.NumLeaves-1
+	root := leafHash
+	for _, sibling := range proof.Siblings {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			root = blake2b.SumPair(sibling, root)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			root = blake2b.SumPair(root, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && root == proof.Header.Commitment
+}
@@ -0,0 +1,524 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+// immutableCacheControl is the Cache-Control value for responses that can
+// never change, such as blocks requested by ID.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// A cacheWriter sets caching headers on successful responses only, so that
+// errors such as 404s are never cached. If the client already has the
+// response, a successful response is replaced with 304 Not Modified.
+type cacheWriter struct {
+	http.ResponseWriter
+	etag         string
+	cacheControl string
+	notModified  bool // the request's If-None-Match header matches etag
+	wroteHeader  bool
+	discard      bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if code == http.StatusOK {
+			cw.Header().Set("ETag", cw.etag)
+			cw.Header().Set("Cache-Control", cw.cacheControl)
+			if cw.notModified {
+				cw.Header().Del("Content-Type")
+				cw.Header().Del("Content-Length")
+				code, cw.discard = http.StatusNotModified, true
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.discard {
+		return len(b), nil
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use by
+// http.ResponseController.
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// etagMatches reports whether the If-None-Match header matches etag, using
+// the weak comparison required by RFC 9110.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceETag returns an entity tag for version of the resource identified
+// by the request's path and query, so that the tag of one response is never
+// shared with a different one.
+func resourceETag(req *http.Request, version string) string {
+	h := types.HashBytes([]byte(version + " " + req.URL.Path + "?" + req.URL.Query().Encode()))
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// withETag calls h, adding the etag and cacheControl headers to a successful
+// response. A successful response is replaced with 304 Not Modified if the
+// request's If-None-Match header matches etag; errors, such as a 404 for a
+// block that doesn't exist, are always served in full.
+func withETag(jc jape.Context, etag, cacheControl string, h jape.Handler) {
+	jc.ResponseWriter = &cacheWriter{
+		ResponseWriter: jc.ResponseWriter,
+		etag:           etag,
+		cacheControl:   cacheControl,
+		notModified:    etagMatches(jc.Request.Header.Get("If-None-Match"), etag),
+	}
+	h(jc)
+}
+
+// tipCached wraps a handler whose response is derived from the current tip.
+// Its ETag changes whenever the tip does, and clients must revalidate on
+// every request.
+func (s *server) tipCached(h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		// long-poll requests wait for a new tip rather than revalidating
+		if jc.Request.FormValue("since") != "" {
+			h(jc)
+			return
+		}
+		withETag(jc, resourceETag(jc.Request, s.chain.Tip().ID.String()), "no-cache", h)
+	}
+}
+
+// immutable wraps a handler whose response is fully determined by the
+// named path parameter, such as a block ID. The response may be cached
+// indefinitely.
+func immutable(param string, h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		withETag(jc, resourceETag(jc.Request, jc.PathParam(param)), immutableCacheControl, h)
+	}
+}
+
+const (
+	// corsMaxAge is the number of seconds that browsers may cache the result
+	// of a CORS preflight request.
+	corsMaxAge = "7200"
+	// corsAllowMethods and corsAllowHeaders are the methods and request
+	// headers permitted in cross-origin requests.
+	corsAllowMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type, If-None-Match, Last-Event-ID, " + BackupPassphraseHeader
+)
+
+// withCORS wraps h, adding CORS headers to requests from the allowed
+// origins and answering preflight requests. An origin of "*" allows any
+// origin, but only exact origins may make credentialed requests, since
+// browsers reject credentials combined with a wildcard.
+func withCORS(origins []string, h http.Handler) http.Handler {
+	exact := make(map[string]bool)
+	var wildcard bool
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			wildcard = true
+		} else {
+			exact[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		// the response depends on the origin, so it must not be shared
+		// between origins by caches
+		w.Header().Add("Vary", "Origin")
+		preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+		switch {
+		case exact[origin]:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case wildcard:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case preflight:
+			writeErrorResponse(w, &Error{Status: http.StatusForbidden, Code: ErrorCodeOriginNotAllowed, Message: "origin not allowed"})
+			return
+		default:
+			// without CORS headers, the browser will reject the response
+			h.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Total-Count")
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// minCompressSize is the minimum size of a response body for it to be
+// compressed; smaller responses fit in a single packet regardless.
+const minCompressSize = 1400
+
+var gzipPools sync.Map // map[int]*sync.Pool, keyed by compression level
+
+func getGzipWriter(w io.Writer, level int) *gzip.Writer {
+	p, _ := gzipPools.LoadOrStore(level, &sync.Pool{
+		New: func() any {
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		},
+	})
+	gz := p.(*sync.Pool).Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer, level int) {
+	p, _ := gzipPools.Load(level)
+	p.(*sync.Pool).Put(gz)
+}
+
+// A gzipWriter compresses a response as it is written. The first
+// minCompressSize bytes are buffered to decide whether compression is
+// worthwhile; after that, output is compressed and written incrementally, so
+// large streamed responses are never held in memory.
+type gzipWriter struct {
+	http.ResponseWriter
+	level int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+// decide determines whether to compress the response and writes its header.
+// The size is the number of bytes written so far, or -1 if the handler is
+// flushing, in which case the final size is unknown.
+func (gw *gzipWriter) decide(size int) {
+	gw.decided = true
+	h := gw.Header()
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		size = n
+	}
+	compress := gw.status != http.StatusNoContent && gw.status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") &&
+		(size < 0 || size >= minCompressSize)
+	h.Add("Vary", "Accept-Encoding")
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		gw.gz = getGzipWriter(gw.ResponseWriter, gw.level)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+	if len(gw.buf) > 0 {
+		gw.write(gw.buf)
+		gw.buf = nil
+	}
+}
+
+func (gw *gzipWriter) write(b []byte) (int, error) {
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+func (gw *gzipWriter) WriteHeader(code int) {
+	if gw.status == 0 {
+		gw.status = code
+	}
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+	if gw.decided {
+		return gw.write(b)
+	}
+	_, known := gw.Header()["Content-Length"]
+	if !known && len(gw.buf)+len(b) < minCompressSize {
+		gw.buf = append(gw.buf, b...)
+		return len(b), nil
+	}
+	gw.decide(len(gw.buf) + len(b))
+	return gw.write(b)
+}
+
+// Flush implements http.Flusher, flushing any compressed output.
+func (gw *gzipWriter) Flush() {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+	if !gw.decided {
+		gw.decide(-1)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use by
+// http.ResponseController.
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// close writes any buffered output and completes the compressed stream.
+func (gw *gzipWriter) close() {
+	if !gw.decided {
+		if gw.status == 0 {
+			// the handler wrote nothing
+			return
+		}
+		gw.decide(len(gw.buf))
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+		putGzipWriter(gw.gz, gw.level)
+		gw.gz = nil
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, enc := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if name = strings.TrimSpace(name); name != "gzip" && name != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// withGzip wraps h, compressing responses for clients that accept gzip.
+// Small responses and event streams are not compressed.
+func withGzip(level int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// WebSocket upgrades hijack the connection
+		if req.Header.Get("Upgrade") != "" || !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, level: level}
+		defer gw.close()
+		h.ServeHTTP(gw, req)
+	})
+}
+
+type routeInfoKey struct{}
+
+// A routeInfo is shared between the middleware wrapping the mux and the
+// route handlers, which can only be identified after routing.
+type routeInfo struct {
+	route string // e.g. "GET /consensus/blocks/:id"
+	// scope and credential are set once the request is authenticated; the
+	// credential is redacted for logging
+	scope      Scope
+	credential string
+}
+
+// requestRoute returns the route matched by req, or the empty string if no
+// route matched or the request did not pass through withRouteInfo.
+func requestRoute(req *http.Request) string {
+	if ri, ok := req.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+		return ri.route
+	}
+	return ""
+}
+
+// withRouteInfo adds a routeInfo to the request context, to be filled in by
+// the handler of the matched route.
+func withRouteInfo(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), routeInfoKey{}, new(routeInfo))
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// limitRequestSize wraps h, limiting the size of the request body to n bytes.
+// Decoding a larger body fails with 413.
+func limitRequestSize(n int64, h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		jc.Request.Body = http.MaxBytesReader(jc.ResponseWriter, jc.Request.Body, n)
+		h(jc)
+	}
+}
+
+// recordRoute wraps the handler of route, recording the route in the
+// request's routeInfo.
+func recordRoute(route string, h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		if ri, ok := jc.Request.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+			ri.route = route
+		}
+		h(jc)
+	}
+}
+
+// clientIP returns the IP address of the client that made req. If
+// trustProxy is set, the last address in the X-Forwarded-For header, which
+// was added by the proxy, is used instead of the connection's address.
+func clientIP(req *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			addrs := strings.Split(xff[len(xff)-1], ",")
+			if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+				return addr
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// A statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.size += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use by
+// http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// withAccessLog wraps h, logging each request. Requests to the paths in
+// exclude, such as health checks, are not logged.
+func withAccessLog(log *zap.Logger, exclude []string, trustProxy bool, h http.Handler) http.Handler {
+	excluded := make(map[string]bool)
+	for _, path := range exclude {
+		excluded[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, req)
+
+		route := requestRoute(req)
+		path := req.URL.Path
+		if route != "" {
+			_, path, _ = strings.Cut(route, " ")
+		}
+		if excluded[path] {
+			return
+		}
+		status := sw.status
+		if status == 0 {
+			if req.Header.Get("Upgrade") != "" {
+				// the connection was hijacked
+				status = http.StatusSwitchingProtocols
+			} else {
+				status = http.StatusOK
+			}
+		}
+		fields := []zap.Field{
+			zap.String("method", req.Method),
+			zap.String("route", route),
+			zap.String("path", req.URL.Path),
+			zap.Int("status", status),
+			zap.Int("size", sw.size),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remote", clientIP(req, trustProxy)),
+		}
+		if ri, ok := req.Context().Value(routeInfoKey{}).(*routeInfo); ok && ri.credential != "" {
+			fields = append(fields, zap.String("credential", ri.credential))
+		}
+		log.Info("request", fields...)
+	})
+}
+
+// withRecovery wraps h, converting a panic in a handler into a 500 response
+// so that one bad request cannot take down the connection. The stack is
+// logged along with a random ID, which is also returned to the client so
+// the two can be correlated.
+func withRecovery(log *zap.Logger, panics *atomic.Uint64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			} else if r == http.ErrAbortHandler {
+				panic(r) // deliberate abort; let the server handle it
+			}
+			panics.Add(1)
+			id := hex.EncodeToString(frand.Bytes(8))
+			log.Error("panic while serving request",
+				zap.String("id", id),
+				zap.String("method", req.Method),
+				zap.String("route", requestRoute(req)),
+				zap.Any("panic", r),
+				zap.ByteString("stack", debug.Stack()))
+			if sw.status != 0 {
+				// part of the response has been sent, so the connection
+				// must be aborted rather than answered
+				panic(http.ErrAbortHandler)
+			}
+			writeErrorResponse(sw, &Error{
+				Status:  http.StatusInternalServerError,
+				Code:    ErrorCodeInternal,
+				Message: fmt.Sprintf("internal error (id %v)", id),
+			})
+		}()
+		h.ServeHTTP(sw, req)
+	})
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	const allowed = "https://wallet.example"
+	_, srv := newTestServer(t, WithCORSOrigins([]string{allowed}))
+
+	preflight := func(origin string) (*http.Response, []byte) {
+		t.Helper()
+		return doRequest(t, http.MethodOptions, srv.URL+"/consensus/tip", nil, http.Header{
+			"Origin":                        {origin},
+			"Access-Control-Request-Method": {http.MethodGet},
+		})
+	}
+
+	// an allowed origin may cache the preflight and send credentials
+	resp, body := preflight(allowed)
+	switch {
+	case resp.StatusCode != http.StatusNoContent:
+		t.Fatalf("expected 204, got %v: %s", resp.StatusCode, body)
+	case resp.Header.Get("Access-Control-Allow-Origin") != allowed:
+		t.Fatalf("expected origin %q, got %q", allowed, resp.Header.Get("Access-Control-Allow-Origin"))
+	case resp.Header.Get("Access-Control-Allow-Credentials") != "true":
+		t.Fatal("expected credentials to be allowed")
+	case resp.Header.Get("Access-Control-Max-Age") != corsMaxAge:
+		t.Fatalf("expected max age %v, got %q", corsMaxAge, resp.Header.Get("Access-Control-Max-Age"))
+	case resp.Header.Get("Access-Control-Allow-Methods") != corsAllowMethods:
+		t.Fatalf("expected methods %q, got %q", corsAllowMethods, resp.Header.Get("Access-Control-Allow-Methods"))
+	case resp.Header.Get("Vary") != "Origin":
+		t.Fatalf("expected the response to vary by origin, got %q", resp.Header.Get("Vary"))
+	}
+
+	// other origins are refused
+	resp, body = preflight("https://evil.example")
+	var apiErr Error
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %v: %s", resp.StatusCode, body)
+	} else if err := json.Unmarshal(body, &apiErr); err != nil {
+		t.Fatal(err)
+	} else if apiErr.Code != ErrorCodeOriginNotAllowed {
+		t.Fatalf("expected %q, got %q", ErrorCodeOriginNotAllowed, apiErr.Code)
+	} else if resp.Header.Get("Access-Control-Max-Age") != "" {
+		t.Fatal("refused preflight must not be cached as allowed")
+	}
+
+	// an actual request carries the origin, but isn't a cacheable preflight
+	resp, body = doRequest(t, http.MethodGet, srv.URL+"/consensus/tip", nil, http.Header{"Origin": {allowed}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	} else if resp.Header.Get("Access-Control-Allow-Origin") != allowed {
+		t.Fatalf("expected origin %q, got %q", allowed, resp.Header.Get("Access-Control-Allow-Origin"))
+	} else if resp.Header.Get("Access-Control-Max-Age") != "" {
+		t.Fatal("expected no max age outside a preflight")
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	_, srv := newTestServer(t, WithCORSOrigins([]string{"*"}))
+	resp, body := doRequest(t, http.MethodOptions, srv.URL+"/consensus/tip", nil, http.Header{
+		"Origin":                        {"https://any.example"},
+		"Access-Control-Request-Method": {http.MethodGet},
+	})
+	switch {
+	case resp.StatusCode != http.StatusNoContent:
+		t.Fatalf("expected 204, got %v: %s", resp.StatusCode, body)
+	case resp.Header.Get("Access-Control-Allow-Origin") != "*":
+		t.Fatalf("expected wildcard origin, got %q", resp.Header.Get("Access-Control-Allow-Origin"))
+	case resp.Header.Get("Access-Control-Allow-Credentials") != "":
+		// browsers reject credentials combined with a wildcard
+		t.Fatal("expected credentials not to be allowed")
+	case resp.Header.Get("Access-Control-Max-Age") != corsMaxAge:
+		t.Fatalf("expected max age %v, got %q", corsMaxAge, resp.Header.Get("Access-Control-Max-Age"))
+	}
+}
+
+// BenchmarkGzipBatch compares the bytes on the wire for a batch of 1,000
+// blocks with and without compression.
+func BenchmarkGzipBatch(b *testing.B) {
+	const batchSize = 1000
+	cm, srv := newTestServer(b, WithMaxBatchSize(batchSize))
+	testutil.MineBlocks(b, cm, types.VoidAddress, batchSize)
+	js, err := json.Marshal(ConsensusBlocksBatchRequest{Start: 1, Limit: batchSize})
+	if err != nil {
+		b.Fatal(err)
+	}
+	// the transport must not decompress the response, so that its size on
+	// the wire can be measured
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	for _, encoding := range []string{"identity", "gzip"} {
+		b.Run(encoding, func(b *testing.B) {
+			var body []byte
+			var wire int
+			for b.Loop() {
+				req, err := http.NewRequest(http.MethodPost, srv.URL+"/consensus/blocks/batch", bytes.NewReader(js))
+				if err != nil {
+					b.Fatal(err)
+				}
+				req.Header.Set("Accept-Encoding", encoding)
+				resp, err := client.Do(req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					b.Fatal(err)
+				} else if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+				} else if got := resp.Header.Get("Content-Encoding"); (encoding == "gzip") != (got == "gzip") {
+					b.Fatalf("expected %v encoding, got %q", encoding, got)
+				}
+				wire = len(body)
+			}
+			b.ReportMetric(float64(wire), "wire-bytes/op")
+			b.StopTimer()
+			if encoding == "gzip" {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					b.Fatal(err)
+				} else if body, err = io.ReadAll(gz); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var blocks []BatchBlock
+			if err := json.Unmarshal(body, &blocks); err != nil {
+				b.Fatal(err)
+			} else if len(blocks) != batchSize {
+				b.Fatalf("expected %v blocks, got %v", batchSize, len(blocks))
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	var panics atomic.Uint64
+	srv := httptest.NewServer(withRecovery(zap.New(core), &panics, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/panic" {
+			var m map[string]int
+			m["boom"]++ // assignment to a nil map
+		}
+		w.Write([]byte("ok"))
+	})))
+	defer srv.Close()
+
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/panic", nil, nil)
+	var apiErr Error
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %v: %s", resp.StatusCode, body)
+	} else if err := json.Unmarshal(body, &apiErr); err != nil {
+		t.Fatal(err)
+	} else if apiErr.Code != ErrorCodeInternal {
+		t.Fatalf("expected %q, got %q", ErrorCodeInternal, apiErr.Code)
+	} else if panics.Load() != 1 {
+		t.Fatalf("expected 1 panic, got %v", panics.Load())
+	}
+
+	// the stack is logged once, with the ID returned to the client
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %v", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	id, _ := fields["id"].(string)
+	if id == "" || !strings.Contains(apiErr.Message, id) {
+		t.Fatalf("expected message %q to contain the logged ID %q", apiErr.Message, id)
+	} else if stack, _ := fields["stack"].(string); !strings.Contains(stack, "TestRecovery") {
+		t.Fatal("expected the stack of the panicking handler to be logged")
+	}
+
+	// the server keeps serving requests
+	resp, body = doRequest(t, http.MethodGet, srv.URL+"/ok", nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	const limit = 1 << 10
+	cm, srv := newTestServer(t, WithMaxRequestSizes(limit, limit))
+	// a dedicated transport, so that the oversized request's connection is
+	// reused if the server keeps it open
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+
+	post := func(v any) (*http.Response, []byte) {
+		t.Helper()
+		js, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Post(srv.URL+"/txpool/broadcast", "application/json", bytes.NewReader(js))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	// a body well beyond the limit is rejected without being read in full
+	oversized := TxpoolBroadcastRequest{
+		Basis:          cm.Tip(),
+		V2Transactions: []types.V2Transaction{{ArbitraryData: make([]byte, 1<<20)}},
+	}
+	resp, body := post(oversized)
+	var apiErr Error
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %v: %s", resp.StatusCode, body)
+	} else if err := json.Unmarshal(body, &apiErr); err != nil {
+		t.Fatal(err)
+	} else if apiErr.Code != ErrorCodeRequestTooLarge {
+		t.Fatalf("expected %q, got %q", ErrorCodeRequestTooLarge, apiErr.Code)
+	}
+
+	// the client can keep using the server afterward
+	small := TxpoolBroadcastRequest{
+		Basis:          cm.Tip(),
+		V2Transactions: []types.V2Transaction{{ArbitraryData: []byte("small")}},
+	}
+	if resp, body := post(small); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	}
+}
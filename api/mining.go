@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.sia.tech/node/internal/mining"
+)
+
+// maxMineBlocks is the maximum number of blocks mined by a single request to
+// [POST] /mine.
+const maxMineBlocks = 1000
+
+// miningEnabled writes an error and returns false if the server has no
+// Miner.
+func (s *server) miningEnabled(jc jape.Context) bool {
+	if s.miner == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("mining is not enabled"))
+		return false
+	}
+	return true
+}
+
+// template returns a template paying the payoutaddress parameter, or the
+// miner's payout address if it is omitted, writing an error if there is
+// neither. The template carries the tag parameter, or the miner's tag if it
+// is omitted. The payout address and tag are returned along with it.
+func (s *server) template(jc jape.Context) (types.Address, string, mining.Template, bool) {
+	var addr types.Address
+	if decodeForm(jc, "payoutaddress", &addr) != nil {
+		return types.VoidAddress, "", mining.Template{}, false
+	}
+	tag := jc.Request.FormValue("tag")
+	if err := mining.ValidateTag(tag); err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("invalid tag: %w", err))
+		return types.VoidAddress, "", mining.Template{}, false
+	}
+	tmpl, err := s.miner.Template(addr, tag)
+	if errors.Is(err, mining.ErrNoPayoutAddress) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeNoPayoutAddress, errors.New("payoutaddress is required when no payout address is set"))
+		return types.VoidAddress, "", mining.Template{}, false
+	} else if s.check(jc, "failed to build template", err) != nil {
+		return types.VoidAddress, "", mining.Template{}, false
+	}
+	return addr, tag, tmpl, true
+}
+
+func (s *server) handleGetMiningBlockTemplate(jc jape.Context) {
+	if !s.miningEnabled(jc) {
+		return
+	}
+	addr, tag, tmpl, ok := s.template(jc)
+	if !ok {
+		return
+	}
+	if jc.Request.FormValue("since") != "" {
+		var since types.Hash256
+		if decodeForm(jc, "since", &since) != nil {
+			return
+		}
+		timeout, err := decodeLongPollTimeout(jc)
+		if err != nil {
+			return
+		} else if tmpl.ID == since {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			select {
+			case <-s.miner.TemplateChanged(since):
+			case <-t.C:
+			case <-jc.Request.Context().Done():
+				return
+			}
+			if tmpl, err = s.miner.Template(addr, tag); s.check(jc, "failed to build template", err) != nil {
+				return
+			}
+		}
+	}
+	s.miner.RecordTemplate(clientIP(jc.Request, s.trustProxy))
+	jc.Encode(tmpl)
+}
+
+func (s *server) handleGetMiningSubscribe(jc jape.Context) {
+	if !s.miningEnabled(jc) {
+		return
+	}
+	addr, tag, tmpl, ok := s.template(jc)
+	if !ok {
+		return
+	}
+	// the server's read deadline is inherited by the hijacked connection
+	rc := http.NewResponseController(jc.ResponseWriter)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		s.check(jc, "failed to clear read deadline", err)
+		return
+	}
+	conn, err := websocket.Accept(jc.ResponseWriter, jc.Request, nil)
+	if err != nil {
+		return // Accept has already written an error response
+	}
+	defer conn.CloseNow()
+	ctx := conn.CloseRead(jc.Request.Context())
+	write := func(fn func(context.Context) error) bool {
+		ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+		defer cancel()
+		return fn(ctx) == nil
+	}
+
+	remote := clientIP(jc.Request, s.trustProxy)
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		s.miner.RecordTemplate(remote)
+		if !write(func(ctx context.Context) error { return wsjson.Write(ctx, conn, tmpl) }) {
+			return
+		}
+		changed := s.miner.TemplateChanged(tmpl.ID)
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				conn.Close(websocket.StatusGoingAway, "")
+				return
+			case <-ping.C:
+				if !write(conn.Ping) {
+					return
+				}
+			case <-changed:
+				waiting = false
+			}
+		}
+		if tmpl, err = s.miner.Template(addr, tag); err != nil {
+			// the payout address was cleared
+			conn.Close(websocket.StatusPolicyViolation, err.Error())
+			return
+		}
+	}
+}
+
+func (s *server) handleGetMiningPayoutAddress(jc jape.Context) {
+	if !s.miningEnabled(jc) {
+		return
+	}
+	jc.Encode(MiningPayoutAddress{Address: s.miner.PayoutAddress()})
+}
+
+func (s *server) handlePutMiningPayoutAddress(jc jape.Context) {
+	var req MiningPayoutAddress
+	if !s.miningEnabled(jc) || decode(jc, &req) != nil {
+		return
+	}
+	err := s.miner.SetPayoutAddress(req.Address)
+	if errors.Is(err, mining.ErrMining) {
+		writeError(jc, http.StatusConflict, ErrorCodeMining, err)
+		return
+	}
+	s.check(jc, "failed to set payout address", err)
+}
+
+func (s *server) handleGetMiningStatus(jc jape.Context) {
+	if !s.miningEnabled(jc) {
+		return
+	}
+	jc.Encode(s.miner.Status())
+}
+
+func (s *server) handlePostMiningStart(jc jape.Context) {
+	var req MiningStartRequest
+	if !s.miningEnabled(jc) || decode(jc, &req) != nil {
+		return
+	} else if req.Threads < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, errors.New("threads must not be negative"))
+		return
+	}
+	switch err := s.miner.Start(req.Address, req.Threads); {
+	case errors.Is(err, mining.ErrMainnet):
+		writeError(jc, http.StatusForbidden, ErrorCodeMiningNotAllowed, err)
+	case errors.Is(err, mining.ErrNoPayoutAddress):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNoPayoutAddress, err)
+	default:
+		s.check(jc, "failed to start mining", err)
+	}
+}
+
+func (s *server) handlePostMine(jc jape.Context) {
+	// refused before anything else, so that no configuration enables it
+	if s.network.Name == "mainnet" {
+		writeError(jc, http.StatusForbidden, ErrorCodeMiningNotAllowed, mining.ErrMainnet)
+		return
+	}
+	var req MineRequest
+	if !s.miningEnabled(jc) || decode(jc, &req) != nil {
+		return
+	} else if req.Blocks < 1 || req.Blocks > maxMineBlocks {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("blocks must be between 1 and %d", maxMineBlocks))
+		return
+	}
+	ids, err := s.miner.MineBlocks(jc.Request.Context(), req.Address, req.Blocks)
+	switch {
+	case errors.Is(err, mining.ErrMainnet):
+		writeError(jc, http.StatusForbidden, ErrorCodeMiningNotAllowed, err)
+	case errors.Is(err, mining.ErrNoPayoutAddress):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNoPayoutAddress, err)
+	case s.check(jc, "failed to mine blocks", err) == nil:
+		jc.Encode(ids)
+	}
+}
+
+func (s *server) handlePostMiningStop(jc jape.Context) {
+	if !s.miningEnabled(jc) {
+		return
+	}
+	s.miner.Stop()
+}
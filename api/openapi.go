@@ -0,0 +1,451 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.sia.tech/node/build"
+	"go.sia.tech/node/internal/index"
+	"go.sia.tech/node/internal/mining"
+	"go.sia.tech/node/internal/peers"
+	"go.sia.tech/node/internal/wallet"
+)
+
+// A routeDoc describes a route for the OpenAPI description. Path parameters
+// are taken from the route itself. Query parameters, requests, and responses
+// are described by example values, whose types determine their schemas. A
+// nil response means the route returns no body.
+type routeDoc struct {
+	summary     string
+	query       map[string]any
+	request     any
+	response    any
+	contentType string // of the response, if not JSON
+}
+
+// routeDocs describes every route served by NewHandler. Adding a route
+// without a corresponding entry causes NewHandler to panic.
+var routeDocs = map[string]routeDoc{
+	"GET /openapi.json": {summary: "Returns the OpenAPI description of the API", response: map[string]any{}},
+	"GET /state":        {summary: "Returns the node's build and runtime information", response: StateResponse{}},
+	"GET /health":       {summary: "Reports whether the node is healthy; returns 503 if any check fails", response: HealthResponse{}},
+	"GET /health/live":  {summary: "Returns 200 while the process is running"},
+	"GET /log/level":    {summary: "Returns the node's log level", response: LogLevelResponse{}},
+	"PUT /log/level":    {summary: "Sets the node's log level", request: LogLevelRequest{}},
+
+	"GET /consensus/network":    {summary: "Returns the consensus network parameters", response: ConsensusNetworkResponse{}},
+	"GET /consensus/hardforks":  {summary: "Returns the status of each hardfork", response: ConsensusHardforksResponse{}},
+	"GET /consensus/supply":     {summary: "Returns the circulating supply", response: ConsensusSupplyResponse{}},
+	"GET /consensus/difficulty": {summary: "Returns the current difficulty and estimated hashrate", query: map[string]any{"window": uint64(0)}, response: ConsensusDifficultyResponse{}},
+	"GET /consensus/tip": {
+		summary:  "Returns the current tip; if since is provided, waits until the tip differs from it",
+		query:    map[string]any{"since": types.ChainIndex{}, "timeout": time.Duration(0)},
+		response: types.ChainIndex{},
+	},
+	"GET /consensus/tipstate":                {summary: "Returns the consensus state of the current tip", response: consensus.State{}},
+	"GET /consensus/blocks/:id":              {summary: "Returns the block with the given ID", response: types.Block{}},
+	"GET /consensus/blocks/:id/summary":      {summary: "Returns a summary of the block with the given ID", response: BlockSummary{}},
+	"GET /consensus/blocks/:id/transactions": {summary: "Returns the transactions in the block with the given ID", query: map[string]any{"offset": 0, "limit": 0}, response: []BlockTransaction{}},
+	"GET /consensus/blocks/:id/proof/:txid":  {summary: "Returns a proof that a transaction is included in a block", response: TransactionProof{}},
+	"GET /consensus/headers":                 {summary: "Returns the headers of the best chain", query: map[string]any{"start": uint64(0), "limit": uint64(0)}, response: []ConsensusHeader{}},
+	"POST /consensus/blocks":                 {summary: "Adds a block to the chain", request: types.Block{}},
+	"POST /consensus/blocks/batch":           {summary: "Returns multiple blocks by ID or height range", request: ConsensusBlocksBatchRequest{}, response: []BatchBlock{}},
+	"POST /consensus/validate":               {summary: "Validates a block or transactions against the current tip", request: ConsensusValidateRequest{}, response: ConsensusValidateResponse{}},
+	"GET /consensus/index/:height":           {summary: "Returns the index of the best chain at the given height", response: types.ChainIndex{}},
+	"GET /consensus/updates/:index":          {summary: "Returns the chain updates since the given index", query: map[string]any{"limit": 0}, response: ConsensusUpdatesResponse{}},
+	"GET /consensus/checkpoint/:id":          {summary: "Returns the block and state with the given ID", response: ConsensusCheckpointResponse{}},
+	"GET /consensus/subscribe":               {summary: "Upgrades to a WebSocket streaming tip and reorg events", response: eventEnvelope{}},
+	"GET /events":                            {summary: "Streams node events as server-sent events", response: eventEnvelope{}, contentType: "text/event-stream"},
+
+	"GET /syncer/peers":                 {summary: "Returns the connected peers", response: []SyncerPeer{}},
+	"GET /syncer/peers/:address":        {summary: "Returns details of a connected peer", response: SyncerPeerDetail{}},
+	"GET /syncer/address":               {summary: "Returns the syncer's listeners", response: []SyncerListener{}},
+	"GET /syncer/status":                {summary: "Returns the sync status of the node", response: SyncerStatusResponse{}},
+	"GET /syncer/stats":                 {summary: "Returns peer discovery and dial statistics", response: SyncerStatsResponse{}},
+	"DELETE /syncer/peers/:address":     {summary: "Disconnects a peer, optionally banning it", query: map[string]any{"ban": false}},
+	"POST /syncer/peers/:address/ban":   {summary: "Bans a connected peer", request: SyncerBanRequest{}},
+	"POST /syncer/peers/:address/ping":  {summary: "Measures the latency to a connected peer", response: SyncerPingResponse{}},
+	"POST /syncer/peers/:address/pin":   {summary: "Pins a peer, keeping it connected"},
+	"DELETE /syncer/peers/:address/pin": {summary: "Unpins a peer"},
+	"GET /syncer/bans":                  {summary: "Returns the active bans", response: []peers.Ban{}},
+	"POST /syncer/bans":                 {summary: "Bans an address or subnet", request: SyncerBanRequest{}},
+	"DELETE /syncer/bans/*address":      {summary: "Removes a ban"},
+	"POST /syncer/broadcast/block":      {summary: "Broadcasts a block to peers", request: SyncerBroadcastBlockRequest{}, response: SyncerBroadcastResponse{}},
+	"POST /syncer/broadcast/transactionset": {
+		summary:  "Broadcasts a v2 transaction set to peers",
+		request:  SyncerBroadcastTransactionSetRequest{},
+		response: SyncerBroadcastResponse{},
+	},
+	"POST /syncer/connect": {summary: "Connects to a peer", request: SyncerConnectRequest{}, response: SyncerPeer{}},
+
+	"GET /txpool/transactions":     {summary: "Returns the transactions in the txpool", query: map[string]any{"offset": 0, "limit": 0}, response: TxpoolTransactionsResponse{}},
+	"GET /txpool/transactions/:id": {summary: "Returns a transaction in the txpool", response: TxpoolTransaction{}},
+	"POST /txpool/broadcast":       {summary: "Adds a transaction set to the txpool and broadcasts it", request: TxpoolBroadcastRequest{}, response: TxpoolBroadcastResponse{}},
+	"POST /txpool/parents":         {summary: "Returns the unconfirmed parents of a transaction", request: TxpoolParentsRequest{}, response: TxpoolTransactionsResponse{}},
+	"GET /txpool/subscribe":        {summary: "Upgrades to a WebSocket streaming txpool events", response: eventEnvelope{}},
+	"GET /txpool/fee":              {summary: "Returns recommended fee rates", response: TxpoolFeeResponse{}},
+	"GET /txpool/stats":            {summary: "Returns txpool statistics", response: TxpoolStatsResponse{}},
+	"GET /txpool/local":            {summary: "Returns the transaction sets broadcast through this node", response: []TxpoolLocalSet{}},
+
+	"GET /mining/blocktemplate": {
+		summary:  "Returns a block template extending the current tip, paying the block reward and fees to payoutaddress, or the miner's payout address if it is omitted, and carrying tag, or the miner's tag, as arbitrary data; if since is provided, waits until the template's ID differs from it. The template changes when the tip does, or when the txpool offers enough additional fees",
+		query:    map[string]any{"payoutaddress": types.Address{}, "tag": "", "since": types.Hash256{}, "timeout": time.Duration(0)},
+		response: mining.Template{},
+	},
+	"GET /mining/status": {summary: "Returns the state of the built-in miner, and the template fetches and block submissions of external miners by remote address", response: mining.Status{}},
+	"GET /mining/subscribe": {
+		summary:  "Upgrades to a WebSocket that sends the current block template, then each new template as soon as the current one changes",
+		query:    map[string]any{"payoutaddress": types.Address{}, "tag": ""},
+		response: mining.Template{},
+	},
+	"GET /mining/payoutaddress": {summary: "Returns the miner's payout address, the default address paid by templates and the built-in miner", response: MiningPayoutAddress{}},
+	"PUT /mining/payoutaddress": {summary: "Sets the miner's payout address, or clears it if it is the void address; it cannot be cleared while mining", request: MiningPayoutAddress{}},
+	"POST /mining/start":        {summary: "Starts the built-in CPU miner, or restarts it with new settings; not allowed on mainnet", request: MiningStartRequest{}},
+	"POST /mining/stop":         {summary: "Stops the built-in CPU miner"},
+	"POST /mine":                {summary: "Mines blocks on the CPU, the first including the txpool's transactions, and returns their IDs once they are added; for development networks, and never allowed on mainnet", request: MineRequest{}, response: []types.BlockID{}},
+
+	"GET /addresses/:addr/balance": {summary: "Returns the value of an address's unspent elements, from the address index; requires -index", response: index.Balance{}},
+
+	"GET /wallets":                             {summary: "Returns the status of each wallet", response: []wallet.Status{}},
+	"GET /wallets/:name":                       {summary: "Returns the status of the wallet", response: wallet.Status{}},
+	"PUT /wallets/:name":                       {summary: "Creates a seed wallet, generating a seed phrase if none is provided, or a watch-only wallet", request: WalletCreateRequest{}, response: WalletCreateResponse{}},
+	"DELETE /wallets/:name":                    {summary: "Deletes the wallet along with its seed and state"},
+	"POST /wallets/:name/watch":                {summary: "Adds addresses to a watch-only wallet, scanning for their elements from the given height", request: WalletWatchRequest{}},
+	"GET /wallets/:name/balance":               {summary: "Returns the wallet's confirmed, immature, and unconfirmed balances", response: wallet.Balance{}},
+	"GET /wallets/:name/balance/detailed":      {summary: "Returns the wallet's confirmed siacoins broken down into spendable, immature, timelocked, reserved, and watch-only buckets", response: wallet.DetailedBalance{}},
+	"GET /wallets/:name/addresses":             {summary: "Returns the wallet's addresses and their balances", response: []wallet.AddressInfo{}},
+	"POST /wallets/:name/addresses":            {summary: "Returns the next count addresses, issuing lookahead addresses before deriving new ones", query: map[string]any{"count": 0}, response: []wallet.Address{}},
+	"POST /wallets/:name/addresses/check":      {summary: "Reports whether each of the given addresses is one of the wallet's, with its index, spend policy, and balance", request: WalletAddressCheckRequest{}, response: []wallet.AddressCheck{}},
+	"GET /wallets/:name/addresses/:addr":       {summary: "Returns one of the wallet's addresses, with its index, spend policy, and balance", response: wallet.AddressInfo{}},
+	"PUT /wallets/:name/addresses/:addr/label": {summary: "Labels one of the wallet's addresses; an empty label removes it", request: WalletLabelRequest{}},
+	"POST /wallets/:name/send":                 {summary: "Sends siacoins from the wallet, optionally with arbitrary data, and broadcasts the transaction, or previews it without reserving its inputs", query: map[string]any{"force": false, "preview": false, "version": 0}, request: WalletSendRequest{}, response: WalletSendResponse{}},
+	"POST /wallets/:name/send/siafund":         {summary: "Sends siafunds from the wallet, claiming their siacoins to the wallet, and broadcasts the transaction", query: map[string]any{"force": false, "version": 0}, request: WalletSendSiafundRequest{}, response: WalletSendResponse{}},
+	"POST /wallets/:name/consolidate":          {summary: "Sends the wallet's smallest outputs back to it as one output, optionally repeating until it has fewer than a target number, and broadcasts the transactions, or previews them without reserving their inputs", query: map[string]any{"preview": false}, request: WalletConsolidateRequest{}, response: WalletConsolidateResponse{}},
+	"POST /wallets/:name/anchor":               {summary: "Embeds arbitrary data in a transaction sending the wallet's funds back to itself, and broadcasts it", query: map[string]any{"version": 0}, request: WalletAnchorRequest{}, response: WalletSendResponse{}},
+	"POST /wallets/:name/transactions/construct": {
+		summary:  "Constructs an unsigned transaction from the wallet, with the information needed to sign it offline, and reserves its inputs",
+		query:    map[string]any{"force": false, "version": 0},
+		request:  WalletConstructRequest{},
+		response: WalletConstructResponse{},
+	},
+	"POST /wallets/:name/transactions/submit": {
+		summary:  "Verifies the signatures of a transaction signed offline and broadcasts it",
+		request:  WalletSubmitRequest{},
+		response: WalletSendResponse{},
+	},
+	"POST /wallets/:name/transactions/bump": {
+		summary:  "Raises the fee of a pending wallet transaction by broadcasting a child transaction spending its outputs to the wallet",
+		request:  WalletBumpRequest{},
+		response: WalletBumpResponse{},
+	},
+	"POST /wallets/:name/fund":            {summary: "Adds and reserves wallet inputs worth the given amount to a transaction", request: WalletFundRequest{}, response: WalletFundResponse{}},
+	"POST /wallets/:name/release":         {summary: "Releases the wallet inputs reserved for a transaction", request: WalletReleaseRequest{}},
+	"POST /wallets/:name/sign":            {summary: "Signs the inputs of a transaction that the wallet controls, or adds its signatures to those of its multisig addresses, without broadcasting it", request: WalletSignRequest{}, response: WalletSignResponse{}},
+	"GET /wallets/:name/multisig":         {summary: "Returns the wallet's multisig addresses and their confirmed elements", response: []wallet.MultisigInfo{}},
+	"POST /wallets/:name/multisig":        {summary: "Adds an m-of-n multisig address including one of the wallet's public keys, and tracks its elements", request: WalletMultisigRequest{}, response: wallet.MultisigAddress{}},
+	"GET /wallets/:name/settings":         {summary: "Returns the wallet's settings", response: wallet.Settings{}},
+	"GET /wallets/:name/backup":           {summary: "Returns a backup of the wallet's seed, addresses, settings, reservations, labels, and memos, encrypted with the passphrase in the X-Backup-Passphrase header", response: map[string]any{}},
+	"POST /wallets/:name/restore":         {summary: "Creates a wallet from a backup encrypted with the passphrase in the X-Backup-Passphrase header, and scans the chain for its elements", request: map[string]any{}},
+	"POST /wallets/:name/unlock":          {summary: "Decrypts the wallet's seed with its passphrase so that it can sign, optionally locking it again after a timeout; repeated wrong passphrases are refused for a while", request: WalletUnlockRequest{}},
+	"POST /wallets/:name/lock":            {summary: "Zeroes the wallet's key material until it is unlocked again; signing and sending return 423 while it is locked"},
+	"PUT /wallets/:name/passphrase":       {summary: "Encrypts the wallet's seed with a passphrase, locking it, or with the node's wallet password again if the passphrase is empty", request: WalletPassphraseRequest{}},
+	"PUT /wallets/:name/settings":         {summary: "Updates the wallet's settings; raising the gap limit scans the chain for the elements of the lookahead addresses it adds", request: wallet.Settings{}},
+	"GET /wallets/:name/events":           {summary: "Returns the wallet's events, oldest first, optionally only those involving an address with the given label", query: map[string]any{"offset": 0, "limit": 0, "label": ""}, response: []wallet.Event{}},
+	"GET /wallets/:name/events/:id":       {summary: "Returns the wallet event with the given ID", response: wallet.Event{}},
+	"GET /wallets/:name/unconfirmed":      {summary: "Returns the events of the txpool transactions that spend or create the wallet's elements", response: []wallet.Event{}},
+	"GET /wallets/:name/rescan":           {summary: "Returns the progress of the wallet's scan", response: wallet.ScanStatus{}},
+	"POST /wallets/:name/rescan":          {summary: "Scans the chain from the given height for the elements of the wallet's addresses", request: WalletRescanRequest{}},
+	"POST /wallets/:name/sweep":           {summary: "Sends the elements of another seed's addresses to the wallet and broadcasts the transactions; the seed is not stored", request: WalletSweepRequest{}, response: WalletSweepResponse{}},
+	"GET /wallets/:name/outputs":          {summary: "Returns the wallet's spendable siacoin and siafund elements", response: WalletOutputsResponse{}},
+	"POST /wallets/:name/outputs/reserve": {summary: "Reserves wallet elements so that the wallet does not spend them", request: WalletReserveRequest{}},
+	"POST /wallets/:name/outputs/release": {summary: "Releases reserved wallet elements", request: WalletReleaseOutputsRequest{}},
+
+	"GET /debug/pprof/*profile":  {summary: "Serves pprof profiles", contentType: "application/octet-stream"},
+	"POST /debug/pprof/*profile": {summary: "Serves pprof symbol lookups", contentType: "text/plain"},
+	"GET /debug/stats":           {summary: "Returns GC and goroutine statistics", response: DebugStatsResponse{}},
+}
+
+// eventEnvelope documents the messages of the event streams.
+type eventEnvelope struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// A schemaBuilder generates JSON schemas from Go types, collecting named
+// struct types as components.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType          = reflect.TypeFor[time.Time]()
+	durationType      = reflect.TypeFor[time.Duration]()
+)
+
+// componentName returns the name of the component for t. Types outside the
+// api package are prefixed with their package name.
+func componentName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeFor[server]().PkgPath() {
+		return t.Name()
+	}
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+// marshaledKind returns the first byte of the JSON encoding of a zero t, which
+// indicates the kind of value produced by a custom marshaler.
+func marshaledKind(t reflect.Type) (kind byte) {
+	defer func() {
+		if recover() != nil {
+			kind = 0
+		}
+	}()
+	js, err := json.Marshal(reflect.New(t).Elem().Interface())
+	if err != nil || len(js) == 0 {
+		return 0
+	}
+	return js[0]
+}
+
+func (sb *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]any{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		switch marshaledKind(t) {
+		case '"':
+			return map[string]any{"type": "string"}
+		case '{':
+			if t.Kind() == reflect.Struct {
+				return sb.structSchema(t)
+			}
+			return map[string]any{"type": "object"}
+		case '[':
+			return map[string]any{"type": "array", "items": map[string]any{}}
+		case 't', 'f':
+			return map[string]any{"type": "boolean"}
+		case 'n', 0:
+			return map[string]any{}
+		default:
+			return map[string]any{"type": "number"}
+		}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return sb.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]any{"type": "integer", "format": "uint64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": sb.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": sb.schema(t.Elem())}
+	case reflect.Struct:
+		return sb.structSchema(t)
+	default:
+		// interfaces can hold any value
+		return map[string]any{}
+	}
+}
+
+// structSchema returns a reference to the component for t, generating it if
+// necessary. Anonymous structs are described inline.
+func (sb *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	if t.Name() == "" {
+		return sb.objectSchema(t)
+	}
+	name := componentName(t)
+	ref := map[string]any{"$ref": "#/components/schemas/" + name}
+	if _, ok := sb.components[name]; !ok {
+		// register the name first, in case t refers to itself
+		sb.components[name] = nil
+		sb.components[name] = sb.objectSchema(t)
+	}
+	return ref
+}
+
+func (sb *schemaBuilder) objectSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for field := range t.Fields() {
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := field.Type
+			if field.Anonymous && name == "" {
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					addFields(ft)
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			} else if name == "" {
+				name = field.Name
+			}
+			properties[name] = sb.schema(ft)
+			if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") && ft.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		slices.Sort(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// textSchema returns the schema of a query or path parameter of type t.
+func textSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		if t == durationType {
+			return map[string]any{"type": "string", "description": "a Go duration, e.g. 30s"}
+		}
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "uint64", "minimum": 0}
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
+
+// openAPIPath converts a route path to an OpenAPI path, returning the names
+// of its parameters.
+func openAPIPath(route string) (string, []string) {
+	var params []string
+	segments := strings.Split(route, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// buildOpenAPISpec returns an OpenAPI 3 description of routes. An error is
+// returned if any route is missing from routeDocs.
+func buildOpenAPISpec(routes map[string]jape.Handler) ([]byte, error) {
+	var missing []string
+	for route := range routes {
+		if _, ok := routeDocs[route]; !ok {
+			missing = append(missing, route)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, fmt.Errorf("routes missing from the OpenAPI description: %v", strings.Join(missing, ", "))
+	}
+
+	sb := &schemaBuilder{components: make(map[string]any)}
+	paths := make(map[string]map[string]any)
+	errorResponse := map[string]any{
+		"description": "error",
+		"content":     map[string]any{"application/json": map[string]any{"schema": sb.schema(reflect.TypeOf(Error{}))}},
+	}
+	for route := range routes {
+		doc := routeDocs[route]
+		method, routePath, _ := strings.Cut(route, " ")
+		p, pathParams := openAPIPath(routePath)
+
+		var params []any
+		for _, name := range pathParams {
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		queryNames := make([]string, 0, len(doc.query))
+		for name := range doc.query {
+			queryNames = append(queryNames, name)
+		}
+		slices.Sort(queryNames)
+		for _, name := range queryNames {
+			params = append(params, map[string]any{
+				"name":   name,
+				"in":     "query",
+				"schema": textSchema(reflect.TypeOf(doc.query[name])),
+			})
+		}
+
+		ok := map[string]any{"description": "success"}
+		if doc.response != nil || doc.contentType != "" {
+			contentType := doc.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			schema := map[string]any{}
+			if doc.response != nil {
+				schema = sb.schema(reflect.TypeOf(doc.response))
+			}
+			ok["content"] = map[string]any{contentType: map[string]any{"schema": schema}}
+		}
+		op := map[string]any{
+			"summary":   doc.summary,
+			"responses": map[string]any{"200": ok, "default": errorResponse},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if doc.request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": sb.schema(reflect.TypeOf(doc.request))}},
+			}
+		}
+		if paths[p] == nil {
+			paths[p] = make(map[string]any)
+		}
+		paths[p][strings.ToLower(method)] = op
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Sia node API",
+			"version": build.Version(),
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": sb.components},
+	}, "", "  ")
+}
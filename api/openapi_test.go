@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.sia.tech/jape"
+)
+
+func TestOpenAPIDescribesRoutes(t *testing.T) {
+	_, srv := newTestServer(t, WithDebug(true))
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/openapi.json", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	// walk the mux with a path for each documented operation, so that a
+	// route missing from the mux, or one documented under the wrong path,
+	// is caught
+	routes := (&server{debug: true}).routes()
+	routes["GET /openapi.json"] = nil
+	mux := jape.Mux(routes)
+	operations := make(map[string]bool)
+	for p, methods := range spec.Paths {
+		for method, op := range methods {
+			route := strings.ToUpper(method) + " " + p
+			operations[route] = true
+
+			var want []string
+			for _, param := range op.Parameters {
+				if param.In == "path" {
+					want = append(want, param.Name)
+				}
+			}
+			segments := strings.Split(p, "/")
+			for i, seg := range segments {
+				if strings.HasPrefix(seg, "{") {
+					segments[i] = "x"
+				}
+			}
+			handle, params, _ := mux.Lookup(strings.ToUpper(method), strings.Join(segments, "/"))
+			if handle == nil {
+				t.Errorf("%v is documented but not served", route)
+				continue
+			}
+			var got []string
+			for _, param := range params {
+				got = append(got, param.Key)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%v: expected path parameters %v, got %v", route, want, got)
+			}
+		}
+	}
+
+	// every route must be described, and every description must be of a
+	// route
+	for route := range routes {
+		method, routePath, _ := strings.Cut(route, " ")
+		p, _ := openAPIPath(routePath)
+		if !operations[method+" "+p] {
+			t.Errorf("%v is served but not documented", route)
+		}
+	}
+	for route := range routeDocs {
+		if _, ok := routes[route]; !ok {
+			t.Errorf("%v is documented but is not a route", route)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.sia.tech/jape"
+)
+
+// maxRateLimitClients is the maximum number of clients tracked by a
+// rateLimiter. When exceeded, the least recently seen client is forgotten,
+// which at worst resets its bucket to full.
+const maxRateLimitClients = 10000
+
+// expensiveRoutes are the routes subject to the expensive rate limit, in
+// addition to the default limit.
+var expensiveRoutes = map[string]bool{
+	"POST /consensus/blocks/batch":  true,
+	"GET /consensus/updates/:index": true,
+	"GET /consensus/headers":        true,
+}
+
+// A RateLimit limits requests to a sustained rate, in requests per second,
+// with bursts of up to Burst requests. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucketEntry struct {
+	client string
+	tokens float64
+	last   time.Time
+}
+
+// A rateLimiter implements a token bucket for each client, retaining the
+// buckets of the most recently seen clients.
+type rateLimiter struct {
+	limit RateLimit
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     *list.List // of *bucketEntry, most recent first
+}
+
+// allow reports whether a request from client is allowed. If not, it also
+// returns the time after which the next request will be allowed.
+func (rl *rateLimiter) allow(client string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	burst := float64(max(rl.limit.Burst, 1))
+
+	var b *bucketEntry
+	if el, ok := rl.clients[client]; ok {
+		rl.lru.MoveToFront(el)
+		b = el.Value.(*bucketEntry)
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.limit.Rate)
+		b.last = now
+	} else {
+		if rl.lru.Len() >= maxRateLimitClients {
+			oldest := rl.lru.Back()
+			rl.lru.Remove(oldest)
+			delete(rl.clients, oldest.Value.(*bucketEntry).client)
+		}
+		b = &bucketEntry{client: client, tokens: burst, last: now}
+		rl.clients[client] = rl.lru.PushFront(b)
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		clients: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// rateLimited wraps the handler of route, rejecting requests from clients
+// that have exceeded their rate limit with 429 Too Many Requests.
+// Requests with admin credentials are not limited.
+func (s *server) rateLimited(route string, h jape.Handler) jape.Handler {
+	// check the expensive limit first, so that requests it rejects do not
+	// count against the default limit
+	var limiters []*rateLimiter
+	if expensiveRoutes[route] {
+		limiters = append(limiters, s.expensiveRateLimiter)
+	}
+	limiters = append(limiters, s.rateLimiter)
+	return func(jc jape.Context) {
+		if requestScope(jc.Request) == ScopeAdmin {
+			h(jc)
+			return
+		}
+		client := clientIP(jc.Request, s.trustProxy)
+		for _, rl := range limiters {
+			if rl == nil {
+				continue
+			} else if ok, wait := rl.allow(client); !ok {
+				jc.ResponseWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(jc, http.StatusTooManyRequests, ErrorCodeRateLimited, errors.New("rate limit exceeded"))
+				return
+			}
+		}
+		h(jc)
+	}
+}
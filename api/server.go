@@ -1,31 +1,2677 @@
 package api
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/gateway"
 	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/syncer"
 	"go.sia.tech/jape"
+	"go.sia.tech/node/build"
+	"go.sia.tech/node/internal/index"
+	"go.sia.tech/node/internal/mining"
+	"go.sia.tech/node/internal/peers"
+	"go.sia.tech/node/internal/txpool"
+	"go.sia.tech/node/internal/wallet"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// defaultUpdatesLimit is the number of updates returned by
+	// [GET] /consensus/updates/:index when no limit is specified.
+	defaultUpdatesLimit = 10
+	// maxUpdatesLimit is the maximum number of updates that can be requested
+	// from [GET] /consensus/updates/:index.
+	maxUpdatesLimit = 100
+
+	// defaultTransactionsLimit is the number of transactions returned by
+	// [GET] /consensus/blocks/:id/transactions when no limit is specified.
+	defaultTransactionsLimit = 100
+	// maxTransactionsLimit is the maximum number of transactions that can be
+	// requested from [GET] /consensus/blocks/:id/transactions.
+	maxTransactionsLimit = 1000
+
+	// defaultPoolLimit is the number of transactions returned by
+	// [GET] /txpool/transactions when no limit is specified.
+	defaultPoolLimit = 100
+	// maxPoolLimit is the maximum number of transactions that can be
+	// requested from [GET] /txpool/transactions.
+	maxPoolLimit = 1000
+
+	// lowFeePercentile, mediumFeePercentile, and highFeePercentile are the
+	// weighted percentiles of pool fee density reported by [GET] /txpool/fee.
+	lowFeePercentile    = 25
+	mediumFeePercentile = 50
+	highFeePercentile   = 90
+
+	// defaultHeadersLimit is the number of headers returned by
+	// [GET] /consensus/headers when no limit is specified.
+	defaultHeadersLimit = 100
+	// maxHeadersLimit is the maximum number of headers that can be requested
+	// from [GET] /consensus/headers.
+	maxHeadersLimit = 1000
+
+	// defaultMaxBatchSize is the default maximum number of blocks that can be
+	// requested from [POST] /consensus/blocks/batch.
+	defaultMaxBatchSize = 100
+
+	// defaultMaxBlockRequestSize and defaultMaxTransactionSetRequestSize are
+	// the default limits on the size of request bodies containing a block or
+	// a transaction set, respectively.
+	defaultMaxBlockRequestSize          = 4 << 20
+	defaultMaxTransactionSetRequestSize = 1 << 20
+	// maxRequestSize is the limit on the size of other request bodies.
+	maxRequestSize = 64 << 10
+	// batchIDSize is the size of a block ID in a [POST]
+	// /consensus/blocks/batch request, including quotes and a comma.
+	batchIDSize = 67
+	// checkAddressSize is the size of an address in a [POST]
+	// /wallets/:name/addresses/check request, including quotes and a comma.
+	checkAddressSize = 79
+
+	// defaultHashrateWindow is the default number of blocks used to estimate
+	// the network hashrate.
+	defaultHashrateWindow = 144
+	// maxHashrateWindow is the maximum number of blocks that can be used to
+	// estimate the network hashrate.
+	maxHashrateWindow = 4032
+
+	// syncerConnectTimeout is the maximum amount of time [POST]
+	// /syncer/connect waits for a connection to be established.
+	syncerConnectTimeout = 30 * time.Second
+	// relayTimeout is the maximum amount of time allowed to relay an object
+	// to a single peer.
+	relayTimeout = 10 * time.Second
+	// pingTimeout is the maximum amount of time [POST]
+	// /syncer/peers/:address/ping waits for a peer to respond.
+	pingTimeout = 10 * time.Second
+	// maxBasisDistance is the maximum number of blocks that may be reverted
+	// and applied to update a v2 transaction set's basis, matching the limit
+	// imposed by the chain manager.
+	maxBasisDistance = 144
+
+	// statusTimeout is the maximum amount of time to wait for each peer to
+	// report its height.
+	statusTimeout = 5 * time.Second
+	// peerHeightInterval is the interval at which connected peers are asked
+	// for their height, which [GET] /syncer/status reports.
+	peerHeightInterval = 30 * time.Second
+	// defaultHealthMinPeers and defaultHealthMaxTipAge are the default
+	// thresholds of [GET] /health.
+	defaultHealthMinPeers  = 1
+	defaultHealthMaxTipAge = 3 * time.Hour
+
+	// syncedTimestampWindow is the maximum age of the tip's timestamp for
+	// the node to be considered synced.
+	syncedTimestampWindow = 3 * time.Hour
+	// syncedHeightTolerance is the number of blocks a peer may be ahead of
+	// the node before the node is no longer considered synced.
+	syncedHeightTolerance = 3
+
+	// peerBanDuration is the default duration of bans applied through the
+	// API.
+	peerBanDuration = 24 * time.Hour
+
+	// defaultLongPollTimeout is the amount of time long-polls, such as [GET]
+	// /consensus/tip, wait for a change when no timeout is specified.
+	defaultLongPollTimeout = 30 * time.Second
+	// maxLongPollTimeout is the maximum amount of time long-polls will wait
+	// for a change.
+	maxLongPollTimeout = 60 * time.Second
+)
+
+// A ServerOption sets an optional parameter for the server.
+type ServerOption func(*server)
+
+// WithPeerStore sets the peer store shared by the node's syncers. Peers
+// connected through the API are added to it so they are retried later.
+func WithPeerStore(ps PeerStore) ServerOption {
+	return func(s *server) {
+		s.peers = ps
+	}
+}
+
+// WithSyncerListeners sets the syncer listeners reported by [GET]
+// /syncer/address.
+func WithSyncerListeners(listeners []SyncerListener) ServerOption {
+	return func(s *server) {
+		s.listeners = listeners
+	}
+}
+
+// WithPeerPinner sets the pinner used to keep pinned peers connected.
+func WithPeerPinner(pp PeerPinner) ServerOption {
+	return func(s *server) {
+		s.pinner = pp
+	}
+}
+
+// WithTxpoolLimiter sets the limiter whose size limit is reported by
+// [GET] /txpool/stats.
+func WithTxpoolLimiter(tl TxpoolLimiter) ServerOption {
+	return func(s *server) {
+		s.limiter = tl
+	}
+}
+
+// WithLocalTxpool sets the tracker of transaction sets broadcast through
+// [POST] /txpool/broadcast.
+func WithLocalTxpool(lt LocalTxpool) ServerOption {
+	return func(s *server) {
+		s.local = lt
+	}
+}
+
+// WithWallets serves the node's wallets under [GET] /wallets.
+func WithWallets(wm WalletManager) ServerOption {
+	return func(s *server) { s.wallets = wm }
+}
+
+// WithMiner sets the Miner used to build block templates and mine blocks.
+func WithMiner(m Miner) ServerOption {
+	return func(s *server) { s.miner = m }
+}
+
+// WithAddressIndex sets the index used by [GET] /addresses/:addr/balance.
+func WithAddressIndex(ix AddressIndex) ServerOption {
+	return func(s *server) { s.index = ix }
+}
+
+// WithDataDir sets the data directory reported by [GET] /state.
+func WithDataDir(dir string) ServerOption {
+	return func(s *server) {
+		s.dataDir = dir
+	}
+}
+
+// WithPeerTracker sets the tracker used to report per-peer connection
+// statistics.
+func WithPeerTracker(pt PeerTracker) ServerOption {
+	return func(s *server) {
+		s.tracker = pt
+	}
+}
+
+// WithMaxBatchSize sets the maximum number of blocks that can be requested in
+// a single batch.
+func WithMaxBatchSize(n int) ServerOption {
+	return func(s *server) {
+		s.maxBatchSize = n
+	}
+}
+
+// WithMaxRequestSizes sets the maximum size, in bytes, of request bodies
+// containing a block, such as [POST] /consensus/blocks, and of those
+// containing a transaction set, such as [POST] /txpool/broadcast. Larger
+// requests are rejected with 413.
+func WithMaxRequestSizes(block, txnSet int64) ServerOption {
+	return func(s *server) {
+		s.maxBlockRequestSize = block
+		s.maxTxnSetRequestSize = txnSet
+	}
+}
+
+// WithHealthThresholds sets the minimum number of connected peers and the
+// maximum age of the tip's timestamp for the node to be reported as healthy
+// by [GET] /health.
+func WithHealthThresholds(minPeers int, maxTipAge time.Duration) ServerOption {
+	return func(s *server) {
+		s.healthMinPeers = minPeers
+		s.healthMaxTipAge = maxTipAge
+	}
+}
+
+// WithDebug enables the profiling endpoints under /debug, which expose
+// internal details of the process and should not be enabled on a public API.
+func WithDebug(enabled bool) ServerOption {
+	return func(s *server) {
+		s.debug = enabled
+	}
+}
+
+// WithCORSOrigins allows cross-origin requests from the given origins, which
+// may include "*" to allow any origin.
+func WithCORSOrigins(origins []string) ServerOption {
+	return func(s *server) {
+		s.corsOrigins = origins
+	}
+}
+
+// WithCompressionLevel sets the gzip compression level of responses. A
+// level of 0 disables compression.
+func WithCompressionLevel(level int) ServerOption {
+	return func(s *server) {
+		s.compressionLevel = level
+	}
+}
+
+// WithAccessLog logs every request to log, except requests to the paths in
+// exclude, e.g. "/health".
+func WithAccessLog(log *zap.Logger, exclude []string) ServerOption {
+	return func(s *server) {
+		s.accessLog = log
+		s.accessLogExclude = exclude
+	}
+}
+
+// WithTrustedProxy sets whether the API is served behind a trusted reverse
+// proxy, in which case the client's address is taken from the
+// X-Forwarded-For header.
+func WithTrustedProxy(trusted bool) ServerOption {
+	return func(s *server) {
+		s.trustProxy = trusted
+	}
+}
+
+// WithContext sets the lifetime of the server. Once ctx is done, the server
+// stops following the chain manager's events. By default, it follows them for
+// the life of the process.
+func WithContext(ctx context.Context) ServerOption {
+	return func(s *server) { s.ctx = ctx }
+}
+
+// WithLogger sets the logger used to record internal errors. By default,
+// nothing is logged.
+func WithLogger(log *zap.Logger) ServerOption {
+	return func(s *server) { s.log = log }
+}
+
+// WithPassword requires requests to authenticate, and accepts password, sent
+// via HTTP basic auth or as a bearer token, as an admin credential. By
+// default, no authentication is required.
+func WithPassword(password string) ServerOption {
+	return func(s *server) {
+		s.credentials = append(s.credentials, credential{
+			digest:   sha256.Sum256([]byte(password)),
+			scope:    ScopeAdmin,
+			redacted: "password",
+		})
+	}
+}
+
+// WithTokens requires requests to authenticate, and accepts each of tokens as
+// a credential with the token's scope.
+func WithTokens(tokens []Token) ServerOption {
+	return func(s *server) {
+		for _, t := range tokens {
+			s.credentials = append(s.credentials, credential{
+				digest:   sha256.Sum256([]byte(t.Token)),
+				scope:    t.Scope,
+				redacted: t.String(),
+			})
+		}
+	}
+}
+
+// WithLogLevel allows the level of the node's loggers to be read and changed
+// through the API.
+func WithLogLevel(level zap.AtomicLevel) ServerOption {
+	return func(s *server) { s.logLevel = &level }
+}
+
+// WithRateLimits limits the rate of requests from each client IP. The
+// expensive limit additionally applies to costly routes, such as block
+// batches. Requests with admin credentials are exempt. By default, requests
+// are not limited.
+func WithRateLimits(limit, expensive RateLimit) ServerOption {
+	return func(s *server) {
+		if limit.Rate > 0 {
+			s.rateLimiter = newRateLimiter(limit)
+		}
+		if expensive.Rate > 0 {
+			s.expensiveRateLimiter = newRateLimiter(expensive)
+		}
+	}
+}
+
+// WithRouteTimeouts sets the maximum durations of API requests. When a
+// request times out, its context is canceled and 503 is returned.
+func WithRouteTimeouts(rt RouteTimeouts) ServerOption {
+	return func(s *server) { s.routeTimeouts = rt }
+}
+
+// WithReadOnly disables every route that changes the node's state, such as
+// broadcasting and connecting to peers. Disabled routes return 405.
+func WithReadOnly(readOnly bool) ServerOption {
+	return func(s *server) { s.readOnly = readOnly }
+}
+
 // ChainManager provides an interface for accessing chain information.
 type ChainManager interface {
 	Tip() types.ChainIndex
+	TipState() consensus.State
+	Block(id types.BlockID) (types.Block, bool)
+	State(id types.BlockID) (consensus.State, bool)
+	BestIndex(height uint64) (types.ChainIndex, bool)
+	Headers(index types.ChainIndex, maxHeaders uint64) ([]types.BlockHeader, uint64, error)
+	UpdateV2TransactionSet(txns []types.V2Transaction, from, to types.ChainIndex) ([]types.V2Transaction, error)
+	UpdatesSince(index types.ChainIndex, maxBlocks int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error)
+	AddBlocks(blocks []types.Block) error
+	OnReorg(fn func(types.ChainIndex)) (cancel func())
+	OnPoolChange(fn func()) (cancel func())
+
+	PoolTransactions() []types.Transaction
+	V2PoolTransactions() []types.V2Transaction
+	RecommendedFee() types.Currency
+	AddPoolTransactions(txns []types.Transaction) (known bool, err error)
+	AddV2PoolTransactions(basis types.ChainIndex, txns []types.V2Transaction) (known bool, err error)
+}
+
+// A Syncer relays blocks and transactions to the network.
+type Syncer interface {
+	Addr() string
+	Connect(ctx context.Context, addr string) (*syncer.Peer, error)
+	Peers() []*syncer.Peer
+	PeerInfo(addr string) (syncer.PeerInfo, error)
+
+	BroadcastV2Header(bh types.BlockHeader) error
+	BroadcastV2BlockOutline(b gateway.V2BlockOutline) error
+}
+
+// A PeerStore stores the addresses of known peers and bans.
+type PeerStore interface {
+	AddPeer(addr string) error
+	Ban(addr string, duration time.Duration, reason string) error
+	Banned(addr string) (bool, error)
+	Bans() ([]peers.Ban, error)
+	Unban(addr string) error
+	Stats() (peers.StoreStats, error)
+}
+
+// A PeerPinner keeps pinned peers connected.
+type PeerPinner interface {
+	Pin(addr string) error
+	Unpin(addr string) error
+	Pinned(addr string) bool
+}
+
+// A LocalTxpool tracks the transaction sets broadcast through the API,
+// rebroadcasting them until they confirm.
+type LocalTxpool interface {
+	AddLocal(basis types.ChainIndex, txns []types.Transaction, v2txns []types.V2Transaction) error
+	LocalSets() []txpool.LocalSet
+}
+
+// A Wallet is one of the node's wallets.
+type Wallet interface {
+	Watch(addrs []types.Address, height uint64) error
+	Status() (wallet.Status, error)
+	Balance() (wallet.Balance, error)
+	DetailedBalance() (wallet.DetailedBalance, error)
+	AddAddresses(n int) ([]wallet.Address, error)
+	Addresses() ([]wallet.AddressInfo, error)
+	Address(addr types.Address) (wallet.AddressInfo, error)
+	CheckAddresses(addrs []types.Address) ([]wallet.AddressCheck, error)
+	SetLabel(addr types.Address, label string) error
+	SetMemo(id types.TransactionID, memo string) error
+	SendSiacoins(addr types.Address, amount types.Currency, data []byte, strategy string, version int, force bool) (wallet.Transaction, error)
+	PreviewSendSiacoins(addr types.Address, amount types.Currency, data []byte, strategy string, version int, force bool) (wallet.Transaction, error)
+	Anchor(data []byte, version int) (wallet.Transaction, error)
+	Consolidate(maxInputs, target int) (wallet.ConsolidateResult, error)
+	PreviewConsolidate(maxInputs, target int) (wallet.ConsolidateResult, error)
+	BumpFee(id types.TransactionID, feeRate types.Currency) (wallet.Bump, error)
+	SendSiafunds(addr types.Address, amount uint64, version int, force bool) (wallet.Transaction, error)
+	Outputs() ([]types.SiacoinElement, []types.SiafundElement, error)
+	Reserve(ids []types.Hash256, d time.Duration) error
+	Release(ids []types.Hash256) error
+	FundTransaction(txn types.Transaction, amount types.Currency, strategy string, d time.Duration) (types.Transaction, error)
+	FundV2Transaction(txn types.V2Transaction, amount types.Currency, strategy string, d time.Duration) (types.ChainIndex, types.V2Transaction, error)
+	ReleaseTransaction(txn types.Transaction) error
+	ReleaseV2Transaction(txn types.V2Transaction) error
+	SignTransaction(txn types.Transaction, toSign []types.Hash256, cf types.CoveredFields) (types.Transaction, []types.Hash256, map[types.Hash256]int, error)
+	SignV2Transaction(txn types.V2Transaction, toSign []types.Hash256) (types.V2Transaction, []types.Hash256, map[types.Hash256]int, error)
+	Events(offset, limit int, label string) ([]wallet.Event, int, error)
+	Event(id types.Hash256) (wallet.Event, error)
+	UnconfirmedEvents() ([]wallet.Event, error)
+	Rescan(height uint64) error
+	ScanStatus() (wallet.ScanStatus, error)
+	ConstructTransaction(outputs []types.SiacoinOutput, strategy string, version int, force bool, d time.Duration) (wallet.UnsignedTransaction, error)
+	VerifyTransaction(txn types.Transaction) error
+	VerifyV2Transaction(txn types.V2Transaction) error
+	Sweep(ctx context.Context, phrase string, lookahead int, height uint64) (wallet.SweepResult, error)
+	AddMultisig(threshold int, keys []types.PublicKey, v2 bool, height uint64) (wallet.MultisigAddress, error)
+	Multisig() ([]wallet.MultisigInfo, error)
+	Settings() (wallet.Settings, error)
+	UpdateSettings(s wallet.Settings) error
+	Backup(passphrase string) ([]byte, error)
+	Unlock(passphrase string, timeout time.Duration) error
+	Lock() error
+	SetPassphrase(old, passphrase string) error
+}
+
+// A WalletManager stores the node's named wallets.
+type WalletManager interface {
+	Create(name, phrase, passphrase string) (string, error)
+	CreateWatch(name string, addrs []types.Address, height uint64) error
+	Wallet(name string) (*wallet.Wallet, error)
+	Wallets() ([]wallet.Status, error)
+	Restore(name string, backup []byte, passphrase string) error
+	Delete(name string) error
+}
+
+// A Miner builds block templates from the tip and the txpool, and mines
+// blocks on the CPU.
+type Miner interface {
+	Template(addr types.Address, tag string) (mining.Template, error)
+	PayoutAddress() types.Address
+	SetPayoutAddress(addr types.Address) error
+	Start(addr types.Address, threads int) error
+	Stop()
+	MineBlocks(ctx context.Context, addr types.Address, n int) ([]types.BlockID, error)
+	Status() mining.Status
+	TemplateChanged(id types.Hash256) <-chan struct{}
+	RecordTemplate(remote string)
+	RecordSubmission(remote, result string)
+}
+
+// An AddressIndex indexes the unspent elements of every address on the
+// chain.
+type AddressIndex interface {
+	Balance(addr types.Address) (index.Balance, error)
+}
+
+// A TxpoolLimiter limits the size of the txpool.
+type TxpoolLimiter interface {
+	MaxSize() uint64
+	Rejected() uint64
+}
+
+// A PeerTracker records statistics for syncer connections.
+type PeerTracker interface {
+	ConnStats(connAddr string) (peers.ConnStats, bool)
+	Probe(p *syncer.Peer, timeout time.Duration) (time.Duration, error)
+	RPC(p *syncer.Peer, fn func() error) error
+	DialStats() peers.DialStats
+}
+
+func summarizeUpdate(index types.ChainIndex, sces []consensus.SiacoinElementDiff, sfes []consensus.SiafundElementDiff) UpdateSummary {
+	us := UpdateSummary{
+		BlockID: index.ID,
+		Height:  index.Height,
+	}
+	for _, diff := range sces {
+		id := diff.SiacoinElement.ID
+		if diff.Created {
+			us.CreatedSiacoinElements = append(us.CreatedSiacoinElements, id)
+		}
+		if diff.Spent {
+			us.SpentSiacoinElements = append(us.SpentSiacoinElements, id)
+		}
+	}
+	for _, diff := range sfes {
+		id := diff.SiafundElement.ID
+		if diff.Created {
+			us.CreatedSiafundElements = append(us.CreatedSiafundElements, id)
+		}
+		if diff.Spent {
+			us.SpentSiafundElements = append(us.SpentSiafundElements, id)
+		}
+	}
+	return us
 }
 
 type server struct {
-	chain ChainManager
+	network   *consensus.Network
+	genesisID types.BlockID
+	chain     ChainManager
+	syncers   []Syncer
+	peers     PeerStore
+	listeners []SyncerListener
+	tracker   PeerTracker
+	pinner    PeerPinner
+	limiter   TxpoolLimiter
+	local     LocalTxpool
+	wallets   WalletManager
+	miner     Miner
+	index     AddressIndex
+	events    *eventBroker
+	dataDir   string
+	startTime time.Time
+
+	maxBatchSize         int
+	maxBlockRequestSize  int64
+	maxTxnSetRequestSize int64
+	healthMinPeers       int
+	healthMaxTipAge      time.Duration
+	debug                bool
+	corsOrigins          []string
+
+	compressionLevel int
+	accessLog        *zap.Logger
+	accessLogExclude []string
+	trustProxy       bool
+
+	rateLimiter          *rateLimiter
+	expensiveRateLimiter *rateLimiter
+
+	credentials []credential
+
+	routeTimeouts RouteTimeouts
+	timeouts      atomic.Uint64
+	readOnly      bool
+
+	peerHeightsMu sync.Mutex
+	peerHeights   map[string]uint64 // keyed by ConnAddr
+
+	ctx      context.Context
+	log      *zap.Logger
+	logLevel *zap.AtomicLevel
+	panics   atomic.Uint64
+}
+
+// decodeLongPollTimeout decodes the timeout of a long-poll, writing an error
+// if it is invalid.
+func decodeLongPollTimeout(jc jape.Context) (time.Duration, error) {
+	str := jc.Request.FormValue("timeout")
+	if str == "" {
+		return defaultLongPollTimeout, nil
+	}
+	timeout, err := time.ParseDuration(str)
+	if err != nil {
+		err = fmt.Errorf("invalid timeout: %w", err)
+	} else if timeout <= 0 || timeout > maxLongPollTimeout {
+		err = fmt.Errorf("timeout must be between 0 and %v", maxLongPollTimeout)
+	}
+	if err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, err)
+		return 0, err
+	}
+	return timeout, nil
 }
 
 func (s *server) handleGetConsensusTip(jc jape.Context) {
+	var since types.ChainIndex
+	if jc.Request.FormValue("since") == "" {
+		jc.Encode(s.chain.Tip())
+		return
+	} else if decodeForm(jc, "since", &since) != nil {
+		return
+	}
+	timeout, err := decodeLongPollTimeout(jc)
+	if err != nil {
+		return
+	}
+
+	// subscribe before checking the tip so that a change between the check
+	// and the subscription can't be missed
+	changed := make(chan struct{}, 1)
+	unsubscribe := s.chain.OnReorg(func(types.ChainIndex) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	if tip := s.chain.Tip(); tip != since {
+		jc.Encode(tip)
+		return
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-changed:
+	case <-t.C:
+	case <-jc.Request.Context().Done():
+		return
+	}
 	jc.Encode(s.chain.Tip())
 }
 
-// NewHandler returns a new HTTP handler for the API.
-func NewHandler(cm ChainManager) http.Handler {
-	s := &server{
-		chain: cm,
+func (s *server) handleGetConsensusTipState(jc jape.Context) {
+	// TipState is a single snapshot, so the index and the rest of the state
+	// are always consistent with each other.
+	jc.Encode(s.chain.TipState())
+}
+
+func (s *server) handleGetConsensusHardforks(jc jape.Context) {
+	tip := s.chain.Tip()
+	n := s.network
+	hardforks := []Hardfork{
+		{Name: "devAddr", Description: "developer address change", Height: n.HardforkDevAddr.Height},
+		{Name: "tax", Description: "siafund tax calculation fix", Height: n.HardforkTax.Height},
+		{Name: "storageProof", Description: "storage proof leaf fix", Height: n.HardforkStorageProof.Height},
+		{Name: "oak", Description: "Oak difficulty adjustment", Height: n.HardforkOak.Height},
+		{Name: "oakFix", Description: "Oak difficulty adjustment fix", Height: n.HardforkOak.FixHeight},
+		{Name: "asic", Description: "ASIC nonce factor", Height: n.HardforkASIC.Height},
+		{Name: "foundation", Description: "Foundation subsidy", Height: n.HardforkFoundation.Height},
+		{Name: "v2Allow", Description: "v2 transactions are allowed", Height: n.HardforkV2.AllowHeight},
+		{Name: "v2Require", Description: "v1 transactions are rejected", Height: n.HardforkV2.RequireHeight},
+		{Name: "v2FinalCut", Description: "v1 block IDs and targets are retired", Height: n.HardforkV2.FinalCutHeight},
+		{Name: "v2EphemeralOutput", Description: "ephemeral output rules are enforced", Height: n.HardforkV2.EphemeralOutputHeight},
+	}
+	for i := range hardforks {
+		hf := &hardforks[i]
+		hf.Active = tip.Height >= hf.Height
+		if !hf.Active {
+			hf.Remaining = hf.Height - tip.Height
+		}
+	}
+	jc.Encode(ConsensusHardforksResponse{
+		Tip:       tip,
+		Hardforks: hardforks,
+	})
+}
+
+// minedSupply returns the sum of the block rewards for heights 1 through
+// height. The reward decreases by 1 SC per block until it reaches the minimum
+// coinbase.
+func minedSupply(n *consensus.Network, height uint64) types.Currency {
+	sc := types.Siacoins(1)
+	initial, minimum := n.InitialCoinbase.Big(), n.MinimumCoinbase.Big()
+
+	// decreasing is the number of heights at which the reward is above the
+	// minimum
+	var decreasing uint64
+	if n.InitialCoinbase.Cmp(n.MinimumCoinbase) > 0 {
+		decreasing = new(big.Int).Div(new(big.Int).Sub(initial, minimum), sc.Big()).Uint64()
+	}
+	decreasing = min(decreasing, height)
+
+	// sum of (initial - h*sc) for h in [1, decreasing]
+	sum := new(big.Int).Mul(initial, new(big.Int).SetUint64(decreasing))
+	triangle := new(big.Int).Mul(new(big.Int).SetUint64(decreasing), new(big.Int).SetUint64(decreasing+1))
+	triangle.Rsh(triangle, 1)
+	sum.Sub(sum, triangle.Mul(triangle, sc.Big()))
+	// remaining heights are paid the minimum coinbase
+	sum.Add(sum, new(big.Int).Mul(minimum, new(big.Int).SetUint64(height-decreasing)))
+	return types.NewCurrency(sum.Uint64(), new(big.Int).Rsh(sum, 64).Uint64())
+}
+
+// foundationSupply returns the total Foundation subsidy paid out through
+// height, matching [consensus.State.FoundationSubsidy].
+func foundationSupply(n *consensus.Network, height uint64) types.Currency {
+	if height < n.HardforkFoundation.Height {
+		return types.ZeroCurrency
+	}
+	subsidyPerBlock := types.Siacoins(30000)
+	blocksPerYear := uint64(365 * 24 * time.Hour / n.BlockInterval)
+	blocksPerMonth := blocksPerYear / 12
+	months := (height - n.HardforkFoundation.Height) / blocksPerMonth
+	return subsidyPerBlock.Mul64(blocksPerYear).Add(subsidyPerBlock.Mul64(blocksPerMonth * months))
+}
+
+func (s *server) handleGetConsensusSupply(jc jape.Context) {
+	cs := s.chain.TipState()
+
+	// the genesis block may create outputs directly
+	genesis, ok := s.chain.Block(s.genesisID)
+	if !ok {
+		writeError(jc, http.StatusInternalServerError, ErrorCodeInternal, errors.New("missing genesis block"))
+		return
+	}
+	var supply types.Currency
+	for _, sco := range genesis.MinerPayouts {
+		supply = supply.Add(sco.Value)
+	}
+	for _, txn := range genesis.Transactions {
+		for _, sco := range txn.SiacoinOutputs {
+			supply = supply.Add(sco.Value)
+		}
+	}
+
+	subsidy := foundationSupply(cs.Network, cs.Index.Height)
+	jc.Encode(ConsensusSupplyResponse{
+		Index:             cs.Index,
+		CirculatingSupply: supply.Add(minedSupply(cs.Network, cs.Index.Height)).Add(subsidy),
+		BlockReward:       cs.BlockReward(),
+		SiafundPool:       cs.SiafundTaxRevenue,
+		FoundationSubsidy: subsidy,
+	})
+}
+
+func (s *server) handleGetConsensusDifficulty(jc jape.Context) {
+	window := uint64(defaultHashrateWindow)
+	if decodeForm(jc, "window", &window) != nil {
+		return
+	} else if window == 0 || window > maxHashrateWindow {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("window must be between 1 and %d", maxHashrateWindow))
+		return
+	}
+
+	cs := s.chain.TipState()
+	window = min(window, cs.Index.Height)
+	resp := ConsensusDifficultyResponse{
+		Index:      cs.Index,
+		Target:     cs.PoWTarget(),
+		Difficulty: cs.Difficulty,
+		Hashrate:   "0",
+		Window:     window,
+	}
+	if window == 0 {
+		jc.Encode(resp)
+		return
+	}
+
+	ancestorIndex, ok := s.chain.BestIndex(cs.Index.Height - window)
+	if !ok {
+		writeError(jc, http.StatusInternalServerError, ErrorCodeInternal, ErrIndexNotFound)
+		return
+	}
+	ancestor, ok := s.chain.State(ancestorIndex.ID)
+	if !ok {
+		writeError(jc, http.StatusInternalServerError, ErrorCodeInternal, ErrBlockNotFound)
+		return
+	}
+
+	// the hashrate is the work added to the chain divided by the time taken
+	// to add it
+	elapsed := cs.PrevTimestamps[0].Sub(ancestor.PrevTimestamps[0])
+	if elapsed >= time.Second {
+		work, _ := new(big.Int).SetString(cs.TotalWork.String(), 10)
+		ancestorWork, _ := new(big.Int).SetString(ancestor.TotalWork.String(), 10)
+		hashrate := work.Sub(work, ancestorWork)
+		hashrate.Div(hashrate, big.NewInt(int64(elapsed/time.Second)))
+		resp.Hashrate = hashrate.String()
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetHealth(jc jape.Context) {
+	var resp HealthResponse
+	check := func(name string, passed bool, format string, args ...any) {
+		resp.Checks = append(resp.Checks, HealthCheck{
+			Name:    name,
+			Passed:  passed,
+			Message: fmt.Sprintf(format, args...),
+		})
+		if !passed {
+			resp.Failing = append(resp.Failing, name)
+		}
+	}
+
+	// reading the tip block requires the consensus database
+	cs := s.chain.TipState()
+	_, ok := s.chain.Block(cs.Index.ID)
+	check("consensus", ok, "tip %v", cs.Index)
+
+	// if no listeners were provided, assume each syncer has one
+	listening := len(s.syncers)
+	if len(s.listeners) > 0 {
+		listening = 0
+		for _, l := range s.listeners {
+			if l.Active {
+				listening++
+			}
+		}
+	}
+	check("listeners", listening > 0, "%d active", listening)
+
+	var connected int
+	for _, sy := range s.syncers {
+		connected += len(sy.Peers())
+	}
+	check("peers", connected >= s.healthMinPeers, "%d connected, minimum %d", connected, s.healthMinPeers)
+
+	age := time.Since(cs.PrevTimestamps[0]).Round(time.Second)
+	check("tip", age <= s.healthMaxTipAge, "tip is %v old, maximum %v", age, s.healthMaxTipAge)
+
+	resp.Healthy = len(resp.Failing) == 0
+	if resp.Failing == nil {
+		resp.Failing = []string{}
+	}
+	if !resp.Healthy {
+		jc.ResponseWriter.Header().Set("Content-Type", "application/json")
+		jc.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetHealthLive(jc jape.Context) {
+	jc.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleGetState(jc jape.Context) {
+	jc.Encode(StateResponse{
+		BuildState: BuildState{
+			Version:   build.Version(),
+			Commit:    build.Commit(),
+			BuildTime: build.Time(),
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+		},
+		StartTime: s.startTime,
+		Network:   s.network.Name,
+		DataDir:   s.dataDir,
+	})
+}
+
+func (s *server) handleGetConsensusNetwork(jc jape.Context) {
+	jc.Encode(ConsensusNetworkResponse{
+		Network:   s.network,
+		GenesisID: s.genesisID,
+	})
+}
+
+func (s *server) handleGetConsensusBlocksID(jc jape.Context) {
+	var id types.BlockID
+	if decodeParam(jc, "id", &id) != nil {
+		return
+	}
+	b, ok := s.chain.Block(id)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+		return
+	}
+	jc.Encode(b)
+}
+
+func (s *server) handleGetConsensusBlocksIDSummary(jc jape.Context) {
+	var id types.BlockID
+	if decodeParam(jc, "id", &id) != nil {
+		return
 	}
-	return jape.Mux(map[string]jape.Handler{
-		"GET /consensus/tip": s.handleGetConsensusTip,
+	b, ok := s.chain.Block(id)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+		return
+	}
+	cs, ok := s.chain.State(id)
+	if !ok {
+		writeError(jc, http.StatusInternalServerError, ErrorCodeInternal, fmt.Errorf("missing state for block %v", id))
+		return
+	}
+
+	summary := BlockSummary{
+		ID:             id,
+		Height:         cs.Index.Height,
+		ParentID:       b.ParentID,
+		Timestamp:      b.Timestamp,
+		TransactionIDs: make([]types.TransactionID, 0, len(b.Transactions)+len(b.V2Transactions())),
+	}
+	for _, mp := range b.MinerPayouts {
+		summary.MinerPayouts = summary.MinerPayouts.Add(mp.Value)
+	}
+	for _, txn := range b.Transactions {
+		for _, fee := range txn.MinerFees {
+			summary.TotalFees = summary.TotalFees.Add(fee)
+		}
+		for _, sco := range txn.SiacoinOutputs {
+			summary.SiacoinOutflow = summary.SiacoinOutflow.Add(sco.Value)
+		}
+		summary.TransactionIDs = append(summary.TransactionIDs, txn.ID())
+	}
+	for _, txn := range b.V2Transactions() {
+		summary.TotalFees = summary.TotalFees.Add(txn.MinerFee)
+		for _, sco := range txn.SiacoinOutputs {
+			summary.SiacoinOutflow = summary.SiacoinOutflow.Add(sco.Value)
+		}
+		summary.TransactionIDs = append(summary.TransactionIDs, txn.ID())
+	}
+	summary.TransactionCount = len(summary.TransactionIDs)
+	jc.Encode(summary)
+}
+
+func (s *server) handleGetConsensusBlocksIDTransactions(jc jape.Context) {
+	var id types.BlockID
+	if decodeParam(jc, "id", &id) != nil {
+		return
+	}
+	offset, limit := 0, defaultTransactionsLimit
+	if decodeForm(jc, "offset", &offset) != nil || decodeForm(jc, "limit", &limit) != nil {
+		return
+	} else if offset < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, errors.New("offset must be non-negative"))
+		return
+	} else if limit <= 0 || limit > maxTransactionsLimit {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("limit must be between 1 and %d", maxTransactionsLimit))
+		return
+	}
+
+	b, ok := s.chain.Block(id)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+		return
+	}
+
+	// v1 transactions are listed before v2 transactions, matching their
+	// order in the block's Merkle tree
+	txns := make([]BlockTransaction, 0, len(b.Transactions)+len(b.V2Transactions()))
+	for i := range b.Transactions {
+		txns = append(txns, BlockTransaction{
+			ID:          b.Transactions[i].ID(),
+			Transaction: &b.Transactions[i],
+		})
+	}
+	for i := range b.V2Transactions() {
+		txn := &b.V2.Transactions[i]
+		txns = append(txns, BlockTransaction{
+			ID:            txn.ID(),
+			V2Transaction: txn,
+		})
+	}
+	if offset >= len(txns) {
+		jc.Encode([]BlockTransaction{})
+		return
+	}
+	jc.Encode(txns[offset:min(offset+limit, len(txns))])
+}
+
+func (s *server) handleGetConsensusBlocksIDProofTxID(jc jape.Context) {
+	var id types.BlockID
+	var txid types.TransactionID
+	if decodeParam(jc, "id", &id) != nil || decodeParam(jc, "txid", &txid) != nil {
+		return
+	}
+	b, ok := s.chain.Block(id)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+		return
+	}
+
+	// the transaction leaves follow the miner payout leaves in v1 blocks and
+	// the state leaf in v2 blocks
+	offset := len(b.MinerPayouts)
+	if b.V2 != nil {
+		offset = 1
+	}
+	index := -1
+	for i := range b.Transactions {
+		if b.Transactions[i].ID() == txid {
+			index = offset + i
+			break
+		}
+	}
+	for i := range b.V2Transactions() {
+		if index == -1 && b.V2.Transactions[i].ID() == txid {
+			index = offset + len(b.Transactions) + i
+			break
+		}
+	}
+	if index == -1 {
+		writeError(jc, http.StatusNotFound, ErrorCodeTransactionNotFound, ErrTransactionNotFound)
+		return
+	}
+
+	var parent consensus.State
+	if b.V2 != nil {
+		if parent, ok = s.chain.State(b.ParentID); !ok {
+			writeError(jc, http.StatusInternalServerError, ErrorCodeInternal, ErrUnknownParent)
+			return
+		}
+	}
+	leaves := commitmentLeaves(parent, b)
+	jc.Encode(TransactionProof{
+		Header:    b.Header(),
+		LeafIndex: uint64(index),
+		NumLeaves: uint64(len(leaves)),
+		Siblings:  merkleProof(leaves, index),
 	})
 }
+
+func (s *server) handleGetConsensusHeaders(jc jape.Context) {
+	var start uint64
+	limit := uint64(defaultHeadersLimit)
+	if decodeForm(jc, "start", &start) != nil || decodeForm(jc, "limit", &limit) != nil {
+		return
+	} else if limit == 0 || limit > maxHeadersLimit {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("limit must be between 1 and %d", maxHeadersLimit))
+		return
+	}
+
+	headers := make([]ConsensusHeader, 0, limit)
+	if start == 0 {
+		// the chain manager only returns headers after a given index, so the
+		// genesis header is read from its block
+		genesis, ok := s.chain.Block(s.genesisID)
+		if !ok {
+			writeError(jc, http.StatusInternalServerError, ErrorCodeInternal, ErrBlockNotFound)
+			return
+		}
+		headers = append(headers, ConsensusHeader{
+			ID:          s.genesisID,
+			Height:      0,
+			BlockHeader: genesis.Header(),
+		})
+		start, limit = 1, limit-1
+	}
+
+	parent, ok := s.chain.BestIndex(start - 1)
+	if !ok || limit == 0 {
+		// the range starts after the tip
+		jc.Encode(headers)
+		return
+	}
+	bhs, _, err := s.chain.Headers(parent, limit)
+	if s.check(jc, "failed to get headers", err) != nil {
+		return
+	}
+	for i, bh := range bhs {
+		headers = append(headers, ConsensusHeader{
+			ID:          bh.ID(),
+			Height:      start + uint64(i),
+			BlockHeader: bh,
+		})
+	}
+	jc.Encode(headers)
+}
+
+func (s *server) handleGetConsensusIndexHeight(jc jape.Context) {
+	// "tip" is accepted in place of a height so that scripts can use a
+	// single endpoint for every lookup
+	if jc.PathParam("height") == "tip" {
+		jc.Encode(s.chain.Tip())
+		return
+	}
+	var height uint64
+	if decodeParam(jc, "height", &height) != nil {
+		return
+	}
+	index, ok := s.chain.BestIndex(height)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeIndexNotFound, ErrIndexNotFound)
+		return
+	}
+	jc.Encode(index)
+}
+
+func (s *server) handleGetConsensusUpdatesIndex(jc jape.Context) {
+	// an empty index (0::0000...) starts from the genesis block
+	var index types.ChainIndex
+	if decodeParam(jc, "index", &index) != nil {
+		return
+	}
+	limit := defaultUpdatesLimit
+	if decodeForm(jc, "limit", &limit) != nil {
+		return
+	} else if limit <= 0 || limit > maxUpdatesLimit {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("limit must be between 1 and %d", maxUpdatesLimit))
+		return
+	}
+
+	reverted, applied, err := s.chain.UpdatesSince(index, limit)
+	if errors.Is(err, chain.ErrMissingBlock) {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, err)
+		return
+	} else if s.check(jc, "failed to get updates", err) != nil {
+		return
+	}
+
+	resp := ConsensusUpdatesResponse{
+		Reorg:    len(reverted) > 0,
+		Reverted: make([]UpdateSummary, 0, len(reverted)),
+		Applied:  make([]UpdateSummary, 0, len(applied)),
+		Index:    index,
+	}
+	for _, cru := range reverted {
+		revertedIndex := types.ChainIndex{Height: cru.State.Index.Height + 1, ID: cru.Block.ID()}
+		resp.Reverted = append(resp.Reverted, summarizeUpdate(revertedIndex, cru.SiacoinElementDiffs(), cru.SiafundElementDiffs()))
+		resp.Index = cru.State.Index
+	}
+	for _, cau := range applied {
+		resp.Applied = append(resp.Applied, summarizeUpdate(cau.State.Index, cau.SiacoinElementDiffs(), cau.SiafundElementDiffs()))
+		resp.Index = cau.State.Index
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handlePostConsensusBlocks(jc jape.Context) {
+	var b types.Block
+	if decode(jc, &b) != nil {
+		return
+	}
+	// submissions are counted towards the external miner's statistics
+	record := func(result string) {
+		if s.miner != nil {
+			s.miner.RecordSubmission(clientIP(jc.Request, s.trustProxy), result)
+		}
+	}
+	stale := b.ParentID != s.chain.Tip().ID
+	if _, ok := s.chain.State(b.ParentID); !ok {
+		// let the miner know it should fetch a new template
+		record(mining.SubmissionStale)
+		writeError(jc, http.StatusConflict, ErrorCodeUnknownParent, ErrUnknownParent)
+		return
+	} else if err := s.chain.AddBlocks([]types.Block{b}); err != nil {
+		record(mining.SubmissionRejected)
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidBlock, err)
+		return
+	} else if stale {
+		record(mining.SubmissionStale)
+	} else {
+		record(mining.SubmissionAccepted)
+	}
+
+	BroadcastBlock(s.chain, s.syncers, b, s.log)
+}
+
+// BroadcastBlock relays b, which has been added to cm, to the peers of each
+// of syncers. v2 blocks are relayed as outlines of cm's pool transactions;
+// v1 blocks can't be outlined, so only their header is announced.
+func BroadcastBlock(cm ChainManager, syncers []Syncer, b types.Block, log *zap.Logger) {
+	broadcast := func(sy Syncer) error { return sy.BroadcastV2Header(b.Header()) }
+	if b.V2 != nil {
+		outline := gateway.OutlineBlock(b, cm.PoolTransactions(), cm.V2PoolTransactions())
+		broadcast = func(sy Syncer) error { return sy.BroadcastV2BlockOutline(outline) }
+	}
+	for _, sy := range syncers {
+		if err := broadcast(sy); errors.Is(err, syncer.ErrNoPeers) {
+			log.Debug("no peers to relay block to", zap.Stringer("block", b.ID()))
+		} else if err != nil {
+			log.Warn("failed to relay block", zap.Stringer("block", b.ID()), zap.Error(err))
+		}
+	}
+}
+
+// medianTimestamp returns the median timestamp of the blocks before cs's
+// child, which v2 spend policies are verified against.
+func medianTimestamp(cs consensus.State) time.Time {
+	n := min(cs.Index.Height+1, uint64(len(cs.PrevTimestamps)))
+	ts := slices.Clone(cs.PrevTimestamps[:n])
+	slices.SortFunc(ts, time.Time.Compare)
+	if len(ts)%2 != 0 {
+		return ts[len(ts)/2]
+	}
+	l, r := ts[len(ts)/2-1], ts[len(ts)/2]
+	return l.Add(r.Sub(l) / 2)
+}
+
+// invalidInput returns the first input of txn that can't be spent in cs,
+// given the elements spent and created by the transactions before it in its
+// set, or nil if every input can be spent and the transaction is invalid for
+// another reason.
+func invalidInput(cs consensus.State, txn types.V2Transaction, spent, created map[types.Hash256]bool) *ValidationInput {
+	sigHash := cs.InputSigHash(txn)
+	median := medianTimestamp(cs)
+	seen := make(map[types.Hash256]bool)
+	spendable := func(id types.Hash256, leafIndex uint64, probe types.V2Transaction, addr types.Address, sp types.SatisfiedPolicy) bool {
+		switch {
+		case spent[id] || seen[id]:
+			return false // double spend
+		case leafIndex == types.UnassignedLeafIndex && !created[id]:
+			return false // nonexistent ephemeral output
+		case leafIndex != types.UnassignedLeafIndex && cs.Elements.ValidateTransactionElements(probe) != nil:
+			return false // spent, or not in the accumulator
+		case sp.Policy.Address() != addr:
+			return false
+		case sp.Policy.Verify(cs.Index.Height, median, sigHash, sp.Signatures, sp.Preimages) != nil:
+			return false
+		}
+		seen[id] = true
+		return true
+	}
+
+	for i, sci := range txn.SiacoinInputs {
+		probe := types.V2Transaction{SiacoinInputs: []types.V2SiacoinInput{sci}}
+		if sci.Parent.MaturityHeight > cs.Index.Height+1 ||
+			!spendable(types.Hash256(sci.Parent.ID), sci.Parent.StateElement.LeafIndex, probe, sci.Parent.SiacoinOutput.Address, sci.SatisfiedPolicy) {
+			return &ValidationInput{Type: "siacoin", Index: i}
+		}
+	}
+	for i, sfi := range txn.SiafundInputs {
+		probe := types.V2Transaction{SiafundInputs: []types.V2SiafundInput{sfi}}
+		if !spendable(types.Hash256(sfi.Parent.ID), sfi.Parent.StateElement.LeafIndex, probe, sfi.Parent.SiafundOutput.Address, sfi.SatisfiedPolicy) {
+			return &ValidationInput{Type: "siafund", Index: i}
+		}
+	}
+	return nil
+}
+
+// validateBlockOutline performs the checks that consensus.ValidateBlock
+// makes before validating b's transactions, given an empty supplement. If
+// they pass, a block that ValidateBlock rejects was rejected because of one
+// of its transactions.
+func validateBlockOutline(cs consensus.State, b types.Block) error {
+	if err := consensus.ValidateOrphan(cs, b); err != nil {
+		return err
+	} else if len(b.Transactions) != 0 {
+		// v1 transactions are only validated after the require height, when
+		// a block containing them is rejected for its supplement
+		return errors.New("v1 transactions require a block supplement")
+	} else if b.V2 != nil && b.V2.Commitment != cs.Commitment(b.MinerPayouts[0].Address, b.Transactions, b.V2Transactions()) {
+		return consensus.ErrCommitmentMismatch
+	}
+	return nil
+}
+
+// validateTransactions validates a transaction set in the context of cs,
+// applying each transaction so that later transactions can spend the outputs
+// of earlier ones. It returns the result for the first invalid transaction.
+func validateTransactions(cs consensus.State, txns []types.Transaction, v2txns []types.V2Transaction) ConsensusValidateResponse {
+	ms := consensus.NewMidState(cs)
+	// the elements spent and created by the set so far, against which the
+	// inputs of an invalid transaction are checked
+	spent := make(map[types.Hash256]bool)
+	created := make(map[types.Hash256]bool)
+	invalid := func(id types.TransactionID, err error) ConsensusValidateResponse {
+		return ConsensusValidateResponse{
+			Error:         err.Error(),
+			TransactionID: &id,
+		}
+	}
+
+	for _, txn := range txns {
+		// v1 transactions are only invalid after the require height, which
+		// is checked before any supplement is needed, so their inputs are
+		// never at fault
+		if err := consensus.ValidateTransaction(ms, txn, consensus.V1TransactionSupplement{}); err != nil {
+			return invalid(txn.ID(), err)
+		}
+		ms.ApplyTransaction(txn, consensus.V1TransactionSupplement{})
+		for _, sci := range txn.SiacoinInputs {
+			spent[types.Hash256(sci.ParentID)] = true
+		}
+		for _, sfi := range txn.SiafundInputs {
+			spent[types.Hash256(sfi.ParentID)] = true
+		}
+		for i := range txn.SiacoinOutputs {
+			created[types.Hash256(txn.SiacoinOutputID(i))] = true
+		}
+		for i := range txn.SiafundOutputs {
+			created[types.Hash256(txn.SiafundOutputID(i))] = true
+		}
+	}
+	for _, txn := range v2txns {
+		if err := consensus.ValidateV2Transaction(ms, txn); err != nil {
+			resp := invalid(txn.ID(), err)
+			resp.Input = invalidInput(cs, txn, spent, created)
+			return resp
+		}
+		ms.ApplyV2Transaction(txn)
+		txid := txn.ID()
+		for _, sci := range txn.SiacoinInputs {
+			spent[types.Hash256(sci.Parent.ID)] = true
+		}
+		for _, sfi := range txn.SiafundInputs {
+			spent[types.Hash256(sfi.Parent.ID)] = true
+		}
+		for i := range txn.SiacoinOutputs {
+			created[types.Hash256(txn.SiacoinOutputID(txid, i))] = true
+		}
+		for i := range txn.SiafundOutputs {
+			created[types.Hash256(txn.SiafundOutputID(txid, i))] = true
+		}
+	}
+	return ConsensusValidateResponse{Valid: true}
+}
+
+func (s *server) handlePostConsensusValidate(jc jape.Context) {
+	var req ConsensusValidateRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if req.Block != nil && (len(req.Transactions) != 0 || len(req.V2Transactions) != 0) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("either a block or transactions must be provided, not both"))
+		return
+	} else if req.Block == nil && len(req.Transactions) == 0 && len(req.V2Transactions) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("a block or transactions must be provided"))
+		return
+	}
+
+	cs := s.chain.TipState()
+	if req.Block != nil {
+		var ok bool
+		if cs, ok = s.chain.State(req.Block.ParentID); !ok {
+			writeError(jc, http.StatusConflict, ErrorCodeUnknownParent, ErrUnknownParent)
+			return
+		}
+		req.Transactions, req.V2Transactions = req.Block.Transactions, req.Block.V2Transactions()
+	}
+	// validating v1 transactions requires a supplement from the chain store,
+	// which is not available to the API
+	if len(req.Transactions) != 0 && cs.Index.Height+1 < cs.Network.HardforkV2.RequireHeight {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("v1 transactions cannot be validated before the v2 require height"))
+		return
+	}
+
+	if req.Block == nil {
+		jc.Encode(validateTransactions(cs, req.Transactions, req.V2Transactions))
+		return
+	}
+
+	b := *req.Block
+	if b.Timestamp.After(cs.MaxFutureTimestamp(time.Now())) {
+		jc.Encode(ConsensusValidateResponse{Error: "block timestamp is too far in the future"})
+		return
+	} else if err := consensus.ValidateBlock(cs, b, consensus.V1BlockSupplement{}); err != nil {
+		// attribute the error to a transaction if the block was rejected
+		// because of it
+		resp := ConsensusValidateResponse{Error: err.Error()}
+		if validateBlockOutline(cs, b) == nil {
+			txnResp := validateTransactions(cs, req.Transactions, req.V2Transactions)
+			resp.TransactionID, resp.Input = txnResp.TransactionID, txnResp.Input
+		}
+		jc.Encode(resp)
+		return
+	}
+	jc.Encode(ConsensusValidateResponse{Valid: true})
+}
+
+func (s *server) handleGetConsensusCheckpointID(jc jape.Context) {
+	// the checkpoint can be requested either by height or by block ID
+	var id types.BlockID
+	if height, err := strconv.ParseUint(jc.PathParam("id"), 10, 64); err == nil {
+		index, ok := s.chain.BestIndex(height)
+		if !ok {
+			writeError(jc, http.StatusNotFound, ErrorCodeIndexNotFound, ErrIndexNotFound)
+			return
+		}
+		id = index.ID
+	} else if decodeParam(jc, "id", &id) != nil {
+		return
+	}
+
+	// blocks and states are keyed by ID, so the pair is consistent even if
+	// a reorg removes the block from the best chain between the two calls.
+	b, ok := s.chain.Block(id)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+		return
+	}
+	cs, ok := s.chain.State(id)
+	if !ok {
+		writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+		return
+	}
+	jc.Encode(ConsensusCheckpointResponse{
+		State: cs,
+		Block: b,
+	})
+}
+
+func (s *server) handlePostConsensusBlocksBatch(jc jape.Context) {
+	var req ConsensusBlocksBatchRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	jc.Custom(nil, []BatchBlock{})
+
+	var ids []types.BlockID
+	if len(req.IDs) > 0 {
+		if len(req.IDs) > s.maxBatchSize {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("batch size must not exceed %d", s.maxBatchSize))
+			return
+		}
+		ids = req.IDs
+	} else {
+		if req.Limit == 0 || req.Limit > uint64(s.maxBatchSize) {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("limit must be between 1 and %d", s.maxBatchSize))
+			return
+		}
+		// the range is clamped to the tip, without computing Start+Limit,
+		// which can overflow
+		n := req.Limit
+		if tip := s.chain.Tip(); req.Start > tip.Height {
+			n = 0
+		} else if n > tip.Height-req.Start {
+			n = tip.Height - req.Start + 1
+		}
+		for i := uint64(0); i < n; i++ {
+			index, ok := s.chain.BestIndex(req.Start + i)
+			if !ok {
+				break // a reorg shortened the chain
+			}
+			ids = append(ids, index.ID)
+		}
+	}
+
+	// blocks are encoded as they are fetched to avoid holding the entire
+	// batch in memory
+	w := jc.ResponseWriter
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	io.WriteString(w, "[")
+	for i, id := range ids {
+		if jc.Request.Context().Err() != nil {
+			return // timed out or client disconnected
+		} else if i > 0 {
+			io.WriteString(w, ",")
+		}
+		bb := BatchBlock{ID: id}
+		if b, ok := s.chain.Block(id); ok {
+			bb.Found = true
+			bb.Block = &b
+		}
+		if err := enc.Encode(bb); err != nil {
+			return // client disconnected
+		}
+	}
+	io.WriteString(w, "]\n")
+}
+
+func (s *server) summarizePeer(sy Syncer, p *syncer.Peer) SyncerPeer {
+	sp := SyncerPeer{
+		Address:     p.Addr(),
+		ConnAddress: p.ConnAddr,
+		Inbound:     p.Inbound,
+		Version:     p.Version(),
+	}
+	// the peer store records when each peer last connected
+	if info, err := sy.PeerInfo(p.Addr()); err == nil {
+		sp.ConnectedSince = info.LastConnect
+		sp.ConnectionDuration = time.Since(info.LastConnect)
+	}
+	if s.pinner != nil {
+		sp.Pinned = s.pinner.Pinned(p.Addr()) || s.pinner.Pinned(p.ConnAddr)
+	}
+	if s.tracker != nil {
+		if stats, ok := s.tracker.ConnStats(p.ConnAddr); ok {
+			sp.Latency = stats.Latency
+			sp.AverageLatency = stats.AverageLatency
+			sp.ProbeError = stats.ProbeError
+		}
+	}
+	return sp
+}
+
+// isListenAddr reports whether addr refers to a syncer's listen address on
+// this machine.
+func isListenAddr(addr, listenAddr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	listenHost, listenPort, err := net.SplitHostPort(listenAddr)
+	if err != nil || port != listenPort {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return strings.EqualFold(host, "localhost")
+	} else if ip.IsLoopback() || ip.IsUnspecified() || ip.Equal(net.ParseIP(listenHost)) {
+		return true
+	}
+	ifaceAddrs, _ := net.InterfaceAddrs()
+	for _, ifaceAddr := range ifaceAddrs {
+		if ipnet, ok := ifaceAddr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncerFor returns the syncer whose listener matches the address family of
+// addr, falling back to the first syncer.
+func (s *server) syncerFor(addr string) (Syncer, bool) {
+	if len(s.syncers) == 0 {
+		return nil, false
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	ip := net.ParseIP(host)
+	wantV6 := ip != nil && ip.To4() == nil
+	for _, sy := range s.syncers {
+		listenHost, _, err := net.SplitHostPort(sy.Addr())
+		if err != nil {
+			continue
+		}
+		listenIP := net.ParseIP(listenHost)
+		if listenIP != nil && (listenIP.To4() == nil) == wantV6 {
+			return sy, true
+		}
+	}
+	return s.syncers[0], true
+}
+
+func (s *server) handleGetSyncerPeers(jc jape.Context) {
+	peers := make([]SyncerPeer, 0)
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			peers = append(peers, s.summarizePeer(sy, p))
+		}
+	}
+	slices.SortFunc(peers, func(a, b SyncerPeer) int { return strings.Compare(a.Address, b.Address) })
+	jc.Encode(peers)
+}
+
+func (s *server) handleGetSyncerPeersAddress(jc jape.Context) {
+	addr := jc.PathParam("address")
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			if p.Addr() != addr && p.ConnAddr != addr {
+				continue
+			}
+			uid := p.UniqueID()
+			detail := SyncerPeerDetail{
+				SyncerPeer: s.summarizePeer(sy, p),
+				UniqueID:   hex.EncodeToString(uid[:]),
+				Synced:     p.Synced(),
+			}
+			if info, err := sy.PeerInfo(p.Addr()); err == nil {
+				detail.SyncedBlocks = info.SyncedBlocks
+				detail.SyncDuration = info.SyncDuration
+			}
+			if s.tracker != nil {
+				if stats, ok := s.tracker.ConnStats(p.ConnAddr); ok {
+					detail.BytesSent = stats.BytesWritten
+					detail.BytesReceived = stats.BytesRead
+					detail.RPCsInFlight = stats.RPCsInFlight
+				}
+			}
+			jc.Encode(detail)
+			return
+		}
+	}
+	writeError(jc, http.StatusNotFound, ErrorCodePeerNotConnected, ErrPeerNotConnected)
+}
+
+func (s *server) handlePostSyncerPeersAddressPing(jc jape.Context) {
+	addr := jc.PathParam("address")
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			if p.Addr() != addr && p.ConnAddr != addr {
+				continue
+			}
+			var rtt time.Duration
+			var err error
+			if s.tracker != nil {
+				rtt, err = s.tracker.Probe(p, pingTimeout)
+			} else {
+				start := time.Now()
+				_, err = p.ShareNodes(pingTimeout)
+				rtt = time.Since(start)
+			}
+			if err != nil {
+				writeError(jc, http.StatusBadGateway, ErrorCodePeerUnreachable, fmt.Errorf("failed to ping peer: %w", err))
+				return
+			}
+			jc.Encode(SyncerPingResponse{Latency: rtt})
+			return
+		}
+	}
+	writeError(jc, http.StatusNotFound, ErrorCodePeerNotConnected, ErrPeerNotConnected)
+}
+
+// refreshPeerHeights asks each connected peer for the height of its tip and
+// replaces the cached heights. Peers are asked for the number of headers after
+// the genesis block, which is their height regardless of which chain the node
+// is on.
+func (s *server) refreshPeerHeights() {
+	genesis := consensus.State{Index: types.ChainIndex{Height: 0, ID: s.genesisID}}
+	var mu sync.Mutex
+	heights := make(map[string]uint64)
+	var wg sync.WaitGroup
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var height uint64
+				err := s.callPeer(p, func() (err error) {
+					_, height, err = p.SendHeaders(genesis, 0, statusTimeout)
+					return
+				})
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				heights[p.ConnAddr] = height
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+	s.peerHeightsMu.Lock()
+	s.peerHeights = heights
+	s.peerHeightsMu.Unlock()
+}
+
+// trackPeerHeights refreshes the cached peer heights every
+// peerHeightInterval until the server's context is done.
+func (s *server) trackPeerHeights() {
+	ticker := time.NewTicker(peerHeightInterval)
+	defer ticker.Stop()
+	for {
+		s.refreshPeerHeights()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// peerHeight returns the highest tip height reported by a connected peer when
+// the peers were last asked, or height if no peer is ahead.
+func (s *server) peerHeight(height uint64) uint64 {
+	s.peerHeightsMu.Lock()
+	defer s.peerHeightsMu.Unlock()
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			if h, ok := s.peerHeights[p.ConnAddr]; ok {
+				height = max(height, h)
+			}
+		}
+	}
+	return height
+}
+
+func (s *server) handleGetSyncerStatus(jc jape.Context) {
+	cs := s.chain.TipState()
+	resp := SyncerStatusResponse{
+		Tip:              cs.Index,
+		TipTimestamp:     cs.PrevTimestamps[0],
+		PeerHeight:       s.peerHeight(cs.Index.Height),
+		BlocksLastMinute: s.events.recentBlocks(),
+	}
+	resp.Synced = time.Since(resp.TipTimestamp) <= syncedTimestampWindow &&
+		resp.PeerHeight <= cs.Index.Height+syncedHeightTolerance
+	if remaining := resp.PeerHeight - cs.Index.Height; remaining > 0 && resp.BlocksLastMinute > 0 {
+		resp.ETA = time.Duration(remaining) * throughputWindow / time.Duration(resp.BlocksLastMinute)
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetSyncerStats(jc jape.Context) {
+	var resp SyncerStatsResponse
+	if s.peers != nil {
+		stats, err := s.peers.Stats()
+		if s.check(jc, "failed to get peer store stats", err) != nil {
+			return
+		}
+		resp.Peers = stats
+	}
+	if s.tracker != nil {
+		resp.Dials = s.tracker.DialStats()
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetSyncerAddress(jc jape.Context) {
+	listeners := s.listeners
+	if listeners == nil {
+		listeners = []SyncerListener{}
+	}
+	jc.Encode(listeners)
+}
+
+// validatePeerAddress checks that addr is a host:port pair that does not
+// refer to one of the node's own syncers.
+func (s *server) validatePeerAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	} else if host == "" {
+		return errors.New("invalid address: missing host")
+	} else if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return errors.New("invalid address: invalid port")
+	}
+	for _, sy := range s.syncers {
+		if isListenAddr(addr, sy.Addr()) {
+			return errors.New("cannot connect to own listen address")
+		}
+	}
+	return nil
+}
+
+func (s *server) handlePostSyncerConnect(jc jape.Context) {
+	var req SyncerConnectRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	if err := s.validatePeerAddress(req.Address); err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidAddress, err)
+		return
+	}
+	host, _, _ := net.SplitHostPort(req.Address)
+
+	// manual connections bypass the syncer's ban checks
+	if s.peers != nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(jc.Request.Context(), host)
+		if err != nil {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidAddress, fmt.Errorf("failed to resolve peer address: %w", err))
+			return
+		}
+		for _, addr := range addrs {
+			if banned, err := s.peers.Banned(addr.String()); s.check(jc, "failed to check ban", err) != nil {
+				return
+			} else if banned {
+				writeError(jc, http.StatusForbidden, ErrorCodePeerBanned, syncer.ErrPeerBanned)
+				return
+			}
+		}
+	}
+
+	sy, ok := s.syncerFor(req.Address)
+	if !ok {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNoSyncer, ErrNoSyncer)
+		return
+	}
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), syncerConnectTimeout)
+	defer cancel()
+	p, err := sy.Connect(ctx, req.Address)
+	if err != nil {
+		writeError(jc, http.StatusBadGateway, ErrorCodePeerUnreachable, fmt.Errorf("failed to connect to peer: %w", err))
+		return
+	} else if s.peers != nil {
+		if s.check(jc, "failed to add peer", s.peers.AddPeer(req.Address)) != nil {
+			return
+		}
+	}
+	jc.Encode(s.summarizePeer(sy, p))
+}
+
+func (s *server) handleDeleteSyncerPeersAddress(jc jape.Context) {
+	// the router matches against the unescaped path, so IPv6 literals such
+	// as [::1]:9981 may be sent either escaped or unescaped
+	addr := jc.PathParam("address")
+	var ban bool
+	if decodeForm(jc, "ban", &ban) != nil {
+		return
+	} else if ban && s.peers == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("banning requires a peer store"))
+		return
+	}
+
+	var found bool
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			if p.Addr() != addr && p.ConnAddr != addr {
+				continue
+			}
+			found = true
+			// ban before disconnecting so the peer can't immediately
+			// reconnect
+			if ban {
+				if s.check(jc, "failed to ban peer", s.peers.Ban(p.ConnAddr, peerBanDuration, "banned via API")) != nil {
+					return
+				}
+			}
+			p.Close()
+		}
+	}
+	if !found {
+		writeError(jc, http.StatusNotFound, ErrorCodePeerNotConnected, ErrPeerNotConnected)
+	}
+}
+
+// ban bans addr, which may be a CIDR subnet, and disconnects any connected
+// peers within it.
+func (s *server) ban(jc jape.Context, addr string, duration time.Duration, reason string) {
+	if s.peers == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("banning requires a peer store"))
+		return
+	}
+	subnet, err := peers.ParseSubnet(addr)
+	if err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidAddress, fmt.Errorf("invalid address: %w", err))
+		return
+	} else if duration < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("duration must be non-negative"))
+		return
+	} else if duration == 0 {
+		duration = peerBanDuration
+	}
+	if reason == "" {
+		reason = "banned via API"
+	}
+	if s.check(jc, "failed to ban peer", s.peers.Ban(subnet.String(), duration, reason)) != nil {
+		return
+	}
+
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			if host, _, err := net.SplitHostPort(p.ConnAddr); err == nil && subnet.Contains(net.ParseIP(host)) {
+				p.Close()
+			}
+		}
+	}
+}
+
+func (s *server) handlePostSyncerPeersAddressBan(jc jape.Context) {
+	var req SyncerBanRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	s.ban(jc, jc.PathParam("address"), req.Duration, req.Reason)
+}
+
+func (s *server) handlePostSyncerPeersAddressPin(jc jape.Context) {
+	if s.pinner == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("pinning requires a peer pinner"))
+		return
+	}
+	addr := jc.PathParam("address")
+	if err := s.validatePeerAddress(addr); err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidAddress, err)
+		return
+	}
+	s.check(jc, "failed to pin peer", s.pinner.Pin(addr))
+}
+
+func (s *server) handleDeleteSyncerPeersAddressPin(jc jape.Context) {
+	if s.pinner == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("pinning requires a peer pinner"))
+		return
+	}
+	addr := jc.PathParam("address")
+	if !s.pinner.Pinned(addr) {
+		writeError(jc, http.StatusNotFound, ErrorCodePeerNotPinned, errors.New("peer is not pinned"))
+		return
+	}
+	s.check(jc, "failed to unpin peer", s.pinner.Unpin(addr))
+}
+
+func (s *server) handleGetSyncerBans(jc jape.Context) {
+	if s.peers == nil {
+		jc.Encode([]peers.Ban{})
+		return
+	}
+	bans, err := s.peers.Bans()
+	if s.check(jc, "failed to get bans", err) != nil {
+		return
+	}
+	jc.Encode(bans)
+}
+
+func (s *server) handlePostSyncerBans(jc jape.Context) {
+	var req SyncerBanRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	s.ban(jc, req.Address, req.Duration, req.Reason)
+}
+
+func (s *server) handleDeleteSyncerBansAddress(jc jape.Context) {
+	if s.peers == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("unbanning requires a peer store"))
+		return
+	}
+	// the catch-all parameter includes the leading slash, and allows CIDR
+	// subnets such as 1.2.3.0/24
+	addr := strings.TrimPrefix(jc.PathParam("address"), "/")
+	if err := s.peers.Unban(addr); errors.Is(err, peers.ErrBanNotFound) {
+		writeError(jc, http.StatusNotFound, ErrorCodeBanNotFound, err)
+		return
+	} else if err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidAddress, err)
+		return
+	}
+}
+
+// callPeer calls fn, which issues an RPC to p, through the peer tracker so
+// that the RPC is counted as in flight.
+func (s *server) callPeer(p *syncer.Peer, fn func() error) error {
+	if s.tracker == nil {
+		return fn()
+	}
+	return s.tracker.RPC(p, fn)
+}
+
+// relay calls fn on every connected peer in parallel, returning the number of
+// peers for which it succeeded.
+func (s *server) relay(fn func(p *syncer.Peer) error) int {
+	var wg sync.WaitGroup
+	var relayed atomic.Int64
+	for _, sy := range s.syncers {
+		for _, p := range sy.Peers() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if s.callPeer(p, func() error { return fn(p) }) == nil {
+					relayed.Add(1)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return int(relayed.Load())
+}
+
+func (s *server) handlePostSyncerBroadcastBlock(jc jape.Context) {
+	var req SyncerBroadcastBlockRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if (req.ID == nil) == (req.Block == nil) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("either a block ID or a block must be provided"))
+		return
+	}
+
+	var b types.Block
+	if req.ID != nil {
+		var ok bool
+		if b, ok = s.chain.Block(*req.ID); !ok {
+			writeError(jc, http.StatusNotFound, ErrorCodeBlockNotFound, ErrBlockNotFound)
+			return
+		}
+	} else {
+		b = *req.Block
+	}
+	if b.V2 == nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidBlock, errors.New("only v2 blocks can be broadcast"))
+		return
+	}
+
+	outline := gateway.OutlineBlock(b, s.chain.PoolTransactions(), s.chain.V2PoolTransactions())
+	jc.Encode(SyncerBroadcastResponse{
+		Peers: s.relay(func(p *syncer.Peer) error { return p.RelayV2BlockOutline(outline, relayTimeout) }),
+	})
+}
+
+func (s *server) handlePostSyncerBroadcastTransactionSet(jc jape.Context) {
+	var req SyncerBroadcastTransactionSetRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if len(req.Transactions) == 0 && len(req.V2Transactions) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("transaction set is empty"))
+		return
+	} else if len(req.Transactions) != 0 {
+		// the syncer only speaks the v2 protocol, which cannot relay v1
+		// transactions
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("v1 transactions cannot be relayed"))
+		return
+	}
+
+	cs := s.chain.TipState()
+	txns := req.V2Transactions
+	if req.Basis != (types.ChainIndex{}) && req.Basis != cs.Index {
+		var err error
+		if _, txns, err = s.updateBasis(txns, req.Basis); errors.Is(err, ErrStaleBasis) {
+			writeError(jc, http.StatusConflict, ErrorCodeStaleBasis, err)
+			return
+		} else if err != nil {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidTransaction, err)
+			return
+		}
+	}
+	if resp := validateTransactions(cs, nil, txns); !resp.Valid {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidTransaction, fmt.Errorf("transaction %v is invalid: %v", *resp.TransactionID, resp.Error))
+		return
+	}
+
+	jc.Encode(SyncerBroadcastResponse{
+		Peers: s.relay(func(p *syncer.Peer) error { return p.RelayV2TransactionSet(cs.Index, txns, relayTimeout) }),
+	})
+}
+
+func (s *server) handleGetTxpoolTransactions(jc jape.Context) {
+	offset, limit := 0, defaultPoolLimit
+	if decodeForm(jc, "offset", &offset) != nil || decodeForm(jc, "limit", &limit) != nil {
+		return
+	} else if offset < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, errors.New("offset must be non-negative"))
+		return
+	} else if limit <= 0 || limit > maxPoolLimit {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("limit must be between 1 and %d", maxPoolLimit))
+		return
+	}
+
+	// v1 transactions are listed before v2 transactions; the offset and
+	// limit apply to the combined list
+	txns, v2txns := s.chain.PoolTransactions(), s.chain.V2PoolTransactions()
+	jc.ResponseWriter.Header().Set("X-Total-Count", strconv.Itoa(len(txns)+len(v2txns)))
+	resp := TxpoolTransactionsResponse{
+		Transactions:   []types.Transaction{},
+		V2Transactions: []types.V2Transaction{},
+	}
+	if offset < len(txns) {
+		end := min(offset+limit, len(txns))
+		resp.Transactions = txns[offset:end]
+		limit -= end - offset
+		offset = 0
+	} else {
+		offset -= len(txns)
+	}
+	if offset < len(v2txns) && limit > 0 {
+		resp.V2Transactions = v2txns[offset:min(offset+limit, len(v2txns))]
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetTxpoolTransactionsID(jc jape.Context) {
+	var id types.TransactionID
+	if decodeParam(jc, "id", &id) != nil {
+		return
+	}
+	// the chain manager's single-transaction lookups share an index between
+	// v1 and v2 transactions, so search the pool directly
+	cs := s.chain.TipState()
+	for _, txn := range s.chain.PoolTransactions() {
+		if txn.ID() != id {
+			continue
+		}
+		var fee types.Currency
+		for _, mf := range txn.MinerFees {
+			fee = fee.Add(mf)
+		}
+		jc.Encode(TxpoolTransaction{
+			ID:          id,
+			Version:     1,
+			Transaction: &txn,
+			Fee:         fee,
+			Size:        cs.TransactionWeight(txn),
+		})
+		return
+	}
+	for _, txn := range s.chain.V2PoolTransactions() {
+		if txn.ID() != id {
+			continue
+		}
+		jc.Encode(TxpoolTransaction{
+			ID:            id,
+			Version:       2,
+			V2Transaction: &txn,
+			Fee:           txn.MinerFee,
+			Size:          cs.V2TransactionWeight(txn),
+		})
+		return
+	}
+	writeError(jc, http.StatusNotFound, ErrorCodeTransactionNotFound, ErrTransactionNotFound)
+}
+
+func (s *server) handleGetTxpoolStats(jc jape.Context) {
+	stats := s.events.txpoolStats()
+	resp := TxpoolStatsResponse{
+		Transactions: stats.count,
+		Size:         stats.size,
+		TotalFees:    stats.fees,
+		FeeHistogram: stats.histogram,
+	}
+	if s.limiter != nil {
+		resp.MaxSize = s.limiter.MaxSize()
+		resp.Rejected = s.limiter.Rejected()
+	}
+	if !stats.oldest.IsZero() {
+		resp.OldestAge = time.Since(stats.oldest)
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handlePostTxpoolParents(jc jape.Context) {
+	var req TxpoolParentsRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if (req.Transaction == nil) == (req.V2Transaction == nil) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("exactly one of transaction or v2Transaction must be provided"))
+		return
+	}
+	parents, v2parents, err := unconfirmedParents(s.chain.PoolTransactions(), s.chain.V2PoolTransactions(), req.Transaction, req.V2Transaction)
+	if err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidTransaction, err)
+		return
+	}
+	jc.Encode(TxpoolTransactionsResponse{
+		Transactions:   parents,
+		V2Transactions: v2parents,
+	})
+}
+
+// basisDistance returns the number of blocks that must be reverted and
+// applied to move from basis to tip, or false if basis is unknown or more
+// than maxBasisDistance blocks away.
+func (s *server) basisDistance(basis, tip types.ChainIndex) (uint64, bool) {
+	if _, ok := s.chain.State(basis.ID); !ok {
+		return 0, false
+	}
+	// walk back from basis until reaching the best chain
+	var reverted uint64
+	for {
+		if index, ok := s.chain.BestIndex(basis.Height); ok && index == basis {
+			break
+		} else if reverted++; reverted > maxBasisDistance {
+			return 0, false
+		}
+		b, ok := s.chain.Block(basis.ID)
+		if !ok {
+			return 0, false
+		}
+		basis = types.ChainIndex{ID: b.ParentID, Height: basis.Height - 1}
+	}
+	if basis.Height > tip.Height {
+		return 0, false // should never happen
+	}
+	distance := reverted + tip.Height - basis.Height
+	return distance, distance <= maxBasisDistance
+}
+
+// updateBasis updates the proofs of a v2 transaction set from basis to the
+// current tip. If basis is too old, the returned error wraps ErrStaleBasis.
+func (s *server) updateBasis(txns []types.V2Transaction, basis types.ChainIndex) (types.ChainIndex, []types.V2Transaction, error) {
+	tip := s.chain.Tip()
+	if basis == tip {
+		return tip, txns, nil
+	} else if _, ok := s.basisDistance(basis, tip); !ok {
+		return types.ChainIndex{}, nil, fmt.Errorf("%w (basis %v, tip %v)", ErrStaleBasis, basis, tip)
+	}
+	txns, err := s.chain.UpdateV2TransactionSet(txns, basis, tip)
+	if err != nil {
+		return types.ChainIndex{}, nil, fmt.Errorf("failed to update transaction set basis: %w", err)
+	}
+	return tip, txns, nil
+}
+
+func (s *server) handlePostTxpoolBroadcast(jc jape.Context) {
+	var req TxpoolBroadcastRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if len(req.Transactions) == 0 && len(req.V2Transactions) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("transaction set is empty"))
+		return
+	}
+	basis := req.Basis
+	if basis == (types.ChainIndex{}) {
+		basis = s.chain.Tip()
+	}
+	if resp, ok := s.broadcast(jc, basis, req.Transactions, req.V2Transactions); ok {
+		jc.Encode(resp)
+	}
+}
+
+// broadcast adds a transaction set to the txpool, tracks it as local, and
+// relays it to peers. If the set is rejected, an error is written and false
+// is returned.
+func (s *server) broadcast(jc jape.Context, basis types.ChainIndex, txns []types.Transaction, v2txns []types.V2Transaction) (TxpoolBroadcastResponse, bool) {
+	known := true
+	if len(txns) > 0 {
+		v1Known, err := s.chain.AddPoolTransactions(txns)
+		if err != nil {
+			writeError(jc, http.StatusBadRequest, ErrorCodeTxpoolRejected, fmt.Errorf("invalid transaction set: %w", err))
+			return TxpoolBroadcastResponse{}, false
+		}
+		known = known && v1Known
+	}
+	if len(v2txns) > 0 {
+		// update the proofs once, so that the same set is pooled and relayed
+		var err error
+		basis, v2txns, err = s.updateBasis(v2txns, basis)
+		if errors.Is(err, ErrStaleBasis) {
+			writeError(jc, http.StatusConflict, ErrorCodeStaleBasis, err)
+			return TxpoolBroadcastResponse{}, false
+		} else if err != nil {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidTransaction, fmt.Errorf("invalid transaction set: %w", err))
+			return TxpoolBroadcastResponse{}, false
+		}
+		v2Known, err := s.chain.AddV2PoolTransactions(basis, v2txns)
+		if err != nil {
+			writeError(jc, http.StatusBadRequest, ErrorCodeTxpoolRejected, fmt.Errorf("invalid transaction set: %w", err))
+			return TxpoolBroadcastResponse{}, false
+		}
+		known = known && v2Known
+	}
+	if s.local != nil {
+		// track the set even if it is known, since it may have been relayed
+		// by a peer before being broadcast locally
+		if s.check(jc, "failed to track local transaction set", s.local.AddLocal(basis, txns, v2txns)) != nil {
+			return TxpoolBroadcastResponse{}, false
+		}
+	}
+	if known {
+		return TxpoolBroadcastResponse{Known: true}, true
+	}
+
+	// the syncer only speaks the v2 protocol, so v1 transactions are added
+	// to the pool but not relayed
+	var resp TxpoolBroadcastResponse
+	if len(v2txns) > 0 {
+		resp.Peers = s.relay(func(p *syncer.Peer) error { return p.RelayV2TransactionSet(basis, v2txns, relayTimeout) })
+	}
+	return resp, true
+}
+
+// poolFeeTiers returns the weighted percentiles of fee density among the
+// transactions in the pool, along with the total weight of the pool.
+func poolFeeTiers(cs consensus.State, txns []types.Transaction, v2txns []types.V2Transaction) (low, medium, high types.Currency, weight uint64) {
+	type entry struct {
+		density types.Currency
+		weight  uint64
+	}
+	entries := make([]entry, 0, len(txns)+len(v2txns))
+	add := func(fee types.Currency, w uint64) {
+		w = max(w, 1)
+		entries = append(entries, entry{fee.Div64(w), w})
+		weight += w
+	}
+	for _, txn := range txns {
+		var fee types.Currency
+		for _, mf := range txn.MinerFees {
+			fee = fee.Add(mf)
+		}
+		add(fee, cs.TransactionWeight(txn))
+	}
+	for _, txn := range v2txns {
+		add(txn.MinerFee, cs.V2TransactionWeight(txn))
+	}
+	if len(entries) == 0 {
+		return
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return a.density.Cmp(b.density) })
+
+	percentile := func(p uint64) types.Currency {
+		target := weight * p / 100
+		var cumulative uint64
+		for _, e := range entries {
+			if cumulative += e.weight; cumulative >= target {
+				return e.density
+			}
+		}
+		return entries[len(entries)-1].density
+	}
+	return percentile(lowFeePercentile), percentile(mediumFeePercentile), percentile(highFeePercentile), weight
+}
+
+func (s *server) handleGetTxpoolFee(jc jape.Context) {
+	txns, v2txns := s.chain.PoolTransactions(), s.chain.V2PoolTransactions()
+	resp := TxpoolFeeResponse{
+		Recommended: s.chain.RecommendedFee(),
+		PoolSize:    len(txns) + len(v2txns),
+	}
+	resp.Low, resp.Medium, resp.High, resp.PoolWeight = poolFeeTiers(s.chain.TipState(), txns, v2txns)
+	if resp.PoolSize == 0 {
+		resp.Low, resp.Medium, resp.High = resp.Recommended, resp.Recommended, resp.Recommended
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetTxpoolLocal(jc jape.Context) {
+	if s.local == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("local transaction tracking is not enabled"))
+		return
+	}
+	sets := s.local.LocalSets()
+	resp := make([]TxpoolLocalSet, len(sets))
+	for i, ls := range sets {
+		resp[i] = TxpoolLocalSet{
+			ID:              ls.ID,
+			Status:          ls.Status,
+			ConfirmedHeight: ls.ConfirmedHeight,
+			Error:           ls.Error,
+			Added:           ls.Added,
+			LastBroadcast:   ls.LastBroadcast,
+			Basis:           ls.Basis,
+			Transactions:    ls.Transactions,
+			V2Transactions:  ls.V2Transactions,
+		}
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetLogLevel(jc jape.Context) {
+	if s.logLevel == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("log level control is not enabled"))
+		return
+	}
+	jc.Encode(LogLevelResponse{Level: s.logLevel.Level()})
+}
+
+func (s *server) handlePutLogLevel(jc jape.Context) {
+	var req LogLevelRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if s.logLevel == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errors.New("log level control is not enabled"))
+		return
+	} else if req.Level == "" {
+		// zap parses the empty string as the info level
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("a level must be provided"))
+		return
+	}
+	level, err := zapcore.ParseLevel(req.Level)
+	if err != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, err)
+		return
+	}
+	// all of the node's loggers share the level, so the change applies to
+	// them immediately
+	if prev := s.logLevel.Level(); prev != level {
+		s.logLevel.SetLevel(level)
+		s.log.Info("log level changed", zap.Stringer("from", prev), zap.Stringer("to", level))
+	}
+}
+
+// routes returns the API's routes, other than GET /openapi.json, which
+// describes them.
+func (s *server) routes() map[string]jape.Handler {
+	routes := map[string]jape.Handler{
+		"GET /state":       s.handleGetState,
+		"GET /health":      s.handleGetHealth,
+		"GET /health/live": s.handleGetHealthLive,
+		"GET /log/level":   s.handleGetLogLevel,
+		"PUT /log/level":   s.handlePutLogLevel,
+
+		"GET /consensus/network":                     s.handleGetConsensusNetwork,
+		"GET /consensus/hardforks":                   s.tipCached(s.handleGetConsensusHardforks),
+		"GET /consensus/supply":                      s.tipCached(s.handleGetConsensusSupply),
+		"GET /consensus/difficulty":                  s.tipCached(s.handleGetConsensusDifficulty),
+		"GET /consensus/tip":                         s.tipCached(s.handleGetConsensusTip),
+		"GET /consensus/tipstate":                    s.tipCached(s.handleGetConsensusTipState),
+		"GET /consensus/blocks/:id":                  immutable("id", s.handleGetConsensusBlocksID),
+		"GET /consensus/blocks/:id/summary":          immutable("id", s.handleGetConsensusBlocksIDSummary),
+		"GET /consensus/blocks/:id/transactions":     immutable("id", s.handleGetConsensusBlocksIDTransactions),
+		"GET /consensus/blocks/:id/proof/:txid":      immutable("id", s.handleGetConsensusBlocksIDProofTxID),
+		"GET /consensus/headers":                     s.tipCached(s.handleGetConsensusHeaders),
+		"POST /consensus/blocks":                     s.handlePostConsensusBlocks,
+		"POST /consensus/blocks/batch":               s.handlePostConsensusBlocksBatch,
+		"POST /consensus/validate":                   s.handlePostConsensusValidate,
+		"GET /consensus/index/:height":               s.tipCached(s.handleGetConsensusIndexHeight),
+		"GET /consensus/updates/:index":              s.handleGetConsensusUpdatesIndex,
+		"GET /consensus/checkpoint/:id":              s.tipCached(s.handleGetConsensusCheckpointID),
+		"GET /consensus/subscribe":                   s.handleGetConsensusSubscribe,
+		"GET /events":                                s.handleGetEvents,
+		"GET /syncer/peers":                          s.handleGetSyncerPeers,
+		"GET /syncer/peers/:address":                 s.handleGetSyncerPeersAddress,
+		"GET /syncer/address":                        s.handleGetSyncerAddress,
+		"GET /syncer/status":                         s.handleGetSyncerStatus,
+		"GET /syncer/stats":                          s.handleGetSyncerStats,
+		"DELETE /syncer/peers/:address":              s.handleDeleteSyncerPeersAddress,
+		"POST /syncer/peers/:address/ban":            s.handlePostSyncerPeersAddressBan,
+		"POST /syncer/peers/:address/ping":           s.handlePostSyncerPeersAddressPing,
+		"POST /syncer/peers/:address/pin":            s.handlePostSyncerPeersAddressPin,
+		"DELETE /syncer/peers/:address/pin":          s.handleDeleteSyncerPeersAddressPin,
+		"GET /syncer/bans":                           s.handleGetSyncerBans,
+		"POST /syncer/bans":                          s.handlePostSyncerBans,
+		"DELETE /syncer/bans/*address":               s.handleDeleteSyncerBansAddress,
+		"POST /syncer/broadcast/block":               s.handlePostSyncerBroadcastBlock,
+		"POST /syncer/broadcast/transactionset":      s.handlePostSyncerBroadcastTransactionSet,
+		"POST /syncer/connect":                       s.handlePostSyncerConnect,
+		"GET /txpool/transactions":                   s.handleGetTxpoolTransactions,
+		"GET /txpool/transactions/:id":               s.handleGetTxpoolTransactionsID,
+		"POST /txpool/broadcast":                     s.handlePostTxpoolBroadcast,
+		"POST /txpool/parents":                       s.handlePostTxpoolParents,
+		"GET /txpool/subscribe":                      s.handleGetTxpoolSubscribe,
+		"GET /txpool/fee":                            s.handleGetTxpoolFee,
+		"GET /txpool/stats":                          s.handleGetTxpoolStats,
+		"GET /txpool/local":                          s.handleGetTxpoolLocal,
+		"GET /mining/blocktemplate":                  s.handleGetMiningBlockTemplate,
+		"GET /mining/status":                         s.handleGetMiningStatus,
+		"GET /mining/subscribe":                      s.handleGetMiningSubscribe,
+		"GET /mining/payoutaddress":                  s.handleGetMiningPayoutAddress,
+		"PUT /mining/payoutaddress":                  s.handlePutMiningPayoutAddress,
+		"POST /mining/start":                         s.handlePostMiningStart,
+		"POST /mining/stop":                          s.handlePostMiningStop,
+		"POST /mine":                                 s.handlePostMine,
+		"GET /addresses/:addr/balance":               s.handleGetAddressesAddrBalance,
+		"GET /wallets":                               s.handleGetWallets,
+		"GET /wallets/:name":                         s.handleGetWalletsName,
+		"PUT /wallets/:name":                         s.handlePutWalletsName,
+		"DELETE /wallets/:name":                      s.handleDeleteWalletsName,
+		"POST /wallets/:name/watch":                  s.handlePostWalletsNameWatch,
+		"GET /wallets/:name/balance":                 s.handleGetWalletsNameBalance,
+		"GET /wallets/:name/balance/detailed":        s.handleGetWalletsNameBalanceDetailed,
+		"GET /wallets/:name/addresses":               s.handleGetWalletsNameAddresses,
+		"POST /wallets/:name/addresses":              s.handlePostWalletsNameAddresses,
+		"POST /wallets/:name/addresses/check":        s.handlePostWalletsNameAddressesCheck,
+		"GET /wallets/:name/addresses/:addr":         s.handleGetWalletsNameAddressesAddr,
+		"PUT /wallets/:name/addresses/:addr/label":   s.handlePutWalletsNameAddressesAddrLabel,
+		"POST /wallets/:name/send":                   s.handlePostWalletsNameSend,
+		"POST /wallets/:name/send/siafund":           s.handlePostWalletsNameSendSiafund,
+		"POST /wallets/:name/anchor":                 s.handlePostWalletsNameAnchor,
+		"POST /wallets/:name/consolidate":            s.handlePostWalletsNameConsolidate,
+		"POST /wallets/:name/transactions/construct": s.handlePostWalletsNameTransactionsConstruct,
+		"POST /wallets/:name/transactions/submit":    s.handlePostWalletsNameTransactionsSubmit,
+		"POST /wallets/:name/transactions/bump":      s.handlePostWalletsNameTransactionsBump,
+		"POST /wallets/:name/fund":                   s.handlePostWalletsNameFund,
+		"POST /wallets/:name/release":                s.handlePostWalletsNameRelease,
+		"POST /wallets/:name/sign":                   s.handlePostWalletsNameSign,
+		"GET /wallets/:name/multisig":                s.handleGetWalletsNameMultisig,
+		"POST /wallets/:name/multisig":               s.handlePostWalletsNameMultisig,
+		"GET /wallets/:name/settings":                s.handleGetWalletsNameSettings,
+		"PUT /wallets/:name/settings":                s.handlePutWalletsNameSettings,
+		"GET /wallets/:name/backup":                  s.handleGetWalletsNameBackup,
+		"POST /wallets/:name/unlock":                 s.handlePostWalletsNameUnlock,
+		"POST /wallets/:name/lock":                   s.handlePostWalletsNameLock,
+		"PUT /wallets/:name/passphrase":              s.handlePutWalletsNamePassphrase,
+		"POST /wallets/:name/restore":                s.handlePostWalletsNameRestore,
+		"GET /wallets/:name/events":                  s.handleGetWalletsNameEvents,
+		"GET /wallets/:name/events/:id":              s.handleGetWalletsNameEventsID,
+		"GET /wallets/:name/unconfirmed":             s.handleGetWalletsNameUnconfirmed,
+		"GET /wallets/:name/rescan":                  s.handleGetWalletsNameRescan,
+		"POST /wallets/:name/rescan":                 s.handlePostWalletsNameRescan,
+		"POST /wallets/:name/sweep":                  s.handlePostWalletsNameSweep,
+		"GET /wallets/:name/outputs":                 s.handleGetWalletsNameOutputs,
+		"POST /wallets/:name/outputs/reserve":        s.handlePostWalletsNameOutputsReserve,
+		"POST /wallets/:name/outputs/release":        s.handlePostWalletsNameOutputsRelease,
+	}
+	if s.debug {
+		routes["GET /debug/pprof/*profile"] = handleDebugPprof
+		routes["POST /debug/pprof/*profile"] = handleDebugPprof
+		routes["GET /debug/stats"] = s.handleGetDebugStats
+	}
+	return routes
+}
+
+// routeAccess classifies a route by the scope it requires and whether it
+// changes the node's state.
+type routeAccess struct {
+	scope Scope
+	write bool
+}
+
+// readOnly replaces write routes when the API is read-only.
+func readOnly(jc jape.Context) {
+	writeError(jc, http.StatusMethodNotAllowed, ErrorCodeReadOnly, fmt.Errorf("%v %v is disabled because the API is read-only", jc.Request.Method, jc.Request.URL.Path))
+}
+
+// routeAccesses classifies every route served by NewHandler. Every route
+// must be classified, so that new routes can't be exposed without
+// authentication, or in read-only mode, by accident.
+var routeAccesses = map[string]routeAccess{
+	"GET /openapi.json": {ScopeRead, false},
+	"GET /state":        {ScopeRead, false},
+	"GET /health":       {scopePublic, false},
+	"GET /health/live":  {scopePublic, false},
+	"GET /log/level":    {ScopeRead, false},
+	"PUT /log/level":    {ScopeAdmin, true},
+
+	"GET /consensus/network":                     {ScopeRead, false},
+	"GET /consensus/hardforks":                   {ScopeRead, false},
+	"GET /consensus/supply":                      {ScopeRead, false},
+	"GET /consensus/difficulty":                  {ScopeRead, false},
+	"GET /consensus/tip":                         {ScopeRead, false},
+	"GET /consensus/tipstate":                    {ScopeRead, false},
+	"GET /consensus/blocks/:id":                  {ScopeRead, false},
+	"GET /consensus/blocks/:id/summary":          {ScopeRead, false},
+	"GET /consensus/blocks/:id/transactions":     {ScopeRead, false},
+	"GET /consensus/blocks/:id/proof/:txid":      {ScopeRead, false},
+	"GET /consensus/headers":                     {ScopeRead, false},
+	"POST /consensus/blocks":                     {ScopeAdmin, true},
+	"POST /consensus/blocks/batch":               {ScopeRead, false},
+	"POST /consensus/validate":                   {ScopeRead, false},
+	"GET /consensus/index/:height":               {ScopeRead, false},
+	"GET /consensus/updates/:index":              {ScopeRead, false},
+	"GET /consensus/checkpoint/:id":              {ScopeRead, false},
+	"GET /consensus/subscribe":                   {ScopeRead, false},
+	"GET /events":                                {ScopeRead, false},
+	"GET /syncer/peers":                          {ScopeRead, false},
+	"GET /syncer/peers/:address":                 {ScopeRead, false},
+	"GET /syncer/address":                        {ScopeRead, false},
+	"GET /syncer/status":                         {ScopeRead, false},
+	"GET /syncer/stats":                          {ScopeRead, false},
+	"DELETE /syncer/peers/:address":              {ScopeAdmin, true},
+	"POST /syncer/peers/:address/ban":            {ScopeAdmin, true},
+	"POST /syncer/peers/:address/ping":           {ScopeAdmin, true},
+	"POST /syncer/peers/:address/pin":            {ScopeAdmin, true},
+	"DELETE /syncer/peers/:address/pin":          {ScopeAdmin, true},
+	"GET /syncer/bans":                           {ScopeRead, false},
+	"POST /syncer/bans":                          {ScopeAdmin, true},
+	"DELETE /syncer/bans/*address":               {ScopeAdmin, true},
+	"POST /syncer/broadcast/block":               {ScopeAdmin, true},
+	"POST /syncer/broadcast/transactionset":      {ScopeAdmin, true},
+	"POST /syncer/connect":                       {ScopeAdmin, true},
+	"GET /txpool/transactions":                   {ScopeRead, false},
+	"GET /txpool/transactions/:id":               {ScopeRead, false},
+	"POST /txpool/broadcast":                     {ScopeAdmin, true},
+	"POST /txpool/parents":                       {ScopeRead, false},
+	"GET /txpool/subscribe":                      {ScopeRead, false},
+	"GET /txpool/fee":                            {ScopeRead, false},
+	"GET /txpool/stats":                          {ScopeRead, false},
+	"GET /txpool/local":                          {ScopeRead, false},
+	"GET /mining/blocktemplate":                  {ScopeRead, false},
+	"GET /mining/status":                         {ScopeRead, false},
+	"GET /mining/subscribe":                      {ScopeRead, false},
+	"GET /mining/payoutaddress":                  {ScopeRead, false},
+	"PUT /mining/payoutaddress":                  {ScopeAdmin, true},
+	"POST /mining/start":                         {ScopeAdmin, true},
+	"POST /mining/stop":                          {ScopeAdmin, true},
+	"POST /mine":                                 {ScopeAdmin, true},
+	"GET /addresses/:addr/balance":               {ScopeRead, false},
+	"GET /wallets":                               {ScopeRead, false},
+	"GET /wallets/:name":                         {ScopeRead, false},
+	"PUT /wallets/:name":                         {ScopeAdmin, true},
+	"DELETE /wallets/:name":                      {ScopeAdmin, true},
+	"POST /wallets/:name/watch":                  {ScopeAdmin, true},
+	"GET /wallets/:name/balance":                 {ScopeRead, false},
+	"GET /wallets/:name/balance/detailed":        {ScopeRead, false},
+	"GET /wallets/:name/addresses":               {ScopeRead, false},
+	"POST /wallets/:name/addresses":              {ScopeAdmin, true},
+	"POST /wallets/:name/addresses/check":        {ScopeRead, false},
+	"GET /wallets/:name/addresses/:addr":         {ScopeRead, false},
+	"PUT /wallets/:name/addresses/:addr/label":   {ScopeAdmin, true},
+	"POST /wallets/:name/send":                   {ScopeAdmin, true},
+	"POST /wallets/:name/anchor":                 {ScopeAdmin, true},
+	"POST /wallets/:name/consolidate":            {ScopeAdmin, true},
+	"POST /wallets/:name/send/siafund":           {ScopeAdmin, true},
+	"POST /wallets/:name/transactions/construct": {ScopeAdmin, true},
+	"POST /wallets/:name/transactions/submit":    {ScopeAdmin, true},
+	"POST /wallets/:name/transactions/bump":      {ScopeAdmin, true},
+	"POST /wallets/:name/fund":                   {ScopeAdmin, true},
+	"POST /wallets/:name/release":                {ScopeAdmin, true},
+	"POST /wallets/:name/sign":                   {ScopeAdmin, true},
+	"GET /wallets/:name/multisig":                {ScopeRead, false},
+	"POST /wallets/:name/multisig":               {ScopeAdmin, true},
+	"GET /wallets/:name/settings":                {ScopeRead, false},
+	"PUT /wallets/:name/settings":                {ScopeAdmin, true},
+	"GET /wallets/:name/backup":                  {ScopeAdmin, false},
+	"POST /wallets/:name/unlock":                 {ScopeAdmin, true},
+	"POST /wallets/:name/lock":                   {ScopeAdmin, true},
+	"PUT /wallets/:name/passphrase":              {ScopeAdmin, true},
+	"POST /wallets/:name/restore":                {ScopeAdmin, true},
+	"GET /wallets/:name/events":                  {ScopeRead, false},
+	"GET /wallets/:name/events/:id":              {ScopeRead, false},
+	"GET /wallets/:name/unconfirmed":             {ScopeRead, false},
+	"GET /wallets/:name/rescan":                  {ScopeRead, false},
+	"POST /wallets/:name/rescan":                 {ScopeAdmin, true},
+	"POST /wallets/:name/sweep":                  {ScopeAdmin, true},
+	"GET /wallets/:name/outputs":                 {ScopeRead, false},
+	"POST /wallets/:name/outputs/reserve":        {ScopeAdmin, true},
+	"POST /wallets/:name/outputs/release":        {ScopeAdmin, true},
+
+	// profiles can reveal memory contents
+	"GET /debug/pprof/*profile":  {ScopeAdmin, false},
+	"POST /debug/pprof/*profile": {ScopeAdmin, false},
+	"GET /debug/stats":           {ScopeRead, false},
+}
+
+// NewHandler returns a new HTTP handler for the API.
+func NewHandler(n *consensus.Network, genesisID types.BlockID, cm ChainManager, syncers []Syncer, opts ...ServerOption) http.Handler {
+	s := &server{
+		network:   n,
+		genesisID: genesisID,
+		chain:     cm,
+		syncers:   syncers,
+		startTime: time.Now(),
+
+		maxBatchSize:         defaultMaxBatchSize,
+		maxBlockRequestSize:  defaultMaxBlockRequestSize,
+		maxTxnSetRequestSize: defaultMaxTransactionSetRequestSize,
+		healthMinPeers:       defaultHealthMinPeers,
+		healthMaxTipAge:      defaultHealthMaxTipAge,
+
+		compressionLevel: gzip.DefaultCompression,
+
+		routeTimeouts: RouteTimeouts{
+			Lookup: defaultLookupTimeout,
+			Write:  defaultWriteTimeout,
+			Long:   defaultLongTimeout,
+		},
+
+		ctx: context.Background(),
+		log: zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.events = newEventBroker(s.ctx, cm)
+	if len(s.syncers) > 0 {
+		go s.trackPeerHeights()
+	}
+	routes := s.routes()
+
+	// routes that take a body are limited to maxRequestSize unless listed
+	// here; a limit of 0 disables it
+	requestSizes := map[string]int64{
+		"POST /consensus/blocks":                  s.maxBlockRequestSize,
+		"POST /consensus/validate":                s.maxBlockRequestSize,
+		"POST /consensus/blocks/batch":            maxRequestSize + int64(s.maxBatchSize)*batchIDSize,
+		"POST /syncer/broadcast/block":            s.maxBlockRequestSize,
+		"POST /syncer/broadcast/transactionset":   s.maxTxnSetRequestSize,
+		"POST /txpool/broadcast":                  s.maxTxnSetRequestSize,
+		"POST /txpool/parents":                    s.maxTxnSetRequestSize,
+		"POST /wallets/:name/transactions/submit": s.maxTxnSetRequestSize,
+		"POST /wallets/:name/send":                s.maxTxnSetRequestSize,
+		"POST /wallets/:name/anchor":              s.maxTxnSetRequestSize,
+		"POST /wallets/:name/restore":             s.maxTxnSetRequestSize,
+		"POST /wallets/:name/addresses/check":     maxRequestSize + maxAddressCheckCount*checkAddressSize,
+
+		// the symbol profile reads a list of addresses of arbitrary length
+		"POST /debug/pprof/*profile": 0,
+	}
+
+	// the description includes itself, so the route must be added first
+	var spec []byte
+	routes["GET /openapi.json"] = func(jc jape.Context) {
+		jc.ResponseWriter.Header().Set("Content-Type", "application/json")
+		jc.ResponseWriter.Write(spec)
+	}
+	spec, err := buildOpenAPISpec(routes)
+	if err != nil {
+		panic(err) // developer error
+	}
+	for route, h := range routes {
+		ra, ok := routeAccesses[route]
+		if !ok {
+			panic(fmt.Sprintf("route %q has no access classification", route)) // developer error
+		}
+		if s.readOnly && ra.write {
+			h = readOnly
+		}
+		if !strings.HasPrefix(route, "GET ") {
+			size, ok := requestSizes[route]
+			if !ok {
+				size = maxRequestSize
+			}
+			if size > 0 {
+				h = limitRequestSize(size, h)
+			}
+		}
+		if d := s.routeTimeouts.timeout(route, ra); d > 0 {
+			h = s.withTimeout(d, h)
+		}
+		if len(s.credentials) > 0 {
+			h = s.authorized(ra.scope, h)
+		}
+		// unauthenticated requests are limited too, so that credentials
+		// can't be guessed at will
+		if s.rateLimiter != nil || s.expensiveRateLimiter != nil {
+			h = s.rateLimited(route, h)
+		}
+		if len(s.credentials) > 0 {
+			h = s.authenticated(h)
+		}
+		routes[route] = recordRoute(route, h)
+	}
+
+	// middleware is listed from innermost to outermost
+	mux := jape.Mux(routes)
+	mux.NotFound, mux.MethodNotAllowed = notFound, methodNotAllowed
+	var h http.Handler = withRecovery(s.log, &s.panics, mux)
+	if s.compressionLevel != 0 {
+		h = withGzip(s.compressionLevel, h)
+	}
+	if len(s.corsOrigins) > 0 {
+		h = withCORS(s.corsOrigins, h)
+	}
+	if s.accessLog != nil {
+		h = withAccessLog(s.accessLog, s.accessLogExclude, s.trustProxy, h)
+	}
+	return withRouteInfo(h)
+}
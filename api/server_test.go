@@ -0,0 +1,642 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/gateway"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/syncer"
+	"go.sia.tech/coreutils/testutil"
+	"go.sia.tech/node/internal/mining"
+	"go.uber.org/zap"
+)
+
+// anyoneCanSpend is the policy of outputs spent by test transactions.
+var anyoneCanSpend = types.AnyoneCanSpend()
+
+// newTestChain returns a chain manager for a test network on which v2
+// transactions are allowed from height 1.
+func newTestChain(t testing.TB) (*consensus.Network, types.Block, *chain.Manager) {
+	n, genesis := testutil.V2Network()
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, genesis, chain.NewManager(store, tipState)
+}
+
+// newTestServer serves the API for a new test chain.
+func newTestServer(t testing.TB, opts ...ServerOption) (*chain.Manager, *httptest.Server) {
+	n, genesis, cm := newTestChain(t)
+	srv := httptest.NewServer(NewHandler(n, genesis.ID(), cm, nil, opts...))
+	t.Cleanup(srv.Close)
+	return cm, srv
+}
+
+// doRequest sends a request with the JSON encoding of body, if it is
+// non-nil, returning the response and its body.
+func doRequest(t testing.TB, method, url string, body any, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(js)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+// spendableElement mines a block paying an anyone-can-spend address and
+// enough blocks for it to mature, returning its element with an up-to-date
+// proof.
+func spendableElement(t testing.TB, cm *chain.Manager) types.SiacoinElement {
+	t.Helper()
+	start := cm.Tip()
+	testutil.MineBlocks(t, cm, anyoneCanSpend.Address(), 1)
+	testutil.MineBlocks(t, cm, types.VoidAddress, int(cm.TipState().Network.MaturityDelay))
+	_, caus, err := cm.UpdatesSince(start, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sce types.SiacoinElement
+	var found bool
+	for _, cau := range caus {
+		if found {
+			cau.UpdateElementProof(&sce.StateElement)
+			continue
+		}
+		for _, sced := range cau.SiacoinElementDiffs() {
+			if sced.Created && sced.SiacoinElement.SiacoinOutput.Address == anyoneCanSpend.Address() {
+				sce, found = sced.SiacoinElement.Copy(), true
+				break
+			}
+		}
+	}
+	if !found {
+		t.Fatal("no spendable element")
+	}
+	return sce
+}
+
+// spendElement returns a v2 transaction spending sce to an anyone-can-spend
+// address, paying fee.
+func spendElement(sce types.SiacoinElement, fee types.Currency) types.V2Transaction {
+	return types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          sce,
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: anyoneCanSpend},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: anyoneCanSpend.Address(),
+			Value:   sce.SiacoinOutput.Value.Sub(fee),
+		}},
+		MinerFee: fee,
+	}
+}
+
+// mineBlock mines a block containing the pool's transactions.
+func mineBlock(t testing.TB, cm *chain.Manager) types.Block {
+	t.Helper()
+	b, ok := coreutils.MineBlock(cm, types.VoidAddress, 5*time.Second)
+	if !ok {
+		t.Fatal("failed to mine block")
+	} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func getSummary(t *testing.T, srv *httptest.Server, id types.BlockID) BlockSummary {
+	t.Helper()
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/blocks/"+id.String()+"/summary", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+	}
+	var summary BlockSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestBlockSummaryMainnetGenesis(t *testing.T) {
+	n, genesis := chain.Mainnet()
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(store, tipState)
+	srv := httptest.NewServer(NewHandler(n, genesis.ID(), cm, nil))
+	defer srv.Close()
+
+	const genesisID = "25f6e3b9295a61f69fcb956aca9f0076234ecf2e02d399db5448b6e22f26e81c"
+	var id types.BlockID
+	if err := id.UnmarshalText([]byte(genesisID)); err != nil {
+		t.Fatal(err)
+	} else if id != genesis.ID() {
+		t.Fatalf("expected genesis ID %v, got %v", id, genesis.ID())
+	}
+
+	summary := getSummary(t, srv, id)
+	switch {
+	case summary.ID != id:
+		t.Fatalf("expected ID %v, got %v", id, summary.ID)
+	case summary.Height != 0:
+		t.Fatalf("expected height 0, got %v", summary.Height)
+	case summary.ParentID != (types.BlockID{}):
+		t.Fatalf("expected no parent, got %v", summary.ParentID)
+	case summary.Timestamp.Unix() != 1433600000:
+		t.Fatalf("expected timestamp 1433600000, got %v", summary.Timestamp.Unix())
+	case !summary.MinerPayouts.IsZero() || !summary.TotalFees.IsZero() || !summary.SiacoinOutflow.IsZero():
+		// the genesis block only creates siafunds
+		t.Fatalf("expected no siacoins, got payouts %v, fees %v, outflow %v", summary.MinerPayouts, summary.TotalFees, summary.SiacoinOutflow)
+	case summary.TransactionCount != 1 || len(summary.TransactionIDs) != 1:
+		t.Fatalf("expected 1 transaction, got %v (%v IDs)", summary.TransactionCount, len(summary.TransactionIDs))
+	case summary.TransactionIDs[0] != genesis.Transactions[0].ID():
+		t.Fatalf("expected transaction %v, got %v", genesis.Transactions[0].ID(), summary.TransactionIDs[0])
+	}
+}
+
+func TestBlockSummaryDerivedFields(t *testing.T) {
+	cm, srv := newTestServer(t)
+	sce := spendableElement(t, cm)
+
+	fee := types.Siacoins(1)
+	txn := spendElement(sce, fee)
+	if _, err := cm.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	parent := cm.TipState()
+	b := mineBlock(t, cm)
+
+	summary := getSummary(t, srv, b.ID())
+	switch {
+	case summary.ID != b.ID():
+		t.Fatalf("expected ID %v, got %v", b.ID(), summary.ID)
+	case summary.Height != parent.Index.Height+1:
+		t.Fatalf("expected height %v, got %v", parent.Index.Height+1, summary.Height)
+	case summary.ParentID != parent.Index.ID:
+		t.Fatalf("expected parent %v, got %v", parent.Index.ID, summary.ParentID)
+	case !summary.TotalFees.Equals(fee):
+		t.Fatalf("expected fees %v, got %v", fee, summary.TotalFees)
+	case !summary.MinerPayouts.Equals(parent.BlockReward().Add(fee)):
+		t.Fatalf("expected payouts %v, got %v", parent.BlockReward().Add(fee), summary.MinerPayouts)
+	case !summary.SiacoinOutflow.Equals(sce.SiacoinOutput.Value.Sub(fee)):
+		t.Fatalf("expected outflow %v, got %v", sce.SiacoinOutput.Value.Sub(fee), summary.SiacoinOutflow)
+	case summary.TransactionCount != len(b.V2Transactions()):
+		t.Fatalf("expected %v transactions, got %v", len(b.V2Transactions()), summary.TransactionCount)
+	}
+	// the miner adds its own transaction to make the block unique
+	for i, txn := range b.V2Transactions() {
+		if summary.TransactionIDs[i] != txn.ID() {
+			t.Fatalf("expected transaction %v to be %v, got %v", i, txn.ID(), summary.TransactionIDs[i])
+		}
+	}
+	if summary.TransactionIDs[len(summary.TransactionIDs)-1] != txn.ID() {
+		t.Fatal("summary is missing the pool transaction")
+	}
+
+	// the default response is the block alone, without derived fields
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/consensus/blocks/"+b.ID().String(), nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v", resp.StatusCode)
+	}
+	var fields map[string]json.RawMessage
+	var decoded types.Block
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded.ID() != b.ID() {
+		t.Fatalf("expected block %v, got %v", b.ID(), decoded.ID())
+	} else if _, ok := fields["transactionIDs"]; ok {
+		t.Fatal("block response includes derived fields")
+	}
+}
+
+func TestValidateAttributesInputs(t *testing.T) {
+	cm, srv := newTestServer(t)
+	sce := spendableElement(t, cm)
+
+	validate := func(txns ...types.V2Transaction) ConsensusValidateResponse {
+		t.Helper()
+		resp, body := doRequest(t, http.MethodPost, srv.URL+"/consensus/validate", ConsensusValidateRequest{V2Transactions: txns}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+		}
+		var vr ConsensusValidateResponse
+		if err := json.Unmarshal(body, &vr); err != nil {
+			t.Fatal(err)
+		}
+		return vr
+	}
+	expectInput := func(vr ConsensusValidateResponse, txn types.V2Transaction, input *ValidationInput) {
+		t.Helper()
+		if vr.Valid {
+			t.Fatal("expected transaction to be invalid")
+		} else if vr.TransactionID == nil || *vr.TransactionID != txn.ID() {
+			t.Fatalf("expected error in %v, got %v (%v)", txn.ID(), vr.TransactionID, vr.Error)
+		} else if (vr.Input == nil) != (input == nil) || (input != nil && *vr.Input != *input) {
+			t.Fatalf("expected input %v, got %v (%v)", input, vr.Input, vr.Error)
+		}
+	}
+
+	txn := spendElement(sce, types.ZeroCurrency)
+	if vr := validate(txn); !vr.Valid {
+		t.Fatal(vr.Error)
+	}
+
+	// a transaction spending an output already spent earlier in the set
+	conflict := spendElement(sce, types.Siacoins(1))
+	expectInput(validate(txn, conflict), conflict, &ValidationInput{Type: "siacoin", Index: 0})
+
+	// the second input spends the same output as the first
+	double := spendElement(sce, types.ZeroCurrency)
+	double.SiacoinInputs = append(double.SiacoinInputs, double.SiacoinInputs[0])
+	expectInput(validate(double), double, &ValidationInput{Type: "siacoin", Index: 1})
+
+	// the policy doesn't match the parent's address
+	wrongPolicy := spendElement(sce, types.ZeroCurrency)
+	wrongPolicy.SiacoinInputs[0].SatisfiedPolicy.Policy = types.PolicyAbove(0)
+	expectInput(validate(wrongPolicy), wrongPolicy, &ValidationInput{Type: "siacoin", Index: 0})
+
+	// an input with an invalid proof
+	badProof := spendElement(sce, types.ZeroCurrency)
+	badProof.SiacoinInputs[0].Parent.StateElement.MerkleProof = nil
+	expectInput(validate(badProof), badProof, &ValidationInput{Type: "siacoin", Index: 0})
+
+	// an output created earlier in the set can be spent, but one that
+	// doesn't exist can't
+	child := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent: types.SiacoinElement{
+				ID:            txn.SiacoinOutputID(txn.ID(), 0),
+				StateElement:  types.StateElement{LeafIndex: types.UnassignedLeafIndex},
+				SiacoinOutput: txn.SiacoinOutputs[0],
+			},
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: anyoneCanSpend},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{txn.SiacoinOutputs[0]},
+	}
+	if vr := validate(txn, child); !vr.Valid {
+		t.Fatal(vr.Error)
+	}
+	expectInput(validate(child), child, &ValidationInput{Type: "siacoin", Index: 0})
+
+	// errors that aren't caused by an input aren't attributed to one
+	unbalanced := spendElement(sce, types.ZeroCurrency)
+	unbalanced.SiacoinOutputs[0].Value = unbalanced.SiacoinOutputs[0].Value.Sub(types.Siacoins(1))
+	expectInput(validate(unbalanced), unbalanced, nil)
+}
+
+func TestValidateAttributesBlockTransactions(t *testing.T) {
+	cm, srv := newTestServer(t)
+	sce := spendableElement(t, cm)
+
+	// a block containing a transaction that spends more than its input
+	unbalanced := spendElement(sce, types.ZeroCurrency)
+	unbalanced.SiacoinOutputs[0].Value = unbalanced.SiacoinOutputs[0].Value.Add(types.Siacoins(1))
+	cs := cm.TipState()
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    cs.PrevTimestamps[0].Add(time.Second),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		V2: &types.V2BlockData{
+			Height:       cs.Index.Height + 1,
+			Transactions: []types.V2Transaction{unbalanced},
+		},
+	}
+	b.V2.Commitment = cs.Commitment(types.VoidAddress, b.Transactions, b.V2Transactions())
+	if !coreutils.FindBlockNonce(cs, &b, 5*time.Second) {
+		t.Fatal("failed to find nonce")
+	}
+
+	validate := func(b types.Block) ConsensusValidateResponse {
+		t.Helper()
+		resp, body := doRequest(t, http.MethodPost, srv.URL+"/consensus/validate", ConsensusValidateRequest{Block: &b}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %v: %s", resp.StatusCode, body)
+		}
+		var vr ConsensusValidateResponse
+		if err := json.Unmarshal(body, &vr); err != nil {
+			t.Fatal(err)
+		}
+		return vr
+	}
+
+	// the block is rejected because of the transaction
+	if vr := validate(b); vr.Valid {
+		t.Fatal("expected block to be invalid")
+	} else if vr.TransactionID == nil || *vr.TransactionID != unbalanced.ID() {
+		t.Fatalf("expected error in %v, got %v (%v)", unbalanced.ID(), vr.TransactionID, vr.Error)
+	}
+
+	// a block with a bad commitment is rejected before its transactions are
+	// validated, so the error is not attributed to one
+	b.V2.Commitment = types.Hash256{}
+	if !coreutils.FindBlockNonce(cs, &b, 5*time.Second) {
+		t.Fatal("failed to find nonce")
+	}
+	if vr := validate(b); vr.Valid {
+		t.Fatal("expected block to be invalid")
+	} else if vr.Error != consensus.ErrCommitmentMismatch.Error() {
+		t.Fatalf("expected commitment mismatch, got %v", vr.Error)
+	} else if vr.TransactionID != nil {
+		t.Fatalf("expected no transaction, got %v", *vr.TransactionID)
+	}
+}
+
+// newTestSyncer returns a syncer for cm, which must follow the test network.
+func newTestSyncer(t testing.TB, cm *chain.Manager) *syncer.Syncer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, _ := cm.BestIndex(0)
+	header := gateway.Header{
+		GenesisID:  genesis.ID,
+		UniqueID:   gateway.GenerateUniqueID(),
+		NetAddress: l.Addr().String(),
+	}
+	s := syncer.New(l, cm, testutil.NewEphemeralPeerStore(), header)
+	go s.Run()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSyncerStatusPeerHeight(t *testing.T) {
+	n, genesis, cm := newTestChain(t)
+	mineBlock(t, cm)
+	sy := newTestSyncer(t, cm)
+
+	// a peer that only answers the height query, reporting a tip far ahead
+	// of the node
+	const peerHeight = 1000
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		tr, err := gateway.Accept(conn, gateway.Header{
+			GenesisID:  genesis.ID(),
+			UniqueID:   gateway.GenerateUniqueID(),
+			NetAddress: l.Addr().String(),
+		})
+		if err != nil {
+			return
+		}
+		defer tr.Close()
+		for {
+			s, err := tr.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer s.Close()
+				var r gateway.RPCSendHeaders
+				if id, err := s.ReadID(); err != nil || id != types.NewSpecifier("SendHeaders") {
+					return
+				} else if err := s.ReadRequest(&r); err != nil || r.Index.Height != 0 || r.Max != 0 {
+					return
+				}
+				r.Remaining = peerHeight
+				s.WriteResponse(&r)
+			}()
+		}
+	}()
+	if _, err := sy.Connect(context.Background(), l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the heights are fetched in the background once the handler is created
+	srv := httptest.NewServer(NewHandler(n, genesis.ID(), cm, []Syncer{sy}))
+	defer srv.Close()
+	var status SyncerStatusResponse
+	for start := time.Now(); status.PeerHeight != peerHeight; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected peer height %d, got %d", peerHeight, status.PeerHeight)
+		}
+		resp, body := doRequest(t, http.MethodGet, srv.URL+"/syncer/status", nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+		} else if err := json.Unmarshal(body, &status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status.Synced {
+		t.Fatal("expected node to be behind its peer")
+	} else if status.Tip != cm.Tip() {
+		t.Fatalf("expected tip %v, got %v", cm.Tip(), status.Tip)
+	}
+}
+
+func TestTxpoolBroadcastAcrossReorg(t *testing.T) {
+	cm, srv := newTestServer(t)
+	sce := spendableElement(t, cm)
+	txn := spendElement(sce, types.Siacoins(1))
+	basis := cm.Tip()
+
+	// replace the basis block with a heavier fork, so that the basis is no
+	// longer on the best chain
+	_, _, fork := newTestChain(t)
+	for height := uint64(1); height < basis.Height; height++ {
+		index, _ := cm.BestIndex(height)
+		b, _ := cm.Block(index.ID)
+		if err := fork.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testutil.MineBlocks(t, fork, types.VoidAddress, 2)
+	var blocks []types.Block
+	for height := basis.Height; height <= fork.Tip().Height; height++ {
+		index, _ := fork.BestIndex(height)
+		b, _ := fork.Block(index.ID)
+		blocks = append(blocks, b)
+	}
+	if err := cm.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != fork.Tip() {
+		t.Fatal("expected reorg to the fork")
+	} else if index, _ := cm.BestIndex(basis.Height); index == basis {
+		t.Fatal("expected basis to be reverted")
+	}
+
+	broadcast := func(basis types.ChainIndex, txn types.V2Transaction) (*http.Response, []byte) {
+		return doRequest(t, http.MethodPost, srv.URL+"/txpool/broadcast", TxpoolBroadcastRequest{
+			Basis:          basis,
+			V2Transactions: []types.V2Transaction{txn},
+		}, nil)
+	}
+
+	// the set's proofs are updated across the reorg before it is pooled
+	if resp, body := broadcast(basis, txn); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	pooled := cm.V2PoolTransactions()
+	if len(pooled) != 1 || pooled[0].ID() != txn.ID() {
+		t.Fatalf("expected transaction %v to be pooled, got %v", txn.ID(), pooled)
+	}
+	mined := mineBlock(t, cm)
+	if !slices.ContainsFunc(mined.V2Transactions(), func(mt types.V2Transaction) bool { return mt.ID() == txn.ID() }) {
+		t.Fatal("expected the updated set to be mined")
+	}
+
+	// a basis too far from the tip must be rebuilt by the client
+	testutil.MineBlocks(t, cm, types.VoidAddress, maxBasisDistance)
+	resp, body := broadcast(basis, spendElement(sce, types.Siacoins(2)))
+	var apiErr Error
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", resp.StatusCode, body)
+	} else if err := json.Unmarshal(body, &apiErr); err != nil {
+		t.Fatal(err)
+	} else if apiErr.Code != ErrorCodeStaleBasis {
+		t.Fatalf("expected %q, got %q", ErrorCodeStaleBasis, apiErr.Code)
+	}
+}
+
+// newTestMiner returns a miner for cm.
+func newTestMiner(t *testing.T, cm *chain.Manager) *mining.Miner {
+	t.Helper()
+	m, err := mining.NewMiner(cm, filepath.Join(t.TempDir(), "mining.json"), nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestMine(t *testing.T) {
+	n, genesis, cm := newTestChain(t)
+	srv := httptest.NewServer(NewHandler(n, genesis.ID(), cm, nil, WithMiner(newTestMiner(t, cm))))
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL, "")
+
+	sce := spendableElement(t, cm)
+	txn := spendElement(sce, types.Siacoins(1))
+	if _, err := cm.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blocks extend the chain in order, paying the requested address,
+	// and the first includes the pooled transaction
+	start := cm.Tip()
+	addr := types.Address{1}
+	ids, err := c.Mine(addr, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 3 {
+		t.Fatalf("expected 3 block IDs, got %v", len(ids))
+	} else if cm.Tip().ID != ids[2] {
+		t.Fatalf("expected the tip to be the last mined block %v, got %v", ids[2], cm.Tip())
+	}
+	for i, id := range ids {
+		b, ok := cm.Block(id)
+		if index, _ := cm.BestIndex(start.Height + uint64(i) + 1); !ok || index.ID != id {
+			t.Fatalf("expected block %v at height %v, got %v", id, start.Height+uint64(i)+1, index.ID)
+		} else if len(b.MinerPayouts) != 1 || b.MinerPayouts[0].Address != addr {
+			t.Fatalf("expected block %v to pay %v, got %v", id, addr, b.MinerPayouts)
+		}
+	}
+	first, _ := cm.Block(ids[0])
+	if !slices.ContainsFunc(first.V2Transactions(), func(mt types.V2Transaction) bool { return mt.ID() == txn.ID() }) {
+		t.Fatal("expected the pooled transaction in the first block")
+	} else if pooled := cm.V2PoolTransactions(); len(pooled) != 0 {
+		t.Fatalf("expected an empty txpool, got %v transactions", len(pooled))
+	}
+}
+
+func TestMineMainnet(t *testing.T) {
+	n, genesis, cm := newTestChain(t)
+	n.Name = "mainnet"
+	srv := httptest.NewServer(NewHandler(n, genesis.ID(), cm, nil, WithMiner(newTestMiner(t, cm))))
+	t.Cleanup(srv.Close)
+
+	tip := cm.Tip()
+	resp, body := doRequest(t, http.MethodPost, srv.URL+"/mine", MineRequest{Blocks: 1, Address: types.Address{1}}, nil)
+	checkError(t, resp.StatusCode, resp.Header, body, http.StatusForbidden, ErrorCodeMiningNotAllowed)
+	if cm.Tip() != tip {
+		t.Fatalf("expected no blocks to be mined, got tip %v", cm.Tip())
+	}
+}
+
+func TestReadOnlyRoutes(t *testing.T) {
+	_, srv := newTestServer(t, WithReadOnly(true), WithDebug(true))
+	routes := (&server{debug: true}).routes()
+	routes["GET /openapi.json"] = nil
+	for route := range routes {
+		ra, ok := routeAccesses[route]
+		if !ok {
+			t.Errorf("%v is not classified", route)
+			continue
+		}
+		method, routePath, _ := strings.Cut(route, " ")
+		if method == http.MethodGet {
+			// GET routes must be untouched by read-only mode; they aren't
+			// requested, since some of them stream indefinitely
+			if ra.write {
+				t.Errorf("%v is classified as a write route", route)
+			}
+			continue
+		}
+
+		segments := strings.Split(routePath, "/")
+		for i, seg := range segments {
+			if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+				segments[i] = "x"
+			}
+		}
+		resp, body := doRequest(t, method, srv.URL+strings.Join(segments, "/"), nil, nil)
+		// other routes may fail, since the request is empty, but not
+		// because the API is read-only
+		var apiErr Error
+		json.Unmarshal(body, &apiErr)
+		if ra.write && (resp.StatusCode != http.StatusMethodNotAllowed || apiErr.Code != ErrorCodeReadOnly) {
+			t.Errorf("%v: expected 405 %q, got %v: %s", route, ErrorCodeReadOnly, resp.StatusCode, body)
+		} else if !ra.write && apiErr.Code == ErrorCodeReadOnly {
+			t.Errorf("%v: expected the route to be served, got %s", route, body)
+		}
+	}
+	for route := range routeAccesses {
+		if _, ok := routes[route]; !ok {
+			t.Errorf("%v is classified but is not a route", route)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.sia.tech/jape"
+)
+
+const (
+	// defaultLookupTimeout, defaultWriteTimeout and defaultLongTimeout are
+	// the default RouteTimeouts.
+	defaultLookupTimeout = 15 * time.Second
+	defaultWriteTimeout  = time.Minute
+	defaultLongTimeout   = 2 * time.Minute
+)
+
+// longRoutes are the routes subject to the long timeout, such as batches and
+// long-polls, and the wallet routes that sign, which may wait on an external
+// signer for each input.
+var longRoutes = map[string]bool{
+	"GET /consensus/tip":                    true,
+	"GET /consensus/headers":                true,
+	"GET /consensus/updates/:index":         true,
+	"GET /mining/blocktemplate":             true,
+	"POST /mine":                            true,
+	"POST /consensus/blocks/batch":          true,
+	"POST /wallets/:name/send":              true,
+	"POST /wallets/:name/send/siafund":      true,
+	"POST /wallets/:name/anchor":            true,
+	"POST /wallets/:name/consolidate":       true,
+	"POST /wallets/:name/transactions/bump": true,
+	"POST /wallets/:name/sign":              true,
+}
+
+// untimedRoutes are the routes that are never timed out: event streams,
+// which last as long as the client wants, profiles, whose duration is chosen
+// by the client, and sweeps, which scan the chain from the client's height.
+var untimedRoutes = map[string]bool{
+	"GET /consensus/subscribe":   true,
+	"GET /txpool/subscribe":      true,
+	"GET /mining/subscribe":      true,
+	"GET /events":                true,
+	"GET /debug/pprof/*profile":  true,
+	"POST /debug/pprof/*profile": true,
+	"POST /wallets/:name/sweep":  true,
+}
+
+// RouteTimeouts are the maximum durations of API requests. Lookup applies to
+// routes that only read, Write to routes that change the node's state, and
+// Long to batches and long-polls. A zero duration disables the timeout.
+type RouteTimeouts struct {
+	Lookup time.Duration
+	Write  time.Duration
+	Long   time.Duration
+}
+
+// timeout returns the timeout of route.
+func (rt RouteTimeouts) timeout(route string, ra routeAccess) time.Duration {
+	switch {
+	case untimedRoutes[route]:
+		return 0
+	case longRoutes[route]:
+		return rt.Long
+	case ra.write:
+		return rt.Write
+	default:
+		return rt.Lookup
+	}
+}
+
+// withTimeout wraps h, canceling the request's context after d. Handlers
+// return without writing a response when their context is canceled; if the
+// deadline was the cause, 503 is written instead.
+func (s *server) withTimeout(d time.Duration, h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		ctx, cancel := context.WithTimeout(jc.Request.Context(), d)
+		defer cancel()
+		sw := &statusWriter{ResponseWriter: jc.ResponseWriter}
+		h(jape.Context{ResponseWriter: sw, Request: jc.Request.WithContext(ctx), PathParams: jc.PathParams})
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		s.timeouts.Add(1)
+		if sw.status == 0 {
+			writeErrorResponse(sw, &Error{
+				Status:  http.StatusServiceUnavailable,
+				Code:    ErrorCodeTimeout,
+				Message: fmt.Sprintf("request timed out after %v", d),
+			})
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+
+	"go.sia.tech/core/types"
+)
+
+// A poolRef identifies a transaction in the txpool.
+type poolRef struct {
+	v2    bool
+	index int
+}
+
+// poolOutputs maps the IDs of elements created by transactions in the pool to
+// the transaction that created them. Unlike the chain manager's internal
+// parent map, v1 and v2 transactions are kept distinct.
+func poolOutputs(txns []types.Transaction, v2txns []types.V2Transaction) map[types.Hash256]poolRef {
+	outputs := make(map[types.Hash256]poolRef)
+	for index, txn := range txns {
+		ref := poolRef{false, index}
+		for i := range txn.SiacoinOutputs {
+			outputs[types.Hash256(txn.SiacoinOutputID(i))] = ref
+		}
+		for i := range txn.SiafundInputs {
+			outputs[types.Hash256(txn.SiafundClaimOutputID(i))] = ref
+		}
+		for i := range txn.SiafundOutputs {
+			outputs[types.Hash256(txn.SiafundOutputID(i))] = ref
+		}
+		for i := range txn.FileContracts {
+			outputs[types.Hash256(txn.FileContractID(i))] = ref
+		}
+	}
+	for index, txn := range v2txns {
+		ref := poolRef{true, index}
+		txid := txn.ID()
+		for i := range txn.SiacoinOutputs {
+			outputs[types.Hash256(txn.SiacoinOutputID(txid, i))] = ref
+		}
+		for _, sfi := range txn.SiafundInputs {
+			outputs[types.Hash256(sfi.Parent.ID.V2ClaimOutputID())] = ref
+		}
+		for i := range txn.SiafundOutputs {
+			outputs[types.Hash256(txn.SiafundOutputID(txid, i))] = ref
+		}
+		for i := range txn.FileContracts {
+			outputs[types.Hash256(txn.V2FileContractID(txid, i))] = ref
+		}
+	}
+	return outputs
+}
+
+// A parentRef is an element spent by a transaction. Ephemeral elements were
+// created by an unconfirmed transaction and must be in the pool.
+type parentRef struct {
+	id        types.Hash256
+	ephemeral bool
+}
+
+func v1Parents(txn types.Transaction) (refs []parentRef) {
+	for _, sci := range txn.SiacoinInputs {
+		refs = append(refs, parentRef{id: types.Hash256(sci.ParentID)})
+	}
+	for _, sfi := range txn.SiafundInputs {
+		refs = append(refs, parentRef{id: types.Hash256(sfi.ParentID)})
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		refs = append(refs, parentRef{id: types.Hash256(fcr.ParentID)})
+	}
+	for _, sp := range txn.StorageProofs {
+		refs = append(refs, parentRef{id: types.Hash256(sp.ParentID)})
+	}
+	return
+}
+
+func v2Parents(txn types.V2Transaction) (refs []parentRef) {
+	add := func(id types.Hash256, se types.StateElement) {
+		refs = append(refs, parentRef{id, se.LeafIndex == types.UnassignedLeafIndex})
+	}
+	for _, sci := range txn.SiacoinInputs {
+		add(types.Hash256(sci.Parent.ID), sci.Parent.StateElement)
+	}
+	for _, sfi := range txn.SiafundInputs {
+		add(types.Hash256(sfi.Parent.ID), sfi.Parent.StateElement)
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		add(types.Hash256(fcr.Parent.ID), fcr.Parent.StateElement)
+	}
+	for _, fcr := range txn.FileContractResolutions {
+		add(types.Hash256(fcr.Parent.ID), fcr.Parent.StateElement)
+	}
+	return
+}
+
+// unconfirmedParents returns the transactions in the pool that txn depends on,
+// directly or indirectly, in an order valid for broadcasting. Exactly one of
+// txn and v2txn must be non-nil. An error is returned if txn spends an
+// ephemeral element that was not created by a pooled transaction, or if txn
+// is its own ancestor.
+func unconfirmedParents(txns []types.Transaction, v2txns []types.V2Transaction, txn *types.Transaction, v2txn *types.V2Transaction) ([]types.Transaction, []types.V2Transaction, error) {
+	var id types.TransactionID
+	var queue []parentRef
+	if txn != nil {
+		id, queue = txn.ID(), v1Parents(*txn)
+	} else {
+		id, queue = v2txn.ID(), v2Parents(*v2txn)
+	}
+
+	outputs := poolOutputs(txns, v2txns)
+	seen := make(map[poolRef]bool)
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+		parent, ok := outputs[ref.id]
+		if !ok {
+			if ref.ephemeral {
+				return nil, nil, fmt.Errorf("missing parent of element %v", ref.id)
+			}
+			continue // confirmed
+		} else if seen[parent] {
+			continue
+		}
+		seen[parent] = true
+		if parent.v2 {
+			if v2txns[parent.index].ID() == id {
+				return nil, nil, fmt.Errorf("transaction %v depends on itself", id)
+			}
+			queue = append(queue, v2Parents(v2txns[parent.index])...)
+		} else {
+			if txns[parent.index].ID() == id {
+				return nil, nil, fmt.Errorf("transaction %v depends on itself", id)
+			}
+			queue = append(queue, v1Parents(txns[parent.index])...)
+		}
+	}
+
+	// any prefix of the pool is a valid transaction set, so pool order is
+	// also dependency order
+	refs := make([]poolRef, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	slices.SortFunc(refs, func(a, b poolRef) int {
+		if a.v2 != b.v2 {
+			if a.v2 {
+				return 1
+			}
+			return -1
+		}
+		return a.index - b.index
+	})
+	parents, v2parents := []types.Transaction{}, []types.V2Transaction{}
+	for _, ref := range refs {
+		if ref.v2 {
+			v2parents = append(v2parents, v2txns[ref.index])
+		} else {
+			parents = append(parents, txns[ref.index])
+		}
+	}
+	return parents, v2parents, nil
+}
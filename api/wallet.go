@@ -0,0 +1,1062 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.sia.tech/node/internal/wallet"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxAddressCount is the maximum number of addresses that can be derived
+	// by a single [POST] /wallets/:name/addresses request.
+	maxAddressCount = 1000
+	// maxAddressCheckCount is the maximum number of addresses that can be
+	// checked by a single [POST] /wallets/:name/addresses/check request.
+	maxAddressCheckCount = 10000
+
+	// defaultReserveDuration and maxReserveDuration are the default and
+	// maximum durations for which [POST] /wallets/:name/fund and [POST]
+	// /wallets/:name/outputs/reserve reserve elements.
+	defaultReserveDuration = time.Hour
+	maxReserveDuration     = 24 * time.Hour
+
+	// defaultEventsLimit is the number of events returned by [GET]
+	// /wallets/:name/events when no limit is specified.
+	defaultEventsLimit = 100
+	// maxEventsLimit is the maximum number of events that can be requested
+	// from [GET] /wallets/:name/events.
+	maxEventsLimit = 1000
+
+	// maxSweepLookahead is the maximum number of addresses that can be
+	// derived from the seed swept by [POST] /wallets/:name/sweep.
+	maxSweepLookahead = 100000
+)
+
+// errWalletNotEnabled is returned by the wallet routes when the node has no
+// wallet.
+var errWalletNotEnabled = errors.New("the wallet is not enabled")
+
+// walletError writes err, returned by the wallet, with the appropriate status
+// and code. It returns false if err is nil.
+func (s *server) walletError(jc jape.Context, msg string, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, wallet.ErrNotFound):
+		writeError(jc, http.StatusNotFound, ErrorCodeWalletNotFound, err)
+	case errors.Is(err, wallet.ErrInvalidName):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, err)
+	case errors.Is(err, wallet.ErrExists):
+		writeError(jc, http.StatusConflict, ErrorCodeWalletExists, err)
+	case errors.Is(err, wallet.ErrInvalidSeed):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidSeed, err)
+	case errors.Is(err, wallet.ErrNotEnoughFunds):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNotEnoughFunds, err)
+	case errors.Is(err, wallet.ErrDustChange):
+		writeError(jc, http.StatusBadRequest, ErrorCodeDustChange, err)
+	case errors.Is(err, wallet.ErrInvalidVersion):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidVersion, err)
+	case errors.Is(err, wallet.ErrDataTooLarge):
+		writeError(jc, http.StatusBadRequest, ErrorCodeDataTooLarge, err)
+	case errors.Is(err, wallet.ErrNothingToConsolidate):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNothingToConsolidate, err)
+	case errors.Is(err, wallet.ErrUneconomical):
+		writeError(jc, http.StatusBadRequest, ErrorCodeUneconomical, err)
+	case errors.Is(err, wallet.ErrNotPending):
+		writeError(jc, http.StatusNotFound, ErrorCodeNotPending, err)
+	case errors.Is(err, wallet.ErrNotWalletTransaction):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNotWalletTransaction, err)
+	case errors.Is(err, wallet.ErrNoChangeOutput):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNoChangeOutput, err)
+	case errors.Is(err, wallet.ErrFeeSufficient):
+		writeError(jc, http.StatusBadRequest, ErrorCodeFeeSufficient, err)
+	case errors.Is(err, wallet.ErrOutputNotFound):
+		writeError(jc, http.StatusNotFound, ErrorCodeOutputNotFound, err)
+	case errors.Is(err, wallet.ErrOutputReserved):
+		writeError(jc, http.StatusConflict, ErrorCodeOutputReserved, err)
+	case errors.Is(err, wallet.ErrReservationLost):
+		writeError(jc, http.StatusConflict, ErrorCodeReservationLost, err)
+	case errors.Is(err, wallet.ErrWatchOnly):
+		writeError(jc, http.StatusBadRequest, ErrorCodeWatchOnly, err)
+	case errors.Is(err, wallet.ErrNotWatchOnly):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNotWatchOnly, err)
+	case errors.Is(err, wallet.ErrScanInProgress):
+		writeError(jc, http.StatusConflict, ErrorCodeScanInProgress, err)
+	case errors.Is(err, wallet.ErrInvalidSignature):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidSignature, err)
+	case errors.Is(err, wallet.ErrEventNotFound):
+		writeError(jc, http.StatusNotFound, ErrorCodeEventNotFound, err)
+	case errors.Is(err, wallet.ErrInvalidMultisig):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidMultisig, err)
+	case errors.Is(err, wallet.ErrInvalidGapLimit):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidGapLimit, err)
+	case errors.Is(err, wallet.ErrInvalidBackup):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidBackup, err)
+	case errors.Is(err, wallet.ErrWrongPassphrase):
+		writeError(jc, http.StatusBadRequest, ErrorCodeWrongPassphrase, err)
+	case errors.Is(err, wallet.ErrAddressNotFound):
+		writeError(jc, http.StatusNotFound, ErrorCodeAddressNotFound, err)
+	case errors.Is(err, wallet.ErrInvalidLabel):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidLabel, err)
+	case errors.Is(err, wallet.ErrInvalidStrategy):
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidStrategy, err)
+	case errors.Is(err, wallet.ErrNoWalletKey):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNoWalletKey, err)
+	case errors.Is(err, wallet.ErrLocked):
+		writeError(jc, http.StatusLocked, ErrorCodeWalletLocked, err)
+	case errors.Is(err, wallet.ErrNoPassphrase):
+		writeError(jc, http.StatusBadRequest, ErrorCodeNoPassphrase, err)
+	case errors.Is(err, wallet.ErrTooManyAttempts):
+		writeError(jc, http.StatusTooManyRequests, ErrorCodeTooManyAttempts, err)
+	case errors.Is(err, wallet.ErrSigner):
+		writeError(jc, http.StatusBadGateway, ErrorCodeSignerFailed, err)
+	default:
+		s.check(jc, msg, err)
+	}
+	return true
+}
+
+// walletEnabled writes an error and returns false if the node has no wallets.
+func (s *server) walletEnabled(jc jape.Context) bool {
+	if s.wallets == nil {
+		writeError(jc, http.StatusServiceUnavailable, ErrorCodeNotEnabled, errWalletNotEnabled)
+		return false
+	}
+	return true
+}
+
+// namedWallet returns the wallet named by the request's name parameter,
+// writing an error and returning false if it does not exist.
+func (s *server) namedWallet(jc jape.Context) (Wallet, bool) {
+	if !s.walletEnabled(jc) {
+		return nil, false
+	}
+	var name string
+	if decodeParam(jc, "name", &name) != nil {
+		return nil, false
+	}
+	w, err := s.wallets.Wallet(name)
+	if s.walletError(jc, "failed to get wallet", err) {
+		return nil, false
+	}
+	return w, true
+}
+
+func (s *server) handleGetWalletsName(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	status, err := w.Status()
+	if s.walletError(jc, "failed to get wallet status", err) {
+		return
+	}
+	jc.Encode(status)
+}
+
+func (s *server) handleGetWallets(jc jape.Context) {
+	if !s.walletEnabled(jc) {
+		return
+	}
+	statuses, err := s.wallets.Wallets()
+	if s.walletError(jc, "failed to get wallets", err) {
+		return
+	}
+	jc.Encode(append([]wallet.Status{}, statuses...))
+}
+
+func (s *server) handlePutWalletsName(jc jape.Context) {
+	if !s.walletEnabled(jc) {
+		return
+	}
+	var name string
+	var req WalletCreateRequest
+	if decodeParam(jc, "name", &name) != nil || decode(jc, &req) != nil {
+		return
+	}
+	switch req.Type {
+	case "", wallet.TypeSeed:
+		if len(req.Addresses) > 0 {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("seed wallets cannot watch addresses"))
+			return
+		}
+		phrase, err := s.wallets.Create(name, req.Phrase, req.Passphrase)
+		if s.walletError(jc, "failed to create wallet", err) {
+			return
+		}
+		jc.Encode(WalletCreateResponse{Phrase: phrase})
+	case wallet.TypeWatch:
+		if req.Phrase != "" || req.Passphrase != "" {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("watch-only wallets have no seed phrase"))
+			return
+		}
+		err := s.wallets.CreateWatch(name, req.Addresses, req.Height)
+		if s.walletError(jc, "failed to create wallet", err) {
+			return
+		}
+		jc.Encode(WalletCreateResponse{})
+	default:
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("unknown wallet type %q", req.Type))
+	}
+}
+
+// backupPassphrase returns the passphrase in the request's
+// BackupPassphraseHeader, writing an error and returning false if it is
+// missing.
+func backupPassphrase(jc jape.Context) (string, bool) {
+	passphrase := jc.Request.Header.Get(BackupPassphraseHeader)
+	if passphrase == "" {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("missing %s header", BackupPassphraseHeader))
+		return "", false
+	}
+	return passphrase, true
+}
+
+func (s *server) handleGetWalletsNameBackup(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	passphrase, ok := backupPassphrase(jc)
+	if !ok {
+		return
+	}
+	backup, err := w.Backup(passphrase)
+	if s.walletError(jc, "failed to back up wallet", err) {
+		return
+	}
+	jc.ResponseWriter.Header().Set("Cache-Control", "no-store")
+	jc.Encode(json.RawMessage(backup))
+}
+
+func (s *server) handlePostWalletsNameRestore(jc jape.Context) {
+	if !s.walletEnabled(jc) {
+		return
+	}
+	var name string
+	var backup json.RawMessage
+	if decodeParam(jc, "name", &name) != nil {
+		return
+	}
+	passphrase, ok := backupPassphrase(jc)
+	if !ok || decode(jc, &backup) != nil {
+		return
+	}
+	s.walletError(jc, "failed to restore wallet", s.wallets.Restore(name, backup, passphrase))
+}
+
+func (s *server) handleDeleteWalletsName(jc jape.Context) {
+	if !s.walletEnabled(jc) {
+		return
+	}
+	var name string
+	if decodeParam(jc, "name", &name) != nil {
+		return
+	}
+	s.walletError(jc, "failed to delete wallet", s.wallets.Delete(name))
+}
+
+func (s *server) handlePostWalletsNameWatch(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletWatchRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if len(req.Addresses) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("no addresses specified"))
+		return
+	}
+	s.walletError(jc, "failed to watch addresses", w.Watch(req.Addresses, req.Height))
+}
+
+func (s *server) handleGetWalletsNameBalance(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	balance, err := w.Balance()
+	if s.walletError(jc, "failed to get wallet balance", err) {
+		return
+	}
+	jc.Encode(balance)
+}
+
+func (s *server) handleGetWalletsNameBalanceDetailed(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	balance, err := w.DetailedBalance()
+	if s.walletError(jc, "failed to get detailed wallet balance", err) {
+		return
+	}
+	jc.Encode(balance)
+}
+
+func (s *server) handleGetWalletsNameAddresses(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	addrs, err := w.Addresses()
+	if s.walletError(jc, "failed to get wallet addresses", err) {
+		return
+	}
+	jc.Encode(addrs)
+}
+
+func (s *server) handlePostWalletsNameAddresses(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	count := 1
+	if decodeForm(jc, "count", &count) != nil {
+		return
+	} else if count <= 0 || count > maxAddressCount {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("count must be between 1 and %d", maxAddressCount))
+		return
+	}
+	addrs, err := w.AddAddresses(count)
+	if s.walletError(jc, "failed to derive wallet addresses", err) {
+		return
+	}
+	jc.Encode(addrs)
+}
+
+func (s *server) handleGetWalletsNameAddressesAddr(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var addr types.Address
+	if decodeParam(jc, "addr", &addr) != nil {
+		return
+	}
+	info, err := w.Address(addr)
+	if s.walletError(jc, "failed to get wallet address", err) {
+		return
+	}
+	jc.Encode(info)
+}
+
+func (s *server) handlePostWalletsNameAddressesCheck(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletAddressCheckRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if len(req.Addresses) > maxAddressCheckCount {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("at most %d addresses can be checked at once", maxAddressCheckCount))
+		return
+	}
+	checks, err := w.CheckAddresses(req.Addresses)
+	if s.walletError(jc, "failed to check wallet addresses", err) {
+		return
+	}
+	jc.Encode(checks)
+}
+
+func (s *server) handlePutWalletsNameAddressesAddrLabel(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var addr types.Address
+	var req WalletLabelRequest
+	if decodeParam(jc, "addr", &addr) != nil || decode(jc, &req) != nil {
+		return
+	}
+	s.walletError(jc, "failed to label wallet address", w.SetLabel(addr, req.Label))
+}
+
+// setMemo attaches memo, if any, to the wallet's record of txn before it is
+// broadcast, releasing its inputs and writing an error if it cannot. It
+// returns false if an error was written.
+func (s *server) setMemo(jc jape.Context, w Wallet, txn wallet.Transaction, memo string) bool {
+	if memo == "" {
+		return true
+	} else if s.walletError(jc, "failed to save transaction memo", w.SetMemo(txn.ID, memo)) {
+		s.releaseWalletInputs(w, txn.Transaction, txn.V2Transaction)
+		return false
+	}
+	return true
+}
+
+func (s *server) handlePostWalletsNameSend(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletSendRequest
+	var force, preview bool
+	var version int
+	if decode(jc, &req) != nil || decodeForm(jc, "force", &force) != nil || decodeForm(jc, "preview", &preview) != nil || decodeForm(jc, "version", &version) != nil {
+		return
+	} else if req.Amount.IsZero() {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("amount must be non-zero"))
+		return
+	} else if preview {
+		txn, err := w.PreviewSendSiacoins(req.Address, req.Amount, req.ArbitraryData, req.Strategy, version, force)
+		if s.walletError(jc, "failed to construct transaction", err) {
+			return
+		}
+		jc.Encode(walletSendResponse(txn))
+		return
+	}
+	txn, err := w.SendSiacoins(req.Address, req.Amount, req.ArbitraryData, req.Strategy, version, force)
+	if s.walletError(jc, "failed to construct transaction", err) || !s.setMemo(jc, w, txn, req.Memo) {
+		return
+	}
+	s.broadcastWalletTransaction(jc, w, txn)
+}
+
+func (s *server) handlePostWalletsNameSendSiafund(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletSendSiafundRequest
+	var force bool
+	var version int
+	if decode(jc, &req) != nil || decodeForm(jc, "force", &force) != nil || decodeForm(jc, "version", &version) != nil {
+		return
+	} else if req.Amount == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("amount must be non-zero"))
+		return
+	}
+	txn, err := w.SendSiafunds(req.Address, req.Amount, version, force)
+	if s.walletError(jc, "failed to construct transaction", err) || !s.setMemo(jc, w, txn, req.Memo) {
+		return
+	}
+	s.broadcastWalletTransaction(jc, w, txn)
+}
+
+func (s *server) handlePostWalletsNameAnchor(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletAnchorRequest
+	var version int
+	if decode(jc, &req) != nil || decodeForm(jc, "version", &version) != nil {
+		return
+	} else if len(req.ArbitraryData) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("arbitraryData must not be empty"))
+		return
+	}
+	txn, err := w.Anchor(req.ArbitraryData, version)
+	if s.walletError(jc, "failed to construct transaction", err) {
+		return
+	}
+	s.broadcastWalletTransaction(jc, w, txn)
+}
+
+func (s *server) handlePostWalletsNameConsolidate(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletConsolidateRequest
+	var preview bool
+	if decode(jc, &req) != nil || decodeForm(jc, "preview", &preview) != nil {
+		return
+	} else if req.MaxInputs < 0 || req.Target < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("maxInputs and target must not be negative"))
+		return
+	}
+	consolidate := w.Consolidate
+	if preview {
+		consolidate = w.PreviewConsolidate
+	}
+	res, err := consolidate(req.MaxInputs, req.Target)
+	if s.walletError(jc, "failed to consolidate outputs", err) {
+		return
+	}
+	// the transactions spend disjoint elements at the same basis, so they
+	// are broadcast as one set
+	var txns []types.Transaction
+	var v2txns []types.V2Transaction
+	var reserved []types.Hash256
+	resp := WalletConsolidateResponse{
+		Inputs:    res.Inputs,
+		Remaining: res.Remaining,
+		Fee:       res.Fee,
+		FeeRate:   res.FeeRate,
+		Savings:   res.Savings,
+	}
+	for _, txn := range res.Transactions {
+		resp.TransactionIDs = append(resp.TransactionIDs, txn.ID)
+		reserved = append(reserved, txn.Reserved...)
+		if txn.Transaction != nil {
+			txns = append(txns, *txn.Transaction)
+		} else {
+			v2txns = append(v2txns, *txn.V2Transaction)
+		}
+		if preview {
+			resp.Transactions = append(resp.Transactions, walletSendResponse(txn))
+		}
+	}
+	if preview {
+		jc.Encode(resp)
+		return
+	}
+	br, ok := s.broadcast(jc, res.Transactions[0].Basis, txns, v2txns)
+	if !ok {
+		if err := w.Release(reserved); err != nil {
+			s.log.Error("failed to release wallet inputs", zap.Error(err))
+		}
+		return
+	}
+	resp.Peers = br.Peers
+	jc.Encode(resp)
+}
+
+func (s *server) handlePostWalletsNameTransactionsConstruct(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletConstructRequest
+	var force bool
+	var version int
+	if decode(jc, &req) != nil || decodeForm(jc, "force", &force) != nil || decodeForm(jc, "version", &version) != nil {
+		return
+	} else if len(req.SiacoinOutputs) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("at least one siacoin output must be provided"))
+		return
+	} else if req.Duration < 0 || req.Duration > maxReserveDuration {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("duration must be between 0 and %v", maxReserveDuration))
+		return
+	} else if req.Duration == 0 {
+		req.Duration = defaultReserveDuration
+	}
+	for _, sco := range req.SiacoinOutputs {
+		if sco.Value.IsZero() {
+			writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("output values must be non-zero"))
+			return
+		}
+	}
+	ut, err := w.ConstructTransaction(req.SiacoinOutputs, req.Strategy, version, force, req.Duration)
+	if s.walletError(jc, "failed to construct transaction", err) || !s.setMemo(jc, w, ut.Transaction, req.Memo) {
+		return
+	}
+	jc.Encode(WalletConstructResponse{
+		ID:            ut.ID,
+		Basis:         ut.Basis,
+		Transaction:   ut.Transaction.Transaction,
+		V2Transaction: ut.V2Transaction,
+		Fee:           ut.Fee,
+		Change:        ut.Change,
+		Inputs:        ut.Inputs,
+		Expires:       ut.Expires,
+	})
+}
+
+func (s *server) handlePostWalletsNameTransactionsSubmit(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletSubmitRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if (req.Transaction == nil) == (req.V2Transaction == nil) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("exactly one of transaction or v2Transaction must be provided"))
+		return
+	}
+	txn := wallet.Transaction{Basis: req.Basis, Transaction: req.Transaction, V2Transaction: req.V2Transaction}
+	var err error
+	if req.Transaction != nil {
+		txn.ID = req.Transaction.ID()
+		txn.Fee = req.Transaction.TotalFees()
+		err = w.VerifyTransaction(*req.Transaction)
+	} else {
+		txn.ID = req.V2Transaction.ID()
+		txn.Fee = req.V2Transaction.MinerFee
+		err = w.VerifyV2Transaction(*req.V2Transaction)
+	}
+	if s.walletError(jc, "failed to verify transaction", err) {
+		return
+	}
+	s.broadcastWalletTransaction(jc, w, txn)
+}
+
+func (s *server) handlePostWalletsNameTransactionsBump(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletBumpRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	bump, err := w.BumpFee(req.ID, req.FeeRate)
+	if s.walletError(jc, "failed to bump transaction fee", err) {
+		return
+	}
+	// the parent is already in the pool, but is broadcast with its child, and
+	// its own unconfirmed parents, so that peers missing it accept the set
+	txns, v2txns, err := unconfirmedParents(s.chain.PoolTransactions(), s.chain.V2PoolTransactions(), bump.Child.Transaction, bump.Child.V2Transaction)
+	if err != nil {
+		s.releaseWalletInputs(w, bump.Child.Transaction, bump.Child.V2Transaction)
+		writeError(jc, http.StatusConflict, ErrorCodeNotPending, err)
+		return
+	} else if bump.Child.Transaction != nil {
+		txns = append(txns, *bump.Child.Transaction)
+	} else {
+		v2txns = append(v2txns, *bump.Child.V2Transaction)
+	}
+	br, ok := s.broadcast(jc, bump.Child.Basis, txns, v2txns)
+	if !ok {
+		s.releaseWalletInputs(w, bump.Child.Transaction, bump.Child.V2Transaction)
+		return
+	}
+	jc.Encode(WalletBumpResponse{
+		ID:            bump.Child.ID,
+		Parent:        bump.Parent.ID,
+		Basis:         bump.Child.Basis,
+		Transaction:   bump.Child.Transaction,
+		V2Transaction: bump.Child.V2Transaction,
+		Fee:           bump.Child.Fee,
+		FeeRate:       bump.FeeRate,
+		Peers:         br.Peers,
+	})
+}
+
+func (s *server) handlePostWalletsNameFund(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletFundRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if (req.Transaction == nil) == (req.V2Transaction == nil) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("exactly one of transaction or v2Transaction must be provided"))
+		return
+	} else if req.Amount.IsZero() {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("amount must be non-zero"))
+		return
+	} else if req.Duration < 0 || req.Duration > maxReserveDuration {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("duration must be between 0 and %v", maxReserveDuration))
+		return
+	} else if req.Duration == 0 {
+		req.Duration = defaultReserveDuration
+	}
+
+	// the wallet appends its inputs and change output to the transaction
+	resp := WalletFundResponse{Inputs: []types.SiacoinOutputID{}}
+	var outputs []types.SiacoinOutput
+	if req.Transaction != nil {
+		txn, err := w.FundTransaction(*req.Transaction, req.Amount, req.Strategy, req.Duration)
+		if s.walletError(jc, "failed to fund transaction", err) {
+			return
+		}
+		for _, sci := range txn.SiacoinInputs[len(req.Transaction.SiacoinInputs):] {
+			resp.Inputs = append(resp.Inputs, sci.ParentID)
+		}
+		resp.Transaction, outputs = &txn, txn.SiacoinOutputs[len(req.Transaction.SiacoinOutputs):]
+	} else {
+		basis, txn, err := w.FundV2Transaction(*req.V2Transaction, req.Amount, req.Strategy, req.Duration)
+		if s.walletError(jc, "failed to fund transaction", err) {
+			return
+		}
+		for _, sci := range txn.SiacoinInputs[len(req.V2Transaction.SiacoinInputs):] {
+			resp.Inputs = append(resp.Inputs, sci.Parent.ID)
+		}
+		resp.Basis, resp.V2Transaction, outputs = basis, &txn, txn.SiacoinOutputs[len(req.V2Transaction.SiacoinOutputs):]
+	}
+	if len(outputs) > 0 {
+		resp.Change = outputs[0].Value
+	}
+	parents, v2parents, err := unconfirmedParents(s.chain.PoolTransactions(), s.chain.V2PoolTransactions(), resp.Transaction, resp.V2Transaction)
+	if err != nil {
+		// the caller's inputs are unknown to the pool, which is for the
+		// caller to resolve; the wallet's own inputs are confirmed
+		s.releaseWalletInputs(w, resp.Transaction, resp.V2Transaction)
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidTransaction, err)
+		return
+	}
+	resp.Parents = TxpoolTransactionsResponse{Transactions: parents, V2Transactions: v2parents}
+	jc.Encode(resp)
+}
+
+func (s *server) handlePostWalletsNameRelease(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletReleaseRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if (req.Transaction == nil) == (req.V2Transaction == nil) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("exactly one of transaction or v2Transaction must be provided"))
+		return
+	}
+	var err error
+	if req.Transaction != nil {
+		err = w.ReleaseTransaction(*req.Transaction)
+	} else {
+		err = w.ReleaseV2Transaction(*req.V2Transaction)
+	}
+	s.walletError(jc, "failed to release transaction inputs", err)
+}
+
+func (s *server) handleGetWalletsNameEvents(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	offset, limit := 0, defaultEventsLimit
+	var label string
+	if decodeForm(jc, "offset", &offset) != nil || decodeForm(jc, "limit", &limit) != nil || decodeForm(jc, "label", &label) != nil {
+		return
+	} else if offset < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, errors.New("offset must be non-negative"))
+		return
+	} else if limit <= 0 || limit > maxEventsLimit {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Errorf("limit must be between 1 and %d", maxEventsLimit))
+		return
+	}
+	events, total, err := w.Events(offset, limit, label)
+	if s.walletError(jc, "failed to get wallet events", err) {
+		return
+	}
+	jc.ResponseWriter.Header().Set("X-Total-Count", strconv.Itoa(total))
+	jc.Encode(append([]wallet.Event{}, events...))
+}
+
+func (s *server) handleGetWalletsNameEventsID(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var id types.Hash256
+	if decodeParam(jc, "id", &id) != nil {
+		return
+	}
+	ev, err := w.Event(id)
+	if s.walletError(jc, "failed to get wallet event", err) {
+		return
+	}
+	jc.Encode(ev)
+}
+
+func (s *server) handleGetWalletsNameUnconfirmed(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	events, err := w.UnconfirmedEvents()
+	if s.walletError(jc, "failed to get unconfirmed wallet events", err) {
+		return
+	}
+	jc.Encode(events)
+}
+
+func (s *server) handleGetWalletsNameRescan(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	status, err := w.ScanStatus()
+	if s.walletError(jc, "failed to get wallet scan status", err) {
+		return
+	}
+	jc.Encode(status)
+}
+
+func (s *server) handlePostWalletsNameRescan(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletRescanRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	s.walletError(jc, "failed to start wallet scan", w.Rescan(req.Height))
+}
+
+func (s *server) handlePostWalletsNameSweep(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletSweepRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if req.Lookahead < 0 || req.Lookahead > maxSweepLookahead {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("lookahead must be between 0 and %d", maxSweepLookahead))
+		return
+	}
+	res, err := w.Sweep(jc.Request.Context(), req.Phrase, req.Lookahead, req.Height)
+	if s.walletError(jc, "failed to sweep seed", err) {
+		return
+	}
+	resp := WalletSweepResponse{
+		Siacoins:        res.Siacoins,
+		Siafunds:        res.Siafunds,
+		Fee:             res.Fee,
+		TransactionIDs:  []types.TransactionID{},
+		Skipped:         res.Skipped,
+		SkippedSiafunds: res.SkippedSiafunds,
+	}
+	if len(res.Transactions) > 0 {
+		// the transactions spend disjoint elements at the same basis, so
+		// they are broadcast as one set
+		var txns []types.Transaction
+		var v2txns []types.V2Transaction
+		for _, txn := range res.Transactions {
+			resp.TransactionIDs = append(resp.TransactionIDs, txn.ID)
+			if txn.Transaction != nil {
+				txns = append(txns, *txn.Transaction)
+			} else {
+				v2txns = append(v2txns, *txn.V2Transaction)
+			}
+		}
+		br, ok := s.broadcast(jc, res.Transactions[0].Basis, txns, v2txns)
+		if !ok {
+			return
+		}
+		resp.Peers = br.Peers
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetWalletsNameOutputs(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	sces, sfes, err := w.Outputs()
+	if s.walletError(jc, "failed to get wallet outputs", err) {
+		return
+	}
+	resp := WalletOutputsResponse{
+		SiacoinElements: append([]types.SiacoinElement{}, sces...),
+		SiafundElements: append([]types.SiafundElement{}, sfes...),
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handlePostWalletsNameOutputsReserve(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletReserveRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if len(req.IDs) == 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("no outputs specified"))
+		return
+	} else if req.Duration < 0 || req.Duration > maxReserveDuration {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Errorf("duration must be between 0 and %v", maxReserveDuration))
+		return
+	} else if req.Duration == 0 {
+		req.Duration = defaultReserveDuration
+	}
+	s.walletError(jc, "failed to reserve outputs", w.Reserve(req.IDs, req.Duration))
+}
+
+func (s *server) handlePostWalletsNameOutputsRelease(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletReleaseOutputsRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	s.walletError(jc, "failed to release outputs", w.Release(req.IDs))
+}
+
+// releaseWalletInputs releases the wallet inputs of a transaction that will
+// not be broadcast. Errors are logged, since the reservations expire anyway.
+func (s *server) releaseWalletInputs(w Wallet, txn *types.Transaction, v2txn *types.V2Transaction) {
+	var err error
+	if txn != nil {
+		err = w.ReleaseTransaction(*txn)
+	} else {
+		err = w.ReleaseV2Transaction(*v2txn)
+	}
+	if err != nil {
+		s.log.Error("failed to release wallet inputs", zap.Error(err))
+	}
+}
+
+func (s *server) handlePostWalletsNameSign(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletSignRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if (req.Transaction == nil) == (req.V2Transaction == nil) {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("exactly one of transaction or v2Transaction must be provided"))
+		return
+	} else if req.CoveredFields != nil && req.V2Transaction != nil {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("coveredFields only applies to v1 transactions"))
+		return
+	}
+
+	var resp WalletSignResponse
+	if req.Transaction != nil {
+		cf := types.CoveredFields{WholeTransaction: true}
+		if req.CoveredFields != nil {
+			cf = *req.CoveredFields
+		}
+		txn, missing, remaining, err := w.SignTransaction(*req.Transaction, req.ToSign, cf)
+		if s.walletError(jc, "failed to sign transaction", err) {
+			return
+		}
+		resp.Transaction, resp.Missing, resp.Remaining = &txn, missing, remaining
+	} else {
+		txn, missing, remaining, err := w.SignV2Transaction(*req.V2Transaction, req.ToSign)
+		if s.walletError(jc, "failed to sign transaction", err) {
+			return
+		}
+		resp.V2Transaction, resp.Missing, resp.Remaining = &txn, missing, remaining
+	}
+	if resp.Missing == nil {
+		resp.Missing = []types.Hash256{} // always an array
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handleGetWalletsNameMultisig(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	infos, err := w.Multisig()
+	if s.walletError(jc, "failed to get multisig addresses", err) {
+		return
+	}
+	jc.Encode(infos)
+}
+
+func (s *server) handlePostWalletsNameMultisig(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletMultisigRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	ms, err := w.AddMultisig(req.Threshold, req.PublicKeys, req.V2, req.Height)
+	if s.walletError(jc, "failed to add multisig address", err) {
+		return
+	}
+	jc.Encode(ms)
+}
+
+func (s *server) handlePostWalletsNameUnlock(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletUnlockRequest
+	if decode(jc, &req) != nil {
+		return
+	} else if req.Timeout < 0 {
+		writeError(jc, http.StatusBadRequest, ErrorCodeInvalidRequest, errors.New("timeout must not be negative"))
+		return
+	}
+	s.walletError(jc, "failed to unlock wallet", w.Unlock(req.Passphrase, req.Timeout))
+}
+
+func (s *server) handlePostWalletsNameLock(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	s.walletError(jc, "failed to lock wallet", w.Lock())
+}
+
+func (s *server) handlePutWalletsNamePassphrase(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var req WalletPassphraseRequest
+	if decode(jc, &req) != nil {
+		return
+	}
+	s.walletError(jc, "failed to set wallet passphrase", w.SetPassphrase(req.Old, req.Passphrase))
+}
+
+func (s *server) handleGetWalletsNameSettings(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	settings, err := w.Settings()
+	if s.walletError(jc, "failed to get wallet settings", err) {
+		return
+	}
+	jc.Encode(settings)
+}
+
+func (s *server) handlePutWalletsNameSettings(jc jape.Context) {
+	w, ok := s.namedWallet(jc)
+	if !ok {
+		return
+	}
+	var settings wallet.Settings
+	if decode(jc, &settings) != nil {
+		return
+	}
+	s.walletError(jc, "failed to update wallet settings", w.UpdateSettings(settings))
+}
+
+// broadcastWalletTransaction broadcasts a transaction constructed by the
+// wallet, releasing its inputs if it is rejected.
+func (s *server) broadcastWalletTransaction(jc jape.Context, w Wallet, txn wallet.Transaction) {
+	var txns []types.Transaction
+	var v2txns []types.V2Transaction
+	if txn.Transaction != nil {
+		txns = append(txns, *txn.Transaction)
+	} else {
+		v2txns = append(v2txns, *txn.V2Transaction)
+	}
+	resp, ok := s.broadcast(jc, txn.Basis, txns, v2txns)
+	if !ok {
+		s.releaseWalletInputs(w, txn.Transaction, txn.V2Transaction)
+		return
+	}
+	sr := walletSendResponse(txn)
+	sr.Peers = resp.Peers
+	jc.Encode(sr)
+}
+
+// walletSendResponse returns the response describing txn, before it is
+// broadcast.
+func walletSendResponse(txn wallet.Transaction) WalletSendResponse {
+	return WalletSendResponse{
+		ID:            txn.ID,
+		Basis:         txn.Basis,
+		Transaction:   txn.Transaction,
+		V2Transaction: txn.V2Transaction,
+		Fee:           txn.Fee,
+		FeeRate:       txn.FeeRate,
+		Inputs:        txn.Reserved,
+		Change:        txn.Change,
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.sia.tech/node/internal/wallet"
+	"go.uber.org/zap"
+)
+
+// newWalletTestServer serves the API with a wallet named "test", created from
+// phrase, whose first address has been paid a mature miner payout.
+func newWalletTestServer(t *testing.T, phrase string) (*chain.Manager, *wallet.Manager, *Client) {
+	t.Helper()
+	n, genesis, cm := newTestChain(t)
+	wm, err := wallet.NewManager(cm, t.TempDir(), "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wm.Close() })
+	srv := httptest.NewServer(NewHandler(n, genesis.ID(), cm, nil, WithWallets(wm)))
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL, "")
+
+	if _, err := wm.Create("test", phrase, ""); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := c.WalletAddresses("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, addrs[0].Address.Address, 1)
+	testutil.MineBlocks(t, cm, types.VoidAddress, int(n.MaturityDelay))
+	waitWalletSynced(t, cm, c)
+	return cm, wm, c
+}
+
+// waitWalletSynced waits for the "test" wallet to reach the chain's tip.
+func waitWalletSynced(t *testing.T, cm *chain.Manager, c *Client) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		status, err := c.Wallet("test")
+		if err != nil {
+			t.Fatal(err)
+		} else if status.Tip == cm.Tip() {
+			return
+		}
+	}
+	t.Fatal("wallet did not sync")
+}
+
+func TestWalletOfflineSigning(t *testing.T) {
+	// the signing device holds only the phrase
+	phrase := cwallet.NewSeedPhrase()
+	cm, _, c := newWalletTestServer(t, phrase)
+
+	dest := types.SiacoinOutput{Address: types.Address{1}, Value: types.Siacoins(100)}
+	ut, err := c.WalletConstructTransaction("test", []types.SiacoinOutput{dest}, "", "", wallet.VersionAuto, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if ut.V2Transaction == nil {
+		t.Fatal("expected a v2 transaction")
+	} else if len(ut.Inputs) != len(ut.V2Transaction.SiacoinInputs) {
+		t.Fatalf("expected signing metadata for %v inputs, got %v", len(ut.V2Transaction.SiacoinInputs), len(ut.Inputs))
+	}
+	outputs, err := c.WalletOutputs("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sce := range outputs.SiacoinElements {
+		for _, in := range ut.Inputs {
+			if types.Hash256(sce.ID) == in.ParentID {
+				t.Fatalf("input %v is not reserved while the transaction is signed", in.ParentID)
+			}
+		}
+	}
+
+	// an unsigned transaction is refused
+	var apiErr *Error
+	if _, err := c.WalletSubmitV2Transaction("test", ut.Basis, *ut.V2Transaction); !errors.As(err, &apiErr) || apiErr.Code != ErrorCodeInvalidSignature {
+		t.Fatalf("expected %q, got %v", ErrorCodeInvalidSignature, err)
+	}
+
+	// the device signs with keys derived from the phrase, checking the
+	// metadata against the transaction rather than trusting it
+	var seed [32]byte
+	if err := cwallet.SeedFromPhrase(&seed, phrase); err != nil {
+		t.Fatal(err)
+	}
+	txn := ut.V2Transaction.DeepCopy()
+	sigHash := cm.TipState().InputSigHash(txn)
+	for i, in := range ut.Inputs {
+		sci := &txn.SiacoinInputs[i]
+		key := cwallet.KeyFromSeed(&seed, in.KeyIndex)
+		if types.Hash256(sci.Parent.ID) != in.ParentID {
+			t.Fatalf("input %v: expected parent %v, got %v", i, sci.Parent.ID, in.ParentID)
+		} else if in.SigHash != sigHash {
+			t.Fatalf("input %v: expected sighash %v, got %v", i, sigHash, in.SigHash)
+		} else if key.PublicKey() != in.PublicKey {
+			t.Fatalf("input %v: key %v does not match public key %v", i, in.KeyIndex, in.PublicKey)
+		} else if types.StandardUnlockHash(in.PublicKey) != sci.Parent.SiacoinOutput.Address {
+			t.Fatalf("input %v: public key does not own %v", i, sci.Parent.SiacoinOutput.Address)
+		}
+		sci.SatisfiedPolicy.Signatures = []types.Signature{key.SignHash(in.SigHash)}
+	}
+
+	resp, err := c.WalletSubmitV2Transaction("test", ut.Basis, txn)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.ID != ut.ID {
+		t.Fatalf("expected ID %v, got %v", ut.ID, resp.ID)
+	}
+	testutil.MineBlocks(t, cm, types.VoidAddress, 1)
+	waitWalletSynced(t, cm, c)
+	ev, err := c.WalletEvent("test", types.Hash256(ut.ID))
+	if err != nil {
+		t.Fatal(err)
+	} else if ev.Index != cm.Tip() {
+		t.Fatalf("expected the transaction to be confirmed at %v, got %v", cm.Tip(), ev.Index)
+	} else if want := dest.Value.Add(ut.Fee); !ev.SiacoinOutflow.Sub(ev.SiacoinInflow).Equals(want) {
+		t.Fatalf("expected the wallet to spend %v, got %v", want, ev.SiacoinOutflow.Sub(ev.SiacoinInflow))
+	}
+}
+
+// A slowSigner signs with the keys of seed once release is closed, closing
+// started when it is first asked for a signature.
+type slowSigner struct {
+	seed    [32]byte
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (ss *slowSigner) SignHash(_ string, index uint64, sigHash types.Hash256) (types.Signature, error) {
+	ss.once.Do(func() { close(ss.started) })
+	<-ss.release
+	return cwallet.KeyFromSeed(&ss.seed, index).SignHash(sigHash), nil
+}
+
+func TestWalletSignSlowSigner(t *testing.T) {
+	phrase := cwallet.NewSeedPhrase()
+	cm, wm, c := newWalletTestServer(t, phrase)
+	ss := &slowSigner{started: make(chan struct{}), release: make(chan struct{})}
+	if err := cwallet.SeedFromPhrase(&ss.seed, phrase); err != nil {
+		t.Fatal(err)
+	}
+	wm.SetSigner(ss)
+
+	dest := types.SiacoinOutput{Address: types.Address{1}, Value: types.Siacoins(100)}
+	ut, err := c.WalletConstructTransaction("test", []types.SiacoinOutput{dest}, "", "", wallet.VersionAuto, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type signResult struct {
+		resp WalletSignResponse
+		err  error
+	}
+	res := make(chan signResult, 1)
+	go func() {
+		resp, err := c.WalletSignV2("test", *ut.V2Transaction, nil)
+		res <- signResult{resp, err}
+	}()
+	select {
+	case <-ss.started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("signer was not called")
+	}
+
+	// the wallet still serves requests and syncs while the signer waits
+	if _, err := c.WalletBalance("test"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, types.VoidAddress, 1)
+	waitWalletSynced(t, cm, c)
+
+	close(ss.release)
+	r := <-res
+	if r.err != nil {
+		t.Fatal(r.err)
+	} else if len(r.resp.Missing) != 0 {
+		t.Fatalf("expected every input to be signed, missing %v", r.resp.Missing)
+	}
+	if _, err := c.WalletSubmitV2Transaction("test", ut.Basis, *r.resp.V2Transaction); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, types.VoidAddress, 1)
+	waitWalletSynced(t, cm, c)
+	if ev, err := c.WalletEvent("test", types.Hash256(ut.ID)); err != nil {
+		t.Fatal(err)
+	} else if ev.Index != cm.Tip() {
+		t.Fatalf("expected the transaction to be confirmed at %v, got %v", cm.Tip(), ev.Index)
+	}
+}
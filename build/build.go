@@ -0,0 +1,32 @@
+// Package build contains information about the build, set at link time with
+// -ldflags, e.g.
+//
+//	go build -ldflags "-X go.sia.tech/node/build.version=v1.0.0 -X go.sia.tech/node/build.commit=$(git rev-parse HEAD) -X go.sia.tech/node/build.buildTime=$(date +%s)" ./cmd/noded
+package build
+
+import (
+	"strconv"
+	"time"
+)
+
+var (
+	version   = "?"
+	commit    = "?"
+	buildTime = "0"
+)
+
+// Version returns the semantic version of the build.
+func Version() string {
+	return version
+}
+
+// Commit returns the git commit of the build.
+func Commit() string {
+	return commit
+}
+
+// Time returns the time at which the build was made.
+func Time() time.Time {
+	t, _ := strconv.ParseInt(buildTime, 10, 64)
+	return time.Unix(t, 0).UTC()
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
 	"os"
@@ -12,6 +17,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"go.sia.tech/core/consensus"
@@ -22,11 +29,38 @@ import (
 	"go.sia.tech/coreutils/syncer"
 	"go.sia.tech/coreutils/testutil"
 	"go.sia.tech/node/api"
+	"go.sia.tech/node/build"
+	"go.sia.tech/node/internal/certs"
+	"go.sia.tech/node/internal/index"
 	"go.sia.tech/node/internal/ip"
+	"go.sia.tech/node/internal/mining"
+	"go.sia.tech/node/internal/peers"
+	"go.sia.tech/node/internal/txpool"
+	"go.sia.tech/node/internal/wallet"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"lukechampine.com/frand"
 )
 
+const (
+	// peerProbeInterval is the interval between latency probes of connected
+	// peers.
+	peerProbeInterval = time.Minute
+	// peerProbeTimeout is the maximum amount of time to wait for a peer to
+	// respond to a latency probe.
+	peerProbeTimeout = 10 * time.Second
+)
+
+// stringsFlag is a flag.Value that collects the values of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
 // initLog initializes the logger with the specified settings.
 func initLog(showColors bool, logLevel zap.AtomicLevel) *zap.Logger {
 	cfg := zap.NewProductionEncoderConfig()
@@ -49,24 +83,253 @@ func initLog(showColors bool, logLevel zap.AtomicLevel) *zap.Logger {
 	return log
 }
 
+// parseTokens parses API tokens given as scope:token pairs.
+func parseTokens(pairs []string) ([]api.Token, error) {
+	var tokens []api.Token
+	for _, pair := range pairs {
+		scope, token, ok := strings.Cut(pair, ":")
+		if !ok || token == "" {
+			return nil, errors.New("tokens must be given as scope:token")
+		}
+		t := api.Token{Token: token}
+		if err := t.Scope.UnmarshalText([]byte(scope)); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+// loadTokens reads a JSON array of API tokens from path.
+func loadTokens(path string) ([]api.Token, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []api.Token
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode %q: %w", path, err)
+	}
+	for _, t := range tokens {
+		if t.Token == "" || t.Scope == "" {
+			return nil, fmt.Errorf("%q contains a token without a value or scope", path)
+		}
+	}
+	return tokens, nil
+}
+
+// listenUnix listens on a Unix socket at path with the given file mode. A
+// socket left behind by a crashed process is removed, but a socket that is
+// still accepting connections is left alone.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists and is not a socket", path)
+		} else if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%q is in use by another process", path)
+		} else if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	// the socket file is removed when the listener is closed
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	} else if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+	return l, nil
+}
+
+// apiPasswordEnv is the environment variable that sets the API password.
+const apiPasswordEnv = "NODED_API_PASSWORD"
+
+// loadAPIPassword returns the API password. The -http.password flag takes
+// precedence, followed by the environment. Otherwise, the password is read
+// from path, which is created with a random password on first run.
+func loadAPIPassword(flagPassword, path string) (password string, generated bool, err error) {
+	if flagPassword != "" {
+		return flagPassword, false, nil
+	} else if env := os.Getenv(apiPasswordEnv); env != "" {
+		return env, false, nil
+	}
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if password = strings.TrimSpace(string(b)); password == "" {
+			return "", false, fmt.Errorf("API password file %q is empty", path)
+		}
+		return password, false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", false, err
+	}
+	password = hex.EncodeToString(frand.Bytes(16))
+	if err := os.WriteFile(path, []byte(password+"\n"), 0600); err != nil {
+		return "", false, err
+	}
+	return password, true, nil
+}
+
+// walletPasswordEnv is the environment variable that sets the wallet
+// password.
+const walletPasswordEnv = "NODED_WALLET_PASSWORD"
+
+// localNetwork returns a private network for development, derived from Zen,
+// whose blocks are trivial to mine and on which every hardfork, including v2,
+// is active from height 1. Its genesis block differs from Zen's, so its
+// nodes do not connect to Zen peers.
+func localNetwork() (*consensus.Network, types.Block) {
+	n, genesis := chain.TestnetZen()
+	n.Name = "local"
+	n.InitialTarget = types.BlockID{0xFF}
+	n.BlockInterval = time.Second
+	n.MaturityDelay = 5
+	n.HardforkDevAddr.Height = 1
+	n.HardforkTax.Height = 1
+	n.HardforkStorageProof.Height = 1
+	n.HardforkOak.Height = 1
+	n.HardforkASIC.Height = 1
+	n.HardforkFoundation.Height = 1
+	n.HardforkV2.AllowHeight = 1
+	n.HardforkV2.RequireHeight = 1
+	n.HardforkV2.FinalCutHeight = 1
+	genesis.Timestamp = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return n, genesis
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "wallet" {
+		if err := runWallet(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "noded wallet:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	runNode()
+}
+
+// runNode parses the node's flags and runs it until it is interrupted.
+func runNode() {
 	var (
 		networkName string
 		dir         string
 		level       zap.AtomicLevel
 		syncerPort  uint
+		pinned      stringsFlag
+		poolMaxSize uint64
+
+		healthMinPeers  int
+		healthMaxTipAge time.Duration
+
+		enablePprof bool
+		corsOrigins string
+		gzipLevel   int
+
+		accessLog        bool
+		accessLogExclude string
+		trustProxy       bool
+		readOnly         bool
+
+		rateLimit, expensiveRateLimit api.RateLimit
+
+		password string
+
+		walletPassword      string
+		walletSigner        string
+		walletSignerTimeout time.Duration
+		tokenPairs          stringsFlag
+		tokensPath          string
+
+		mine         bool
+		mineThreads  int
+		mineAddress  string
+		mineTag      string
+		feeThreshold types.Currency
+
+		enableIndex bool
+
+		stratumAddr       string
+		stratumDifficulty uint64
+
+		tlsCert, tlsKey string
+		tlsAuto         bool
+
+		apiAddr    string
+		socketPath string
+		socketMode string
 	)
 
-	flag.StringVar(&networkName, "network", "mainnet", "the network to use (mainnet, zen)")
+	flag.StringVar(&networkName, "network", "mainnet", "the network to use (mainnet, zen, or local, a private network for development)")
 	flag.StringVar(&dir, "dir", ".", "the directory to store data")
 	flag.UintVar(&syncerPort, "port", 9981, "the port to listen for syncer connections on")
+	flag.Var(&pinned, "peer", "a peer to keep connected; may be repeated")
+	flag.Uint64Var(&poolMaxSize, "txpool.maxsize", 64<<20, "the maximum size of the txpool in bytes; once it is reached, transaction sets from peers are rejected rather than evicting pooled ones, and transactions broadcast through the API are exempt")
+	flag.IntVar(&healthMinPeers, "health.minpeers", 1, "the minimum number of connected peers for the node to be healthy")
+	flag.DurationVar(&healthMaxTipAge, "health.maxtipage", 3*time.Hour, "the maximum age of the tip's timestamp for the node to be healthy")
+	flag.StringVar(&corsOrigins, "http.cors", "", "a comma-separated list of origins allowed to make cross-origin API requests, or * for any origin")
+	flag.IntVar(&gzipLevel, "http.gzip", gzip.DefaultCompression, "the gzip compression level of API responses (1-9, or -1 for the default); 0 disables compression")
+	flag.BoolVar(&accessLog, "http.log", true, "log API requests")
+	flag.StringVar(&accessLogExclude, "http.log.exclude", "/health,/health/live", "a comma-separated list of paths excluded from the API request log")
+	flag.StringVar(&password, "http.password", "", "the API password; if unset, it is read from "+apiPasswordEnv+" or the apipassword file in the data directory, which is generated on first run")
+	flag.Var(&tokenPairs, "http.token", "an API bearer token given as scope:token, where scope is read or admin; may be repeated")
+	flag.StringVar(&tokensPath, "http.tokens", "", "a JSON file containing an array of API tokens, each with a token and scope")
+	flag.StringVar(&tlsCert, "http.tls.cert", "", "the path of a TLS certificate to serve the API over HTTPS with; reloaded when it changes")
+	flag.StringVar(&tlsKey, "http.tls.key", "", "the path of the TLS certificate's private key")
+	flag.BoolVar(&tlsAuto, "http.tls.auto", false, "serve the API over HTTPS with a self-signed certificate stored in the data directory")
+	flag.StringVar(&apiAddr, "http.addr", "localhost:9980", "the address to serve the API on; empty to only serve on -http.socket")
+	flag.StringVar(&socketPath, "http.socket", "", "the path of a Unix socket to serve the API on, in addition to -http.addr")
+	flag.StringVar(&socketMode, "http.socket.mode", "0660", "the file mode of the API's Unix socket, in octal")
+	flag.BoolVar(&readOnly, "http.readonly", false, "disable API routes that change the node's state, such as broadcasting and connecting to peers")
+	flag.BoolVar(&trustProxy, "http.trustproxy", false, "trust the X-Forwarded-For header set by a reverse proxy")
+	flag.Float64Var(&rateLimit.Rate, "http.ratelimit", 0, "the maximum sustained rate of API requests per second from each client IP; 0 disables the limit")
+	flag.IntVar(&rateLimit.Burst, "http.ratelimit.burst", 20, "the maximum burst of API requests from each client IP")
+	flag.Float64Var(&expensiveRateLimit.Rate, "http.ratelimit.expensive", 0, "the maximum sustained rate of requests per second to expensive API routes, such as block batches, from each client IP; 0 disables the limit")
+	flag.IntVar(&expensiveRateLimit.Burst, "http.ratelimit.expensive.burst", 5, "the maximum burst of requests to expensive API routes from each client IP")
+	flag.StringVar(&walletPassword, "wallet.password", "", "the password wallet seeds are encrypted with; if unset, it is read from "+walletPasswordEnv+", and wallets are disabled if neither is set")
+	flag.StringVar(&walletSigner, "wallet.signer", "", "an external signer that signs for the wallets instead of their seeds: a loopback http URL to post signing requests to, or a command, with any arguments, to run for each signature")
+	flag.DurationVar(&walletSignerTimeout, "wallet.signer.timeout", wallet.DefaultSignerTimeout, "the maximum time to wait for the external signer to return each signature")
+	flag.BoolVar(&mine, "mine", false, "mine blocks on the CPU, paying the payout address; not allowed on mainnet")
+	flag.IntVar(&mineThreads, "mine.threads", 1, "the number of threads to mine with; 0 for one per CPU")
+	flag.StringVar(&mineAddress, "mine.address", "", "the payout address of templates and mined blocks, if none is stored in the data directory")
+	flag.StringVar(&mineTag, "mine.tag", "", fmt.Sprintf("arbitrary data, of at most %d bytes, to mark blocks mined by the node and templates requested without a tag", mining.MaxTagSize))
+	flag.TextVar(&feeThreshold, "mining.feedelta", types.ZeroCurrency, "the additional fees, such as 1SC, that new txpool transactions must pay for the block template to change before the tip does")
+	flag.StringVar(&stratumAddr, "mining.stratum.addr", "", "the address to serve work to mining hardware on, over a Stratum-like protocol; empty to disable")
+	flag.Uint64Var(&stratumDifficulty, "mining.stratum.difficulty", mining.DefaultStratumDifficulty, "the difficulty of Stratum shares, in expected hashes per share")
+	flag.BoolVar(&enableIndex, "index", false, "maintain an index of every address's unspent elements, in a separate database in the data directory, to serve address balances; built from the genesis block when first enabled")
+	flag.BoolVar(&enablePprof, "debug.pprof", false, "serve profiling endpoints under /debug")
 	flag.TextVar(&level, "log.level", zap.NewAtomicLevelAt(zap.InfoLevel), "the log level")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  noded [flags]\n  noded wallet <generate|recover> [flags]\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	log := initLog(runtime.GOOS != "windows", level)
 
-	if syncerPort == 0 || syncerPort > 65535 {
+	if gzipLevel < gzip.HuffmanOnly || gzipLevel > gzip.BestCompression {
+		log.Panic("invalid gzip compression level", zap.Int("level", gzipLevel))
+	} else if syncerPort == 0 || syncerPort > 65535 {
 		log.Panic("invalid syncer port", zap.Uint("port", syncerPort))
+	} else if (tlsCert == "") != (tlsKey == "") {
+		log.Panic("-http.tls.cert and -http.tls.key must be provided together")
+	} else if tlsAuto && tlsCert != "" {
+		log.Panic("-http.tls.auto cannot be combined with -http.tls.cert")
+	}
+	if apiAddr == "" && socketPath == "" {
+		log.Panic("-http.addr or -http.socket must be provided")
+	} else if apiAddr == "" && (tlsCert != "" || tlsAuto) {
+		log.Panic("TLS requires -http.addr")
+	} else if apiAddr != "" {
+		if _, port, err := net.SplitHostPort(apiAddr); err != nil {
+			log.Panic("invalid API address", zap.String("address", apiAddr), zap.Error(err))
+		} else if n, err := strconv.ParseUint(port, 10, 16); err != nil || (n == 0 && port != "0") {
+			log.Panic("invalid API address", zap.String("address", apiAddr), zap.String("port", port))
+		}
+	}
+	mode, err := strconv.ParseUint(socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		log.Panic("invalid socket mode", zap.String("mode", socketMode))
 	}
 
 	var network *consensus.Network
@@ -79,16 +342,58 @@ func main() {
 	case "zen":
 		bootstrapPeers = syncer.ZenBootstrapPeers
 		network, genesis = chain.TestnetZen()
+	case "local":
+		network, genesis = localNetwork()
 	default:
 		log.Panic("unknown network", zap.String("name", networkName))
 	}
 	genesisID := genesis.ID()
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	var payoutAddr types.Address
+	if mine && network.Name == "mainnet" {
+		log.Panic("-mine is not allowed on mainnet")
+	} else if mineThreads < 0 {
+		log.Panic("-mine.threads must not be negative")
+	} else if err := mining.ValidateTag(mineTag); err != nil {
+		log.Panic("invalid -mine.tag", zap.Error(err))
+	} else if stratumAddr != "" && stratumDifficulty == 0 {
+		log.Panic("-mining.stratum.difficulty must be positive")
+	} else if mineAddress != "" {
+		if payoutAddr, err = types.ParseAddress(mineAddress); err != nil {
+			log.Panic("invalid -mine.address", zap.Error(err))
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		log.Panic("failed to create data directory", zap.Error(err))
+	} else if dir, err = filepath.Abs(dir); err != nil {
+		log.Panic("failed to resolve data directory", zap.Error(err))
+	}
+
+	passwordPath := filepath.Join(dir, "apipassword")
+	password, generated, err := loadAPIPassword(password, passwordPath)
+	if err != nil {
+		log.Panic("failed to load API password", zap.Error(err))
+	} else if generated {
+		log.Info("generated API password", zap.String("path", passwordPath))
+	}
+
+	tokens, err := parseTokens(tokenPairs)
+	if err != nil {
+		log.Panic("invalid API token", zap.Error(err))
+	}
+	if tokensPath != "" {
+		fileTokens, err := loadTokens(tokensPath)
+		if err != nil {
+			log.Panic("failed to load API tokens", zap.Error(err))
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	for _, t := range tokens {
+		log.Debug("loaded API token", zap.Stringer("token", t))
 	}
 
 	bdb, err := coreutils.OpenBoltChainDB(filepath.Join(dir, "consensus.db"))
@@ -102,6 +407,7 @@ func main() {
 		log.Panic("failed to create chain store", zap.Error(err))
 	}
 	cm := chain.NewManager(dbstore, tipState, chain.WithLog(log.Named("chain")))
+	log.Info("starting node", zap.String("version", build.Version()), zap.String("commit", build.Commit()))
 	log.Info("using network", zap.String("name", networkName), zap.Stringer("genesisID", genesisID), zap.Stringer("tip", cm.Tip()))
 
 	stop := cm.OnReorg(func(tip types.ChainIndex) {
@@ -109,11 +415,17 @@ func main() {
 	})
 	defer stop()
 
+	tracker := peers.NewTracker()
+	// transactions relayed by peers are subject to the txpool size limit
+	limiter := txpool.NewLimiter(cm, poolMaxSize, log.Named("txpool"))
 	syncerOpts := []syncer.Option{
 		syncer.WithMaxInflightRPCs(1e6), syncer.WithMaxInboundPeers(1e6),
+		syncer.WithDialer(tracker), syncer.WithLogger(log.Named("syncer")),
 	}
 
-	ps := testutil.NewEphemeralPeerStore()
+	var syncers []api.Syncer
+	var listeners []api.SyncerListener
+	ps := peers.NewBanStore(testutil.NewEphemeralPeerStore())
 	for _, addr := range bootstrapPeers {
 		ps.AddPeer(addr)
 	}
@@ -121,6 +433,7 @@ func main() {
 	ip4, err := ip.Getv4()
 	if err != nil {
 		log.Warn("failed to determine IPv4 address", zap.Error(err))
+		listeners = append(listeners, api.SyncerListener{Network: "tcp4", Error: err.Error()})
 	} else {
 		log.Info("determined IPv4 address", zap.String("ip", ip4.String()))
 		netAddress := net.JoinHostPort(ip4.String(), strconv.Itoa(int(syncerPort)))
@@ -136,14 +449,22 @@ func main() {
 			NetAddress: netAddress,
 		}
 		log.Info("listening for syncer connections on IPv4", zap.String("address", netAddress))
-		s := syncer.New(l, cm, ps, header, syncerOpts...)
+		s := syncer.New(tracker.Listener(l), limiter, tracker.PeerStore(ps), header, syncerOpts...)
 		defer s.Close()
 		go s.Run()
+		syncers = append(syncers, s)
+		listeners = append(listeners, api.SyncerListener{
+			Network:       "tcp4",
+			NetAddress:    header.NetAddress,
+			ListenAddress: l.Addr().String(),
+			Active:        true,
+		})
 	}
 
 	ip6, err := ip.Getv6()
 	if err != nil {
 		log.Warn("failed to determine IPv6 address", zap.Error(err))
+		listeners = append(listeners, api.SyncerListener{Network: "tcp6", Error: err.Error()})
 	} else {
 		log.Info("determined IPv6 address", zap.String("ip", ip6.String()))
 		netAddress := net.JoinHostPort(ip6.String(), strconv.Itoa(int(syncerPort)))
@@ -159,28 +480,221 @@ func main() {
 			NetAddress: netAddress,
 		}
 		log.Info("listening for syncer connections on IPv6", zap.String("address", netAddress))
-		s := syncer.New(l, cm, ps, header, syncerOpts...)
+		s := syncer.New(tracker.Listener(l), limiter, tracker.PeerStore(ps), header, syncerOpts...)
 		defer s.Close()
 		go s.Run()
+		syncers = append(syncers, s)
+		listeners = append(listeners, api.SyncerListener{
+			Network:       "tcp6",
+			NetAddress:    header.NetAddress,
+			ListenAddress: l.Addr().String(),
+			Active:        true,
+		})
 	}
 
-	l, err := net.Listen("tcp", ":8080")
+	connectedPeers := func() (connected []*syncer.Peer) {
+		for _, s := range syncers {
+			connected = append(connected, s.Peers()...)
+		}
+		return connected
+	}
+	go tracker.ProbePeers(ctx, peerProbeInterval, peerProbeTimeout, connectedPeers)
+
+	local, err := txpool.NewLocal(cm, filepath.Join(dir, "local.json"), connectedPeers, log.Named("local"))
 	if err != nil {
-		log.Panic("failed to listen for API connections", zap.Error(err))
+		log.Panic("failed to initialize local transaction tracking", zap.Error(err))
+	}
+	defer local.Close()
+
+	// mined blocks are relayed like those submitted through the API
+	broadcast := func(b types.Block) { api.BroadcastBlock(cm, syncers, b, log.Named("mining")) }
+	miner, err := mining.NewMiner(cm, filepath.Join(dir, "mining.json"), broadcast, log.Named("mining"))
+	if err != nil {
+		log.Panic("failed to initialize miner", zap.Error(err))
+	}
+	defer miner.Close()
+	miner.SetFeeThreshold(feeThreshold)
+	if err := miner.SetTag(mineTag); err != nil {
+		log.Panic("failed to set mining tag", zap.Error(err))
+	}
+	if stored := miner.PayoutAddress(); payoutAddr != types.VoidAddress && stored == types.VoidAddress {
+		if err := miner.SetPayoutAddress(payoutAddr); err != nil {
+			log.Panic("failed to set payout address", zap.Error(err))
+		}
+	} else if payoutAddr != types.VoidAddress && payoutAddr != stored {
+		log.Info("ignoring -mine.address; a different payout address is stored", zap.Stringer("address", stored))
+	}
+	if mine {
+		if err := miner.Start(types.VoidAddress, mineThreads); errors.Is(err, mining.ErrNoPayoutAddress) {
+			log.Panic("-mine requires a payout address; set -mine.address")
+		} else if err != nil {
+			log.Panic("failed to start mining", zap.Error(err))
+		}
+	}
+	if stratumAddr != "" {
+		l, err := net.Listen("tcp", stratumAddr)
+		if err != nil {
+			log.Panic("failed to listen for Stratum connections", zap.String("address", stratumAddr), zap.Error(err))
+		}
+		ss, err := mining.NewStratumServer(miner, l, stratumDifficulty, log.Named("stratum"))
+		if err != nil {
+			log.Panic("failed to start Stratum server", zap.Error(err))
+		}
+		defer ss.Close()
+		log.Info("listening for Stratum connections", zap.Stringer("address", ss.Addr()), zap.Uint64("difficulty", stratumDifficulty))
+	}
+
+	var ix *index.Index
+	if enableIndex {
+		ix, err = index.NewIndex(cm, filepath.Join(dir, "index.db"), log.Named("index"))
+		if err != nil {
+			log.Panic("failed to open address index", zap.Error(err))
+		}
+		defer ix.Close()
+	}
+
+	if walletPassword == "" {
+		walletPassword = os.Getenv(walletPasswordEnv)
+	}
+	var wm *wallet.Manager
+	if walletPassword != "" {
+		wm, err = wallet.NewManager(cm, filepath.Join(dir, "wallet"), walletPassword, log.Named("wallet"))
+		if err != nil {
+			log.Panic("failed to load wallets", zap.Error(err))
+		}
+		defer wm.Close()
+		if walletSigner != "" {
+			signer, err := newSigner(walletSigner, walletSignerTimeout)
+			if err != nil {
+				log.Panic("failed to initialize wallet signer", zap.Error(err))
+			}
+			wm.SetSigner(signer)
+			log.Info("wallets sign with an external signer", zap.Duration("timeout", walletSignerTimeout))
+		}
+	} else {
+		log.Info("wallets disabled; set " + walletPasswordEnv + " to enable them")
+	}
+
+	apiOpts := []api.ServerOption{
+		api.WithDataDir(dir),
+		api.WithHealthThresholds(healthMinPeers, healthMaxTipAge),
+		api.WithDebug(enablePprof),
+		api.WithCompressionLevel(gzipLevel),
+		api.WithTrustedProxy(trustProxy),
+		api.WithReadOnly(readOnly),
+		api.WithRateLimits(rateLimit, expensiveRateLimit),
+		api.WithContext(ctx),
+		api.WithLogger(log.Named("api")),
+		api.WithLogLevel(level),
+		api.WithPassword(password),
+		api.WithTokens(tokens),
+		api.WithPeerStore(ps),
+		api.WithPeerTracker(tracker),
+		api.WithSyncerListeners(listeners),
+		api.WithTxpoolLimiter(limiter),
+		api.WithLocalTxpool(local),
+		api.WithMiner(miner),
+	}
+	if wm != nil {
+		apiOpts = append(apiOpts, api.WithWallets(wm))
+	}
+	if ix != nil {
+		apiOpts = append(apiOpts, api.WithAddressIndex(ix))
+	}
+	if accessLog {
+		var exclude []string
+		if accessLogExclude != "" {
+			exclude = strings.Split(accessLogExclude, ",")
+		}
+		apiOpts = append(apiOpts, api.WithAccessLog(log.Named("api"), exclude))
+	}
+	if corsOrigins != "" {
+		apiOpts = append(apiOpts, api.WithCORSOrigins(strings.Split(corsOrigins, ",")))
+	}
+	if len(syncers) > 0 {
+		// any syncer can dial either address family
+		pinner, err := peers.NewPinner(ps, syncers[0].Connect, connectedPeers, log.Named("pinner"))
+		if err != nil {
+			log.Panic("failed to initialize peer pinner", zap.Error(err))
+		}
+		defer pinner.Close()
+		for _, addr := range pinned {
+			if err := pinner.Pin(addr); err != nil {
+				log.Panic("failed to pin peer", zap.String("address", addr), zap.Error(err))
+			}
+		}
+		apiOpts = append(apiOpts, api.WithPeerPinner(pinner))
+	} else if len(pinned) > 0 {
+		log.Warn("no syncers are running; pinned peers will not be connected")
 	}
-	defer l.Close()
 
 	s := &http.Server{
-		Handler:           api.NewHandler(cm),
+		Handler:           api.NewHandler(network, genesisID, cm, syncers, apiOpts...),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       10 * time.Second,
+		// cancel in-flight requests, including event streams, on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	if apiAddr != "" {
+		l, err := net.Listen("tcp", apiAddr)
+		if err != nil {
+			log.Panic("failed to listen for API connections", zap.String("address", apiAddr), zap.Error(err))
+		}
+		defer l.Close()
+
+		scheme := "http"
+		if tlsAuto {
+			tlsCert, tlsKey = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+			hosts := []string{"localhost", "127.0.0.1", "::1"}
+			if hostname, err := os.Hostname(); err == nil {
+				hosts = append(hosts, hostname)
+			}
+			if generated, err := certs.EnsureSelfSigned(tlsCert, tlsKey, hosts); err != nil {
+				log.Panic("failed to generate self-signed certificate", zap.Error(err))
+			} else if generated {
+				log.Info("generated self-signed TLS certificate", zap.String("path", tlsCert))
+			}
+		}
+		if tlsCert != "" {
+			reloader, err := certs.NewReloader(tlsCert, tlsKey, log.Named("tls"))
+			if err != nil {
+				log.Panic("failed to load TLS certificate", zap.Error(err))
+			}
+			defer reloader.Close()
+			l = tls.NewListener(l, &tls.Config{
+				GetCertificate: reloader.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			})
+			scheme = "https"
+		}
+
+		go func() {
+			// the bound address includes the port chosen for :0
+			log.Info("listening for API connections", zap.String("address", scheme+"://"+l.Addr().String()))
+			if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Panic("API server failed", zap.Error(err))
+			}
+		}()
 	}
-	go func() {
-		log.Info("listening for API connections on :8080")
-		if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Panic("API server failed", zap.Error(err))
+	if socketPath != "" {
+		ul, err := listenUnix(socketPath, os.FileMode(mode))
+		if err != nil {
+			log.Panic("failed to listen on API socket", zap.String("path", socketPath), zap.Error(err))
 		}
-	}()
+		defer ul.Close()
+		go func() {
+			log.Info("listening for API connections", zap.String("address", "unix://"+socketPath))
+			if err := s.Serve(ul); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Panic("API server failed", zap.Error(err))
+			}
+		}()
+	}
 
 	<-ctx.Done()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		log.Warn("failed to shut down API server", zap.Error(err))
+	}
 }
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || windows)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho is not supported on this platform, so input is always read as
+// if from a pipe.
+func disableEcho(*os.File) (func(), error) {
+	return nil, errors.New("terminal echo cannot be disabled on this platform")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// disableEcho stops the console f from echoing input, returning a function
+// that restores its previous mode. It fails if f is not a console.
+func disableEcho(f *os.File) (restore func(), err error) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return nil, err
+	}
+	noEcho := mode&^windows.ENABLE_ECHO_INPUT | windows.ENABLE_PROCESSED_INPUT | windows.ENABLE_LINE_INPUT
+	if err := windows.SetConsoleMode(h, noEcho); err != nil {
+		return nil, err
+	}
+	return func() { windows.SetConsoleMode(h, mode) }, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.sia.tech/node/internal/wallet"
+)
+
+const walletUsage = `Usage:
+  noded wallet generate [flags]   print a new recovery phrase, optionally creating a wallet from it
+  noded wallet recover [flags]    create a wallet from a recovery phrase read from stdin
+
+The node does not need to be running; it loads new wallets when it next
+starts. The wallet password is read from ` + walletPasswordEnv + `, or
+prompted for.
+`
+
+// readLine prints prompt to stderr and reads a line from r.
+func readLine(r *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := r.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// readSecret is like readLine, but if stdin is a terminal, the line is not
+// echoed as it is typed. Lines piped to stdin are read as usual.
+func readSecret(r *bufio.Reader, prompt string) (string, error) {
+	restore, err := disableEcho(os.Stdin)
+	if err != nil {
+		return readLine(r, prompt)
+	}
+	line, err := readLine(r, prompt)
+	restore()
+	fmt.Fprintln(os.Stderr) // the newline ending the input was not echoed
+	return line, err
+}
+
+// readWalletPassword returns the wallet password from the environment, or
+// prompts for it without echoing it.
+func readWalletPassword(r *bufio.Reader) (string, error) {
+	if env := os.Getenv(walletPasswordEnv); env != "" {
+		return env, nil
+	}
+	password, err := readSecret(r, "Wallet password: ")
+	if err != nil {
+		return "", err
+	} else if password == "" {
+		return "", errors.New("wallet password must not be empty")
+	}
+	return password, nil
+}
+
+// runWallet runs the wallet subcommand given by args.
+func runWallet(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, walletUsage)
+		return errors.New("missing wallet subcommand")
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("wallet "+cmd, flag.ContinueOnError)
+	dir := fs.String("dir", ".", "the node's data directory")
+	name := fs.String("name", "default", "the name of the wallet")
+	var write bool
+	switch cmd {
+	case "generate":
+		fs.BoolVar(&write, "write", false, "also create a wallet from the phrase in the data directory")
+	case "recover":
+	default:
+		fmt.Fprint(os.Stderr, walletUsage)
+		return fmt.Errorf("unknown wallet subcommand %q", cmd)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		// phrases passed as arguments would end up in the shell history
+		return errors.New("unexpected arguments; the recovery phrase is read from stdin")
+	}
+	walletDir := filepath.Join(*dir, "wallet")
+	stdin := bufio.NewReader(os.Stdin)
+
+	switch cmd {
+	case "generate":
+		if !write {
+			fmt.Println(cwallet.NewSeedPhrase())
+			return nil
+		}
+		password, err := readWalletPassword(stdin)
+		if err != nil {
+			return err
+		}
+		phrase, err := wallet.Init(walletDir, *name, password, "")
+		if err != nil {
+			return err
+		}
+		fmt.Println(phrase)
+		fmt.Fprintf(os.Stderr, "Created wallet %q. Write down the recovery phrase; it is the only way to recover the wallet.\n", *name)
+	case "recover":
+		phrase, err := readSecret(stdin, "Recovery phrase: ")
+		if err != nil {
+			return err
+		} else if err := wallet.CheckPhrase(phrase); err != nil {
+			return err
+		}
+		password, err := readWalletPassword(stdin)
+		if err != nil {
+			return err
+		} else if _, err := wallet.Init(walletDir, *name, password, phrase); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Recovered wallet %q. It is scanned from the genesis block when the node next starts.\n", *name)
+	}
+	return nil
+}
+
+// newSigner returns the external signer described by the -wallet.signer
+// flag: an HTTP signer if s is a URL, and otherwise a command signer running
+// the command and arguments in s.
+func newSigner(s string, timeout time.Duration) (wallet.Signer, error) {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return wallet.NewHTTPSigner(s, timeout)
+	}
+	fields := strings.Fields(s)
+	return wallet.NewCommandSigner(fields[0], fields[1:], timeout), nil
+}
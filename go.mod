@@ -3,10 +3,15 @@ module go.sia.tech/node
 go 1.26.0
 
 require (
+	github.com/coder/websocket v1.8.14
+	go.etcd.io/bbolt v1.5.0
 	go.sia.tech/core v0.21.5
 	go.sia.tech/coreutils v0.23.4
 	go.sia.tech/jape v0.14.1
 	go.uber.org/zap v1.28.0
+	golang.org/x/crypto v0.53.0
+	golang.org/x/sys v0.46.0
+	lukechampine.com/frand v1.5.1
 )
 
 require (
@@ -15,13 +20,9 @@ require (
 	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/quic-go/quic-go v0.60.0 // indirect
 	github.com/quic-go/webtransport-go v0.11.1 // indirect
-	go.etcd.io/bbolt v1.5.0 // indirect
 	go.sia.tech/mux v1.5.2 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/crypto v0.53.0 // indirect
 	golang.org/x/net v0.56.0 // indirect
-	golang.org/x/sys v0.46.0 // indirect
 	golang.org/x/text v0.38.0 // indirect
 	golang.org/x/tools v0.47.0 // indirect
-	lukechampine.com/frand v1.5.1 // indirect
 )
@@ -0,0 +1,192 @@
+// Package certs loads TLS certificates for the API, reloading them when they
+// change on disk.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// reloadInterval is the interval between checks for a changed
+	// certificate.
+	reloadInterval = 30 * time.Second
+
+	// selfSignedValidity is the lifetime of a generated certificate.
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenewBefore is how long before expiry a generated
+	// certificate is replaced.
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// A Reloader serves a certificate and key loaded from disk, reloading them
+// whenever either file is modified, e.g. by certbot. Connections keep the
+// certificate they were established with.
+type Reloader struct {
+	certPath, keyPath string
+	log               *zap.Logger
+
+	close chan struct{}
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest of the two files
+}
+
+func modTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		} else if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the certificate if either file has changed since it was last
+// loaded, returning the new certificate, or nil if it has not changed.
+func (r *Reloader) reload() (*tls.Certificate, error) {
+	mt, err := modTime(r.certPath, r.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	unchanged := mt.Equal(r.modTime)
+	r.mu.Unlock()
+	if unchanged {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, mt
+	r.mu.Unlock()
+	return &cert, nil
+}
+
+func (r *Reloader) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.close:
+			return
+		case <-ticker.C:
+		}
+		if cert, err := r.reload(); err != nil {
+			// the files may be mid-renewal; keep serving the old
+			// certificate and try again later
+			r.log.Warn("failed to reload TLS certificate", zap.Error(err))
+		} else if cert != nil {
+			r.log.Info("reloaded TLS certificate", zap.Time("expires", cert.Leaf.NotAfter))
+		}
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// Close stops watching the files for changes.
+func (r *Reloader) Close() error {
+	close(r.close)
+	r.wg.Wait()
+	return nil
+}
+
+// NewReloader loads the certificate and key at the given paths, and reloads
+// them whenever they change.
+func NewReloader(certPath, keyPath string, log *zap.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		log:      log,
+		close:    make(chan struct{}),
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// EnsureSelfSigned writes a self-signed certificate for hosts, and its key,
+// to the given paths. An existing certificate is kept unless it is due to
+// expire. It reports whether a new certificate was generated.
+func EnsureSelfSigned(certPath, keyPath string, hosts []string) (bool, error) {
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		if time.Until(cert.Leaf.NotAfter) > selfSignedRenewBefore {
+			return false, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("failed to load existing certificate: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "noded"},
+		NotBefore:             now.Add(-time.Hour), // allow for clock skew
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return false, fmt.Errorf("failed to write key: %w", err)
+	} else if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return false, fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,346 @@
+// Package index maintains an index of the unspent siacoin and siafund
+// elements of every address on the chain.
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxSyncBlocks is the number of blocks applied to the index in each
+	// database transaction.
+	maxSyncBlocks = 1000
+	// progressInterval is the interval at which the progress of the index is
+	// logged while it catches up with the chain.
+	progressInterval = 10 * time.Second
+)
+
+var (
+	bucketMeta     = []byte("meta")
+	bucketSiacoins = []byte("siacoins")
+	bucketSiafunds = []byte("siafunds")
+
+	keyGenesis = []byte("genesis")
+	keyTip     = []byte("tip")
+)
+
+// A ChainManager provides the blocks applied to the index.
+type ChainManager interface {
+	Tip() types.ChainIndex
+	BestIndex(height uint64) (types.ChainIndex, bool)
+	OnReorg(fn func(types.ChainIndex)) (cancel func())
+	UpdatesSince(index types.ChainIndex, maxBlocks int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error)
+}
+
+// A Balance is the value of an address's unspent elements as of Index, the
+// tip of the index. Immature siacoins, such as miner payouts, are included in
+// Siacoins. The index is Synced once it has applied every block up to the
+// chain's tip.
+type Balance struct {
+	Index            types.ChainIndex `json:"index"`
+	Synced           bool             `json:"synced"`
+	Siacoins         types.Currency   `json:"siacoins"`
+	ImmatureSiacoins types.Currency   `json:"immatureSiacoins"`
+	Siafunds         uint64           `json:"siafunds"`
+	Outputs          int              `json:"outputs"`
+}
+
+// An Index maps every address on the chain to its unspent siacoin and siafund
+// elements, in a database separate from the chain's. It applies and reverts
+// blocks as the chain manager's tip changes, catching up from the genesis
+// block when it is first created, or from wherever it left off if it was
+// closed.
+type Index struct {
+	chain ChainManager
+	db    *bbolt.DB
+	log   *zap.Logger
+
+	tipCh  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	tip types.ChainIndex
+}
+
+// elementKey returns the key of the element with the given ID, sent to addr,
+// so that an address's elements are adjacent.
+func elementKey(addr types.Address, id types.Hash256) []byte {
+	return append(addr[:], id[:]...)
+}
+
+// encodeSiacoin returns the value stored for a siacoin element: its value and
+// maturity height.
+func encodeSiacoin(sce types.SiacoinElement) []byte {
+	v := make([]byte, 24)
+	binary.LittleEndian.PutUint64(v[0:], sce.SiacoinOutput.Value.Lo)
+	binary.LittleEndian.PutUint64(v[8:], sce.SiacoinOutput.Value.Hi)
+	binary.LittleEndian.PutUint64(v[16:], sce.MaturityHeight)
+	return v
+}
+
+// encodeSiafund returns the value stored for a siafund element.
+func encodeSiafund(sfe types.SiafundElement) []byte {
+	return binary.LittleEndian.AppendUint64(nil, sfe.SiafundOutput.Value)
+}
+
+func encodeIndex(index types.ChainIndex) []byte {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	index.EncodeTo(e)
+	e.Flush()
+	return buf.Bytes()
+}
+
+func decodeIndex(b []byte) (index types.ChainIndex, err error) {
+	d := types.NewBufDecoder(b)
+	index.DecodeFrom(d)
+	return index, d.Err()
+}
+
+// addSiacoin adds or removes sce from the index.
+func addSiacoin(tx *bbolt.Tx, sce types.SiacoinElement, add bool) error {
+	b, k := tx.Bucket(bucketSiacoins), elementKey(sce.SiacoinOutput.Address, types.Hash256(sce.ID))
+	if add {
+		return b.Put(k, encodeSiacoin(sce))
+	}
+	return b.Delete(k)
+}
+
+// addSiafund adds or removes sfe from the index.
+func addSiafund(tx *bbolt.Tx, sfe types.SiafundElement, add bool) error {
+	b, k := tx.Bucket(bucketSiafunds), elementKey(sfe.SiafundOutput.Address, types.Hash256(sfe.ID))
+	if add {
+		return b.Put(k, encodeSiafund(sfe))
+	}
+	return b.Delete(k)
+}
+
+// applyUpdate adds the elements created by a block and removes those it
+// spent.
+func applyUpdate(tx *bbolt.Tx, cau chain.ApplyUpdate) error {
+	for _, sced := range cau.SiacoinElementDiffs() {
+		if sced.Created && sced.Spent {
+			continue // ephemeral
+		} else if err := addSiacoin(tx, sced.SiacoinElement, sced.Created); err != nil {
+			return err
+		}
+	}
+	for _, sfed := range cau.SiafundElementDiffs() {
+		if sfed.Created && sfed.Spent {
+			continue
+		} else if err := addSiafund(tx, sfed.SiafundElement, sfed.Created); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertUpdate removes the elements created by a block and restores those it
+// spent.
+func revertUpdate(tx *bbolt.Tx, cru chain.RevertUpdate) error {
+	for _, sced := range cru.SiacoinElementDiffs() {
+		if sced.Created && sced.Spent {
+			continue
+		} else if err := addSiacoin(tx, sced.SiacoinElement, sced.Spent); err != nil {
+			return err
+		}
+	}
+	for _, sfed := range cru.SiafundElementDiffs() {
+		if sfed.Created && sfed.Spent {
+			continue
+		} else if err := addSiafund(tx, sfed.SiafundElement, sfed.Spent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync applies blocks to the index until it reaches the chain's tip.
+func (ix *Index) sync() error {
+	var lastLog time.Time
+	for ix.ctx.Err() == nil {
+		ix.mu.Lock()
+		tip := ix.tip
+		ix.mu.Unlock()
+		reverted, applied, err := ix.chain.UpdatesSince(tip, maxSyncBlocks)
+		if err != nil {
+			return fmt.Errorf("failed to get updates since %v: %w", tip, err)
+		} else if len(reverted) == 0 && len(applied) == 0 {
+			if !lastLog.IsZero() {
+				ix.log.Info("address index caught up", zap.Stringer("tip", tip))
+			}
+			return nil
+		}
+
+		next := tip
+		err = ix.db.Update(func(tx *bbolt.Tx) error {
+			for _, cru := range reverted {
+				if err := revertUpdate(tx, cru); err != nil {
+					return err
+				}
+				next = cru.State.Index
+			}
+			for _, cau := range applied {
+				if err := applyUpdate(tx, cau); err != nil {
+					return err
+				}
+				next = cau.State.Index
+			}
+			return tx.Bucket(bucketMeta).Put(keyTip, encodeIndex(next))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply updates: %w", err)
+		}
+		ix.mu.Lock()
+		ix.tip = next
+		ix.mu.Unlock()
+		if len(reverted) > 0 {
+			ix.log.Debug("reverted blocks", zap.Int("blocks", len(reverted)), zap.Stringer("tip", next))
+		}
+		if chainTip := ix.chain.Tip(); time.Since(lastLog) >= progressInterval && chainTip.Height > next.Height {
+			ix.log.Info("indexing blocks", zap.Uint64("height", next.Height), zap.Uint64("remaining", chainTip.Height-next.Height))
+			lastLog = time.Now()
+		}
+	}
+	return nil
+}
+
+func (ix *Index) run() {
+	defer ix.wg.Done()
+	for {
+		select {
+		case <-ix.ctx.Done():
+			return
+		case <-ix.tipCh:
+			if err := ix.sync(); err != nil {
+				ix.log.Error("failed to sync address index", zap.Error(err))
+			}
+		}
+	}
+}
+
+// trigger wakes the run loop to apply any new chain updates.
+func (ix *Index) trigger() {
+	select {
+	case ix.tipCh <- struct{}{}:
+	default:
+	}
+}
+
+// Tip returns the index of the last block applied to the index.
+func (ix *Index) Tip() types.ChainIndex {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.tip
+}
+
+// Balance returns the balance of addr. Its index, and whether the index is
+// synced, are read from the same database transaction as its elements.
+func (ix *Index) Balance(addr types.Address) (b Balance, err error) {
+	err = ix.db.View(func(tx *bbolt.Tx) error {
+		index, err := decodeIndex(tx.Bucket(bucketMeta).Get(keyTip))
+		if err != nil {
+			return fmt.Errorf("failed to decode tip: %w", err)
+		}
+		b.Index = index
+		b.Synced = index == ix.chain.Tip()
+		next := b.Index.Height + 1
+		c := tx.Bucket(bucketSiacoins).Cursor()
+		for k, v := c.Seek(addr[:]); k != nil && bytes.HasPrefix(k, addr[:]); k, v = c.Next() {
+			value := types.NewCurrency(binary.LittleEndian.Uint64(v[0:]), binary.LittleEndian.Uint64(v[8:]))
+			b.Siacoins = b.Siacoins.Add(value)
+			if binary.LittleEndian.Uint64(v[16:]) > next {
+				b.ImmatureSiacoins = b.ImmatureSiacoins.Add(value)
+			}
+			b.Outputs++
+		}
+		c = tx.Bucket(bucketSiafunds).Cursor()
+		for k, v := c.Seek(addr[:]); k != nil && bytes.HasPrefix(k, addr[:]); k, v = c.Next() {
+			b.Siafunds += binary.LittleEndian.Uint64(v)
+			b.Outputs++
+		}
+		return nil
+	})
+	return
+}
+
+// Close stops the index from applying blocks and closes its database.
+func (ix *Index) Close() error {
+	ix.cancel()
+	ix.wg.Wait()
+	return ix.db.Close()
+}
+
+// NewIndex opens the index at path, creating it if it does not exist, and
+// starts applying cm's blocks to it in the background. An index created for
+// a different chain is refused.
+func NewIndex(cm ChainManager, path string, log *zap.Logger) (*Index, error) {
+	genesis, ok := cm.BestIndex(0)
+	if !ok {
+		return nil, errors.New("chain has no genesis block")
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %v: %w", path, err)
+	}
+	var tip types.ChainIndex
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketSiacoins, bucketSiafunds} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(bucketMeta)
+		if id := meta.Get(keyGenesis); id == nil {
+			if err := meta.Put(keyGenesis, genesis.ID[:]); err != nil {
+				return err
+			}
+			return meta.Put(keyTip, encodeIndex(types.ChainIndex{}))
+		} else if !bytes.Equal(id, genesis.ID[:]) {
+			return fmt.Errorf("index was built for a different chain, with genesis block %x", id)
+		}
+		tip, err = decodeIndex(meta.Get(keyTip))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ix := &Index{
+		chain:  cm,
+		db:     db,
+		log:    log,
+		tipCh:  make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+		tip:    tip,
+	}
+	if chainTip := cm.Tip(); tip == (types.ChainIndex{}) {
+		log.Info("building address index from the genesis block", zap.Uint64("blocks", chainTip.Height+1))
+	} else if chainTip.Height > tip.Height {
+		log.Info("catching up address index", zap.Stringer("tip", tip), zap.Uint64("remaining", chainTip.Height-tip.Height))
+	}
+	// the callback must not block the chain manager, so updates are applied
+	// by the run loop
+	stop := cm.OnReorg(func(types.ChainIndex) { ix.trigger() })
+	context.AfterFunc(ctx, stop)
+	ix.trigger()
+	ix.wg.Add(1)
+	go ix.run()
+	return ix, nil
+}
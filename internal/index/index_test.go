@@ -0,0 +1,225 @@
+package index
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	"go.uber.org/zap"
+)
+
+// anyoneCanSpend is the policy of outputs spent by test transactions.
+var anyoneCanSpend = types.AnyoneCanSpend()
+
+// newTestChain returns a chain manager for a test network. Managers with the
+// same genesis block can exchange blocks.
+func newTestChain(t *testing.T) (types.Block, *chain.Manager) {
+	t.Helper()
+	n, genesis := testutil.V2Network()
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return genesis, chain.NewManager(store, tipState)
+}
+
+// newTestIndex returns a new index of cm, once it has reached cm's tip.
+func newTestIndex(t *testing.T, cm *chain.Manager) *Index {
+	t.Helper()
+	ix, err := NewIndex(cm, filepath.Join(t.TempDir(), "index.db"), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+	waitSynced(t, cm, ix)
+	return ix
+}
+
+// waitSynced waits for ix to apply every block up to cm's tip.
+func waitSynced(t *testing.T, cm *chain.Manager, ix *Index) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if b, err := ix.Balance(types.VoidAddress); err != nil {
+			t.Fatal(err)
+		} else if b.Synced && b.Index == cm.Tip() {
+			return
+		}
+	}
+	t.Fatal("index did not sync")
+}
+
+// copyBlocks adds the blocks of src's best chain after from to dst.
+func copyBlocks(t *testing.T, dst, src *chain.Manager, from uint64) {
+	t.Helper()
+	var blocks []types.Block
+	for height := from + 1; height <= src.Tip().Height; height++ {
+		index, _ := src.BestIndex(height)
+		b, _ := src.Block(index.ID)
+		blocks = append(blocks, b)
+	}
+	if err := dst.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spendableElement mines a block paying an anyone-can-spend address and
+// enough blocks for it to mature, returning its element with an up-to-date
+// proof.
+func spendableElement(t *testing.T, cm *chain.Manager) types.SiacoinElement {
+	t.Helper()
+	start := cm.Tip()
+	testutil.MineBlocks(t, cm, anyoneCanSpend.Address(), 1)
+	testutil.MineBlocks(t, cm, types.VoidAddress, int(cm.TipState().Network.MaturityDelay))
+	_, caus, err := cm.UpdatesSince(start, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sce types.SiacoinElement
+	var found bool
+	for _, cau := range caus {
+		if found {
+			cau.UpdateElementProof(&sce.StateElement)
+			continue
+		}
+		for _, sced := range cau.SiacoinElementDiffs() {
+			if sced.Created && sced.SiacoinElement.SiacoinOutput.Address == anyoneCanSpend.Address() {
+				sce, found = sced.SiacoinElement.Copy(), true
+				break
+			}
+		}
+	}
+	if !found {
+		t.Fatal("no spendable element")
+	}
+	return sce
+}
+
+// sendElement mines a block on cm, paying addr, with a transaction sending
+// amount of sce to addr and the rest back to an anyone-can-spend address.
+func sendElement(t *testing.T, cm *chain.Manager, sce types.SiacoinElement, addr types.Address, amount types.Currency) {
+	t.Helper()
+	txn := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          sce,
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: anyoneCanSpend},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: addr, Value: amount},
+			{Address: anyoneCanSpend.Address(), Value: sce.SiacoinOutput.Value.Sub(amount)},
+		},
+	}
+	if _, err := cm.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, addr, 1)
+}
+
+// chainBalance returns the balance of addr computed from the elements
+// created and spent by every block on cm's best chain.
+func chainBalance(t *testing.T, cm *chain.Manager, addr types.Address) Balance {
+	t.Helper()
+	sces := make(map[types.SiacoinOutputID]types.SiacoinElement)
+	sfes := make(map[types.SiafundOutputID]types.SiafundElement)
+	var index types.ChainIndex
+	for index != cm.Tip() {
+		_, caus, err := cm.UpdatesSince(index, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cau := range caus {
+			for _, sced := range cau.SiacoinElementDiffs() {
+				if sced.Spent {
+					delete(sces, sced.SiacoinElement.ID)
+				} else if sced.Created {
+					sces[sced.SiacoinElement.ID] = sced.SiacoinElement
+				}
+			}
+			for _, sfed := range cau.SiafundElementDiffs() {
+				if sfed.Spent {
+					delete(sfes, sfed.SiafundElement.ID)
+				} else if sfed.Created {
+					sfes[sfed.SiafundElement.ID] = sfed.SiafundElement
+				}
+			}
+			index = cau.State.Index
+		}
+	}
+
+	b := Balance{Index: index, Synced: true}
+	for _, sce := range sces {
+		if sce.SiacoinOutput.Address != addr {
+			continue
+		}
+		b.Siacoins = b.Siacoins.Add(sce.SiacoinOutput.Value)
+		if sce.MaturityHeight > index.Height+1 {
+			b.ImmatureSiacoins = b.ImmatureSiacoins.Add(sce.SiacoinOutput.Value)
+		}
+		b.Outputs++
+	}
+	for _, sfe := range sfes {
+		if sfe.SiafundOutput.Address == addr {
+			b.Siafunds += sfe.SiafundOutput.Value
+			b.Outputs++
+		}
+	}
+	return b
+}
+
+func TestIndexReorg(t *testing.T) {
+	genesis, cm := newTestChain(t)
+	sce := spendableElement(t, cm)
+	// a second chain sharing every block so far, so that both can spend sce
+	_, fork := newTestChain(t)
+	copyBlocks(t, fork, cm, 0)
+
+	ix := newTestIndex(t, cm)
+	addrA, addrB := types.Address{1}, types.Address{2}
+	sendElement(t, cm, sce, addrA, types.Siacoins(1))
+	waitSynced(t, cm, ix)
+	if b, err := ix.Balance(addrA); err != nil {
+		t.Fatal(err)
+	} else if b != chainBalance(t, cm, addrA) {
+		t.Fatalf("expected %+v, got %+v", chainBalance(t, cm, addrA), b)
+	} else if b.Outputs != 2 || b.ImmatureSiacoins.IsZero() {
+		t.Fatalf("expected the sent output and an immature payout, got %+v", b)
+	}
+
+	// a longer fork spending sce differently replaces the block
+	base := cm.Tip().Height - 1
+	sendElement(t, fork, sce, addrB, types.Siacoins(2))
+	testutil.MineBlocks(t, fork, types.VoidAddress, 1)
+	copyBlocks(t, cm, fork, base)
+	if cm.Tip() != fork.Tip() {
+		t.Fatalf("expected a reorg to %v, got %v", fork.Tip(), cm.Tip())
+	}
+	waitSynced(t, cm, ix)
+
+	// the reorged index matches one built from scratch, and the chain
+	fresh := newTestIndex(t, cm)
+	addrs := []types.Address{addrA, addrB, anyoneCanSpend.Address(), types.VoidAddress}
+	for _, sfo := range genesis.Transactions[0].SiafundOutputs {
+		addrs = append(addrs, sfo.Address)
+	}
+	for _, addr := range addrs {
+		want := chainBalance(t, cm, addr)
+		if got, err := ix.Balance(addr); err != nil {
+			t.Fatal(err)
+		} else if got != want {
+			t.Fatalf("expected %v to have %+v after the reorg, got %+v", addr, want, got)
+		} else if got, err := fresh.Balance(addr); err != nil {
+			t.Fatal(err)
+		} else if got != want {
+			t.Fatalf("expected %v to have %+v in a fresh index, got %+v", addr, want, got)
+		}
+	}
+	if b, _ := ix.Balance(addrA); b.Outputs != 0 {
+		t.Fatalf("expected the reverted outputs to be removed, got %+v", b)
+	} else if b, _ := ix.Balance(addrB); b.Outputs != 2 {
+		t.Fatalf("expected the fork's outputs, got %+v", b)
+	} else if b, _ := ix.Balance(genesis.Transactions[0].SiafundOutputs[0].Address); b.Siafunds == 0 {
+		t.Fatalf("expected the genesis siafunds, got %+v", b)
+	}
+}
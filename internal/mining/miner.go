@@ -0,0 +1,503 @@
+package mining
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// templateRefresh is the interval at which the miner rebuilds its
+	// template, so that it includes new pool transactions and a current
+	// timestamp. It rebuilds immediately when the tip changes.
+	templateRefresh = 10 * time.Second
+	// hashBatch is the number of nonces each thread tries between checks for
+	// a new template.
+	hashBatch = 1 << 12
+	// hashrateWindow is the number of one-second samples the hashrate is
+	// averaged over.
+	hashrateWindow = 30
+)
+
+var (
+	// ErrMainnet is returned when starting the miner on mainnet, where CPU
+	// mining can never find a block.
+	ErrMainnet = errors.New("CPU mining is not allowed on mainnet")
+	// ErrNoPayoutAddress is returned when starting the miner, or building a
+	// template, without an address to pay and with no payout address set.
+	ErrNoPayoutAddress = errors.New("no payout address")
+	// ErrMining is returned when clearing the payout address while the
+	// miner is running.
+	ErrMining = errors.New("miner is running")
+)
+
+// A MinerChainManager is a ChainManager that also accepts mined blocks.
+type MinerChainManager interface {
+	ChainManager
+	AddBlocks(blocks []types.Block) error
+}
+
+// Status is the state of the built-in miner, along with the statistics of
+// external miners. Hashrate is in hashes per second, averaged over the last
+// hashrateWindow seconds. BlocksFound counts the blocks mined since the node
+// started, of which the most recent are listed in Blocks. Stale counts blocks
+// abandoned because the tip changed before they could be added, and Rejected
+// those the chain refused. TemplateID and TemplateFees describe the current
+// template's transactions.
+type Status struct {
+	Running      bool                   `json:"running"`
+	Threads      int                    `json:"threads"`
+	Address      types.Address          `json:"address"`
+	Hashrate     float64                `json:"hashrate"`
+	BlocksFound  uint64                 `json:"blocksFound"`
+	Blocks       []FoundBlock           `json:"blocks"`
+	Stale        uint64                 `json:"stale"`
+	Rejected     uint64                 `json:"rejected"`
+	TemplateID   types.Hash256          `json:"templateID"`
+	TemplateFees types.Currency         `json:"templateFees"`
+	Clients      map[string]ClientStats `json:"clients"`
+}
+
+// persistMiner is the on-disk format of a Miner's settings.
+type persistMiner struct {
+	PayoutAddress types.Address `json:"payoutAddress"`
+}
+
+// A Miner mines blocks on the CPU, from templates built by a Templater, and
+// adds them to the chain. It is intended for test networks, where the
+// difficulty is low enough for a CPU to find blocks. Its payout address is
+// the default address paid by templates and mined blocks, and is persisted
+// across restarts.
+type Miner struct {
+	templates *Templater
+	path      string
+	chain     MinerChainManager
+	broadcast func(types.Block)
+	log       *zap.Logger
+	cancelTip func()
+
+	hashes atomic.Uint64 // tried since the miner was created
+
+	runMu  sync.Mutex // serializes Start and Stop
+	mineMu sync.Mutex // serializes MineBlocks
+
+	mu      sync.Mutex
+	payout  types.Address
+	tag     string
+	threads int
+	addr    types.Address
+	stop    context.CancelFunc // nil if not running
+	done    chan struct{}
+	abort   context.CancelFunc // aborts the current template
+	samples []uint64           // hashes at each of the last hashrateWindow seconds
+
+	found    uint64
+	blocks   []FoundBlock
+	stale    uint64
+	rejected uint64
+	clients  map[string]*ClientStats
+}
+
+// newTemplate aborts the current template, if any, so that the miner
+// rebuilds it.
+func (m *Miner) newTemplate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.abort != nil {
+		m.abort()
+	}
+}
+
+// grind tries nonces for b, starting at offset multiples of factor and
+// stepping by threads of them, until one meets target or ctx is done.
+func (m *Miner) grind(ctx context.Context, b types.Block, target types.BlockID, factor uint64, offset, threads int) (types.Block, bool) {
+	bh := b.Header()
+	bh.Nonce = uint64(offset) * factor
+	step := uint64(threads) * factor
+	for ctx.Err() == nil {
+		for range hashBatch {
+			if bh.ID().CmpWork(target) >= 0 {
+				b.Nonce = bh.Nonce
+				m.hashes.Add(hashBatch)
+				return b, true
+			}
+			bh.Nonce += step
+		}
+		m.hashes.Add(hashBatch)
+	}
+	return types.Block{}, false
+}
+
+// mineTemplate mines a template paying addr with threads goroutines until a
+// block is found, the template changes, templateRefresh passes, or ctx is
+// done.
+func (m *Miner) mineTemplate(ctx context.Context, addr types.Address, threads int) (types.Block, bool) {
+	ctx, cancel := context.WithTimeout(ctx, templateRefresh)
+	defer cancel()
+	m.mu.Lock()
+	m.abort = cancel
+	m.mu.Unlock()
+	tmpl := m.templates.Template(addr, m.Tag())
+	// the tip may have changed before the abort was set
+	if m.chain.Tip().ID != tmpl.ParentID {
+		return types.Block{}, false
+	}
+	return m.solve(ctx, tmpl, m.templates.Changed(tmpl.ID), threads)
+}
+
+// solve mines tmpl with threads goroutines until a block is found, changed is
+// closed, or ctx is done.
+func (m *Miner) solve(ctx context.Context, tmpl Template, changed <-chan struct{}, threads int) (types.Block, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan types.Block, threads)
+	var wg sync.WaitGroup
+	for i := range threads {
+		wg.Go(func() {
+			if b, ok := m.grind(ctx, tmpl.Block, tmpl.Target, tmpl.NonceFactor, i, threads); ok {
+				found <- b
+			}
+		})
+	}
+	var b types.Block
+	var ok bool
+	select {
+	case b = <-found:
+		ok = true
+	case <-changed:
+	case <-ctx.Done():
+	}
+	cancel()
+	wg.Wait()
+	return b, ok
+}
+
+// submit adds a mined block to the chain and broadcasts it.
+func (m *Miner) submit(b types.Block) {
+	tip := m.chain.Tip()
+	if tip.ID != b.ParentID {
+		m.mu.Lock()
+		m.stale++
+		m.mu.Unlock()
+		m.log.Debug("abandoned stale block", zap.Stringer("id", b.ID()), zap.Stringer("tip", tip))
+		return
+	} else if err := m.chain.AddBlocks([]types.Block{b}); err != nil {
+		m.mu.Lock()
+		m.rejected++
+		m.mu.Unlock()
+		m.log.Warn("mined block rejected", zap.Stringer("id", b.ID()), zap.Error(err))
+		return
+	}
+	m.mu.Lock()
+	m.recordFound(b, tip.Height+1)
+	m.mu.Unlock()
+	m.log.Info("mined block", zap.Stringer("id", b.ID()), zap.Stringer("payout", b.MinerPayouts[0].Value))
+	if m.broadcast != nil {
+		m.broadcast(b)
+	}
+}
+
+// submitBlock adds a block mined by the external miner at remote to the chain
+// and broadcasts it, recording the result of the submission. Unlike blocks
+// submitted through the API, stale blocks are not added.
+func (m *Miner) submitBlock(remote string, b types.Block) (string, error) {
+	result := SubmissionAccepted
+	var err error
+	if tip := m.chain.Tip(); tip.ID != b.ParentID {
+		result = SubmissionStale
+	} else if err = m.chain.AddBlocks([]types.Block{b}); err != nil {
+		result = SubmissionRejected
+		m.log.Debug("external block rejected", zap.String("remote", remote), zap.Stringer("id", b.ID()), zap.Error(err))
+	} else {
+		m.log.Info("external miner found block", zap.String("remote", remote), zap.Stringer("id", b.ID()), zap.Uint64("height", tip.Height+1))
+		if m.broadcast != nil {
+			m.broadcast(b)
+		}
+	}
+	m.RecordSubmission(remote, result)
+	return result, err
+}
+
+// run mines until ctx is done.
+func (m *Miner) run(ctx context.Context, addr types.Address, threads int) {
+	for ctx.Err() == nil {
+		if b, ok := m.mineTemplate(ctx, addr, threads); ok {
+			m.submit(b)
+		}
+	}
+}
+
+// MineBlocks mines n blocks that pay addr, or the payout address if addr is
+// the void address, and adds them to the chain, returning their IDs. Unlike
+// the templates mined by Start, the first block includes every pool
+// transaction that fits. It mines on every CPU while ctx is not done, and,
+// like Start, is not allowed on mainnet. If it fails, it returns the IDs of
+// the blocks already added.
+func (m *Miner) MineBlocks(ctx context.Context, addr types.Address, n int) ([]types.BlockID, error) {
+	if m.chain.TipState().Network.Name == "mainnet" {
+		return nil, ErrMainnet
+	}
+	addr, err := m.payoutAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	m.mineMu.Lock()
+	defer m.mineMu.Unlock()
+	ids := make([]types.BlockID, 0, n)
+	for len(ids) < n {
+		sel, _ := m.templates.selectPool()
+		b, ok := m.solve(ctx, buildTemplate(sel, addr, m.Tag()), nil, runtime.NumCPU())
+		if !ok {
+			return ids, ctx.Err()
+		} else if m.chain.Tip().ID != b.ParentID {
+			continue // another miner extended the tip first
+		} else if err := m.chain.AddBlocks([]types.Block{b}); err != nil {
+			return ids, fmt.Errorf("mined block was rejected: %w", err)
+		}
+		m.mu.Lock()
+		m.recordFound(b, sel.cs.Index.Height+1)
+		m.mu.Unlock()
+		if m.broadcast != nil {
+			m.broadcast(b)
+		}
+		ids = append(ids, b.ID())
+	}
+	m.log.Debug("mined blocks on request", zap.Int("blocks", n), zap.Stringer("address", addr))
+	return ids, nil
+}
+
+// sample records the number of hashes tried each second, from which the
+// hashrate is computed.
+func (m *Miner) sample(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		m.mu.Lock()
+		m.samples = append(m.samples, m.hashes.Load())
+		if len(m.samples) > hashrateWindow+1 {
+			m.samples = m.samples[1:]
+		}
+		m.mu.Unlock()
+	}
+}
+
+// payoutAddress returns addr, or the payout address if addr is the void
+// address.
+func (m *Miner) payoutAddress(addr types.Address) (types.Address, error) {
+	if addr != types.VoidAddress {
+		return addr, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.payout == types.VoidAddress {
+		return types.VoidAddress, ErrNoPayoutAddress
+	}
+	return m.payout, nil
+}
+
+// Template returns a template that extends the current tip and pays addr, or
+// the payout address if addr is the void address. It carries tag, or the
+// miner's tag if tag is empty.
+func (m *Miner) Template(addr types.Address, tag string) (Template, error) {
+	addr, err := m.payoutAddress(addr)
+	if err != nil {
+		return Template{}, err
+	} else if tag == "" {
+		tag = m.Tag()
+	} else if err := ValidateTag(tag); err != nil {
+		return Template{}, err
+	}
+	return m.templates.Template(addr, tag), nil
+}
+
+// Tag returns the tag of the blocks mined by the miner, and of templates
+// requested without one.
+func (m *Miner) Tag() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tag
+}
+
+// SetTag sets the tag of the blocks mined by the miner, and of templates
+// requested without one, or clears it if tag is empty.
+func (m *Miner) SetTag(tag string) error {
+	if err := ValidateTag(tag); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tag = tag
+	return nil
+}
+
+// TemplateChanged returns a channel that is closed once the ID of the
+// current template is no longer id.
+func (m *Miner) TemplateChanged(id types.Hash256) <-chan struct{} {
+	return m.templates.Changed(id)
+}
+
+// SetFeeThreshold sets the additional fees that new pool transactions must
+// pay for the template to change while the tip does not.
+func (m *Miner) SetFeeThreshold(threshold types.Currency) {
+	m.templates.SetFeeThreshold(threshold)
+}
+
+// PayoutAddress returns the miner's payout address, or the void address if
+// none is set.
+func (m *Miner) PayoutAddress() types.Address {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.payout
+}
+
+// SetPayoutAddress sets the miner's payout address, or clears it if addr is
+// the void address. It cannot be cleared while the miner is running. Running
+// miners keep paying the address they were started with.
+func (m *Miner) SetPayoutAddress(addr types.Address) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if addr == types.VoidAddress && m.stop != nil {
+		return fmt.Errorf("cannot clear the payout address: %w", ErrMining)
+	}
+	js, err := json.MarshalIndent(persistMiner{PayoutAddress: addr}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, js, 0600); err != nil {
+		return err
+	} else if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.payout = addr
+	m.log.Info("set payout address", zap.Stringer("address", addr))
+	return nil
+}
+
+// Start starts mining blocks that pay addr, or the payout address if addr is
+// the void address, with threads goroutines, or one per CPU if threads is
+// zero. If the miner is already running, it is restarted with the new
+// settings.
+func (m *Miner) Start(addr types.Address, threads int) error {
+	if m.chain.TipState().Network.Name == "mainnet" {
+		return ErrMainnet
+	}
+	addr, err := m.payoutAddress(addr)
+	if err != nil {
+		return err
+	} else if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	m.stopMining()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	m.addr, m.threads, m.stop, m.done = addr, threads, cancel, done
+	m.samples = []uint64{m.hashes.Load()}
+	go m.sample(ctx)
+	go func() {
+		defer close(done)
+		m.run(ctx, addr, threads)
+	}()
+	m.log.Info("started mining", zap.Stringer("address", addr), zap.Int("threads", threads))
+	return nil
+}
+
+// stopMining stops the miner's goroutines, if they are running, and waits
+// for them to exit. It must be called with m.runMu held.
+func (m *Miner) stopMining() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done, m.samples = nil, nil, nil
+	m.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-done
+	m.log.Info("stopped mining")
+}
+
+// Stop stops mining. It does nothing if the miner is not running.
+func (m *Miner) Stop() {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	m.stopMining()
+}
+
+// Status returns the state of the miner.
+func (m *Miner) Status() Status {
+	sel := m.templates.selection()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Status{
+		Running:      m.stop != nil,
+		Threads:      m.threads,
+		Address:      m.addr,
+		BlocksFound:  m.found,
+		Blocks:       append([]FoundBlock{}, m.blocks...),
+		Stale:        m.stale,
+		Rejected:     m.rejected,
+		TemplateID:   sel.id,
+		TemplateFees: sel.fees,
+		Clients:      make(map[string]ClientStats, len(m.clients)),
+	}
+	if n := len(m.samples); n > 1 {
+		s.Hashrate = float64(m.samples[n-1]-m.samples[0]) / float64(n-1)
+	}
+	for addr, cs := range m.clients {
+		s.Clients[addr] = *cs
+	}
+	return s
+}
+
+// Close stops the miner and its Templater.
+func (m *Miner) Close() error {
+	m.Stop()
+	m.cancelTip()
+	return m.templates.Close()
+}
+
+// NewMiner returns a Miner that mines blocks extending cm's tip, and passes
+// each block it mines, or that is found by a Stratum client, to broadcast, if
+// it is not nil, once it has been added to the chain. Its settings are stored
+// at path. It mines nothing until it is started.
+func NewMiner(cm MinerChainManager, path string, broadcast func(types.Block), log *zap.Logger) (*Miner, error) {
+	var p persistMiner
+	if js, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(js, &p); err != nil {
+			return nil, fmt.Errorf("failed to decode %v: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	m := &Miner{
+		templates: NewTemplater(cm, log),
+		path:      path,
+		chain:     cm,
+		broadcast: broadcast,
+		log:       log,
+		payout:    p.PayoutAddress,
+		clients:   make(map[string]*ClientStats),
+	}
+	m.cancelTip = cm.OnReorg(func(types.ChainIndex) { m.newTemplate() })
+	return m, nil
+}
@@ -0,0 +1,107 @@
+package mining
+
+import (
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+const (
+	// maxFoundBlocks is the number of recently mined blocks listed in the
+	// miner's status.
+	maxFoundBlocks = 100
+	// maxClients is the number of external miners whose statistics are
+	// tracked. When it is exceeded, the least recently seen is forgotten.
+	maxClients = 1000
+)
+
+// Results of a block submitted by an external miner.
+const (
+	SubmissionAccepted = "accepted"
+	SubmissionStale    = "stale"
+	SubmissionRejected = "rejected"
+)
+
+// A FoundBlock is a block mined by the built-in miner.
+type FoundBlock struct {
+	Height    uint64        `json:"height"`
+	ID        types.BlockID `json:"id"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// ClientStats are the statistics of an external miner, identified by its
+// remote address. Stale submissions extend a block other than the tip at the
+// time they are submitted, and may still be added to the chain. Shares counts
+// the valid shares submitted over Stratum, including those that were blocks.
+type ClientStats struct {
+	Templates uint64    `json:"templates"`
+	Shares    uint64    `json:"shares"`
+	Accepted  uint64    `json:"accepted"`
+	Stale     uint64    `json:"stale"`
+	Rejected  uint64    `json:"rejected"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// client returns the statistics of the external miner at remote, forgetting
+// the least recently seen miner if there are too many. It must be called
+// with m.mu held.
+func (m *Miner) client(remote string) *ClientStats {
+	cs, ok := m.clients[remote]
+	if !ok {
+		if len(m.clients) >= maxClients {
+			var oldest string
+			for addr, c := range m.clients {
+				if oldest == "" || c.LastSeen.Before(m.clients[oldest].LastSeen) {
+					oldest = addr
+				}
+			}
+			delete(m.clients, oldest)
+		}
+		cs = new(ClientStats)
+		m.clients[remote] = cs
+	}
+	cs.LastSeen = time.Now()
+	return cs
+}
+
+// RecordTemplate records that the external miner at remote fetched a
+// template.
+func (m *Miner) RecordTemplate(remote string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client(remote).Templates++
+}
+
+// RecordSubmission records the result of a block submitted by the external
+// miner at remote.
+func (m *Miner) RecordSubmission(remote, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cs := m.client(remote)
+	switch result {
+	case SubmissionAccepted:
+		cs.Accepted++
+	case SubmissionStale:
+		cs.Stale++
+	case SubmissionRejected:
+		cs.Rejected++
+	}
+}
+
+// recordShare records a valid share submitted by the Stratum client at
+// remote.
+func (m *Miner) recordShare(remote string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client(remote).Shares++
+}
+
+// recordFound records a block mined by the built-in miner. It must be called
+// with m.mu held.
+func (m *Miner) recordFound(b types.Block, height uint64) {
+	m.found++
+	m.blocks = append(m.blocks, FoundBlock{Height: height, ID: b.ID(), Timestamp: b.Timestamp})
+	if len(m.blocks) > maxFoundBlocks {
+		m.blocks = m.blocks[1:]
+	}
+}
@@ -0,0 +1,465 @@
+package mining
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// DefaultStratumDifficulty is the default share difficulty of a
+// StratumServer: the expected number of hashes per share, about one share a
+// second for each TH/s.
+const DefaultStratumDifficulty = 1 << 40
+
+const (
+	// maxStratumLine is the maximum size of a message from a Stratum client.
+	maxStratumLine = 4096
+	// maxStratumJobs is the number of recent jobs of each connection that
+	// shares may be submitted for.
+	maxStratumJobs = 8
+	// stratumWriteTimeout is the maximum amount of time to wait for a Stratum
+	// client to accept a message.
+	stratumWriteTimeout = 10 * time.Second
+)
+
+// Stratum methods.
+const (
+	stratumMethodSubscribe = "mining.subscribe"
+	stratumMethodAuthorize = "mining.authorize"
+	stratumMethodSetTarget = "mining.set_target"
+	stratumMethodNotify    = "mining.notify"
+	stratumMethodSubmit    = "mining.submit"
+)
+
+// Stratum error codes.
+const (
+	stratumErrOther         = 20
+	stratumErrJobNotFound   = 21
+	stratumErrDuplicate     = 22
+	stratumErrLowDifficulty = 23
+	stratumErrUnauthorized  = 24
+	stratumErrNotSubscribed = 25
+)
+
+// stratumExtranonce2Size is the size, in bytes, of the part of the nonce
+// chosen by a Stratum client. The rest is the connection's 16-bit extranonce.
+const stratumExtranonce2Size = 6
+
+// A stratumError is the error of a Stratum response, encoded as
+// [code, message, null].
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.code, e.message, nil})
+}
+
+type stratumRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type stratumResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result any             `json:"result"`
+	Error  *stratumError   `json:"error"`
+}
+
+type stratumNotification struct {
+	ID     *int   `json:"id"` // always null
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+}
+
+// A stratumJob is a template sent to a Stratum client.
+type stratumJob struct {
+	id     string
+	tmpl   Template
+	header types.BlockHeader
+	target types.BlockID // share target
+	shares map[stratumShare]bool
+}
+
+// A stratumShare identifies a share submitted for a job.
+type stratumShare struct {
+	nonce     uint64
+	timestamp int64
+}
+
+// A stratumConn is a connection to a Stratum client.
+type stratumConn struct {
+	ss         *StratumServer
+	conn       net.Conn
+	remote     string
+	extranonce uint16
+	wake       chan struct{} // signalled when the client authorizes
+
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	subscribed bool
+	addr       types.Address // void until the client authorizes
+	jobs       []*stratumJob // most recent last
+	nextJob    uint64
+	target     types.BlockID // last share target sent
+}
+
+// write writes a message to the client.
+func (sc *stratumConn) write(v any) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+	_, err = sc.conn.Write(append(js, '\n'))
+	return err
+}
+
+// newJob builds a job from the current template, paying the client's
+// address, and adds it to the client's recent jobs. It returns the
+// notifications that send it to the client.
+func (sc *stratumConn) newJob() (Template, []stratumNotification) {
+	sc.mu.Lock()
+	addr := sc.addr
+	sc.mu.Unlock()
+	tmpl := sc.ss.miner.templates.Template(addr, sc.ss.miner.Tag())
+	job := &stratumJob{
+		tmpl:   tmpl,
+		header: tmpl.Block.Header(), // computed once, since it is expensive for v1 blocks
+		target: sc.ss.target,
+		shares: make(map[stratumShare]bool),
+	}
+	if tmpl.Target.CmpWork(job.target) < 0 {
+		// blocks are easier to find than shares
+		job.target = tmpl.Target
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.nextJob++
+	job.id = strconv.FormatUint(sc.nextJob, 16)
+	clean := len(sc.jobs) == 0 || sc.jobs[len(sc.jobs)-1].tmpl.ParentID != tmpl.ParentID
+	sc.jobs = append(sc.jobs, job)
+	if len(sc.jobs) > maxStratumJobs {
+		sc.jobs = sc.jobs[1:]
+	}
+	var notes []stratumNotification
+	if job.target != sc.target {
+		sc.target = job.target
+		notes = append(notes, stratumNotification{Method: stratumMethodSetTarget, Params: []any{job.target}})
+	}
+	notes = append(notes, stratumNotification{
+		Method: stratumMethodNotify,
+		Params: []any{job.id, job.header.ParentID, job.header.Commitment, job.header.Timestamp.Unix(), tmpl.NonceFactor, tmpl.Height, clean},
+	})
+	return tmpl, notes
+}
+
+// notify sends the client a new job whenever the template changes, once it
+// has authorized, until ctx is done.
+func (sc *stratumConn) notify(ctx context.Context) {
+	var changed <-chan struct{} // nil until the client authorizes
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sc.wake:
+		case <-changed:
+		}
+		tmpl, notes := sc.newJob()
+		for _, n := range notes {
+			if err := sc.write(n); err != nil {
+				sc.conn.Close()
+				return
+			}
+		}
+		sc.ss.miner.RecordTemplate(sc.remote)
+		changed = sc.ss.miner.TemplateChanged(tmpl.ID)
+	}
+}
+
+func (sc *stratumConn) handleSubscribe() (any, *stratumError) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.subscribed = true
+	id := fmt.Sprintf("%04x", sc.extranonce)
+	return []any{[][]string{{stratumMethodNotify, id}}, id, stratumExtranonce2Size}, nil
+}
+
+func (sc *stratumConn) handleAuthorize(params []json.RawMessage) (any, *stratumError) {
+	var user string
+	if len(params) < 1 || json.Unmarshal(params[0], &user) != nil {
+		return nil, &stratumError{stratumErrOther, "expected a username"}
+	}
+	// the username is the payout address, optionally followed by a worker
+	// name, as in "addr.worker"
+	s, _, _ := strings.Cut(user, ".")
+	addr, err := types.ParseAddress(s)
+	if err != nil || addr == types.VoidAddress {
+		return nil, &stratumError{stratumErrUnauthorized, "username must be a payout address"}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.subscribed {
+		return nil, &stratumError{stratumErrNotSubscribed, "not subscribed"}
+	}
+	sc.addr = addr
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// job returns the recent job with the given ID. It must be called with
+// sc.mu held.
+func (sc *stratumConn) job(id string) *stratumJob {
+	for _, job := range sc.jobs {
+		if job.id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (sc *stratumConn) handleSubmit(params []json.RawMessage) (any, *stratumError) {
+	var worker, jobID, nonceHex string
+	if len(params) < 3 || json.Unmarshal(params[0], &worker) != nil || json.Unmarshal(params[1], &jobID) != nil || json.Unmarshal(params[2], &nonceHex) != nil {
+		return nil, &stratumError{stratumErrOther, "expected worker, job ID, and nonce"}
+	}
+	nonce, err := strconv.ParseUint(nonceHex, 16, 64)
+	if err != nil {
+		return nil, &stratumError{stratumErrOther, "invalid nonce"}
+	}
+	var timestamp int64
+	if len(params) > 3 && json.Unmarshal(params[3], &timestamp) != nil {
+		return nil, &stratumError{stratumErrOther, "invalid timestamp"}
+	}
+
+	sc.mu.Lock()
+	if sc.addr == types.VoidAddress {
+		sc.mu.Unlock()
+		return nil, &stratumError{stratumErrUnauthorized, "not authorized"}
+	}
+	job := sc.job(jobID)
+	if job == nil {
+		sc.mu.Unlock()
+		return nil, &stratumError{stratumErrJobNotFound, "job not found"}
+	}
+	h := job.header
+	if timestamp == 0 {
+		timestamp = h.Timestamp.Unix()
+	} else if timestamp < h.Timestamp.Unix() || time.Unix(timestamp, 0).After(sc.ss.miner.chain.TipState().MaxFutureTimestamp(time.Now())) {
+		sc.mu.Unlock()
+		return nil, &stratumError{stratumErrOther, "timestamp is out of range"}
+	}
+	share := stratumShare{nonce, timestamp}
+	factor := job.tmpl.NonceFactor // at least 1
+	if nonce%factor != 0 || uint16(nonce>>48) != sc.extranonce {
+		sc.mu.Unlock()
+		return nil, &stratumError{stratumErrOther, "nonce is outside of the connection's extranonce"}
+	} else if job.shares[share] {
+		sc.mu.Unlock()
+		return nil, &stratumError{stratumErrDuplicate, "duplicate share"}
+	}
+	h.Nonce, h.Timestamp = nonce, time.Unix(timestamp, 0)
+	id := h.ID()
+	if id.CmpWork(job.target) < 0 {
+		sc.mu.Unlock()
+		return nil, &stratumError{stratumErrLowDifficulty, "low difficulty share"}
+	}
+	job.shares[share] = true
+	sc.mu.Unlock()
+
+	if id.CmpWork(job.tmpl.Target) >= 0 {
+		b := job.tmpl.Block
+		b.Nonce, b.Timestamp = nonce, h.Timestamp
+		switch result, err := sc.ss.miner.submitBlock(sc.remote, b); result {
+		case SubmissionStale:
+			return nil, &stratumError{stratumErrJobNotFound, "stale job"}
+		case SubmissionRejected:
+			return nil, &stratumError{stratumErrOther, "block rejected: " + err.Error()}
+		}
+	} else if job.tmpl.ParentID != sc.ss.miner.chain.Tip().ID {
+		return nil, &stratumError{stratumErrJobNotFound, "stale job"}
+	}
+	sc.ss.miner.recordShare(sc.remote)
+	return true, nil
+}
+
+// serve handles the client's requests until the connection is closed.
+func (sc *stratumConn) serve() {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer sc.conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg.Go(func() { sc.notify(ctx) })
+
+	s := bufio.NewScanner(sc.conn)
+	s.Buffer(make([]byte, maxStratumLine), maxStratumLine)
+	for s.Scan() {
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var req stratumRequest
+		if err := json.Unmarshal(s.Bytes(), &req); err != nil {
+			sc.write(stratumResponse{ID: json.RawMessage("null"), Error: &stratumError{stratumErrOther, "malformed request"}})
+			return
+		} else if len(req.ID) == 0 {
+			req.ID = json.RawMessage("null")
+		}
+		var result any
+		var serr *stratumError
+		switch req.Method {
+		case stratumMethodSubscribe:
+			result, serr = sc.handleSubscribe()
+		case stratumMethodAuthorize:
+			result, serr = sc.handleAuthorize(req.Params)
+		case stratumMethodSubmit:
+			result, serr = sc.handleSubmit(req.Params)
+		default:
+			serr = &stratumError{stratumErrOther, "unknown method " + strconv.Quote(req.Method)}
+		}
+		if err := sc.write(stratumResponse{ID: req.ID, Result: result, Error: serr}); err != nil {
+			return
+		}
+	}
+}
+
+// A StratumServer serves work from a Miner's templates to mining hardware
+// over a Stratum-like protocol of newline-delimited JSON-RPC messages:
+//
+//   - mining.subscribe returns [[["mining.notify", id]], extranonce1, 6],
+//     where extranonce1 is the hex-encoded 16-bit extranonce of the
+//     connection.
+//   - mining.authorize, with params [username, password], sets the payout
+//     address of the connection's jobs to the username, optionally followed
+//     by a period and a worker name. The password is ignored.
+//   - mining.set_target, sent by the server, gives the hex-encoded target
+//     that shares must meet.
+//   - mining.notify, sent by the server, gives a job as [jobID, parentID,
+//     commitment, timestamp, nonceFactor, height, cleanJobs]. A block header
+//     is the parent ID, the nonce as a little-endian uint64, the timestamp
+//     as a little-endian uint64, and the commitment. The upper 16 bits of
+//     the nonce must be extranonce1, and the nonce must be a multiple of
+//     nonceFactor. If cleanJobs is true, the tip has changed, and earlier
+//     jobs are stale.
+//   - mining.submit, with params [worker, jobID, nonce, timestamp], submits
+//     the hex-encoded nonce of a share. The timestamp may be omitted, or
+//     rolled forward from the job's, up to the chain's limit on future
+//     timestamps. Shares that also meet the block target are added to the
+//     chain and broadcast.
+//
+// Share difficulty is static: each connection is sent the same share target,
+// unless blocks are easier to find.
+type StratumServer struct {
+	miner      *Miner
+	l          net.Listener
+	target     types.BlockID
+	log        *zap.Logger
+	extranonce atomic.Uint32
+	wg         sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// accept serves connections until the listener is closed.
+func (ss *StratumServer) accept() {
+	for {
+		conn, err := ss.l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			ss.log.Warn("failed to accept Stratum connection", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		ss.mu.Lock()
+		if ss.closed {
+			ss.mu.Unlock()
+			conn.Close()
+			return
+		}
+		ss.conns[conn] = struct{}{}
+		ss.mu.Unlock()
+
+		remote := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		sc := &stratumConn{
+			ss:         ss,
+			conn:       conn,
+			remote:     remote,
+			extranonce: uint16(ss.extranonce.Add(1)),
+			wake:       make(chan struct{}, 1),
+		}
+		ss.wg.Go(func() {
+			ss.log.Debug("Stratum client connected", zap.String("remote", remote))
+			sc.serve()
+			ss.mu.Lock()
+			delete(ss.conns, conn)
+			ss.mu.Unlock()
+			ss.log.Debug("Stratum client disconnected", zap.String("remote", remote))
+		})
+	}
+}
+
+// Addr returns the address the server is listening on.
+func (ss *StratumServer) Addr() net.Addr {
+	return ss.l.Addr()
+}
+
+// Close stops accepting connections, closes those that are open, and waits
+// for them to finish.
+func (ss *StratumServer) Close() error {
+	err := ss.l.Close()
+	ss.mu.Lock()
+	ss.closed = true
+	for conn := range ss.conns {
+		conn.Close()
+	}
+	ss.mu.Unlock()
+	ss.wg.Wait()
+	return err
+}
+
+// NewStratumServer returns a StratumServer that serves work from m's
+// templates on l, with shares at the given difficulty.
+func NewStratumServer(m *Miner, l net.Listener, difficulty uint64, log *zap.Logger) (*StratumServer, error) {
+	if difficulty == 0 {
+		return nil, errors.New("share difficulty must be positive")
+	}
+	// the target is the largest ID expected to take difficulty hashes
+	t := new(big.Int).Lsh(big.NewInt(1), 256)
+	t.Sub(t, big.NewInt(1)).Div(t, new(big.Int).SetUint64(difficulty))
+	ss := &StratumServer{
+		miner: m,
+		l:     l,
+		log:   log,
+		conns: make(map[net.Conn]struct{}),
+	}
+	t.FillBytes(ss.target[:])
+	ss.wg.Go(ss.accept)
+	return ss, nil
+}
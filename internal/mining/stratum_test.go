@@ -0,0 +1,254 @@
+package mining
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	"go.uber.org/zap"
+)
+
+// newTestChain returns a chain manager for a test network. Managers with the
+// same genesis block can exchange blocks.
+func newTestChain(t *testing.T) *chain.Manager {
+	t.Helper()
+	n, genesis := testutil.V2Network()
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain.NewManager(store, tipState)
+}
+
+// A stratumMessage is a response or notification read by a test client.
+type stratumMessage struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []json.RawMessage `json:"error"`
+}
+
+// A testJob is a job received by a test client.
+type testJob struct {
+	id          string
+	parentID    types.BlockID
+	nonceFactor uint64
+	height      uint64
+	clean       bool
+}
+
+// A stratumClient is a minimal Stratum client, which subscribes and
+// authorizes when it connects.
+type stratumClient struct {
+	t          *testing.T
+	conn       net.Conn
+	s          *bufio.Scanner
+	nextID     int
+	extranonce uint16
+	notes      []stratumMessage
+}
+
+func dialStratum(t *testing.T, addr net.Addr, payout types.Address) *stratumClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &stratumClient{t: t, conn: conn, s: bufio.NewScanner(conn)}
+
+	var sub []json.RawMessage
+	if err := json.Unmarshal(c.call(stratumMethodSubscribe), &sub); err != nil || len(sub) != 3 {
+		t.Fatalf("unexpected subscribe result: %v", err)
+	}
+	var extranonce string
+	if err := json.Unmarshal(sub[1], &extranonce); err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.ParseUint(extranonce, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.extranonce = uint16(n)
+	if string(c.call(stratumMethodAuthorize, payout.String()+".test", "")) != "true" {
+		t.Fatal("not authorized")
+	}
+	return c
+}
+
+// read reads the next message from the server.
+func (c *stratumClient) read() stratumMessage {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if !c.s.Scan() {
+		c.t.Fatalf("failed to read from server: %v", c.s.Err())
+	}
+	var msg stratumMessage
+	if err := json.Unmarshal(c.s.Bytes(), &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// request sends a request, returning the result of its response, or the code
+// of its error. Notifications received in the meantime are kept for job.
+func (c *stratumClient) request(method string, params ...any) (json.RawMessage, int) {
+	c.t.Helper()
+	c.nextID++
+	js, _ := json.Marshal(map[string]any{"id": c.nextID, "method": method, "params": params})
+	if _, err := c.conn.Write(append(js, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		if msg.Method != "" {
+			c.notes = append(c.notes, msg)
+			continue
+		} else if string(msg.ID) != strconv.Itoa(c.nextID) {
+			c.t.Fatalf("expected a response to request %v, got %s", c.nextID, msg.ID)
+		} else if len(msg.Error) > 0 {
+			var code int
+			if err := json.Unmarshal(msg.Error[0], &code); err != nil {
+				c.t.Fatal(err)
+			}
+			return nil, code
+		}
+		return msg.Result, 0
+	}
+}
+
+// call sends a request that must succeed, returning its result.
+func (c *stratumClient) call(method string, params ...any) json.RawMessage {
+	c.t.Helper()
+	result, code := c.request(method, params...)
+	if code != 0 {
+		c.t.Fatalf("%v failed with code %v", method, code)
+	}
+	return result
+}
+
+// job returns the next job sent by the server.
+func (c *stratumClient) job() testJob {
+	c.t.Helper()
+	for {
+		var msg stratumMessage
+		if len(c.notes) > 0 {
+			msg, c.notes = c.notes[0], c.notes[1:]
+		} else {
+			msg = c.read()
+		}
+		if msg.Method != stratumMethodNotify {
+			continue
+		} else if len(msg.Params) != 7 {
+			c.t.Fatalf("expected 7 job params, got %v", len(msg.Params))
+		}
+		var job testJob
+		for i, v := range []any{&job.id, &job.parentID, nil, nil, &job.nonceFactor, &job.height, &job.clean} {
+			if v == nil {
+				continue
+			} else if err := json.Unmarshal(msg.Params[i], v); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return job
+	}
+}
+
+// waitJob returns the first job sent by the server that extends the tip
+// with id.
+func (c *stratumClient) waitJob(tip types.BlockID) testJob {
+	c.t.Helper()
+	for {
+		if job := c.job(); job.parentID == tip {
+			return job
+		}
+	}
+}
+
+// submit submits a share for job with the smallest nonce the connection may
+// use, returning the code of the error, if any.
+func (c *stratumClient) submit(job testJob) int {
+	c.t.Helper()
+	nonce := uint64(c.extranonce) << 48
+	nonce += (job.nonceFactor - nonce%job.nonceFactor) % job.nonceFactor
+	_, code := c.request(stratumMethodSubmit, "test", job.id, strconv.FormatUint(nonce, 16))
+	return code
+}
+
+func TestStratumReorg(t *testing.T) {
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, types.VoidAddress, 2)
+
+	m, err := NewMiner(cm, filepath.Join(t.TempDir(), "mining.json"), nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every hash meets the share target
+	ss, err := NewStratumServer(m, l, 1, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	payout := types.Address{1}
+	c := dialStratum(t, ss.Addr(), payout)
+	old := c.job()
+	if !old.clean {
+		t.Fatal("expected the first job to be clean")
+	} else if old.parentID != cm.Tip().ID {
+		t.Fatalf("expected a job extending %v, got %v", cm.Tip().ID, old.parentID)
+	}
+
+	// a longer fork from the genesis block replaces the tip
+	fork := newTestChain(t)
+	testutil.MineBlocks(t, fork, types.VoidAddress, 3)
+	var blocks []types.Block
+	for height := uint64(1); height <= fork.Tip().Height; height++ {
+		index, _ := fork.BestIndex(height)
+		b, _ := fork.Block(index.ID)
+		blocks = append(blocks, b)
+	}
+	if err := cm.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != fork.Tip() {
+		t.Fatalf("expected the fork's tip %v, got %v", fork.Tip(), cm.Tip())
+	}
+
+	// the connected client is sent a clean job on the new tip, and its
+	// earlier job is stale
+	if job := c.waitJob(cm.Tip().ID); !job.clean {
+		t.Fatal("expected the job after the reorg to be clean")
+	}
+	if code := c.submit(old); code != stratumErrJobNotFound {
+		t.Fatalf("expected a stale job error (%v), got %v", stratumErrJobNotFound, code)
+	}
+	c.conn.Close()
+
+	// so is a client reconnecting after the reorg, whose shares on the new
+	// tip are accepted
+	c = dialStratum(t, ss.Addr(), payout)
+	job := c.job()
+	if !job.clean {
+		t.Fatal("expected the first job after reconnecting to be clean")
+	} else if job.parentID != cm.Tip().ID || job.parentID == old.parentID {
+		t.Fatalf("expected a job extending the new tip %v, got %v", cm.Tip().ID, job.parentID)
+	} else if job.height != cm.Tip().Height+1 {
+		t.Fatalf("expected a job at height %v, got %v", cm.Tip().Height+1, job.height)
+	}
+	if code := c.submit(job); code != 0 {
+		t.Fatalf("share rejected with code %v", code)
+	}
+}
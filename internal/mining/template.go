@@ -0,0 +1,506 @@
+package mining
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// MaxTagSize is the maximum size, in bytes, of the tag of a template.
+const MaxTagSize = 64
+
+// ValidateTag returns an error if tag cannot be included in a block.
+func ValidateTag(tag string) error {
+	if len(tag) > MaxTagSize {
+		return fmt.Errorf("tag is %d bytes, more than the maximum of %d", len(tag), MaxTagSize)
+	} else if strings.HasPrefix(tag, string(types.SpecifierFoundation[:])) {
+		// it would be decoded as a Foundation address update
+		return fmt.Errorf("tag must not begin with %q", types.SpecifierFoundation)
+	}
+	return nil
+}
+
+// tagTransactions returns the transaction that carries tag in a block at
+// height: a v1 transaction before the v2 hardfork, and a v2 transaction
+// after, each containing only the tag as arbitrary data. Neither is returned
+// if tag is empty.
+func tagTransactions(cs consensus.State, tag string) ([]types.Transaction, []types.V2Transaction) {
+	if tag == "" {
+		return nil, nil
+	} else if cs.Index.Height+1 < cs.Network.HardforkV2.AllowHeight {
+		return []types.Transaction{{ArbitraryData: [][]byte{[]byte(tag)}}}, nil
+	}
+	return nil, []types.V2Transaction{{ArbitraryData: []byte(tag)}}
+}
+
+// tagWeight returns the weight of the transaction that carries a tag of
+// MaxTagSize bytes, which is reserved in every template.
+func tagWeight(cs consensus.State) (weight uint64) {
+	txns, v2txns := tagTransactions(cs, strings.Repeat("\x00", MaxTagSize))
+	for _, txn := range txns {
+		weight += cs.TransactionWeight(txn)
+	}
+	for _, txn := range v2txns {
+		weight += cs.V2TransactionWeight(txn)
+	}
+	return
+}
+
+// A ChainManager provides the tip and txpool from which templates are built.
+type ChainManager interface {
+	Tip() types.ChainIndex
+	TipState() consensus.State
+	PoolTransactions() []types.Transaction
+	V2PoolTransactions() []types.V2Transaction
+	OnReorg(fn func(types.ChainIndex)) (cancel func())
+	OnPoolChange(fn func()) (cancel func())
+}
+
+// A Template is a block that extends the current tip, paying the block reward
+// and the fees of its transactions to a single address. Apart from its nonce,
+// it is ready to be mined: a miner varies Block.Nonce, in multiples of
+// NonceFactor, and optionally Block.Timestamp, until the block's ID meets
+// Target.
+//
+// If Tag is not empty, the block ends with a transaction containing only the
+// tag as arbitrary data, which marks the block as produced by its miner.
+//
+// ID identifies the parent and transactions of the template, but not its
+// payout address, tag, or timestamp; a template whose ID differs from that of
+// the current template is stale, and should be abandoned.
+type Template struct {
+	ID          types.Hash256  `json:"id"`
+	ParentID    types.BlockID  `json:"parentID"`
+	Height      uint64         `json:"height"`
+	Target      types.BlockID  `json:"target"`
+	NonceFactor uint64         `json:"nonceFactor"`
+	Reward      types.Currency `json:"reward"`
+	Fees        types.Currency `json:"fees"`
+	Weight      uint64         `json:"weight"`
+	MaxWeight   uint64         `json:"maxWeight"`
+	Tag         string         `json:"tag,omitempty"`
+	Block       types.Block    `json:"block"`
+}
+
+// A selection is the set of pool transactions included in templates built
+// from a particular tip and pool.
+type selection struct {
+	gen    uint64
+	id     types.Hash256
+	cs     consensus.State
+	txns   []types.Transaction
+	v2txns []types.V2Transaction
+	fees   types.Currency
+	weight uint64
+}
+
+// A Templater builds block templates from the tip and the txpool. The
+// transactions in a template are chosen again whenever the tip or pool
+// changes, but the template only changes, and its ID with it, when the tip
+// changes, when one of its transactions leaves the pool, or when the new
+// transactions pay more than the fee threshold in additional fees. Miners are
+// thus not asked to switch templates for every small transaction.
+type Templater struct {
+	chain  ChainManager
+	log    *zap.Logger
+	cancel []func()
+
+	updateCh chan struct{}
+	closeCh  chan struct{}
+	wg       sync.WaitGroup
+
+	buildMu sync.Mutex // serializes selection
+
+	mu        sync.Mutex
+	gen       uint64 // incremented whenever the tip or pool changes
+	sel       *selection
+	threshold types.Currency
+	changed   chan struct{} // closed when sel is replaced
+}
+
+// invalidate marks the current selection as stale, and signals the update
+// loop to choose it again.
+func (t *Templater) invalidate() {
+	t.mu.Lock()
+	t.gen++
+	t.mu.Unlock()
+	select {
+	case t.updateCh <- struct{}{}:
+	default:
+	}
+}
+
+// material reports whether next should replace the current selection, cur.
+// It must be called with t.mu held.
+func (t *Templater) material(cur, next *selection, pool map[types.TransactionID]bool) bool {
+	if cur == nil || cur.cs.Index != next.cs.Index {
+		return true
+	}
+	for _, txn := range cur.txns {
+		if !pool[txn.ID()] {
+			return true
+		}
+	}
+	for _, txn := range cur.v2txns {
+		if !pool[txn.ID()] {
+			return true
+		}
+	}
+	return next.fees.Cmp(cur.fees.Add(t.threshold)) > 0
+}
+
+// selection returns the transactions to include in templates for the current
+// tip and pool, choosing them again if either has changed.
+func (t *Templater) selection() *selection {
+	t.buildMu.Lock()
+	defer t.buildMu.Unlock()
+	t.mu.Lock()
+	gen, sel := t.gen, t.sel
+	t.mu.Unlock()
+	if sel != nil && sel.gen == gen {
+		return sel
+	}
+
+	// the chain manager's callbacks are called without its lock held, so the
+	// pool may change while it is read; the generation is read first, so
+	// such a change marks the new selection stale
+	next, pool := t.selectPool()
+	next.gen = gen
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.material(t.sel, next, pool) {
+		// the current template is still valid, and good enough
+		kept := *t.sel
+		kept.gen = gen
+		t.sel = &kept
+		return t.sel
+	}
+	t.sel = next
+	close(t.changed)
+	t.changed = make(chan struct{})
+	t.log.Debug("selected template transactions", zap.Stringer("parent", next.cs.Index), zap.Int("transactions", len(next.txns)+len(next.v2txns)), zap.Uint64("weight", next.weight))
+	return next
+}
+
+// selectPool chooses the pool transactions to include in a block extending
+// the current tip, regardless of the fee threshold. It also returns the IDs
+// of the pool's transactions.
+func (t *Templater) selectPool() (*selection, map[types.TransactionID]bool) {
+retry:
+	cs := t.chain.TipState()
+	txns, v2txns := t.chain.PoolTransactions(), t.chain.V2PoolTransactions()
+	if cs.Index != t.chain.Tip() {
+		goto retry
+	}
+	pool := make(map[types.TransactionID]bool, len(txns)+len(v2txns))
+	for _, txn := range txns {
+		pool[txn.ID()] = true
+	}
+	for _, txn := range v2txns {
+		pool[txn.ID()] = true
+	}
+	return selectTransactions(cs, txns, v2txns), pool
+}
+
+// Changed returns a channel that is closed once the ID of the current
+// template is no longer id.
+func (t *Templater) Changed(id types.Hash256) <-chan struct{} {
+	t.selection()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sel.id != id {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return t.changed
+}
+
+// SetFeeThreshold sets the additional fees that new pool transactions must
+// pay for the template to change while the tip does not.
+func (t *Templater) SetFeeThreshold(threshold types.Currency) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.threshold = threshold
+}
+
+// Template returns a template that extends the current tip, pays addr, and
+// carries tag, which must be valid.
+func (t *Templater) Template(addr types.Address, tag string) Template {
+	return buildTemplate(t.selection(), addr, tag)
+}
+
+// buildTemplate returns a template of the transactions in sel that pays addr
+// and carries tag.
+func buildTemplate(sel *selection, addr types.Address, tag string) Template {
+	cs := sel.cs
+	tagTxns, tagV2Txns := tagTransactions(cs, tag)
+	tmpl := Template{
+		ID:          sel.id,
+		ParentID:    cs.Index.ID,
+		Height:      cs.Index.Height + 1,
+		Target:      cs.PoWTarget(),
+		NonceFactor: cs.NonceFactor(),
+		Reward:      cs.BlockReward(),
+		Fees:        sel.fees,
+		Weight:      sel.weight,
+		MaxWeight:   cs.MaxBlockWeight(),
+		Tag:         tag,
+		Block: types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: addr, Value: cs.BlockReward().Add(sel.fees)}},
+			Transactions: append(slices.Clone(sel.txns), tagTxns...),
+		},
+	}
+	for _, txn := range tagTxns {
+		tmpl.Weight += cs.TransactionWeight(txn)
+	}
+	for _, txn := range tagV2Txns {
+		tmpl.Weight += cs.V2TransactionWeight(txn)
+	}
+	if tmpl.Height >= cs.Network.HardforkV2.AllowHeight {
+		tmpl.Block.V2 = &types.V2BlockData{
+			Height:       tmpl.Height,
+			Transactions: append(slices.Clone(sel.v2txns), tagV2Txns...),
+		}
+		tmpl.Block.V2.Commitment = cs.Commitment(addr, tmpl.Block.Transactions, tmpl.Block.V2.Transactions)
+	}
+	return tmpl
+}
+
+// Close stops the Templater from tracking the tip and pool.
+func (t *Templater) Close() error {
+	for _, cancel := range t.cancel {
+		cancel()
+	}
+	close(t.closeCh)
+	t.wg.Wait()
+	return nil
+}
+
+// NewTemplater returns a Templater that builds templates from cm's tip and
+// txpool.
+func NewTemplater(cm ChainManager, log *zap.Logger) *Templater {
+	t := &Templater{
+		chain:    cm,
+		log:      log,
+		updateCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+	// the selection is updated as soon as the tip or pool changes, so that
+	// waiting miners are notified; the callbacks must not block the chain
+	// manager, so the update loop does it
+	t.wg.Go(func() {
+		for {
+			select {
+			case <-t.closeCh:
+				return
+			case <-t.updateCh:
+				t.selection()
+			}
+		}
+	})
+	t.cancel = []func(){
+		cm.OnReorg(func(types.ChainIndex) { t.invalidate() }),
+		cm.OnPoolChange(t.invalidate),
+	}
+	return t
+}
+
+// A candidate is a pool transaction that may be included in a template.
+type candidate struct {
+	v2        bool
+	index     int // in the pool's v1 or v2 transactions
+	fee       types.Currency
+	weight    uint64
+	ancestors []int // candidates that must precede it, in pool order
+	density   types.Currency
+}
+
+// selectTransactions chooses the pool transactions to include in a block
+// extending cs, by the fee density of each transaction together with its
+// unconfirmed ancestors, until the block weight limit is reached. Pool order
+// is dependency order, and is preserved in the selection.
+func selectTransactions(cs consensus.State, txns []types.Transaction, v2txns []types.V2Transaction) *selection {
+	height := cs.Index.Height + 1
+	allowV1 := height < cs.Network.HardforkV2.RequireHeight
+	allowV2 := height >= cs.Network.HardforkV2.AllowHeight
+
+	// v1 transactions precede v2 transactions in a block, and cannot spend
+	// their outputs, so the concatenation of the two is dependency order
+	var cands []candidate
+	outputs := make(map[types.Hash256]int)
+	excluded := make(map[int]bool)
+	add := func(c candidate, parents []types.Hash256, created []types.Hash256, allowed bool) {
+		i := len(cands)
+		seen := make(map[int]bool)
+		for _, id := range parents {
+			p, ok := outputs[id]
+			if !ok || seen[p] {
+				continue
+			}
+			seen[p] = true
+			for _, a := range cands[p].ancestors {
+				seen[a] = true
+			}
+		}
+		for a := range seen {
+			c.ancestors = append(c.ancestors, a)
+			allowed = allowed && !excluded[a]
+		}
+		slices.Sort(c.ancestors)
+		excluded[i] = !allowed
+		for _, id := range created {
+			outputs[id] = i
+		}
+		cands = append(cands, c)
+	}
+	for i, txn := range txns {
+		add(candidate{index: i, fee: txn.TotalFees(), weight: cs.TransactionWeight(txn)}, v1Parents(txn), v1Outputs(txn), allowV1)
+	}
+	for i, txn := range v2txns {
+		add(candidate{v2: true, index: i, fee: txn.MinerFee, weight: cs.V2TransactionWeight(txn)}, v2Parents(txn), v2Outputs(txn), allowV2)
+	}
+
+	// each candidate is ranked by the fee density of the package formed
+	// with its ancestors, so that a transaction paying a high fee for a
+	// low-fee parent is included along with it
+	order := make([]int, 0, len(cands))
+	for i := range cands {
+		if excluded[i] {
+			continue
+		}
+		c := &cands[i]
+		fee, weight := c.fee, c.weight
+		for _, a := range c.ancestors {
+			fee, weight = fee.Add(cands[a].fee), weight+cands[a].weight
+		}
+		c.density = fee.Div64(max(weight, 1))
+		order = append(order, i)
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cands[b].density.Cmp(cands[a].density) })
+
+	sel := &selection{cs: cs}
+	// room is left for a tag, so that the selection does not depend on it
+	maxWeight := cs.MaxBlockWeight() - tagWeight(cs)
+	selected := make(map[int]bool)
+	for _, i := range order {
+		if selected[i] {
+			continue
+		}
+		pkg := []int{i}
+		weight := cands[i].weight
+		for _, a := range cands[i].ancestors {
+			if !selected[a] {
+				pkg = append(pkg, a)
+				weight += cands[a].weight
+			}
+		}
+		if sel.weight+weight > maxWeight {
+			continue
+		}
+		sel.weight += weight
+		for _, j := range pkg {
+			selected[j] = true
+			sel.fees = sel.fees.Add(cands[j].fee)
+		}
+	}
+
+	h := types.NewHasher()
+	h.WriteDistinguisher("template")
+	cs.Index.ID.EncodeTo(h.E)
+	chosen := make([]int, 0, len(selected))
+	for i := range selected {
+		chosen = append(chosen, i)
+	}
+	slices.SortFunc(chosen, cmp.Compare)
+	for _, i := range chosen {
+		if c := cands[i]; c.v2 {
+			sel.v2txns = append(sel.v2txns, v2txns[c.index])
+			v2txns[c.index].ID().EncodeTo(h.E)
+		} else {
+			sel.txns = append(sel.txns, txns[c.index])
+			txns[c.index].ID().EncodeTo(h.E)
+		}
+	}
+	sel.id = h.Sum()
+	return sel
+}
+
+// v1Parents returns the IDs of the elements spent by txn.
+func v1Parents(txn types.Transaction) (ids []types.Hash256) {
+	for _, sci := range txn.SiacoinInputs {
+		ids = append(ids, types.Hash256(sci.ParentID))
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ids = append(ids, types.Hash256(sfi.ParentID))
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		ids = append(ids, types.Hash256(fcr.ParentID))
+	}
+	for _, sp := range txn.StorageProofs {
+		ids = append(ids, types.Hash256(sp.ParentID))
+	}
+	return
+}
+
+// v1Outputs returns the IDs of the elements created by txn.
+func v1Outputs(txn types.Transaction) (ids []types.Hash256) {
+	for i := range txn.SiacoinOutputs {
+		ids = append(ids, types.Hash256(txn.SiacoinOutputID(i)))
+	}
+	for i := range txn.SiafundInputs {
+		ids = append(ids, types.Hash256(txn.SiafundClaimOutputID(i)))
+	}
+	for i := range txn.SiafundOutputs {
+		ids = append(ids, types.Hash256(txn.SiafundOutputID(i)))
+	}
+	for i := range txn.FileContracts {
+		ids = append(ids, types.Hash256(txn.FileContractID(i)))
+	}
+	return
+}
+
+// v2Parents returns the IDs of the elements spent or revised by txn.
+func v2Parents(txn types.V2Transaction) (ids []types.Hash256) {
+	for _, sci := range txn.SiacoinInputs {
+		ids = append(ids, types.Hash256(sci.Parent.ID))
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ids = append(ids, types.Hash256(sfi.Parent.ID))
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		ids = append(ids, types.Hash256(fcr.Parent.ID))
+	}
+	for _, fcr := range txn.FileContractResolutions {
+		ids = append(ids, types.Hash256(fcr.Parent.ID))
+	}
+	return
+}
+
+// v2Outputs returns the IDs of the elements created by txn.
+func v2Outputs(txn types.V2Transaction) (ids []types.Hash256) {
+	txid := txn.ID()
+	for i := range txn.SiacoinOutputs {
+		ids = append(ids, types.Hash256(txn.SiacoinOutputID(txid, i)))
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ids = append(ids, types.Hash256(sfi.Parent.ID.V2ClaimOutputID()))
+	}
+	for i := range txn.SiafundOutputs {
+		ids = append(ids, types.Hash256(txn.SiafundOutputID(txid, i)))
+	}
+	for i := range txn.FileContracts {
+		ids = append(ids, types.Hash256(txn.V2FileContractID(txid, i)))
+	}
+	return
+}
@@ -0,0 +1,226 @@
+package peers
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/coreutils/syncer"
+)
+
+// ErrBanNotFound is returned when unbanning an address that is not banned.
+var ErrBanNotFound = errors.New("ban not found")
+
+// A Ban prevents peers within a subnet from connecting until it expires.
+type Ban struct {
+	Subnet     string    `json:"subnet"`
+	Expiration time.Time `json:"expiration"`
+	Reason     string    `json:"reason"`
+}
+
+// A BanStore wraps a syncer.PeerStore, adding support for temporary bans of
+// individual IPs and CIDR subnets. It also implements PinStore.
+type BanStore struct {
+	syncer.PeerStore
+
+	discovered atomic.Uint64
+
+	mu   sync.Mutex
+	bans map[string]Ban // keyed by normalized subnet
+	pins map[string]struct{}
+}
+
+// StoreStats contains statistics about a BanStore. Discovered counts the
+// addresses added to the store since startup that were not already known,
+// most of which are learned from peers via the ShareNodes RPC.
+type StoreStats struct {
+	Known      int    `json:"known"`
+	Discovered uint64 `json:"discovered"`
+}
+
+// ParseSubnet parses addr as a CIDR subnet. Addresses that are not in CIDR
+// form are treated as a single IP, with or without a port.
+func ParseSubnet(addr string) (*net.IPNet, error) {
+	if strings.Contains(addr, "/") {
+		_, subnet, err := net.ParseCIDR(addr)
+		return subnet, err
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	// strip the zone from link-local IPv6 addresses
+	if i := strings.IndexByte(host, '%'); i != -1 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.New("invalid IP address")
+	} else if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// AddPeer implements syncer.PeerStore, counting newly discovered addresses.
+func (bs *BanStore) AddPeer(addr string) error {
+	_, infoErr := bs.PeerStore.PeerInfo(addr)
+	if err := bs.PeerStore.AddPeer(addr); err != nil {
+		return err
+	} else if errors.Is(infoErr, syncer.ErrPeerNotFound) {
+		bs.discovered.Add(1)
+	}
+	return nil
+}
+
+// Stats returns statistics about the store.
+func (bs *BanStore) Stats() (StoreStats, error) {
+	known, err := bs.PeerStore.Peers()
+	if err != nil {
+		return StoreStats{}, err
+	}
+	return StoreStats{
+		Known:      len(known),
+		Discovered: bs.discovered.Load(),
+	}, nil
+}
+
+// Ban temporarily bans one or more IPs. The addr should either be a single
+// IP with port (e.g. 1.2.3.4:5678) or a CIDR subnet (e.g. 1.2.3.4/16).
+func (bs *BanStore) Ban(addr string, duration time.Duration, reason string) error {
+	subnet, err := ParseSubnet(addr)
+	if err != nil {
+		return err
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	key := subnet.String()
+	expiration := time.Now().Add(duration)
+	// never shorten an existing ban
+	if existing, ok := bs.bans[key]; ok && existing.Expiration.After(expiration) {
+		expiration = existing.Expiration
+	}
+	bs.bans[key] = Ban{
+		Subnet:     key,
+		Expiration: expiration,
+		Reason:     reason,
+	}
+	return nil
+}
+
+// pinnedIP reports whether a pinned peer's address has the given IP. Pins
+// with a hostname are not resolved.
+func (bs *BanStore) pinnedIP(ip net.IP) bool {
+	for addr := range bs.pins {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		if pinned := net.ParseIP(host); pinned != nil && pinned.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Banned returns true, nil if the peer is banned. The addr may be an IP, with
+// or without a port. Pinned peers are never banned, so the syncer neither
+// refuses their connections nor skips dialing them, even if it banned them
+// for misbehaving.
+func (bs *BanStore) Banned(addr string) (bool, error) {
+	subnet, err := ParseSubnet(addr)
+	if err != nil {
+		// hostnames are resolved by the syncer before checking bans
+		return false, nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.pinnedIP(subnet.IP) {
+		return false, nil
+	}
+	for key, ban := range bs.bans {
+		if time.Now().After(ban.Expiration) {
+			delete(bs.bans, key)
+			continue
+		}
+		_, banned, _ := net.ParseCIDR(key)
+		if banned.Contains(subnet.IP) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Bans returns the active bans, ordered by expiration.
+func (bs *BanStore) Bans() ([]Ban, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bans := make([]Ban, 0, len(bs.bans))
+	for key, ban := range bs.bans {
+		if time.Now().After(ban.Expiration) {
+			delete(bs.bans, key)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	slices.SortFunc(bans, func(a, b Ban) int { return a.Expiration.Compare(b.Expiration) })
+	return bans, nil
+}
+
+// Unban removes the ban on addr, which must match the address or subnet that
+// was banned.
+func (bs *BanStore) Unban(addr string) error {
+	subnet, err := ParseSubnet(addr)
+	if err != nil {
+		return err
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	key := subnet.String()
+	if _, ok := bs.bans[key]; !ok {
+		return ErrBanNotFound
+	}
+	delete(bs.bans, key)
+	return nil
+}
+
+// Pin adds addr to the set of pinned peers.
+func (bs *BanStore) Pin(addr string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.pins[addr] = struct{}{}
+	return nil
+}
+
+// Unpin removes addr from the set of pinned peers.
+func (bs *BanStore) Unpin(addr string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	delete(bs.pins, addr)
+	return nil
+}
+
+// Pins returns the addresses of the pinned peers.
+func (bs *BanStore) Pins() ([]string, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	pins := make([]string, 0, len(bs.pins))
+	for addr := range bs.pins {
+		pins = append(pins, addr)
+	}
+	slices.Sort(pins)
+	return pins, nil
+}
+
+// NewBanStore returns a BanStore that stores peers in ps.
+func NewBanStore(ps syncer.PeerStore) *BanStore {
+	return &BanStore{
+		PeerStore: ps,
+		bans:      make(map[string]Ban),
+		pins:      make(map[string]struct{}),
+	}
+}
@@ -0,0 +1,40 @@
+package peers
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/coreutils/testutil"
+)
+
+func TestBanStorePinnedExempt(t *testing.T) {
+	bs := NewBanStore(testutil.NewEphemeralPeerStore())
+	if err := bs.Ban("1.2.3.0/24", time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	banned := func(addr string) bool {
+		t.Helper()
+		ok, err := bs.Banned(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if !banned("1.2.3.4") || !banned("1.2.3.5:9981") {
+		t.Fatal("expected subnet to be banned")
+	}
+
+	if err := bs.Pin("1.2.3.4:9981"); err != nil {
+		t.Fatal(err)
+	} else if banned("1.2.3.4") {
+		t.Fatal("pinned peer should be exempt from bans")
+	} else if !banned("1.2.3.5") {
+		t.Fatal("unpinned peers in the subnet should still be banned")
+	}
+
+	if err := bs.Unpin("1.2.3.4:9981"); err != nil {
+		t.Fatal(err)
+	} else if !banned("1.2.3.4") {
+		t.Fatal("unpinned peer should be banned again")
+	}
+}
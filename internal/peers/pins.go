@@ -0,0 +1,166 @@
+package peers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.sia.tech/coreutils/syncer"
+	"go.uber.org/zap"
+)
+
+const (
+	// pinCheckInterval is the interval between checks that a pinned peer is
+	// still connected.
+	pinCheckInterval = 10 * time.Second
+	// minPinBackoff and maxPinBackoff bound the delay between attempts to
+	// redial a pinned peer.
+	minPinBackoff = time.Second
+	maxPinBackoff = 5 * time.Minute
+)
+
+// A PinStore stores the addresses of pinned peers.
+type PinStore interface {
+	Pin(addr string) error
+	Unpin(addr string) error
+	Pins() ([]string, error)
+}
+
+// A Pinner keeps pinned peers connected, redialing them with exponential
+// backoff whenever their connection drops. The syncer does not evict
+// connected peers to make room for others; it refuses new connections once
+// it reaches its limits. Pinned peers are dialed directly, bypassing those
+// limits, and a BanStore exempts them from bans, so a pinned peer that the
+// syncer disconnects for misbehaving is redialed.
+type Pinner struct {
+	store   PinStore
+	connect func(ctx context.Context, addr string) (*syncer.Peer, error)
+	peers   func() []*syncer.Peer
+	log     *zap.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	pins map[string]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// connected returns an existing connection to addr, which may have been
+// established by the peer.
+func (pn *Pinner) connected(addr string) *syncer.Peer {
+	for _, p := range pn.peers() {
+		if p.Addr() == addr || p.ConnAddr == addr {
+			return p
+		}
+	}
+	return nil
+}
+
+func (pn *Pinner) maintain(ctx context.Context, addr string) {
+	log := pn.log.With(zap.String("peer", addr))
+	backoff := minPinBackoff
+	var p *syncer.Peer
+	for {
+		wait := pinCheckInterval
+		if p == nil || p.Err() != nil {
+			if p = pn.connected(addr); p == nil {
+				var err error
+				if p, err = pn.connect(ctx, addr); err != nil {
+					log.Debug("failed to connect to pinned peer", zap.Error(err), zap.Duration("backoff", backoff))
+					p, wait = nil, backoff
+					backoff = min(backoff*2, maxPinBackoff)
+				} else {
+					log.Debug("connected to pinned peer")
+				}
+			}
+			if p != nil {
+				backoff = minPinBackoff
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (pn *Pinner) start(addr string) {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+	if _, ok := pn.pins[addr]; ok || pn.ctx.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(pn.ctx)
+	pn.pins[addr] = cancel
+	pn.wg.Add(1)
+	go func() {
+		defer pn.wg.Done()
+		pn.maintain(ctx, addr)
+	}()
+}
+
+// Pin pins addr, connecting to it if necessary.
+func (pn *Pinner) Pin(addr string) error {
+	if err := pn.store.Pin(addr); err != nil {
+		return err
+	}
+	pn.start(addr)
+	return nil
+}
+
+// Unpin unpins addr. The peer is not disconnected, but it will no longer be
+// redialed.
+func (pn *Pinner) Unpin(addr string) error {
+	if err := pn.store.Unpin(addr); err != nil {
+		return err
+	}
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+	if cancel, ok := pn.pins[addr]; ok {
+		cancel()
+		delete(pn.pins, addr)
+	}
+	return nil
+}
+
+// Pinned reports whether addr is pinned.
+func (pn *Pinner) Pinned(addr string) bool {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+	_, ok := pn.pins[addr]
+	return ok
+}
+
+// Close stops redialing pinned peers.
+func (pn *Pinner) Close() error {
+	pn.cancel()
+	pn.wg.Wait()
+	return nil
+}
+
+// NewPinner returns a Pinner that keeps the peers pinned in store connected.
+// Peers are dialed with connect, and peers returns the currently connected
+// peers so that inbound connections from pinned peers are not duplicated.
+func NewPinner(store PinStore, connect func(ctx context.Context, addr string) (*syncer.Peer, error), peers func() []*syncer.Peer, log *zap.Logger) (*Pinner, error) {
+	pins, err := store.Pins()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pn := &Pinner{
+		store:   store,
+		connect: connect,
+		peers:   peers,
+		log:     log,
+		ctx:     ctx,
+		cancel:  cancel,
+		pins:    make(map[string]context.CancelFunc),
+	}
+	for _, addr := range pins {
+		pn.start(addr)
+	}
+	return pn, nil
+}
@@ -0,0 +1,387 @@
+package peers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.sia.tech/coreutils/syncer"
+)
+
+const (
+	// latencySamples is the number of latency measurements averaged for each
+	// connection.
+	latencySamples = 10
+	// dialHistory is the period for which dial outcomes are retained.
+	dialHistory = time.Hour
+)
+
+// Dial outcomes recorded by the Tracker.
+const (
+	dialSucceeded = "succeeded"
+	dialTimeout   = "timeout"
+	dialRefused   = "refused"
+	dialHandshake = "handshake"
+	dialBanned    = "banned"
+	dialOther     = "other"
+)
+
+// DialStats counts the outcomes of outbound connection attempts. A dial
+// succeeds once the gateway handshake completes. Handshake failures include
+// peers on a different network and peers that are already connected. Banned
+// counts dials skipped because the peer is banned.
+type DialStats struct {
+	Succeeded uint64 `json:"succeeded"`
+	Failed    uint64 `json:"failed"`
+	Timeout   uint64 `json:"timeout"`
+	Refused   uint64 `json:"refused"`
+	Handshake uint64 `json:"handshake"`
+	Banned    uint64 `json:"banned"`
+	Other     uint64 `json:"other"`
+}
+
+type dialOutcome struct {
+	timestamp time.Time
+	outcome   string
+}
+
+// ConnStats contains statistics about a single peer connection. RPCsInFlight
+// counts the RPCs issued to the peer through the Tracker that have not yet
+// returned. Latency is the most recent round-trip time and AverageLatency is
+// the average of the last few measurements. If the most recent probe failed,
+// ProbeError is set.
+type ConnStats struct {
+	ConnectedAt    time.Time
+	BytesRead      uint64
+	BytesWritten   uint64
+	RPCsInFlight   int
+	Latency        time.Duration
+	AverageLatency time.Duration
+	LastProbe      time.Time
+	ProbeError     string
+}
+
+type trackedConn struct {
+	net.Conn
+	t   *Tracker
+	key string
+
+	connectedAt time.Time
+	outbound    bool
+	read        atomic.Uint64
+	written     atomic.Uint64
+	rpcs        atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration // ring buffer of recent measurements
+	probes    int
+	lastProbe time.Time
+	probeErr  string
+	handshook bool
+	closed    bool
+}
+
+func (tc *trackedConn) recordProbe(rtt time.Duration, err error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.lastProbe = time.Now()
+	if err != nil {
+		tc.probeErr = err.Error()
+		return
+	}
+	tc.probeErr = ""
+	if len(tc.latencies) < latencySamples {
+		tc.latencies = append(tc.latencies, rtt)
+	} else {
+		tc.latencies[tc.probes%latencySamples] = rtt
+	}
+	tc.probes++
+}
+
+func (tc *trackedConn) Read(b []byte) (int, error) {
+	n, err := tc.Conn.Read(b)
+	tc.read.Add(uint64(n))
+	return n, err
+}
+
+func (tc *trackedConn) Write(b []byte) (int, error) {
+	n, err := tc.Conn.Write(b)
+	tc.written.Add(uint64(n))
+	return n, err
+}
+
+// SetDeadline implements net.Conn. The syncer clears the deadline of an
+// outbound connection once the gateway handshake succeeds, but closes the
+// connection first if the handshake fails, so this is where a dial is
+// considered successful.
+func (tc *trackedConn) SetDeadline(t time.Time) error {
+	if t.IsZero() && tc.outbound {
+		tc.mu.Lock()
+		if !tc.closed && !tc.handshook {
+			tc.handshook = true
+			tc.t.recordDial(dialSucceeded)
+		}
+		tc.mu.Unlock()
+	}
+	return tc.Conn.SetDeadline(t)
+}
+
+func (tc *trackedConn) Close() error {
+	tc.mu.Lock()
+	if !tc.closed {
+		tc.closed = true
+		tc.t.remove(tc)
+		if tc.outbound && !tc.handshook {
+			tc.t.recordDial(dialHandshake)
+		}
+	}
+	tc.mu.Unlock()
+	return tc.Conn.Close()
+}
+
+type trackedListener struct {
+	net.Listener
+	t *Tracker
+}
+
+func (tl trackedListener) Accept() (net.Conn, error) {
+	conn, err := tl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tl.t.track(conn, false), nil
+}
+
+// A Tracker records statistics for syncer connections. Connections are
+// keyed by their remote address, which matches the ConnAddr of the
+// corresponding syncer.Peer.
+type Tracker struct {
+	dialer net.Dialer
+
+	mu    sync.Mutex
+	conns map[string]*trackedConn
+	dials []dialOutcome // within the last dialHistory
+}
+
+func (t *Tracker) recordDial(outcome string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dials = append(t.dials, dialOutcome{time.Now(), outcome})
+	t.pruneDials()
+}
+
+func (t *Tracker) pruneDials() {
+	cutoff := time.Now().Add(-dialHistory)
+	i := 0
+	for i < len(t.dials) && t.dials[i].timestamp.Before(cutoff) {
+		i++
+	}
+	t.dials = t.dials[i:]
+}
+
+// classifyDialError returns the outcome corresponding to a failed dial.
+func classifyDialError(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return dialTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return dialRefused
+	default:
+		return dialOther
+	}
+}
+
+func (t *Tracker) track(conn net.Conn, outbound bool) net.Conn {
+	tc := &trackedConn{
+		Conn:        conn,
+		t:           t,
+		key:         conn.RemoteAddr().String(),
+		connectedAt: time.Now(),
+		outbound:    outbound,
+	}
+	t.mu.Lock()
+	t.conns[tc.key] = tc
+	t.mu.Unlock()
+	return tc
+}
+
+func (t *Tracker) remove(tc *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns[tc.key] == tc {
+		delete(t.conns, tc.key)
+	}
+}
+
+// Listener wraps l so that accepted connections are tracked.
+func (t *Tracker) Listener(l net.Listener) net.Listener {
+	return trackedListener{Listener: l, t: t}
+}
+
+// DialContext implements syncer.Dialer, tracking outbound connections.
+func (t *Tracker) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := t.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		t.recordDial(classifyDialError(err))
+		return nil, err
+	}
+	return t.track(conn, true), nil
+}
+
+// DialStats returns the outcomes of outbound connection attempts within the
+// last hour.
+func (t *Tracker) DialStats() (stats DialStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneDials()
+	for _, d := range t.dials {
+		switch d.outcome {
+		case dialSucceeded:
+			stats.Succeeded++
+			continue
+		case dialTimeout:
+			stats.Timeout++
+		case dialRefused:
+			stats.Refused++
+		case dialHandshake:
+			stats.Handshake++
+		case dialBanned:
+			stats.Banned++
+		default:
+			stats.Other++
+		}
+		stats.Failed++
+	}
+	return stats
+}
+
+func (t *Tracker) conn(connAddr string) (*trackedConn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, ok := t.conns[connAddr]
+	return tc, ok
+}
+
+// RPC calls fn, which issues an RPC to p, counting the RPC as in flight until
+// fn returns.
+func (t *Tracker) RPC(p *syncer.Peer, fn func() error) error {
+	if tc, ok := t.conn(p.ConnAddr); ok {
+		tc.rpcs.Add(1)
+		defer tc.rpcs.Add(-1)
+	}
+	return fn()
+}
+
+// ConnStats returns the statistics for the connection with the given remote
+// address.
+func (t *Tracker) ConnStats(connAddr string) (ConnStats, bool) {
+	tc, ok := t.conn(connAddr)
+	if !ok {
+		return ConnStats{}, false
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	stats := ConnStats{
+		ConnectedAt:  tc.connectedAt,
+		BytesRead:    tc.read.Load(),
+		BytesWritten: tc.written.Load(),
+		RPCsInFlight: int(tc.rpcs.Load()),
+		LastProbe:    tc.lastProbe,
+		ProbeError:   tc.probeErr,
+	}
+	if len(tc.latencies) > 0 {
+		stats.Latency = tc.latencies[(tc.probes-1)%latencySamples]
+		var sum time.Duration
+		for _, rtt := range tc.latencies {
+			sum += rtt
+		}
+		stats.AverageLatency = sum / time.Duration(len(tc.latencies))
+	}
+	return stats, true
+}
+
+// Probe measures the round-trip time to p using the lightweight ShareNodes
+// RPC and records the result. A peer that does not respond within timeout
+// is recorded as failed.
+func (t *Tracker) Probe(p *syncer.Peer, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	err := t.RPC(p, func() error {
+		_, err := p.ShareNodes(timeout)
+		return err
+	})
+	rtt := time.Since(start)
+
+	if tc, ok := t.conn(p.ConnAddr); ok {
+		tc.recordProbe(rtt, err)
+	}
+	return rtt, err
+}
+
+// ProbePeers probes the peers returned by fn every interval until ctx is
+// canceled. Peers are probed concurrently, so an unresponsive peer only
+// delays its own measurement.
+func (t *Tracker) ProbePeers(ctx context.Context, interval, timeout time.Duration, fn func() []*syncer.Peer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var wg sync.WaitGroup
+		for _, p := range fn() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t.Probe(p, timeout)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// connected reports whether a connection to host is open.
+func (t *Tracker) connected(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.conns {
+		if h, _, err := net.SplitHostPort(key); err == nil && h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerStore wraps ps so that dials skipped because the peer is banned are
+// recorded. The syncer checks whether an address is banned before dialing it
+// and after accepting a connection from it; accepted connections are already
+// tracked, so a banned address without an open connection is a skipped dial.
+func (t *Tracker) PeerStore(ps syncer.PeerStore) syncer.PeerStore {
+	return trackedStore{PeerStore: ps, t: t}
+}
+
+type trackedStore struct {
+	syncer.PeerStore
+	t *Tracker
+}
+
+func (ts trackedStore) Banned(addr string) (bool, error) {
+	banned, err := ts.PeerStore.Banned(addr)
+	if err == nil && banned && !ts.t.connected(addr) {
+		ts.t.recordDial(dialBanned)
+	}
+	return banned, err
+}
+
+// NewTracker returns a new Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		conns: make(map[string]*trackedConn),
+	}
+}
@@ -0,0 +1,102 @@
+package peers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/gateway"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/syncer"
+	"go.sia.tech/coreutils/testutil"
+)
+
+func newTrackedSyncer(t *testing.T, ps syncer.PeerStore) (*syncer.Syncer, *Tracker) {
+	t.Helper()
+	n, genesis := testutil.V2Network()
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(store, tipState)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracker()
+	header := gateway.Header{
+		GenesisID:  genesis.ID(),
+		UniqueID:   gateway.GenerateUniqueID(),
+		NetAddress: l.Addr().String(),
+	}
+	s := syncer.New(tr.Listener(l), cm, tr.PeerStore(ps), header, syncer.WithDialer(tr))
+	go s.Run()
+	t.Cleanup(func() { s.Close() })
+	return s, tr
+}
+
+func TestTrackerRPCsInFlight(t *testing.T) {
+	a, tr := newTrackedSyncer(t, testutil.NewEphemeralPeerStore())
+	b, _ := newTrackedSyncer(t, testutil.NewEphemeralPeerStore())
+	p, err := a.Connect(context.Background(), b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inflight := func() int {
+		t.Helper()
+		stats, ok := tr.ConnStats(p.ConnAddr)
+		if !ok {
+			t.Fatal("connection is not tracked")
+		}
+		return stats.RPCsInFlight
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.RPC(p, func() error {
+			close(started)
+			<-release
+			return errors.New("failed")
+		})
+	}()
+	<-started
+	if n := inflight(); n != 1 {
+		t.Fatalf("expected 1 RPC in flight, got %d", n)
+	}
+	close(release)
+	if err := <-errCh; err == nil || err.Error() != "failed" {
+		t.Fatalf("expected RPC error to be returned, got %v", err)
+	} else if n := inflight(); n != 0 {
+		t.Fatalf("expected no RPCs in flight, got %d", n)
+	}
+
+	if _, err := tr.Probe(p, time.Second); err != nil {
+		t.Fatal(err)
+	} else if n := inflight(); n != 0 {
+		t.Fatalf("expected no RPCs in flight after probe, got %d", n)
+	}
+}
+
+func TestTrackerBannedDials(t *testing.T) {
+	// the syncer tries the peers in its store as soon as it starts
+	ps := NewBanStore(testutil.NewEphemeralPeerStore())
+	if err := ps.AddPeer("127.0.0.2:9981"); err != nil {
+		t.Fatal(err)
+	} else if err := ps.Ban("127.0.0.2", time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	_, tr := newTrackedSyncer(t, ps)
+
+	for start := time.Now(); tr.DialStats().Banned == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("banned dial was not recorded")
+		}
+	}
+	if stats := tr.DialStats(); stats.Banned != 1 || stats.Failed != 1 || stats.Succeeded != 0 {
+		t.Fatalf("expected 1 banned dial, got %+v", stats)
+	}
+}
@@ -0,0 +1,179 @@
+package txpool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/syncer"
+	"go.uber.org/zap"
+)
+
+// ErrPoolFull is returned when adding a transaction set would exceed the
+// pool's size limit.
+var ErrPoolFull = errors.New("txpool is full")
+
+// A ChainManager manages the txpool.
+type ChainManager interface {
+	syncer.ChainManager
+
+	PoolTransactions() []types.Transaction
+	V2PoolTransactions() []types.V2Transaction
+}
+
+// A writeCounter counts the bytes written to it.
+type writeCounter uint64
+
+func (wc *writeCounter) Write(p []byte) (int, error) {
+	*wc += writeCounter(len(p))
+	return len(p), nil
+}
+
+// EncodedSize returns the size of v when encoded.
+func EncodedSize(v types.EncoderTo) uint64 {
+	var wc writeCounter
+	e := types.NewEncoder(&wc)
+	v.EncodeTo(e)
+	e.Flush()
+	return uint64(wc)
+}
+
+// A Limiter wraps a ChainManager, limiting the total encoded size of the
+// transactions added to its pool. It should be passed to the syncer so that
+// transactions relayed by peers are subject to the limit, while transactions
+// added directly to the chain manager, such as those broadcast through the
+// API, are not.
+//
+// The chain manager does not support removing transactions from its pool, so
+// the limit is enforced when a transaction set is added: a set that would
+// exceed the limit is rejected with ErrPoolFull. As the pool drains into
+// blocks, new sets are accepted again. Making room for a better-paying set by
+// evicting the lowest fee-density sets, while sparing their ancestors and
+// local sets, requires coreutils to add a way to remove pool transactions.
+type Limiter struct {
+	ChainManager
+	maxSize uint64
+	log     *zap.Logger
+
+	rejected atomic.Uint64
+
+	mu    sync.Mutex
+	sizes map[types.TransactionID]uint64 // cache of pool transaction sizes
+	full  bool
+}
+
+// poolSize returns the total size of the pool and the IDs of its
+// transactions.
+func (l *Limiter) poolSize() (uint64, map[types.TransactionID]bool) {
+	pooled := make(map[types.TransactionID]bool)
+	var size uint64
+	add := func(id types.TransactionID, v types.EncoderTo) {
+		n, ok := l.sizes[id]
+		if !ok {
+			n = EncodedSize(v)
+			l.sizes[id] = n
+		}
+		pooled[id] = true
+		size += n
+	}
+	for _, txn := range l.ChainManager.PoolTransactions() {
+		add(txn.ID(), txn)
+	}
+	for _, txn := range l.ChainManager.V2PoolTransactions() {
+		add(txn.ID(), txn)
+	}
+	// drop the sizes of transactions that have left the pool
+	for id := range l.sizes {
+		if !pooled[id] {
+			delete(l.sizes, id)
+		}
+	}
+	return size, pooled
+}
+
+// admit checks whether a set containing the given transactions fits within
+// the limit. It must be called with l.mu held.
+func (l *Limiter) admit(ids []types.TransactionID, txns []types.EncoderTo) error {
+	size, pooled := l.poolSize()
+	var added uint64
+	for i, id := range ids {
+		if !pooled[id] {
+			n := EncodedSize(txns[i])
+			l.sizes[id] = n
+			added += n
+		}
+	}
+	// only report the pool as no longer full once it has drained somewhat,
+	// so that a mix of large and small sets does not flood the log
+	if l.full && size+added <= l.maxSize*3/4 {
+		l.full = false
+		l.log.Info("txpool is no longer full; accepting transactions from peers", zap.Uint64("size", size), zap.Uint64("maxSize", l.maxSize))
+	}
+	if added == 0 || size+added <= l.maxSize {
+		return nil
+	}
+	l.rejected.Add(1)
+	if !l.full {
+		l.full = true
+		l.log.Info("txpool is full; rejecting transactions from peers", zap.Uint64("size", size), zap.Uint64("maxSize", l.maxSize))
+	}
+	l.log.Debug("rejected transaction set", zap.Stringer("id", ids[len(ids)-1]), zap.Uint64("size", added))
+	return ErrPoolFull
+}
+
+// AddPoolTransactions implements syncer.ChainManager.
+func (l *Limiter) AddPoolTransactions(txns []types.Transaction) (bool, error) {
+	ids := make([]types.TransactionID, len(txns))
+	encs := make([]types.EncoderTo, len(txns))
+	for i, txn := range txns {
+		ids[i], encs[i] = txn.ID(), txn
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(txns) > 0 {
+		if err := l.admit(ids, encs); err != nil {
+			return false, err
+		}
+	}
+	return l.ChainManager.AddPoolTransactions(txns)
+}
+
+// AddV2PoolTransactions implements syncer.ChainManager.
+func (l *Limiter) AddV2PoolTransactions(basis types.ChainIndex, txns []types.V2Transaction) (bool, error) {
+	ids := make([]types.TransactionID, len(txns))
+	encs := make([]types.EncoderTo, len(txns))
+	for i, txn := range txns {
+		ids[i], encs[i] = txn.ID(), txn
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(txns) > 0 {
+		if err := l.admit(ids, encs); err != nil {
+			return false, err
+		}
+	}
+	return l.ChainManager.AddV2PoolTransactions(basis, txns)
+}
+
+// MaxSize returns the pool's size limit in bytes.
+func (l *Limiter) MaxSize() uint64 {
+	return l.maxSize
+}
+
+// Rejected returns the number of transaction sets rejected because the pool
+// was full.
+func (l *Limiter) Rejected() uint64 {
+	return l.rejected.Load()
+}
+
+// NewLimiter returns a Limiter that rejects transaction sets once the pool
+// of cm exceeds maxSize bytes.
+func NewLimiter(cm ChainManager, maxSize uint64, log *zap.Logger) *Limiter {
+	return &Limiter{
+		ChainManager: cm,
+		maxSize:      maxSize,
+		log:          log,
+		sizes:        make(map[types.TransactionID]uint64),
+	}
+}
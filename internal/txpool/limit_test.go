@@ -0,0 +1,59 @@
+package txpool
+
+import (
+	"errors"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	"go.uber.org/zap"
+)
+
+func newTestChain(t *testing.T) *chain.Manager {
+	t.Helper()
+	n, genesis := testutil.V2Network()
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(store, tipState)
+	testutil.MineBlocks(t, cm, types.VoidAddress, 1)
+	return cm
+}
+
+func TestLimiter(t *testing.T) {
+	cm := newTestChain(t)
+	l := NewLimiter(cm, 1000, zap.NewNop())
+
+	txn := func(b byte) types.V2Transaction {
+		data := make([]byte, 600)
+		data[0] = b
+		return types.V2Transaction{ArbitraryData: data}
+	}
+	first, second := txn(1), txn(2)
+	if EncodedSize(first) <= 600 {
+		t.Fatal("expected the encoded size to include the transaction's fields")
+	}
+
+	if _, err := l.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{first}); err != nil {
+		t.Fatal(err)
+	} else if _, err := l.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{second}); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("expected ErrPoolFull, got %v", err)
+	} else if l.Rejected() != 1 {
+		t.Fatalf("expected 1 rejected set, got %d", l.Rejected())
+	}
+
+	// a set that is already pooled adds nothing, so it is never rejected
+	if known, err := l.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{first}); err != nil {
+		t.Fatal(err)
+	} else if !known {
+		t.Fatal("expected the set to be known")
+	}
+
+	// the limit doesn't apply to transactions added to the chain manager
+	// directly
+	if _, err := cm.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{second}); err != nil {
+		t.Fatal(err)
+	}
+}
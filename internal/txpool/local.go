@@ -0,0 +1,499 @@
+package txpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/syncer"
+	"go.uber.org/zap"
+)
+
+const (
+	// localPeerInterval is the interval between checks for newly connected
+	// peers, which are sent any pending local transaction sets.
+	localPeerInterval = 10 * time.Second
+	// localRelayTimeout is the maximum amount of time to wait for a peer to
+	// accept a rebroadcast transaction set.
+	localRelayTimeout = 10 * time.Second
+	// localExpiry is the amount of time after which a local transaction set
+	// that has not confirmed is abandoned.
+	localExpiry = 72 * time.Hour
+	// localRetention is the amount of time that confirmed and abandoned sets
+	// are retained after being added.
+	localRetention = 7 * 24 * time.Hour
+	// maxLocalSearch is the maximum number of blocks searched for confirmed
+	// transactions after a tip change.
+	maxLocalSearch = 1000
+)
+
+// Statuses of a local transaction set.
+const (
+	LocalPending   = "pending"
+	LocalConfirmed = "confirmed"
+	LocalAbandoned = "abandoned"
+)
+
+// A LocalSet is a transaction set that was broadcast through the node. Its ID
+// is the ID of the last transaction in the set. A confirmed set has
+// ConfirmedHeight set to the height at which its last transaction confirmed,
+// and an abandoned set has Error set to the reason it was abandoned. Basis is
+// the index at which the set's v2 proofs were last updated.
+type LocalSet struct {
+	ID              types.TransactionID   `json:"id"`
+	Status          string                `json:"status"`
+	ConfirmedHeight uint64                `json:"confirmedHeight,omitempty"`
+	Error           string                `json:"error,omitempty"`
+	Added           time.Time             `json:"added"`
+	LastBroadcast   time.Time             `json:"lastBroadcast"`
+	Basis           types.ChainIndex      `json:"basis"`
+	Transactions    []types.Transaction   `json:"transactions,omitempty"`
+	V2Transactions  []types.V2Transaction `json:"v2Transactions,omitempty"`
+}
+
+// localSet is a LocalSet along with the heights at which its transactions
+// confirmed.
+type localSet struct {
+	LocalSet
+	Confirmed map[types.TransactionID]uint64 `json:"confirmed"`
+}
+
+func (ls *localSet) ids() (ids []types.TransactionID) {
+	for _, txn := range ls.Transactions {
+		ids = append(ids, txn.ID())
+	}
+	for _, txn := range ls.V2Transactions {
+		ids = append(ids, txn.ID())
+	}
+	return
+}
+
+// persistLocal is the on-disk format of a Local's sets.
+type persistLocal struct {
+	Tip  types.ChainIndex `json:"tip"`
+	Sets []*localSet      `json:"sets"`
+}
+
+// A LocalChainManager is a ChainManager that can also notify of tip changes
+// and update transaction sets across them.
+type LocalChainManager interface {
+	ChainManager
+
+	OnReorg(fn func(types.ChainIndex)) (cancel func())
+	UpdateV2TransactionSet(txns []types.V2Transaction, from, to types.ChainIndex) ([]types.V2Transaction, error)
+}
+
+// Local tracks transaction sets broadcast through the node and rebroadcasts
+// them until they confirm or expire. Pending sets are re-added to the pool
+// and relayed whenever the tip changes, so that they survive reorgs, and are
+// sent to newly connected peers so that they survive network partitions.
+//
+// The syncer only relays v2 transactions, so v1 transactions are re-added to
+// the pool but are not sent to peers.
+type Local struct {
+	chain LocalChainManager
+	peers func() []*syncer.Peer
+	path  string
+	log   *zap.Logger
+
+	tipCh  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	tip  types.ChainIndex // last index at which confirmations were checked
+	sets []*localSet
+	seen map[string]bool // ConnAddrs of peers sent the pending sets
+}
+
+func setID(txns []types.Transaction, v2txns []types.V2Transaction) types.TransactionID {
+	if len(v2txns) > 0 {
+		return v2txns[len(v2txns)-1].ID()
+	}
+	return txns[len(txns)-1].ID()
+}
+
+// save writes the sets to disk. It must be called with l.mu held.
+func (l *Local) save() error {
+	js, err := json.MarshalIndent(persistLocal{Tip: l.tip, Sets: l.sets}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, js, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *Local) load() error {
+	js, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var p persistLocal
+	if err := json.Unmarshal(js, &p); err != nil {
+		return fmt.Errorf("failed to decode %v: %w", l.path, err)
+	}
+	l.tip, l.sets = p.Tip, p.Sets
+	for _, ls := range l.sets {
+		if ls.Confirmed == nil {
+			ls.Confirmed = make(map[types.TransactionID]uint64)
+		}
+	}
+	return nil
+}
+
+// blocksSince returns the IDs of the transactions in blocks that were
+// reverted and applied between prev and tip, along with the height of each
+// applied transaction.
+func (l *Local) blocksSince(prev, tip types.ChainIndex) (reverted map[types.TransactionID]bool, applied map[types.TransactionID]uint64) {
+	reverted = make(map[types.TransactionID]bool)
+	applied = make(map[types.TransactionID]uint64)
+	addIDs := func(b types.Block, fn func(types.TransactionID)) {
+		for _, txn := range b.Transactions {
+			fn(txn.ID())
+		}
+		for _, txn := range b.V2Transactions() {
+			fn(txn.ID())
+		}
+	}
+	// walk both chains back to their common ancestor
+	for i := 0; tip != prev && i < maxLocalSearch; i++ {
+		if tip.Height >= prev.Height {
+			b, ok := l.chain.Block(tip.ID)
+			if !ok {
+				break
+			}
+			height := tip.Height
+			addIDs(b, func(id types.TransactionID) { applied[id] = height })
+			tip = types.ChainIndex{ID: b.ParentID, Height: tip.Height - 1}
+		} else {
+			b, ok := l.chain.Block(prev.ID)
+			if !ok {
+				break
+			}
+			addIDs(b, func(id types.TransactionID) { reverted[id] = true })
+			prev = types.ChainIndex{ID: b.ParentID, Height: prev.Height - 1}
+		}
+	}
+	return
+}
+
+// updateConfirmations updates the status of each set after a tip change. It
+// must be called with l.mu held.
+func (l *Local) updateConfirmations(tip types.ChainIndex) {
+	reverted, applied := l.blocksSince(l.tip, tip)
+	l.tip = tip
+	for _, ls := range l.sets {
+		if ls.Status == LocalAbandoned {
+			continue
+		}
+		for id := range ls.Confirmed {
+			if reverted[id] {
+				delete(ls.Confirmed, id)
+			}
+		}
+		confirmed := true
+		for _, id := range ls.ids() {
+			if height, ok := applied[id]; ok {
+				ls.Confirmed[id] = height
+			} else if _, ok := ls.Confirmed[id]; !ok {
+				confirmed = false
+			}
+		}
+		if confirmed {
+			ls.Status, ls.ConfirmedHeight = LocalConfirmed, ls.Confirmed[ls.ID]
+		} else if ls.Status == LocalConfirmed {
+			l.log.Info("local transaction set reverted", zap.Stringer("id", ls.ID))
+			ls.Status, ls.ConfirmedHeight = LocalPending, 0
+		}
+	}
+}
+
+// abandon marks ls as abandoned. It must be called with l.mu held.
+func (l *Local) abandon(ls *localSet, reason string) {
+	l.log.Info("abandoned local transaction set", zap.Stringer("id", ls.ID), zap.String("reason", reason))
+	ls.Status, ls.Error = LocalAbandoned, reason
+}
+
+// repool re-adds the unconfirmed transactions of each pending set to the
+// pool, updating v2 proofs to tip. It must be called with l.mu held.
+func (l *Local) repool(tip types.ChainIndex) {
+	for _, ls := range l.sets {
+		if ls.Status != LocalPending {
+			continue
+		} else if time.Since(ls.Added) > localExpiry {
+			l.abandon(ls, "expired")
+			continue
+		}
+
+		var txns []types.Transaction
+		for _, txn := range ls.Transactions {
+			if _, ok := ls.Confirmed[txn.ID()]; !ok {
+				txns = append(txns, txn)
+			}
+		}
+		if len(txns) > 0 {
+			if _, err := l.chain.AddPoolTransactions(txns); err != nil {
+				l.abandon(ls, err.Error())
+				continue
+			}
+		}
+
+		if len(ls.V2Transactions) == 0 {
+			continue
+		} else if ls.Basis != tip {
+			// update the whole set, so that ephemeral elements created by
+			// confirmed parents are updated as well
+			updated, err := l.chain.UpdateV2TransactionSet(ls.V2Transactions, ls.Basis, tip)
+			if err != nil {
+				l.abandon(ls, fmt.Sprintf("failed to update transaction set basis: %v", err))
+				continue
+			}
+			ls.Basis, ls.V2Transactions = tip, updated
+		}
+		var v2txns []types.V2Transaction
+		for _, txn := range ls.V2Transactions {
+			if _, ok := ls.Confirmed[txn.ID()]; !ok {
+				v2txns = append(v2txns, txn)
+			}
+		}
+		if _, err := l.chain.AddV2PoolTransactions(ls.Basis, v2txns); err != nil {
+			l.abandon(ls, err.Error())
+		}
+	}
+
+	// prune old sets that are no longer pending
+	l.sets = slices.DeleteFunc(l.sets, func(ls *localSet) bool {
+		return ls.Status != LocalPending && time.Since(ls.Added) > localRetention
+	})
+}
+
+// A relaySet is a snapshot of the unconfirmed v2 transactions of a pending
+// set.
+type relaySet struct {
+	id     types.TransactionID
+	basis  types.ChainIndex
+	v2txns []types.V2Transaction
+}
+
+// pending returns the unconfirmed v2 transactions of each pending set. It must
+// be called with l.mu held.
+func (l *Local) pending() (sets []relaySet) {
+	for _, ls := range l.sets {
+		if ls.Status != LocalPending || len(ls.V2Transactions) == 0 {
+			continue
+		}
+		var v2txns []types.V2Transaction
+		for _, txn := range ls.V2Transactions {
+			if _, ok := ls.Confirmed[txn.ID()]; !ok {
+				v2txns = append(v2txns, txn)
+			}
+		}
+		sets = append(sets, relaySet{ls.ID, ls.Basis, v2txns})
+	}
+	return
+}
+
+// relay sends sets to peers. It must be called without l.mu held, since each
+// peer may take up to localRelayTimeout to respond.
+func (l *Local) relay(peers []*syncer.Peer, sets []relaySet) {
+	for _, rs := range sets {
+		var wg sync.WaitGroup
+		for _, p := range peers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := p.RelayV2TransactionSet(rs.basis, rs.v2txns, localRelayTimeout); err != nil {
+					l.log.Debug("failed to relay local transaction set", zap.Stringer("id", rs.id), zap.Stringer("peer", p), zap.Error(err))
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// relayed records that sets were sent to peers. It must be called with l.mu
+// held.
+func (l *Local) relayed(peers []*syncer.Peer, sets []relaySet) {
+	for _, p := range peers {
+		l.seen[p.ConnAddr] = true
+	}
+	if len(peers) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, rs := range sets {
+		for _, ls := range l.sets {
+			if ls.ID == rs.id {
+				ls.LastBroadcast = now
+			}
+		}
+	}
+}
+
+// rebroadcast handles a tip change, updating confirmations and resending
+// pending sets to all peers.
+func (l *Local) rebroadcast() {
+	l.mu.Lock()
+	tip := l.chain.Tip()
+	l.updateConfirmations(tip)
+	l.repool(tip)
+	sets := l.pending()
+	l.mu.Unlock()
+
+	peers := l.peers()
+	l.relay(peers, sets)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.relayed(peers, sets)
+	if err := l.save(); err != nil {
+		l.log.Error("failed to save local transaction sets", zap.Error(err))
+	}
+}
+
+// relayNewPeers sends pending sets to peers that connected since the last
+// broadcast.
+func (l *Local) relayNewPeers() {
+	connected := make(map[string]bool)
+	var peers []*syncer.Peer
+	l.mu.Lock()
+	for _, p := range l.peers() {
+		connected[p.ConnAddr] = true
+		if !l.seen[p.ConnAddr] {
+			peers = append(peers, p)
+		}
+	}
+	// forget disconnected peers, so that they are resent the sets when they
+	// reconnect
+	for addr := range l.seen {
+		if !connected[addr] {
+			delete(l.seen, addr)
+		}
+	}
+	sets := l.pending()
+	l.mu.Unlock()
+
+	l.relay(peers, sets)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.relayed(peers, sets)
+}
+
+func (l *Local) run() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(localPeerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-l.tipCh:
+			l.rebroadcast()
+		case <-ticker.C:
+			l.relayNewPeers()
+		}
+	}
+}
+
+// AddLocal starts tracking a transaction set that has been added to the pool
+// and broadcast. For v2 transactions, basis is the index at which their
+// proofs are valid.
+func (l *Local) AddLocal(basis types.ChainIndex, txns []types.Transaction, v2txns []types.V2Transaction) error {
+	if len(txns) == 0 && len(v2txns) == 0 {
+		return errors.New("transaction set is empty")
+	}
+	id := setID(txns, v2txns)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ls := range l.sets {
+		if ls.ID == id {
+			return nil
+		}
+	}
+	// the set's transactions are not modified, but they are retained
+	// indefinitely, so copy them to avoid aliasing the caller's slices
+	ls := &localSet{
+		LocalSet: LocalSet{
+			ID:             id,
+			Status:         LocalPending,
+			Added:          time.Now(),
+			LastBroadcast:  time.Now(),
+			Basis:          basis,
+			Transactions:   slices.Clone(txns),
+			V2Transactions: slices.Clone(v2txns),
+		},
+		Confirmed: make(map[types.TransactionID]uint64),
+	}
+	l.sets = append(l.sets, ls)
+	return l.save()
+}
+
+// LocalSets returns the tracked transaction sets, ordered by when they were
+// added.
+func (l *Local) LocalSets() []LocalSet {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sets := make([]LocalSet, len(l.sets))
+	for i, ls := range l.sets {
+		sets[i] = ls.LocalSet
+	}
+	return sets
+}
+
+// Close stops rebroadcasting local transaction sets.
+func (l *Local) Close() error {
+	l.cancel()
+	l.wg.Wait()
+	return nil
+}
+
+// NewLocal returns a Local that persists its sets to path. Pending sets are
+// relayed to the peers returned by peers.
+func NewLocal(cm LocalChainManager, path string, peers func() []*syncer.Peer, log *zap.Logger) (*Local, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Local{
+		chain:  cm,
+		peers:  peers,
+		path:   path,
+		log:    log,
+		tipCh:  make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+		seen:   make(map[string]bool),
+	}
+	if err := l.load(); err != nil {
+		cancel()
+		return nil, err
+	} else if l.tip == (types.ChainIndex{}) {
+		l.tip = cm.Tip()
+	}
+
+	// the callback must not block the chain manager, so tip changes are
+	// handled by the run loop
+	stop := cm.OnReorg(func(types.ChainIndex) {
+		select {
+		case l.tipCh <- struct{}{}:
+		default:
+		}
+	})
+	context.AfterFunc(ctx, stop)
+	// check for confirmations that happened while the node was offline
+	l.tipCh <- struct{}{}
+	l.wg.Add(1)
+	go l.run()
+	return l, nil
+}
@@ -0,0 +1,126 @@
+package txpool
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/gateway"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/syncer"
+	"go.sia.tech/coreutils/testutil"
+	"go.uber.org/zap"
+)
+
+func TestLocalRelayUnlocked(t *testing.T) {
+	cm := newTestChain(t)
+	genesis, _ := cm.BestIndex(0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sy := syncer.New(l, cm, testutil.NewEphemeralPeerStore(), gateway.Header{
+		GenesisID:  genesis.ID,
+		UniqueID:   gateway.GenerateUniqueID(),
+		NetAddress: l.Addr().String(),
+	})
+	go sy.Run()
+	defer sy.Close()
+
+	// a peer that accepts relayed transaction sets but never reads them
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+	relaying := make(chan struct{}, 1)
+	transport := make(chan *gateway.Transport, 1)
+	go func() {
+		conn, err := pl.Accept()
+		if err != nil {
+			return
+		}
+		// keep the receive window small, so that the relay stalls once the
+		// peer stops reading
+		conn.(*net.TCPConn).SetReadBuffer(4096)
+		tr, err := gateway.Accept(conn, gateway.Header{
+			GenesisID:  genesis.ID,
+			UniqueID:   gateway.GenerateUniqueID(),
+			NetAddress: pl.Addr().String(),
+		})
+		if err != nil {
+			return
+		}
+		transport <- tr
+		for {
+			s, err := tr.AcceptStream()
+			if err != nil {
+				return
+			}
+			// close other RPCs, so that their requests don't stall the
+			// connection
+			if id, err := s.ReadID(); err != nil || id != types.NewSpecifier("RelayV2Txns") {
+				s.Close()
+				continue
+			}
+			select {
+			case relaying <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	if _, err := sy.Connect(context.Background(), pl.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	tr := <-transport
+
+	// track sets that are together larger than the connection's buffers
+	path := filepath.Join(t.TempDir(), "local.json")
+	local, err := NewLocal(cm, path, func() []*syncer.Peer { return nil }, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 8
+	for i := range n {
+		data := make([]byte, 1<<20)
+		data[0] = byte(i)
+		if err := local.AddLocal(cm.Tip(), nil, []types.V2Transaction{{ArbitraryData: data}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local.Close()
+
+	// reopening rebroadcasts the sets
+	local, err = NewLocal(cm, path, sy.Peers, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	// disconnect the peer before closing local, so that the relay fails
+	// instead of timing out
+	defer tr.Close()
+	select {
+	case <-relaying:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sets were not relayed")
+	}
+
+	// the sets are visible while the relay is waiting on the peer
+	done := make(chan []LocalSet, 1)
+	go func() { done <- local.LocalSets() }()
+	select {
+	case sets := <-done:
+		if len(sets) != n {
+			t.Fatalf("expected %v sets, got %v", n, len(sets))
+		}
+		for _, ls := range sets {
+			if ls.Status != LocalPending {
+				t.Fatalf("expected set %v to be pending, got %v (%v)", ls.ID, ls.Status, ls.Error)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LocalSets blocked on the relay")
+	}
+}
@@ -0,0 +1,239 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"go.sia.tech/core/types"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrInvalidBackup is returned when restoring a wallet from a backup
+	// that cannot be decoded, or that describes an invalid wallet.
+	ErrInvalidBackup = errors.New("invalid wallet backup")
+	// ErrWrongPassphrase is returned when a backup, or a seed encrypted with
+	// a wallet passphrase, cannot be decrypted with the supplied passphrase,
+	// or has been modified.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// A backupState is the plaintext of a wallet backup. It holds what cannot be
+// rebuilt by scanning the chain: the seed phrase and the number of addresses
+// issued from it, or the watched addresses, along with the wallet's multisig
+// addresses, settings, reservations, labels, and memos. Height is the height
+// from which the chain is scanned for the wallet's elements once it is
+// restored.
+type backupState struct {
+	Type         string                         `json:"type"`
+	Phrase       string                         `json:"phrase,omitempty"`
+	Issued       uint64                         `json:"issued,omitempty"`
+	Watched      []types.Address                `json:"watched,omitempty"`
+	Multisig     []MultisigAddress              `json:"multisig,omitempty"`
+	GapLimit     int                            `json:"gapLimit,omitempty"`
+	Strategy     string                         `json:"strategy,omitempty"`
+	Height       uint64                         `json:"height"`
+	Reservations map[types.Hash256]time.Time    `json:"reservations,omitempty"`
+	Labels       map[types.Address]string       `json:"labels,omitempty"`
+	Memos        map[types.TransactionID]string `json:"memos,omitempty"`
+}
+
+// Backup returns a backup of the wallet, encrypted with passphrase, from
+// which Manager.Restore recreates it. Elements, events, and anything else
+// found by scanning the chain are left out, so the backup of a seed wallet
+// is only a few hundred bytes. A wallet whose seed is encrypted with a
+// wallet passphrase must be unlocked to be backed up.
+func (w *Wallet) Backup(passphrase string) ([]byte, error) {
+	w.mu.Lock()
+	if !w.exists {
+		w.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	b := backupState{
+		Type:         w.state.Type,
+		GapLimit:     w.state.GapLimit,
+		Strategy:     w.state.Strategy,
+		Height:       w.state.Birth,
+		Reservations: maps.Clone(w.state.Reservations),
+		Labels:       maps.Clone(w.state.Labels),
+		Memos:        maps.Clone(w.state.Memos),
+	}
+	for _, addr := range w.state.Addresses {
+		if w.state.Type == TypeWatch {
+			b.Watched = append(b.Watched, addr.Address)
+		} else if !addr.Lookahead {
+			b.Issued++
+		}
+	}
+	for _, ms := range w.state.Multisig {
+		ms.Used = false
+		b.Multisig = append(b.Multisig, ms)
+		b.Height = min(b.Height, ms.Height)
+	}
+	// a seed encrypted with a passphrase can only be read while the wallet
+	// is unlocked, from memory
+	readFile := w.state.Type == TypeSeed && !w.passphrase
+	if w.passphrase {
+		if w.seed == nil {
+			w.mu.Unlock()
+			return nil, ErrLocked
+		}
+		b.Phrase = string(w.phrase)
+	}
+	w.mu.Unlock()
+
+	// expired reservations no longer prevent anything from being spent
+	maps.DeleteFunc(b.Reservations, func(_ types.Hash256, t time.Time) bool { return time.Now().After(t) })
+	if readFile {
+		phrase, err := readSeed(w.seedPath(), w.password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed: %w", err)
+		}
+		b.Phrase = phrase
+	}
+	js, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := seal(js, passphrase)
+	clear(js)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(sf, "", "  ")
+}
+
+// decodeBackup decrypts and validates a backup made by Backup.
+func decodeBackup(backup []byte, passphrase string) (backupState, error) {
+	var sf sealedFile
+	if err := json.Unmarshal(backup, &sf); err != nil {
+		return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	js, ok, err := sf.open(passphrase)
+	if err != nil {
+		return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	} else if !ok {
+		return backupState{}, ErrWrongPassphrase
+	}
+	defer clear(js)
+	var b backupState
+	if err := json.Unmarshal(js, &b); err != nil {
+		return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	switch b.Type {
+	case TypeSeed:
+		if err := CheckPhrase(b.Phrase); err != nil {
+			return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		} else if b.Issued == 0 || len(b.Watched) > 0 {
+			return backupState{}, fmt.Errorf("%w: seed wallets must issue addresses, and cannot watch them", ErrInvalidBackup)
+		}
+	case TypeWatch:
+		if b.Phrase != "" || b.Issued > 0 || len(b.Multisig) > 0 || b.GapLimit > 0 {
+			return backupState{}, fmt.Errorf("%w: watch-only wallets have no seed", ErrInvalidBackup)
+		}
+	default:
+		return backupState{}, fmt.Errorf("%w: unknown wallet type %q", ErrInvalidBackup, b.Type)
+	}
+	if b.GapLimit < 0 || b.GapLimit > MaxGapLimit {
+		return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, ErrInvalidGapLimit)
+	} else if err := checkStrategy(b.Strategy); err != nil {
+		return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	for _, ms := range b.Multisig {
+		if ms.SpendPolicy.Address() != ms.Address {
+			return backupState{}, fmt.Errorf("%w: multisig address %v does not match its policy", ErrInvalidBackup, ms.Address)
+		}
+	}
+	for _, label := range b.Labels {
+		if err := checkLabel(label, MaxLabelLength); err != nil {
+			return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		}
+	}
+	for _, memo := range b.Memos {
+		if err := checkLabel(memo, MaxMemoLength); err != nil {
+			return backupState{}, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		}
+	}
+	return b, nil
+}
+
+// restore creates the wallet from a backup made by Backup. Nothing is
+// written unless the backup is decrypted and valid, and nothing is left
+// behind if writing it fails.
+func (w *Wallet) restore(backup []byte, passphrase string) error {
+	b, err := decodeBackup(backup, passphrase)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exists {
+		return ErrExists
+	}
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return err
+	}
+	w.state = persistWallet{
+		Type:            b.Type,
+		Tip:             w.scanStart(b.Height),
+		SiacoinElements: make(map[types.SiacoinOutputID]types.SiacoinElement),
+		SiafundElements: make(map[types.SiafundOutputID]types.SiafundElement),
+		Reservations:    b.Reservations,
+		Labels:          b.Labels,
+		Memos:           b.Memos,
+		GapLimit:        b.GapLimit,
+		Strategy:        b.Strategy,
+		Birth:           b.Height,
+	}
+	if w.state.Reservations == nil {
+		w.state.Reservations = make(map[types.Hash256]time.Time)
+	}
+	w.owned = make(map[types.Address]int)
+	w.multisig = nil
+	w.synced = false
+	if b.Type == TypeSeed {
+		w.seed = new([32]byte)
+		if err := cwallet.SeedFromPhrase(w.seed, b.Phrase); err != nil {
+			w.seed = nil
+			return fmt.Errorf("%w: %w", ErrInvalidSeed, err)
+		}
+		for range b.Issued {
+			w.addAddress()
+		}
+		w.extendLookahead()
+	}
+	for _, addr := range b.Watched {
+		if !w.owns(addr) {
+			w.watchAddress(addr)
+		}
+	}
+	for _, ms := range b.Multisig {
+		if _, ok := w.multisig[ms.Address]; !ok {
+			w.addMultisig(ms)
+		}
+	}
+	fail := func(err error) error {
+		w.seed, w.state = nil, persistWallet{}
+		w.owned, w.multisig = make(map[types.Address]int), nil
+		os.RemoveAll(w.dir)
+		return err
+	}
+	if err := w.save(); err != nil {
+		return fail(fmt.Errorf("failed to save wallet: %w", err))
+	} else if b.Type == TypeSeed {
+		if err := writeSeed(w.seedPath(), b.Phrase, w.password, false); err != nil {
+			return fail(fmt.Errorf("failed to save seed: %w", err))
+		}
+	}
+	w.exists = true
+	w.log.Info("restored wallet", zap.String("type", b.Type), zap.Int("addresses", len(w.state.Addresses)), zap.Uint64("height", b.Height))
+	w.trigger()
+	return nil
+}
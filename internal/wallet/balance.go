@@ -0,0 +1,116 @@
+package wallet
+
+import (
+	"cmp"
+	"slices"
+
+	"go.sia.tech/core/types"
+)
+
+// A BalanceBucket is the value and number of the siacoin elements in part of
+// the wallet's balance.
+type BalanceBucket struct {
+	Value    types.Currency `json:"value"`
+	Elements int            `json:"elements"`
+}
+
+func (b *BalanceBucket) add(sce types.SiacoinElement) {
+	b.Value = b.Value.Add(sce.SiacoinOutput.Value)
+	b.Elements++
+}
+
+// A HeightBucket is a BalanceBucket whose elements become spendable in the
+// block at Height.
+type HeightBucket struct {
+	Height uint64 `json:"height"`
+	BalanceBucket
+}
+
+// A DetailedBalance breaks the wallet's confirmed siacoins, Total, down by
+// when they can be spent, as of Index. Each element is counted in exactly
+// one bucket, so the buckets sum to Total, which is the sum of a Balance's
+// Siacoins and Immature. The elements of a watch-only wallet are WatchOnly,
+// since it has no keys to spend them. Otherwise, elements are Immature until
+// their maturity height, such as miner payouts; Timelocked until their
+// address's timelock; and Reserved while reserved by a constructed
+// transaction or spent by one in the txpool. The rest are Spendable. As with
+// Balance, the elements of multisig addresses are not counted.
+type DetailedBalance struct {
+	Index      types.ChainIndex `json:"index"`
+	Total      BalanceBucket    `json:"total"`
+	Spendable  BalanceBucket    `json:"spendable"`
+	Immature   []HeightBucket   `json:"immature"`
+	Timelocked []HeightBucket   `json:"timelocked"`
+	Reserved   BalanceBucket    `json:"reserved"`
+	WatchOnly  BalanceBucket    `json:"watchOnly"`
+}
+
+// addHeight adds sce to the bucket of buckets at height.
+func addHeight(buckets []HeightBucket, height uint64, sce types.SiacoinElement) []HeightBucket {
+	i, ok := slices.BinarySearchFunc(buckets, height, func(b HeightBucket, h uint64) int { return cmp.Compare(b.Height, h) })
+	if !ok {
+		buckets = slices.Insert(buckets, i, HeightBucket{Height: height})
+	}
+	buckets[i].add(sce)
+	return buckets
+}
+
+// timelock returns the height at which the unlock conditions of addr allow
+// it to be spent, or zero if they do not have a timelock. It must be called
+// with w.mu held.
+func (w *Wallet) timelock(addr types.Address) uint64 {
+	sp := w.state.Addresses[w.owned[addr]].SpendPolicy
+	if sp == nil {
+		return 0
+	} else if uc, ok := sp.Type.(types.PolicyTypeUnlockConditions); ok {
+		return uc.Timelock
+	}
+	return 0
+}
+
+// DetailedBalance returns the wallet's confirmed siacoins, broken down by
+// when they can be spent.
+func (w *Wallet) DetailedBalance() (DetailedBalance, error) {
+	inPool := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range w.chain.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.ParentID] = true
+		}
+	}
+	for _, txn := range w.chain.V2PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.Parent.ID] = true
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return DetailedBalance{}, ErrNotFound
+	}
+	b := DetailedBalance{
+		Index:      w.state.Tip,
+		Immature:   []HeightBucket{},
+		Timelocked: []HeightBucket{},
+	}
+	next := w.state.Tip.Height + 1
+	for id, sce := range w.state.SiacoinElements {
+		if !w.owns(sce.SiacoinOutput.Address) {
+			continue
+		}
+		b.Total.add(sce)
+		switch {
+		case w.state.Type == TypeWatch:
+			b.WatchOnly.add(sce)
+		case sce.MaturityHeight > next:
+			b.Immature = addHeight(b.Immature, sce.MaturityHeight, sce)
+		case w.timelock(sce.SiacoinOutput.Address) > next:
+			b.Timelocked = addHeight(b.Timelocked, w.timelock(sce.SiacoinOutput.Address), sce)
+		case inPool[id] || w.reserved(types.Hash256(id)):
+			b.Reserved.add(sce)
+		default:
+			b.Spendable.add(sce)
+		}
+	}
+	return b, nil
+}
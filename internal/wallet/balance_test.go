@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/testutil"
+	"go.uber.org/zap"
+)
+
+// checkBucketSums checks that the buckets of b sum to its total, and that
+// the total matches the wallet's balance.
+func checkBucketSums(t *testing.T, w *Wallet, b DetailedBalance) {
+	t.Helper()
+	var sum BalanceBucket
+	add := func(bb BalanceBucket) {
+		sum.Value = sum.Value.Add(bb.Value)
+		sum.Elements += bb.Elements
+	}
+	add(b.Spendable)
+	add(b.Reserved)
+	add(b.WatchOnly)
+	for _, hb := range append(b.Immature, b.Timelocked...) {
+		if hb.Elements == 0 {
+			t.Fatalf("empty bucket at height %v", hb.Height)
+		}
+		add(hb.BalanceBucket)
+	}
+	if sum != b.Total {
+		t.Fatalf("buckets sum to %v in %v elements, but the total is %v in %v", sum.Value, sum.Elements, b.Total.Value, b.Total.Elements)
+	}
+	bal, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	} else if headline := bal.Siacoins.Add(bal.Immature); !b.Total.Value.Equals(headline) {
+		t.Fatalf("expected a total of %v, got %v", headline, b.Total.Value)
+	}
+}
+
+func TestDetailedBalanceSum(t *testing.T) {
+	cm, w := newTestWallet(t)
+	addr := w.state.Addresses[0].Address
+	n := cm.TipState().Network
+
+	// miner payouts maturing at successive heights, some of them already
+	// mature
+	testutil.MineBlocks(t, cm, addr, int(n.MaturityDelay)+3)
+	waitSynced(t, cm, w)
+
+	// one element reserved, and another spent by a transaction in the pool
+	sces, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sces) < 3 {
+		t.Fatalf("expected at least 3 spendable elements, got %v", len(sces))
+	}
+	if err := w.Reserve([]types.Hash256{types.Hash256(sces[len(sces)-1].ID)}, reservationDuration); err != nil {
+		t.Fatal(err)
+	}
+	txn, err := w.SendSiacoins(types.VoidAddress, types.Siacoins(1), nil, StrategyLargest, VersionAuto, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, cm, txn)
+	if err := w.Release(txn.Reserved); err != nil {
+		t.Fatal(err) // counted as reserved while it is in the pool
+	}
+
+	// the wallet's own addresses have no timelock, so one is added directly
+	w.mu.Lock()
+	uc := types.StandardUnlockConditions(types.PublicKey{1})
+	uc.Timelock = w.state.Tip.Height + 10
+	policy := types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(uc)}
+	locked := Address{Index: uint64(len(w.state.Addresses)), Address: policy.Address(), SpendPolicy: &policy}
+	w.owned[locked.Address] = len(w.state.Addresses)
+	w.state.Addresses = append(w.state.Addresses, locked)
+	w.state.SiacoinElements[types.SiacoinOutputID{1}] = types.SiacoinElement{
+		ID:            types.SiacoinOutputID{1},
+		SiacoinOutput: types.SiacoinOutput{Address: locked.Address, Value: types.Siacoins(7)},
+	}
+	w.mu.Unlock()
+
+	b, err := w.DetailedBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBucketSums(t, w, b)
+	switch {
+	case b.Spendable.Elements == 0:
+		t.Fatal("expected spendable elements")
+	case len(b.Immature) < 2:
+		t.Fatalf("expected immature elements at several heights, got %v", len(b.Immature))
+	case len(b.Timelocked) != 1 || b.Timelocked[0].Height != uc.Timelock:
+		t.Fatalf("expected a timelocked element at %v, got %v", uc.Timelock, b.Timelocked)
+	case b.Reserved.Elements != 2:
+		t.Fatalf("expected 2 reserved elements, got %v", b.Reserved.Elements)
+	case b.WatchOnly.Elements != 0:
+		t.Fatalf("expected no watch-only elements, got %v", b.WatchOnly.Elements)
+	}
+
+	// a watch-only wallet's elements are all watch-only
+	m, err := NewManager(cm, t.TempDir(), "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.CreateWatch("watch", []types.Address{addr}, 0); err != nil {
+		t.Fatal(err)
+	}
+	ww, err := m.Wallet("watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(t, cm, ww)
+	b, err = ww.DetailedBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBucketSums(t, ww, b)
+	if b.WatchOnly != b.Total || b.Total.Elements == 0 {
+		t.Fatalf("expected all %v elements to be watch-only, got %v", b.Total.Elements, b.WatchOnly.Elements)
+	}
+}
@@ -0,0 +1,161 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+var (
+	// ErrNotPending is returned when a transaction to bump is not in the
+	// pool, e.g. because it has already been confirmed.
+	ErrNotPending = errors.New("transaction is not in the pool")
+	// ErrNotWalletTransaction is returned when a transaction to bump was not
+	// created by the wallet.
+	ErrNotWalletTransaction = errors.New("transaction was not created by the wallet")
+	// ErrNoChangeOutput is returned when a transaction to bump has no
+	// unspent outputs to the wallet for a child transaction to spend.
+	ErrNoChangeOutput = errors.New("transaction has no unspent outputs to the wallet")
+	// ErrFeeSufficient is returned when a transaction to bump already pays
+	// the requested fee rate.
+	ErrFeeSufficient = errors.New("transaction already pays the fee rate")
+)
+
+// A Bump is a child transaction that raises the effective fee rate of its
+// pending parent by spending the parent's outputs to the wallet. FeeRate is
+// the combined fee rate of the two transactions, which must be broadcast
+// together, parent first.
+type Bump struct {
+	Parent  Transaction
+	Child   Transaction
+	FeeRate types.Currency
+}
+
+// BumpFee constructs and signs a transaction spending the unspent outputs to
+// the wallet of the pending transaction with id, paying enough fee that the
+// two together pay feeRate, or the chain's recommended fee if it is zero.
+// Every input of the parent must belong to the wallet. The parent itself is
+// left untouched, since the pool does not accept conflicting transactions,
+// so it may still confirm on its own; the child remains valid once it does.
+//
+// The spent outputs are reserved; if the child is not broadcast, they should
+// be released with Release.
+func (w *Wallet) BumpFee(id types.TransactionID, feeRate types.Currency) (Bump, error) {
+	cs := w.chain.TipState()
+	if feeRate.IsZero() {
+		feeRate = w.chain.RecommendedFee()
+	}
+	pool := w.chain.PoolTransactions()
+	v2pool := w.chain.V2PoolTransactions()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return Bump{}, err
+	}
+
+	spent := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range pool {
+		for _, sci := range txn.SiacoinInputs {
+			spent[sci.ParentID] = true
+		}
+	}
+	for _, txn := range v2pool {
+		for _, sci := range txn.SiacoinInputs {
+			spent[sci.Parent.ID] = true
+		}
+	}
+
+	// find the parent, and check that it spends only the wallet's elements
+	parent := Transaction{ID: id, Basis: cs.Index}
+	var ours bool
+	var outputs []types.SiacoinElement
+	var parentWeight uint64
+	for _, txn := range pool {
+		if txn.ID() != id {
+			continue
+		}
+		parent.Transaction = &txn
+		ours = len(txn.SiacoinInputs)+len(txn.SiafundInputs) > 0
+		for _, sci := range txn.SiacoinInputs {
+			ours = ours && w.owns(sci.UnlockConditions.UnlockHash())
+		}
+		for _, sfi := range txn.SiafundInputs {
+			ours = ours && w.owns(sfi.UnlockConditions.UnlockHash())
+		}
+		for i, sco := range txn.SiacoinOutputs {
+			if w.owns(sco.Address) && !spent[txn.SiacoinOutputID(i)] {
+				outputs = append(outputs, types.SiacoinElement{ID: txn.SiacoinOutputID(i), SiacoinOutput: sco})
+			}
+		}
+		parent.Fee = txn.TotalFees()
+		parentWeight = cs.TransactionWeight(txn)
+		break
+	}
+	for _, txn := range v2pool {
+		if parent.Transaction != nil || txn.ID() != id {
+			continue
+		}
+		parent.V2Transaction = &txn
+		ours = len(txn.SiacoinInputs)+len(txn.SiafundInputs) > 0
+		for _, sci := range txn.SiacoinInputs {
+			ours = ours && w.owns(sci.Parent.SiacoinOutput.Address)
+		}
+		for _, sfi := range txn.SiafundInputs {
+			ours = ours && w.owns(sfi.Parent.SiafundOutput.Address)
+		}
+		for i, sco := range txn.SiacoinOutputs {
+			if sce := txn.EphemeralSiacoinOutput(i); w.owns(sco.Address) && !spent[sce.ID] {
+				outputs = append(outputs, sce)
+			}
+		}
+		parent.Fee = txn.MinerFee
+		parentWeight = cs.V2TransactionWeight(txn)
+		break
+	}
+	if parent.Transaction == nil && parent.V2Transaction == nil {
+		return Bump{}, fmt.Errorf("%w: %v", ErrNotPending, id)
+	} else if !ours {
+		return Bump{}, fmt.Errorf("%w: %v", ErrNotWalletTransaction, id)
+	} else if len(outputs) == 0 {
+		return Bump{}, fmt.Errorf("%w: %v", ErrNoChangeOutput, id)
+	} else if parent.Fee.Cmp(feeRate.Mul64(parentWeight)) >= 0 {
+		return Bump{}, fmt.Errorf("%w: %v pays %v/weight", ErrFeeSufficient, id, parent.Fee.Div64(parentWeight))
+	}
+
+	sp := spend{v2: parent.V2Transaction != nil, inputs: outputs}
+	var inputSum types.Currency
+	for _, sce := range outputs {
+		inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+	}
+	sp.outputs = []types.SiacoinOutput{{Address: w.state.Addresses[0].Address, Value: inputSum}}
+	total := feeRate.Mul64(parentWeight + sp.weight(w, cs))
+	sp.fee = total.Sub(parent.Fee)
+	if inputSum.Cmp(sp.fee) <= 0 {
+		return Bump{}, fmt.Errorf("%w: %v of outputs to the wallet, %v needed", ErrNotEnoughFunds, inputSum, sp.fee)
+	}
+	// dust is added to the fee, since the child only exists to pay it
+	sp.outputs[0].Value = inputSum.Sub(sp.fee)
+	if dust := feeRate.Mul64(spend{v2: sp.v2, inputs: sp.inputs[:1]}.weight(w, cs)); sp.outputs[0].Value.Cmp(dust) < 0 {
+		sp.fee, sp.outputs = inputSum, nil
+	}
+
+	sp.feeRate = feeRate
+	child, err := w.finish(sp, cs, true, reservationDuration)
+	if err != nil {
+		return Bump{}, err
+	}
+	child.Basis = cs.Index
+	var childWeight uint64
+	if child.V2Transaction != nil {
+		childWeight = cs.V2TransactionWeight(*child.V2Transaction)
+	} else {
+		childWeight = cs.TransactionWeight(*child.Transaction)
+	}
+	return Bump{
+		Parent:  parent,
+		Child:   child,
+		FeeRate: parent.Fee.Add(child.Fee).Div64(parentWeight + childWeight),
+	}, nil
+}
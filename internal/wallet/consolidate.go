@@ -0,0 +1,161 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.sia.tech/core/types"
+)
+
+const (
+	// DefaultConsolidateInputs is the number of elements consolidated by
+	// each transaction if no maximum is given.
+	DefaultConsolidateInputs = 100
+	// maxStandardWeight is the weight above which a transaction is
+	// considered non-standard, and may not be relayed by all peers.
+	maxStandardWeight = 32000
+)
+
+var (
+	// ErrNothingToConsolidate is returned when the wallet has fewer than two
+	// siacoin elements worth consolidating.
+	ErrNothingToConsolidate = errors.New("not enough outputs to consolidate")
+	// ErrUneconomical is returned when the fee of a consolidation would
+	// exceed the value of the elements it consolidates.
+	ErrUneconomical = errors.New("consolidation fee exceeds the value recovered")
+)
+
+// A ConsolidateResult describes the transactions that consolidate the
+// wallet's smallest siacoin elements. Inputs is the number of elements
+// spent, and Remaining the number of spendable elements the wallet will have
+// once the transactions confirm. FeeRate is the fee rate of the
+// transactions, and Savings is the fee saved by spending the consolidated
+// output rather than its inputs, at that rate.
+type ConsolidateResult struct {
+	Transactions []Transaction
+	Inputs       int
+	Remaining    int
+	Fee          types.Currency
+	FeeRate      types.Currency
+	Savings      types.Currency
+}
+
+// Consolidate constructs and signs transactions sending the wallet's
+// smallest spendable siacoin elements, at most maxInputs at a time, to its
+// first address. Each transaction is kept below the standard weight.
+// Elements worth less than the fee to spend them are left alone. If target
+// is zero, one transaction is constructed; otherwise transactions are
+// constructed until the wallet would have fewer than target spendable
+// elements, spending no more than necessary, or none are left to
+// consolidate. The spent elements are reserved; if the transactions are not
+// broadcast, they should be released with Release.
+func (w *Wallet) Consolidate(maxInputs, target int) (ConsolidateResult, error) {
+	return w.consolidate(maxInputs, target, false)
+}
+
+// PreviewConsolidate returns the result that Consolidate would return with
+// the same arguments, as of the current tip and fee rate, without signing
+// the transactions or reserving their inputs, like PreviewSendSiacoins.
+func (w *Wallet) PreviewConsolidate(maxInputs, target int) (ConsolidateResult, error) {
+	return w.consolidate(maxInputs, target, true)
+}
+
+// consolidate implements Consolidate and, if preview is set,
+// PreviewConsolidate.
+func (w *Wallet) consolidate(maxInputs, target int, preview bool) (ConsolidateResult, error) {
+	if maxInputs <= 0 {
+		maxInputs = DefaultConsolidateInputs
+	}
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return ConsolidateResult{}, err
+	}
+	dest := w.state.Addresses[0].Address
+	sces := w.spendableSiacoins()
+	res := ConsolidateResult{Remaining: len(sces), FeeRate: feeRate}
+	var candidates []types.SiacoinElement
+	for _, sce := range slices.Backward(sces) {
+		dust := feeRate.Mul64(spend{v2: newSpend(cs).v2, inputs: []types.SiacoinElement{sce}}.weight(w, cs))
+		if sce.SiacoinOutput.Value.Cmp(dust) > 0 {
+			candidates = append(candidates, sce)
+		}
+	}
+
+	for len(candidates) >= 2 && (target == 0 || res.Remaining >= target) {
+		sp := newSpend(cs)
+		sp.feeRate = feeRate
+		sp.outputs = []types.SiacoinOutput{{Address: dest}}
+		n := min(maxInputs, len(candidates))
+		if target != 0 {
+			// spending n elements into one removes n-1 of them
+			n = min(n, res.Remaining-target+2)
+		}
+		var inputSum types.Currency
+		for _, sce := range candidates[:n] {
+			sp.inputs = append(sp.inputs, sce)
+			sp.outputs[0].Value = inputSum.Add(sce.SiacoinOutput.Value) // v1 currencies are variable-length
+			if sp.weight(w, cs) > maxStandardWeight {
+				sp.inputs = sp.inputs[:len(sp.inputs)-1]
+				break
+			}
+			inputSum = sp.outputs[0].Value
+		}
+		if len(sp.inputs) < 2 {
+			break
+		}
+		sp.outputs[0].Value = inputSum
+		sp.fee = feeRate.Mul64(sp.weight(w, cs))
+		if sp.fee.Cmp(inputSum) >= 0 {
+			if len(res.Transactions) == 0 {
+				return ConsolidateResult{}, fmt.Errorf("%w: fee of %v for %d outputs worth %v", ErrUneconomical, sp.fee, len(sp.inputs), inputSum)
+			}
+			break
+		}
+		sp.outputs[0].Value = inputSum.Sub(sp.fee)
+
+		// the transactions spend disjoint elements, so a preview does not
+		// need to reserve them to keep them from being chosen twice
+		var txn Transaction
+		var err error
+		if preview {
+			txn = w.build(sp)
+		} else {
+			txn, err = w.finish(sp, cs, true, reservationDuration)
+		}
+		if err != nil {
+			for _, txn := range res.Transactions {
+				for _, id := range txn.Reserved {
+					delete(w.state.Reservations, id)
+				}
+			}
+			return ConsolidateResult{}, err
+		}
+		// spending the consolidated output instead of its inputs saves the
+		// weight of every input but one
+		spendInputs := spend{v2: sp.v2, inputs: sp.inputs}.weight(w, cs)
+		spendOutput := spend{v2: sp.v2, inputs: sp.inputs[:1]}.weight(w, cs)
+		res.Savings = res.Savings.Add(feeRate.Mul64(spendInputs - spendOutput))
+		res.Transactions = append(res.Transactions, txn)
+		res.Inputs += len(sp.inputs)
+		res.Remaining -= len(sp.inputs) - 1
+		res.Fee = res.Fee.Add(sp.fee)
+		// an external signer signs with w.mu released, in which time other
+		// transactions may have reserved or spent the remaining candidates
+		candidates = slices.DeleteFunc(candidates[len(sp.inputs):], func(sce types.SiacoinElement) bool {
+			_, ok := w.state.SiacoinElements[sce.ID]
+			return !ok || w.reserved(types.Hash256(sce.ID))
+		})
+		if target == 0 {
+			break
+		}
+	}
+	if len(res.Transactions) == 0 {
+		return ConsolidateResult{}, ErrNothingToConsolidate
+	}
+	return res, nil
+}
@@ -0,0 +1,334 @@
+package wallet
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	cwallet "go.sia.tech/coreutils/wallet"
+)
+
+// ErrEventNotFound is returned when the wallet has no event with the
+// requested ID.
+var ErrEventNotFound = errors.New("event not found")
+
+// An Event records a change to the wallet's balance: a transaction that
+// spends or creates its elements, or a payout, such as a miner payout,
+// siafund claim, or contract resolution. Type is one of the coreutils wallet
+// event types. The ID of a transaction event is the transaction's ID; the ID
+// of a payout is the ID of the siacoin element it created, and
+// TransactionID, if any, is the transaction that caused it. Payouts cannot
+// be spent until MaturityHeight. Addresses are the wallet's addresses whose
+// elements the event spends or creates. Labels are the labels of those
+// addresses, and Memo is the memo of the transaction that caused the event;
+// both are added when the event is returned, so that changing a label or
+// memo applies to every event.
+type Event struct {
+	ID             types.Hash256        `json:"id"`
+	Index          types.ChainIndex     `json:"index"`
+	Timestamp      time.Time            `json:"timestamp"`
+	Type           string               `json:"type"`
+	TransactionID  *types.TransactionID `json:"transactionID,omitempty"`
+	MaturityHeight uint64               `json:"maturityHeight"`
+	SiacoinInflow  types.Currency       `json:"siacoinInflow"`
+	SiacoinOutflow types.Currency       `json:"siacoinOutflow"`
+	SiafundInflow  uint64               `json:"siafundInflow"`
+	SiafundOutflow uint64               `json:"siafundOutflow"`
+	Addresses      []types.Address      `json:"addresses,omitempty"`
+	Labels         []string             `json:"labels,omitempty"`
+	Memo           string               `json:"memo,omitempty"`
+}
+
+// involve adds addr to the addresses involved in ev.
+func (ev *Event) involve(addr types.Address) {
+	if !slices.Contains(ev.Addresses, addr) {
+		ev.Addresses = append(ev.Addresses, addr)
+	}
+}
+
+// appliedEvents returns the wallet's events in the block applied by cau. It
+// must be called with w.mu held.
+func (w *Wallet) appliedEvents(cau chain.ApplyUpdate) (events []Event) {
+	index, timestamp := cau.State.Index, cau.Block.Timestamp
+	sces := make(map[types.SiacoinOutputID]types.SiacoinElement)
+	for _, sced := range cau.SiacoinElementDiffs() {
+		sces[sced.SiacoinElement.ID] = sced.SiacoinElement
+	}
+	sfes := make(map[types.SiafundOutputID]types.SiafundElement)
+	for _, sfed := range cau.SiafundElementDiffs() {
+		sfes[sfed.SiafundElement.ID] = sfed.SiafundElement
+	}
+
+	// payouts are only added once, even if an output appears more than once
+	// below, and empty payouts, such as a claim with no accrued revenue, are
+	// skipped
+	added := make(map[types.Hash256]bool)
+	addPayout := func(id types.SiacoinOutputID, typ string, txid *types.TransactionID) {
+		sce, ok := sces[id]
+		if !ok || !w.tracks(sce.SiacoinOutput.Address) || sce.SiacoinOutput.Value.IsZero() || added[types.Hash256(id)] {
+			return
+		}
+		added[types.Hash256(id)] = true
+		events = append(events, Event{
+			ID:             types.Hash256(id),
+			Index:          index,
+			Timestamp:      timestamp,
+			Type:           typ,
+			TransactionID:  txid,
+			MaturityHeight: sce.MaturityHeight,
+			SiacoinInflow:  sce.SiacoinOutput.Value,
+			Addresses:      []types.Address{sce.SiacoinOutput.Address},
+		})
+	}
+	addTransaction := func(ev Event) {
+		if ev.SiacoinInflow.IsZero() && ev.SiacoinOutflow.IsZero() && ev.SiafundInflow == 0 && ev.SiafundOutflow == 0 {
+			return
+		}
+		ev.Index, ev.Timestamp, ev.MaturityHeight = index, timestamp, index.Height
+		events = append(events, ev)
+	}
+
+	spentSC := func(id types.SiacoinOutputID) (types.SiacoinOutput, bool) {
+		sce, ok := sces[id]
+		return sce.SiacoinOutput, ok
+	}
+	spentSF := func(id types.SiafundOutputID) (types.SiafundOutput, bool) {
+		sfe, ok := sfes[id]
+		return sfe.SiafundOutput, ok
+	}
+	for _, txn := range cau.Block.Transactions {
+		ev := w.v1TransactionEvent(txn, spentSC, spentSF)
+		addTransaction(ev)
+		for _, sfi := range txn.SiafundInputs {
+			addPayout(sfi.ParentID.ClaimOutputID(), cwallet.EventTypeSiafundClaim, ev.TransactionID)
+		}
+	}
+	for _, txn := range cau.Block.V2Transactions() {
+		ev := w.v2TransactionEvent(txn)
+		addTransaction(ev)
+		for _, sfi := range txn.SiafundInputs {
+			addPayout(sfi.Parent.ID.V2ClaimOutputID(), cwallet.EventTypeSiafundClaim, ev.TransactionID)
+		}
+	}
+
+	for _, fced := range cau.FileContractElementDiffs() {
+		if !fced.Resolved {
+			continue
+		}
+		fce := fced.FileContractElement
+		if fced.Valid {
+			for i := range fce.FileContract.ValidProofOutputs {
+				addPayout(fce.ID.ValidOutputID(i), cwallet.EventTypeV1ContractResolution, nil)
+			}
+		} else {
+			for i := range fce.FileContract.MissedProofOutputs {
+				addPayout(fce.ID.MissedOutputID(i), cwallet.EventTypeV1ContractResolution, nil)
+			}
+		}
+	}
+	for _, fced := range cau.V2FileContractElementDiffs() {
+		if fced.Resolution == nil {
+			continue
+		}
+		id := fced.V2FileContractElement.ID
+		addPayout(id.V2HostOutputID(), cwallet.EventTypeV2ContractResolution, nil)
+		addPayout(id.V2RenterOutputID(), cwallet.EventTypeV2ContractResolution, nil)
+	}
+
+	bid := cau.Block.ID()
+	for i := range cau.Block.MinerPayouts {
+		addPayout(bid.MinerOutputID(i), cwallet.EventTypeMinerPayout, nil)
+	}
+	addPayout(bid.FoundationOutputID(), cwallet.EventTypeFoundationSubsidy, nil)
+	return events
+}
+
+// v1TransactionEvent returns the event of txn, whose flows are empty if it
+// does not involve the wallet. v1 inputs do not include their parents, so
+// the outputs they spend are looked up with sco and sfo. It must be called
+// with w.mu held.
+func (w *Wallet) v1TransactionEvent(txn types.Transaction, sco func(types.SiacoinOutputID) (types.SiacoinOutput, bool), sfo func(types.SiafundOutputID) (types.SiafundOutput, bool)) Event {
+	txid := txn.ID()
+	ev := Event{ID: types.Hash256(txid), Type: cwallet.EventTypeV1Transaction, TransactionID: &txid}
+	for _, sci := range txn.SiacoinInputs {
+		if parent, ok := sco(sci.ParentID); ok && w.tracks(parent.Address) {
+			ev.SiacoinOutflow = ev.SiacoinOutflow.Add(parent.Value)
+			ev.involve(parent.Address)
+		}
+	}
+	for _, out := range txn.SiacoinOutputs {
+		if w.tracks(out.Address) {
+			ev.SiacoinInflow = ev.SiacoinInflow.Add(out.Value)
+			ev.involve(out.Address)
+		}
+	}
+	for _, sfi := range txn.SiafundInputs {
+		if parent, ok := sfo(sfi.ParentID); ok && w.tracks(parent.Address) {
+			ev.SiafundOutflow += parent.Value
+			ev.involve(parent.Address)
+		}
+	}
+	for _, out := range txn.SiafundOutputs {
+		if w.tracks(out.Address) {
+			ev.SiafundInflow += out.Value
+			ev.involve(out.Address)
+		}
+	}
+	return ev
+}
+
+// v2TransactionEvent returns the event of txn, whose flows are empty if it
+// does not involve the wallet. It must be called with w.mu held.
+func (w *Wallet) v2TransactionEvent(txn types.V2Transaction) Event {
+	txid := txn.ID()
+	ev := Event{ID: types.Hash256(txid), Type: cwallet.EventTypeV2Transaction, TransactionID: &txid}
+	for _, sci := range txn.SiacoinInputs {
+		if w.tracks(sci.Parent.SiacoinOutput.Address) {
+			ev.SiacoinOutflow = ev.SiacoinOutflow.Add(sci.Parent.SiacoinOutput.Value)
+			ev.involve(sci.Parent.SiacoinOutput.Address)
+		}
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		if w.tracks(sco.Address) {
+			ev.SiacoinInflow = ev.SiacoinInflow.Add(sco.Value)
+			ev.involve(sco.Address)
+		}
+	}
+	for _, sfi := range txn.SiafundInputs {
+		if w.tracks(sfi.Parent.SiafundOutput.Address) {
+			ev.SiafundOutflow += sfi.Parent.SiafundOutput.Value
+			ev.involve(sfi.Parent.SiafundOutput.Address)
+		}
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		if w.tracks(sfo.Address) {
+			ev.SiafundInflow += sfo.Value
+			ev.involve(sfo.Address)
+		}
+	}
+	return ev
+}
+
+// revertEvents removes the events of the block reverted by cru, which are
+// the last events in the wallet. It must be called with w.mu held.
+func (w *Wallet) revertEvents(cru chain.RevertUpdate) {
+	reverted := types.ChainIndex{ID: cru.Block.ID(), Height: cru.State.Index.Height + 1}
+	n := len(w.state.Events)
+	for n > 0 && w.state.Events[n-1].Index == reverted {
+		n--
+	}
+	w.state.Events = w.state.Events[:n]
+}
+
+// Events returns up to limit of the wallet's events, oldest first, skipping
+// the first offset, along with the total number of events. If label is not
+// empty, only the events involving an address with that label are returned
+// and counted. Events recorded before addresses were added to them involve
+// no addresses until the wallet is rescanned.
+func (w *Wallet) Events(offset, limit int, label string) ([]Event, int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return nil, 0, ErrNotFound
+	}
+	events := w.state.Events
+	if label != "" {
+		events = nil
+		for _, ev := range w.state.Events {
+			if slices.ContainsFunc(ev.Addresses, func(addr types.Address) bool { return w.state.Labels[addr] == label }) {
+				events = append(events, ev)
+			}
+		}
+	}
+	total := len(events)
+	events = events[min(offset, total):]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	page := make([]Event, len(events))
+	for i, ev := range events {
+		page[i] = w.annotate(ev)
+	}
+	return page, total, nil
+}
+
+// Event returns the wallet event with id.
+func (w *Wallet) Event(id types.Hash256) (Event, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return Event{}, ErrNotFound
+	}
+	for _, ev := range w.state.Events {
+		if ev.ID == id {
+			return w.annotate(ev), nil
+		}
+	}
+	return Event{}, ErrEventNotFound
+}
+
+// UnconfirmedEvents returns the events of the transactions in the txpool
+// that spend or create the wallet's elements, parents first. The pool is
+// read on each call, so a transaction's unconfirmed event is replaced by its
+// confirmed one once it is mined, and disappears if it is evicted.
+// Transactions the wallet has already recorded as confirmed are skipped.
+// Unconfirmed events have no Index or Timestamp, and their MaturityHeight is
+// the height of the next block; payouts, such as siafund claims, are only
+// recorded once confirmed.
+func (w *Wallet) UnconfirmedEvents() ([]Event, error) {
+	pool := w.chain.PoolTransactions()
+	v2pool := w.chain.V2PoolTransactions()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return nil, ErrNotFound
+	}
+
+	confirmed := make(map[types.Hash256]bool)
+	for _, ev := range w.state.Events {
+		confirmed[ev.ID] = true
+	}
+	// v1 inputs may spend outputs created in the pool
+	scos := make(map[types.SiacoinOutputID]types.SiacoinOutput)
+	sfos := make(map[types.SiafundOutputID]types.SiafundOutput)
+	for _, txn := range pool {
+		for i, sco := range txn.SiacoinOutputs {
+			scos[txn.SiacoinOutputID(i)] = sco
+		}
+		for i, sfo := range txn.SiafundOutputs {
+			sfos[txn.SiafundOutputID(i)] = sfo
+		}
+	}
+	spentSC := func(id types.SiacoinOutputID) (types.SiacoinOutput, bool) {
+		if sce, ok := w.state.SiacoinElements[id]; ok {
+			return sce.SiacoinOutput, true
+		}
+		sco, ok := scos[id]
+		return sco, ok
+	}
+	spentSF := func(id types.SiafundOutputID) (types.SiafundOutput, bool) {
+		if sfe, ok := w.state.SiafundElements[id]; ok {
+			return sfe.SiafundOutput, true
+		}
+		sfo, ok := sfos[id]
+		return sfo, ok
+	}
+
+	events := []Event{}
+	add := func(ev Event) {
+		if confirmed[ev.ID] || (ev.SiacoinInflow.IsZero() && ev.SiacoinOutflow.IsZero() && ev.SiafundInflow == 0 && ev.SiafundOutflow == 0) {
+			return
+		}
+		ev.MaturityHeight = w.state.Tip.Height + 1
+		events = append(events, w.annotate(ev))
+	}
+	for _, txn := range pool {
+		add(w.v1TransactionEvent(txn, spentSC, spentSF))
+	}
+	for _, txn := range v2pool {
+		add(w.v2TransactionEvent(txn))
+	}
+	return events, nil
+}
@@ -0,0 +1,151 @@
+package wallet
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// A fundKey identifies a request to fund a transaction: the ID of the
+// transaction before it was funded, the amount, and the coin selection
+// strategy.
+type fundKey struct {
+	id       types.TransactionID
+	v2       bool
+	amount   types.Currency
+	strategy string
+}
+
+// A fundedTransaction is the result of funding a transaction. It is returned
+// again for the same request as long as its inputs remain reserved.
+type fundedTransaction struct {
+	txn      types.Transaction
+	v2txn    types.V2Transaction
+	basis    types.ChainIndex
+	reserved []types.Hash256
+}
+
+// selectFunding selects spendable siacoin elements not already spent by the
+// transaction, in the order chosen by strategy, until they cover amount,
+// returning the elements and the change. The caller's amount includes the
+// fee, so branch-and-bound selection looks for elements whose change is
+// worth less than the fee to spend it at feeRate. It must be called with
+// w.mu held.
+func (w *Wallet) selectFunding(cs consensus.State, feeRate, amount types.Currency, v2 bool, strategy string, spent map[types.SiacoinOutputID]bool) ([]types.SiacoinElement, types.Currency, error) {
+	strategy, err := w.strategy(strategy)
+	if err != nil {
+		return nil, types.ZeroCurrency, err
+	}
+	sces := slices.DeleteFunc(w.spendableSiacoins(), func(sce types.SiacoinElement) bool { return spent[sce.ID] })
+	if len(sces) > 0 {
+		dust := feeRate.Mul64(spend{v2: v2, inputs: sces[:1]}.weight(w, cs))
+		sces, _ = orderCoins(strategy, sces, amount, types.ZeroCurrency, dust, w.rng)
+	}
+	var selected []types.SiacoinElement
+	var inputSum types.Currency
+	for _, sce := range sces {
+		if inputSum.Cmp(amount) >= 0 {
+			break
+		}
+		selected = append(selected, sce)
+		inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+	}
+	if inputSum.Cmp(amount) < 0 {
+		return nil, types.ZeroCurrency, fmt.Errorf("%w: %v spendable, %v needed", ErrNotEnoughFunds, inputSum, amount)
+	}
+	return selected, inputSum.Sub(amount), nil
+}
+
+// FundTransaction adds siacoin inputs worth at least amount to txn, and a
+// change output to the wallet's first address if necessary. The inputs are
+// selected by strategy, or the wallet's default strategy if it is empty, and
+// are reserved for d; they must be signed before the transaction is
+// broadcast. Funding the same transaction with the same amount and strategy
+// again returns the same result, as long as its inputs are still reserved.
+func (w *Wallet) FundTransaction(txn types.Transaction, amount types.Currency, strategy string, d time.Duration) (types.Transaction, error) {
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return types.Transaction{}, err
+	}
+	key := fundKey{id: txn.ID(), amount: amount, strategy: strategy}
+	if ft, ok := w.funded[key]; ok && w.allReserved(ft.reserved) {
+		return ft.txn, w.reserve(ft.reserved, d)
+	}
+
+	spent := make(map[types.SiacoinOutputID]bool)
+	for _, sci := range txn.SiacoinInputs {
+		spent[sci.ParentID] = true
+	}
+	selected, change, err := w.selectFunding(cs, feeRate, amount, false, strategy, spent)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	txn.SiacoinInputs = slices.Clone(txn.SiacoinInputs)
+	txn.SiacoinOutputs = slices.Clone(txn.SiacoinOutputs)
+	var reserved []types.Hash256
+	for _, sce := range selected {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			ParentID:         sce.ID,
+			UnlockConditions: w.unlockConditions(sce.SiacoinOutput.Address),
+		})
+		reserved = append(reserved, types.Hash256(sce.ID))
+	}
+	if !change.IsZero() {
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Address: w.state.Addresses[0].Address, Value: change})
+	}
+	if err := w.reserve(reserved, d); err != nil {
+		return types.Transaction{}, err
+	}
+	w.funded[key] = fundedTransaction{txn: txn, reserved: reserved}
+	return txn, nil
+}
+
+// FundV2Transaction is like FundTransaction, but for v2 transactions. The
+// returned basis is the index at which the added inputs' proofs are valid.
+func (w *Wallet) FundV2Transaction(txn types.V2Transaction, amount types.Currency, strategy string, d time.Duration) (types.ChainIndex, types.V2Transaction, error) {
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return types.ChainIndex{}, types.V2Transaction{}, err
+	}
+	key := fundKey{id: txn.ID(), v2: true, amount: amount, strategy: strategy}
+	if ft, ok := w.funded[key]; ok && w.allReserved(ft.reserved) {
+		return ft.basis, ft.v2txn, w.reserve(ft.reserved, d)
+	}
+
+	spent := make(map[types.SiacoinOutputID]bool)
+	for _, sci := range txn.SiacoinInputs {
+		spent[sci.Parent.ID] = true
+	}
+	selected, change, err := w.selectFunding(cs, feeRate, amount, true, strategy, spent)
+	if err != nil {
+		return types.ChainIndex{}, types.V2Transaction{}, err
+	}
+	txn = txn.DeepCopy()
+	var reserved []types.Hash256
+	for _, sce := range selected {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+			Parent:          sce.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: w.policy(sce.SiacoinOutput.Address)},
+		})
+		reserved = append(reserved, types.Hash256(sce.ID))
+	}
+	if !change.IsZero() {
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Address: w.state.Addresses[0].Address, Value: change})
+	}
+	if err := w.reserve(reserved, d); err != nil {
+		return types.ChainIndex{}, types.V2Transaction{}, err
+	}
+	w.funded[key] = fundedTransaction{v2txn: txn, basis: w.state.Tip, reserved: reserved}
+	return w.state.Tip, txn, nil
+}
@@ -0,0 +1,122 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+// MaxGapLimit is the largest gap limit a wallet can be configured with.
+const MaxGapLimit = 1000
+
+// ErrInvalidGapLimit is returned when a gap limit is negative or exceeds
+// MaxGapLimit, or is set for a watch-only wallet.
+var ErrInvalidGapLimit = errors.New("invalid gap limit")
+
+// Settings are a wallet's configurable settings. GapLimit is the number of
+// addresses beyond the highest used one that a seed wallet derives and
+// watches, so that outputs sent to addresses derived elsewhere, such as by
+// another wallet restored from the same seed, are found. Zero disables the
+// lookahead. Strategy is the coin selection strategy used when a request
+// does not specify one; if it is empty, StrategyLargest is used.
+type Settings struct {
+	GapLimit int    `json:"gapLimit"`
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// Settings returns the wallet's settings.
+func (w *Wallet) Settings() (Settings, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return Settings{}, ErrNotFound
+	}
+	return Settings{GapLimit: w.state.GapLimit, Strategy: w.state.Strategy}, nil
+}
+
+// UpdateSettings replaces the wallet's settings. Raising the gap limit
+// derives the addresses it adds to the window, and scans the blocks the
+// wallet has already applied for their elements in the background; lowering
+// it stops deriving addresses, but does not stop watching those already
+// derived.
+func (w *Wallet) UpdateSettings(s Settings) error {
+	if s.GapLimit < 0 || s.GapLimit > MaxGapLimit {
+		return fmt.Errorf("%w: must be between 0 and %d", ErrInvalidGapLimit, MaxGapLimit)
+	} else if err := checkStrategy(s.Strategy); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	} else if w.state.Type == TypeWatch && s.GapLimit > 0 {
+		return fmt.Errorf("%w: watch-only wallets do not derive addresses", ErrInvalidGapLimit)
+	}
+
+	prev, n, unscanned := Settings{GapLimit: w.state.GapLimit, Strategy: w.state.Strategy}, len(w.state.Addresses), w.state.Unscanned
+	w.state.GapLimit, w.state.Strategy = s.GapLimit, s.Strategy
+	w.extendLookahead()
+	if err := w.save(); err != nil {
+		for _, addr := range w.state.Addresses[n:] {
+			delete(w.owned, addr.Address)
+		}
+		w.state.GapLimit, w.state.Strategy = prev.GapLimit, prev.Strategy
+		w.state.Addresses, w.state.Unscanned = w.state.Addresses[:n], unscanned
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return w.scanLookahead()
+}
+
+// needsScan reports whether blocks that may contain outputs to the wallet's
+// addresses have already been applied, so that an address derived now must
+// be scanned for before it is tracked. It must be called with w.mu held.
+func (w *Wallet) needsScan() bool {
+	return w.state.Tip != (types.ChainIndex{}) && w.state.Tip.Height >= w.state.Birth
+}
+
+// deriveLookahead derives the next address as part of the lookahead window.
+// If the wallet has applied blocks since its birth, the address is tracked
+// once they have been scanned for it; so is every address after it, so that
+// the addresses awaiting a scan are always the last Unscanned of them. It
+// must be called with w.mu held.
+func (w *Wallet) deriveLookahead() Address {
+	addr := deriveAddress(w.seed, uint64(len(w.state.Addresses)))
+	addr.Lookahead = true
+	if w.state.Unscanned == 0 && !w.needsScan() {
+		w.owned[addr.Address] = len(w.state.Addresses)
+	} else {
+		w.state.Unscanned++
+	}
+	w.state.Addresses = append(w.state.Addresses, addr)
+	return addr
+}
+
+// extendLookahead derives addresses until the wallet has GapLimit of them
+// beyond its highest used address. A locked wallet derives them once it is
+// unlocked. It must be called with w.mu held.
+func (w *Wallet) extendLookahead() {
+	if w.seed == nil || w.state.GapLimit == 0 {
+		return
+	}
+	want := uint64(w.state.GapLimit)
+	for _, addr := range w.state.Addresses {
+		if addr.Used {
+			want = max(want, addr.Index+1+uint64(w.state.GapLimit))
+		}
+	}
+	for uint64(len(w.state.Addresses)) < want {
+		w.deriveLookahead()
+	}
+}
+
+// scanLookahead starts scanning for the addresses awaiting a scan from the
+// wallet's birth, unless another scan is in progress, in which case they are
+// scanned once it finishes. It must be called with w.mu held.
+func (w *Wallet) scanLookahead() error {
+	if w.state.Unscanned == 0 || w.state.Scan != nil {
+		return nil
+	}
+	addrs := w.state.Addresses[len(w.state.Addresses)-w.state.Unscanned:]
+	return w.startScan(w.state.Birth, addrs, false)
+}
@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestGapLimitEdge(t *testing.T) {
+	const gap = 5
+	cm, w := newTestWallet(t)
+	if err := w.UpdateSettings(Settings{GapLimit: gap}); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(t, cm, w)
+
+	// the genesis outputs were sent to the first address, so the window
+	// covers the gap limit's addresses after it
+	w.mu.Lock()
+	issued := uint64(1)
+	inside := deriveAddress(w.seed, issued+gap-1).Address
+	outside := deriveAddress(w.seed, issued+gap).Address
+	n := len(w.state.Addresses)
+	w.mu.Unlock()
+	if n != int(issued+gap) {
+		t.Fatalf("expected %v addresses, got %v", issued+gap, n)
+	}
+
+	found := func(addr types.Address) bool {
+		t.Helper()
+		info, err := w.Address(addr)
+		if err == ErrAddressNotFound {
+			return false
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return !info.Siacoins.IsZero()
+	}
+	send := func(addr types.Address) {
+		t.Helper()
+		txn, err := w.SendSiacoins(addr, types.Siacoins(1), nil, "", VersionAuto, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		broadcast(t, cm, txn)
+		mineBlocks(t, cm, w, 1)
+	}
+
+	// an output just beyond the window is missed
+	send(outside)
+	if found(outside) {
+		t.Fatalf("found an output at index %v, beyond the window", issued+gap)
+	}
+
+	// an output at the edge of the window is found, which slides the window
+	// past the missed output, and a scan finds it too
+	send(inside)
+	if !found(inside) {
+		t.Fatalf("missed an output at index %v, at the edge of the window", issued+gap-1)
+	}
+	waitSynced(t, cm, w)
+	if !found(outside) {
+		t.Fatalf("missed an output at index %v after the window slid", issued+gap)
+	}
+	w.mu.Lock()
+	n, unscanned := len(w.state.Addresses), w.state.Unscanned
+	w.mu.Unlock()
+	if want := int(issued + gap + 1 + gap); n != want {
+		t.Fatalf("expected %v addresses, got %v", want, n)
+	} else if unscanned != 0 {
+		t.Fatalf("expected every address to be scanned, got %v unscanned", unscanned)
+	}
+}
@@ -0,0 +1,126 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"go.sia.tech/core/types"
+)
+
+// MaxLabelLength and MaxMemoLength are the maximum lengths, in bytes, of an
+// address label and a transaction memo.
+const (
+	MaxLabelLength = 256
+	MaxMemoLength  = 1024
+)
+
+var (
+	// ErrAddressNotFound is returned when labeling an address that is not
+	// one of the wallet's addresses or multisig addresses.
+	ErrAddressNotFound = errors.New("address not found")
+	// ErrInvalidLabel is returned when a label or memo is too long, or is
+	// not valid UTF-8.
+	ErrInvalidLabel = errors.New("invalid label")
+)
+
+// checkLabel returns an error if s cannot be used as a label or memo of at
+// most n bytes.
+func checkLabel(s string, n int) error {
+	if len(s) > n {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrInvalidLabel, n)
+	} else if !utf8.ValidString(s) {
+		return fmt.Errorf("%w: must be valid UTF-8", ErrInvalidLabel)
+	}
+	return nil
+}
+
+// hasAddress reports whether addr is one of the wallet's addresses,
+// including lookahead addresses awaiting a scan, or one of its multisig
+// addresses. It must be called with w.mu held.
+func (w *Wallet) hasAddress(addr types.Address) bool {
+	_, ok := w.position(addr)
+	return ok || w.tracks(addr)
+}
+
+// annotate returns ev with the labels of its addresses and the memo of its
+// transaction. It must be called with w.mu held.
+func (w *Wallet) annotate(ev Event) Event {
+	for _, addr := range ev.Addresses {
+		if label, ok := w.state.Labels[addr]; ok && !slices.Contains(ev.Labels, label) {
+			ev.Labels = append(ev.Labels, label)
+		}
+	}
+	if ev.TransactionID != nil {
+		ev.Memo = w.state.Memos[*ev.TransactionID]
+	}
+	return ev
+}
+
+// SetLabel labels one of the wallet's addresses, replacing its previous
+// label. An empty label removes it. Labels are returned with the addresses
+// and the events that involve them.
+func (w *Wallet) SetLabel(addr types.Address, label string) error {
+	if err := checkLabel(label, MaxLabelLength); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	} else if !w.hasAddress(addr) {
+		return ErrAddressNotFound
+	}
+	prev, ok := w.state.Labels[addr]
+	if label == "" {
+		delete(w.state.Labels, addr)
+	} else {
+		if w.state.Labels == nil {
+			w.state.Labels = make(map[types.Address]string)
+		}
+		w.state.Labels[addr] = label
+	}
+	if err := w.save(); err != nil {
+		if ok {
+			w.state.Labels[addr] = prev
+		} else {
+			delete(w.state.Labels, addr)
+		}
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return nil
+}
+
+// SetMemo attaches memo to the wallet's record of the transaction with id,
+// replacing its previous memo. An empty memo removes it. The memo is never
+// added to the transaction itself; it is returned with the transaction's
+// events, and those of the payouts it causes, once they are recorded.
+func (w *Wallet) SetMemo(id types.TransactionID, memo string) error {
+	if err := checkLabel(memo, MaxMemoLength); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	}
+	prev, ok := w.state.Memos[id]
+	if memo == "" {
+		delete(w.state.Memos, id)
+	} else {
+		if w.state.Memos == nil {
+			w.state.Memos = make(map[types.TransactionID]string)
+		}
+		w.state.Memos[id] = memo
+	}
+	if err := w.save(); err != nil {
+		if ok {
+			w.state.Memos[id] = prev
+		} else {
+			delete(w.state.Memos, id)
+		}
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return nil
+}
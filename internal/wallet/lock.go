@@ -0,0 +1,233 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxUnlockAttempts is the number of consecutive wrong passphrases after
+	// which unlocking is refused for unlockLockout.
+	maxUnlockAttempts = 5
+	// unlockLockout is how long unlocking is refused after
+	// maxUnlockAttempts wrong passphrases.
+	unlockLockout = time.Minute
+)
+
+var (
+	// ErrLocked is returned when signing with, spending from, or deriving
+	// addresses for a wallet whose seed is encrypted with a passphrase, and
+	// which has not been unlocked.
+	ErrLocked = errors.New("wallet is locked")
+	// ErrNoPassphrase is returned when locking or unlocking a wallet whose
+	// seed is not encrypted with a passphrase.
+	ErrNoPassphrase = errors.New("wallet has no passphrase")
+	// ErrTooManyAttempts is returned when unlocking a wallet after too many
+	// wrong passphrases.
+	ErrTooManyAttempts = errors.New("too many wrong passphrases")
+)
+
+// lock zeroes the wallet's key material and stops its auto-lock timer. It
+// must be called with w.mu held.
+func (w *Wallet) lock() {
+	if w.lockTimer != nil {
+		w.lockTimer.Stop()
+		w.lockTimer = nil
+	}
+	if w.seed != nil {
+		clear(w.seed[:])
+		w.seed = nil
+	}
+	clear(w.phrase)
+	w.phrase = nil
+	w.lockAt = time.Time{}
+}
+
+// checkAttempt returns an error if unlocking is refused after too many wrong
+// passphrases. It must be called with w.unlockMu held.
+func (w *Wallet) checkAttempt() error {
+	if w.failedUnlocks < maxUnlockAttempts {
+		return nil
+	} else if wait := time.Until(w.lastFailedUnlock.Add(unlockLockout)); wait > 0 {
+		return fmt.Errorf("%w; try again in %v", ErrTooManyAttempts, wait.Round(time.Second))
+	}
+	w.failedUnlocks = 0
+	return nil
+}
+
+// openPassphraseSeed decrypts the wallet's seed phrase with passphrase,
+// counting wrong passphrases towards the unlock limit. It must be called
+// with w.unlockMu held.
+func (w *Wallet) openPassphraseSeed(passphrase string) ([]byte, error) {
+	if err := w.checkAttempt(); err != nil {
+		return nil, err
+	}
+	phrase, err := openSeed(w.seedPath(), passphrase, true)
+	if errors.Is(err, ErrWrongPassphrase) {
+		w.failedUnlocks++
+		w.lastFailedUnlock = time.Now()
+		w.log.Warn("wrong wallet passphrase", zap.Int("attempts", w.failedUnlocks))
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read seed: %w", err)
+	}
+	w.failedUnlocks = 0
+	return phrase, nil
+}
+
+// passphraseState returns an error if the wallet's seed cannot be encrypted
+// with a passphrase, and otherwise reports whether it already is.
+func (w *Wallet) passphraseState() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return false, ErrNotFound
+	} else if w.state.Type == TypeWatch {
+		return false, ErrWatchOnly
+	}
+	return w.passphrase, nil
+}
+
+// unlocked derives the lookahead addresses that could not be derived while
+// the wallet was locked, and starts scanning for them. It must be called
+// with w.mu held.
+func (w *Wallet) unlocked() error {
+	n, unscanned := len(w.state.Addresses), w.state.Unscanned
+	w.extendLookahead()
+	if len(w.state.Addresses) == n {
+		return nil
+	} else if err := w.save(); err != nil {
+		for _, addr := range w.state.Addresses[n:] {
+			delete(w.owned, addr.Address)
+		}
+		w.state.Addresses, w.state.Unscanned = w.state.Addresses[:n], unscanned
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return w.scanLookahead()
+}
+
+// Unlock decrypts the wallet's seed with passphrase, so that it can sign
+// transactions and derive addresses. If timeout is positive, the wallet locks
+// itself again once it has passed; otherwise, it stays unlocked until Lock is
+// called. Unlocking an unlocked wallet replaces its timeout. After
+// maxUnlockAttempts wrong passphrases in a row, attempts are refused with
+// ErrTooManyAttempts for unlockLockout.
+func (w *Wallet) Unlock(passphrase string, timeout time.Duration) error {
+	if ok, err := w.passphraseState(); err != nil {
+		return err
+	} else if !ok {
+		return ErrNoPassphrase
+	}
+	// attempts are serialized, so that concurrent ones cannot exceed the
+	// limit; the key derivation is slow, so w.mu is not held for it
+	w.unlockMu.Lock()
+	defer w.unlockMu.Unlock()
+	phrase, err := w.openPassphraseSeed(passphrase)
+	if err != nil {
+		return err
+	}
+	seed := new([32]byte)
+	if err := cwallet.SeedFromPhrase(seed, string(phrase)); err != nil {
+		clear(phrase)
+		return fmt.Errorf("failed to decode seed phrase: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		clear(phrase)
+		clear(seed[:])
+		return ErrNotFound
+	}
+	w.lock()
+	w.seed, w.phrase = seed, phrase
+	if timeout > 0 {
+		w.lockAt = time.Now().Add(timeout)
+		var t *time.Timer
+		t = time.AfterFunc(timeout, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			// the wallet may have been locked and unlocked again since
+			if w.lockTimer == t {
+				w.lock()
+				w.log.Info("locked wallet after timeout")
+			}
+		})
+		w.lockTimer = t
+	}
+	w.log.Info("unlocked wallet", zap.Duration("timeout", timeout))
+	return w.unlocked()
+}
+
+// Lock zeroes the wallet's key material, so that it cannot sign transactions
+// or derive addresses until it is unlocked with its passphrase. Everything
+// else, such as its balance and events, remains available.
+func (w *Wallet) Lock() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	} else if w.state.Type == TypeWatch {
+		return ErrWatchOnly
+	} else if !w.passphrase {
+		return ErrNoPassphrase
+	}
+	w.lock()
+	w.log.Info("locked wallet")
+	return nil
+}
+
+// SetPassphrase encrypts the wallet's seed with passphrase instead of the
+// wallet password, or, if it is empty, with the wallet password again. If the
+// seed is already encrypted with a passphrase, old must be that passphrase,
+// and wrong ones count towards the same limit as Unlock. Setting a
+// passphrase locks the wallet; removing it leaves the wallet unlocked for
+// good.
+func (w *Wallet) SetPassphrase(old, passphrase string) error {
+	had, err := w.passphraseState()
+	if err != nil {
+		return err
+	}
+	w.unlockMu.Lock()
+	defer w.unlockMu.Unlock()
+	var phrase []byte
+	if had {
+		phrase, err = w.openPassphraseSeed(old)
+	} else {
+		phrase, err = openSeed(w.seedPath(), w.password, false)
+	}
+	if err != nil {
+		return err
+	}
+	defer clear(phrase)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	}
+	key := w.password
+	if passphrase != "" {
+		key = passphrase
+	}
+	if err := writeSeed(w.seedPath(), string(phrase), key, passphrase != ""); err != nil {
+		return fmt.Errorf("failed to save seed: %w", err)
+	}
+	w.passphrase = passphrase != ""
+	w.lock()
+	if w.passphrase {
+		w.log.Info("set wallet passphrase")
+		return nil
+	}
+	w.seed = new([32]byte)
+	if err := cwallet.SeedFromPhrase(w.seed, string(phrase)); err != nil {
+		w.seed = nil
+		return fmt.Errorf("failed to decode seed phrase: %w", err)
+	}
+	w.log.Info("removed wallet passphrase")
+	return w.unlocked()
+}
@@ -0,0 +1,381 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+// defaultName is the name given to a wallet created before the node
+// supported more than one.
+const defaultName = "default"
+
+// ErrInvalidName is returned when a wallet name is empty, too long, or
+// contains characters other than letters, digits, '-', and '_'.
+var ErrInvalidName = errors.New("invalid wallet name")
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// A Manager stores a node's named wallets, each in its own directory, and
+// applies chain updates to all of them. Wallets at the same tip share each
+// batch of updates, so that a block is fetched once no matter how many
+// wallets there are.
+type Manager struct {
+	chain    ChainManager
+	dir      string
+	password string
+	log      *zap.Logger
+
+	tipCh  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	wallets map[string]*Wallet
+	signer  Signer
+}
+
+func (m *Manager) newWallet(name string) *Wallet {
+	return &Wallet{
+		chain:    m.chain,
+		dir:      filepath.Join(m.dir, name),
+		password: m.password,
+		log:      m.log.With(zap.String("wallet", name)),
+		name:     name,
+		trigger:  m.trigger,
+		owned:    make(map[types.Address]int),
+		signer:   m.signer,
+
+		funded: make(map[fundKey]fundedTransaction),
+		rng:    rand.New(rand.NewChaCha8(frand.Entropy256())),
+	}
+}
+
+// list returns the manager's wallets, ordered by name.
+func (m *Manager) list() []*Wallet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ws := make([]*Wallet, 0, len(m.wallets))
+	for _, w := range m.wallets {
+		ws = append(ws, w)
+	}
+	slices.SortFunc(ws, func(a, b *Wallet) int { return strings.Compare(a.name, b.name) })
+	return ws
+}
+
+// sync applies chain updates until every wallet reaches the chain's tip.
+func (m *Manager) sync() error {
+	for m.ctx.Err() == nil {
+		// wallets at the same tip share a single batch of updates
+		var tips []types.ChainIndex
+		groups := make(map[types.ChainIndex][]*Wallet)
+		for _, w := range m.list() {
+			w.mu.Lock()
+			exists, tip := w.exists, w.state.Tip
+			w.mu.Unlock()
+			if !exists {
+				continue
+			} else if _, ok := groups[tip]; !ok {
+				tips = append(tips, tip)
+			}
+			groups[tip] = append(groups[tip], w)
+		}
+
+		done := true
+		for _, tip := range tips {
+			reverted, applied, err := m.chain.UpdatesSince(tip, maxSyncBlocks)
+			if err != nil {
+				return fmt.Errorf("failed to get updates since %v: %w", tip, err)
+			}
+			for _, w := range groups[tip] {
+				synced, err := w.syncTo(tip, reverted, applied)
+				if err != nil {
+					return err
+				}
+				done = done && synced
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *Manager) run() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-m.tipCh:
+			if err := m.sync(); err != nil {
+				m.log.Error("failed to sync wallets", zap.Error(err))
+			}
+			// scans are applied a batch at a time, so that new blocks are
+			// not held up behind them
+			var more bool
+			for _, w := range m.list() {
+				if ok, err := w.scan(); err != nil {
+					w.log.Error("failed to scan wallet", zap.Error(err))
+				} else if ok {
+					more = true
+				}
+			}
+			if more {
+				m.trigger()
+			}
+		}
+	}
+}
+
+// trigger wakes the run loop to apply any new chain updates.
+func (m *Manager) trigger() {
+	select {
+	case m.tipCh <- struct{}{}:
+	default:
+	}
+}
+
+// SetSigner makes the manager's seed wallets sign with s, rather than with
+// the keys derived from their seeds. They sign with it even while locked,
+// though they still need their seeds to derive new addresses. A nil Signer
+// restores signing with the seeds.
+func (m *Manager) SetSigner(s Signer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signer = s
+	for _, w := range m.wallets {
+		w.mu.Lock()
+		w.signer = s
+		w.mu.Unlock()
+	}
+}
+
+// add creates a wallet named name with create, and adds it to the manager.
+func (m *Manager) add(name string, create func(*Wallet) error) error {
+	if !nameRegexp.MatchString(name) {
+		return ErrInvalidName
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.wallets[name]; ok {
+		return ErrExists
+	}
+	w := m.newWallet(name)
+	if err := create(w); err != nil {
+		return err
+	}
+	m.wallets[name] = w
+	return nil
+}
+
+// Create creates a seed wallet named name from phrase. If phrase is empty, a
+// new one is generated and returned; since a new seed cannot own any
+// existing outputs, the wallet starts at the current tip. Otherwise, the
+// wallet is scanned from the genesis block. If passphrase is set, the seed
+// is encrypted with it, rather than the wallet password, and the wallet is
+// locked until it is unlocked with it.
+func (m *Manager) Create(name, phrase, passphrase string) (generated string, err error) {
+	err = m.add(name, func(w *Wallet) (err error) {
+		generated, err = w.create(phrase, passphrase)
+		return
+	})
+	return
+}
+
+// CreateWatch creates a watch-only wallet named name that tracks addrs. The
+// chain is scanned for their elements from height.
+func (m *Manager) CreateWatch(name string, addrs []types.Address, height uint64) error {
+	return m.add(name, func(w *Wallet) error {
+		return w.createWatch(addrs, height)
+	})
+}
+
+// Restore creates a wallet named name from a backup made by Wallet.Backup,
+// encrypted with passphrase. The chain is scanned for the wallet's elements
+// from the height the backed-up wallet was created at. The restored seed is
+// encrypted with the wallet password; a wallet passphrase can be set again
+// with SetPassphrase.
+func (m *Manager) Restore(name string, backup []byte, passphrase string) error {
+	return m.add(name, func(w *Wallet) error {
+		return w.restore(backup, passphrase)
+	})
+}
+
+// Wallet returns the wallet named name.
+func (m *Manager) Wallet(name string) (*Wallet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.wallets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return w, nil
+}
+
+// Wallets returns the status of each wallet, ordered by name.
+func (m *Manager) Wallets() ([]Status, error) {
+	var statuses []Status
+	for _, w := range m.list() {
+		status, err := w.Status()
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since it was listed
+		} else if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Delete removes the wallet named name, along with its seed and state.
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.wallets[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.wallets, name)
+	if err := w.remove(); err != nil {
+		return fmt.Errorf("failed to remove wallet %q: %w", name, err)
+	}
+	m.log.Info("deleted wallet", zap.String("wallet", name))
+	return nil
+}
+
+// Close stops applying chain updates to the wallets.
+func (m *Manager) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	return nil
+}
+
+// migrate moves the seed and state of a wallet created before the node
+// supported more than one into the directory of the default wallet.
+func migrate(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "wallet.json")); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, defaultName), 0700); err != nil {
+		return err
+	}
+	for _, file := range []string{"seed.json", "wallet.json"} {
+		err := os.Rename(filepath.Join(dir, file), filepath.Join(dir, defaultName, file))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Init creates a seed wallet named name from phrase in dir, the wallet
+// directory of a node that need not be running, encrypting its seed with
+// password. The node loads the wallet when it next starts. If phrase is
+// empty, a new one is generated and returned, and the wallet starts at the
+// node's tip; otherwise, it is scanned from the genesis block. password must
+// be the one the node's other wallets are encrypted with.
+func Init(dir, name, password, phrase string) (string, error) {
+	if !nameRegexp.MatchString(name) {
+		return "", ErrInvalidName
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	} else if err := migrate(dir); err != nil {
+		return "", fmt.Errorf("failed to migrate wallet: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	// the key derivation is slow, so only one other seed is checked
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		_, err := readSeed(filepath.Join(dir, e.Name(), "seed.json"), password)
+		if err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errSeedPassphrase) {
+			return "", err
+		}
+	}
+
+	w := &Wallet{
+		dir:      filepath.Join(dir, name),
+		password: password,
+		log:      zap.NewNop(),
+		name:     name,
+		trigger:  func() {},
+		owned:    make(map[types.Address]int),
+	}
+	if _, err := os.Stat(w.statePath()); err == nil {
+		return "", ErrExists
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return w.create(phrase, "")
+}
+
+// NewManager returns a Manager that stores each wallet in a subdirectory of
+// dir, encrypting their seeds with password. A wallet stored directly in dir,
+// as by earlier versions, is moved to the wallet named "default".
+func NewManager(cm ChainManager, dir, password string, log *zap.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	} else if err := migrate(dir); err != nil {
+		return nil, fmt.Errorf("failed to migrate wallet: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		chain:    cm,
+		dir:      dir,
+		password: password,
+		log:      log,
+		tipCh:    make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		wallets:  make(map[string]*Wallet),
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !nameRegexp.MatchString(e.Name()) {
+			continue
+		}
+		w := m.newWallet(e.Name())
+		if err := w.load(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to load wallet %q: %w", e.Name(), err)
+		} else if w.exists {
+			m.wallets[e.Name()] = w
+		}
+	}
+
+	// the callback must not block the chain manager, so updates are applied
+	// by the run loop
+	stop := cm.OnReorg(func(types.ChainIndex) { m.trigger() })
+	context.AfterFunc(ctx, stop)
+	// apply any blocks added while the node was offline
+	m.trigger()
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
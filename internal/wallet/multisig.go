@@ -0,0 +1,293 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+var (
+	// ErrInvalidMultisig is returned when a multisig address's threshold or
+	// public keys are invalid.
+	ErrInvalidMultisig = errors.New("invalid multisig address")
+	// ErrNoWalletKey is returned when none of a multisig address's public
+	// keys belong to the wallet.
+	ErrNoWalletKey = errors.New("none of the public keys belong to the wallet")
+)
+
+// maxMultisigKeys is the maximum number of public keys in a multisig
+// address, the most a v2 threshold policy can have.
+const maxMultisigKeys = 255
+
+// A MultisigAddress is an m-of-n address whose keys include at least one of
+// the wallet's. The wallet tracks its elements, which it only spends
+// cooperatively, by adding its signatures to transactions signed by the
+// address's other keys. A v1 multisig address is defined by unlock
+// conditions, and can be spent by v1 and v2 transactions; a v2 multisig
+// address is a threshold policy, and can only be spent by v2 transactions.
+// Height is the height from which the chain was scanned for its elements.
+type MultisigAddress struct {
+	Address     types.Address     `json:"address"`
+	SpendPolicy types.SpendPolicy `json:"spendPolicy"`
+	Threshold   int               `json:"threshold"`
+	PublicKeys  []types.PublicKey `json:"publicKeys"`
+	V2          bool              `json:"v2"`
+	Height      uint64            `json:"height"`
+	Used        bool              `json:"used"`
+}
+
+// A MultisigInfo is a MultisigAddress along with its label and confirmed
+// elements.
+type MultisigInfo struct {
+	MultisigAddress
+	Label           string                 `json:"label,omitempty"`
+	SiacoinElements []types.SiacoinElement `json:"siacoinElements"`
+	SiafundElements []types.SiafundElement `json:"siafundElements"`
+}
+
+// keyIndices returns the derivation index of each of the wallet's public
+// keys. It must be called with w.mu held.
+func (w *Wallet) keyIndices() map[types.PublicKey]uint64 {
+	m := make(map[types.PublicKey]uint64, len(w.state.Addresses))
+	for _, addr := range w.state.Addresses {
+		m[w.publicKey(addr.Address)] = addr.Index
+	}
+	return m
+}
+
+// AddMultisig adds the threshold-of-n address of keys, in order, to the
+// wallet, and starts tracking its elements. At least one of the keys must be
+// derived from the wallet's seed, and the address must be created with the
+// same keys, in the same order, by each co-signer. If the wallet has already
+// applied blocks from height onward, they are scanned for the address's
+// elements in the background; the address is tracked once the scan
+// finishes.
+func (w *Wallet) AddMultisig(threshold int, keys []types.PublicKey, v2 bool, height uint64) (MultisigAddress, error) {
+	if len(keys) < 2 || len(keys) > maxMultisigKeys {
+		return MultisigAddress{}, fmt.Errorf("%w: must have between 2 and %d public keys", ErrInvalidMultisig, maxMultisigKeys)
+	} else if threshold < 1 || threshold > len(keys) {
+		return MultisigAddress{}, fmt.Errorf("%w: threshold must be between 1 and the number of public keys", ErrInvalidMultisig)
+	}
+	for i, pk := range keys {
+		if slices.Contains(keys[:i], pk) {
+			return MultisigAddress{}, fmt.Errorf("%w: duplicate public key %v", ErrInvalidMultisig, pk)
+		}
+	}
+	ms := MultisigAddress{
+		Threshold:  threshold,
+		PublicKeys: slices.Clone(keys),
+		V2:         v2,
+		Height:     height,
+	}
+	if v2 {
+		of := make([]types.SpendPolicy, len(keys))
+		for i, pk := range keys {
+			of[i] = types.PolicyPublicKey(pk)
+		}
+		ms.SpendPolicy = types.PolicyThreshold(uint8(threshold), of)
+	} else {
+		uc := types.UnlockConditions{SignaturesRequired: uint64(threshold)}
+		for _, pk := range keys {
+			uc.PublicKeys = append(uc.PublicKeys, pk.UnlockKey())
+		}
+		ms.SpendPolicy = types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(uc)}
+	}
+	ms.Address = ms.SpendPolicy.Address()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return MultisigAddress{}, err
+	} else if i, ok := w.multisig[ms.Address]; ok {
+		return w.state.Multisig[i], nil
+	}
+	own := w.keyIndices()
+	if !slices.ContainsFunc(keys, func(pk types.PublicKey) bool { _, ok := own[pk]; return ok }) {
+		return MultisigAddress{}, ErrNoWalletKey
+	}
+
+	scan := height <= w.state.Tip.Height
+	sc := w.state.Scan
+	if scan && sc != nil {
+		return MultisigAddress{}, ErrScanInProgress
+	}
+	if scan {
+		// the address is added once the scan finishes, so that the blocks
+		// the wallet applies in the meantime are not counted twice
+		if err := w.startScan(height, []Address{{Address: ms.Address}}, false); err != nil {
+			return MultisigAddress{}, err
+		}
+		w.state.Scan.Multisig = []MultisigAddress{ms}
+		if err := w.save(); err != nil {
+			w.state.Scan = nil
+			return MultisigAddress{}, fmt.Errorf("failed to save wallet: %w", err)
+		}
+		return ms, nil
+	}
+
+	// a full scan replaces the wallet's events once it finishes, so it must
+	// cover the new address too
+	w.addMultisig(ms)
+	if sc != nil && sc.Full {
+		sc.State.Addresses = append(sc.State.Addresses, Address{Index: uint64(len(sc.State.Addresses)), Address: ms.Address})
+	}
+	if err := w.save(); err != nil {
+		delete(w.multisig, ms.Address)
+		w.state.Multisig = w.state.Multisig[:len(w.state.Multisig)-1]
+		if sc != nil && sc.Full {
+			sc.State.Addresses = sc.State.Addresses[:len(sc.State.Addresses)-1]
+		}
+		return MultisigAddress{}, fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return ms, nil
+}
+
+// addMultisig starts tracking the elements of ms. It must be called with
+// w.mu held.
+func (w *Wallet) addMultisig(ms MultisigAddress) {
+	if w.multisig == nil {
+		w.multisig = make(map[types.Address]int)
+	}
+	w.multisig[ms.Address] = len(w.state.Multisig)
+	w.state.Multisig = append(w.state.Multisig, ms)
+}
+
+// Multisig returns the wallet's multisig addresses along with their labels
+// and confirmed elements.
+func (w *Wallet) Multisig() ([]MultisigInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return nil, ErrNotFound
+	}
+	infos := make([]MultisigInfo, len(w.state.Multisig))
+	for i, ms := range w.state.Multisig {
+		infos[i] = MultisigInfo{
+			MultisigAddress: ms,
+			Label:           w.state.Labels[ms.Address],
+			SiacoinElements: []types.SiacoinElement{},
+			SiafundElements: []types.SiafundElement{},
+		}
+	}
+	for _, sce := range w.state.SiacoinElements {
+		if i, ok := w.multisig[sce.SiacoinOutput.Address]; ok {
+			infos[i].SiacoinElements = append(infos[i].SiacoinElements, sce.Copy())
+		}
+	}
+	for _, sfe := range w.state.SiafundElements {
+		if i, ok := w.multisig[sfe.SiafundOutput.Address]; ok {
+			infos[i].SiafundElements = append(infos[i].SiafundElements, sfe.Copy())
+		}
+	}
+	return infos, nil
+}
+
+// signMultisigV1 adds the wallet's signatures, made with sign, for the input
+// with id, whose unlock conditions are uc, to txn, until the input has as
+// many signatures as it requires. It returns the number still required. It
+// must be called with w.mu held.
+func (w *Wallet) signMultisigV1(cs consensus.State, txn *types.Transaction, id types.Hash256, uc types.UnlockConditions, cf types.CoveredFields, sign signFunc) int {
+	signed := make(map[uint64]bool)
+	for _, ts := range txn.Signatures {
+		if ts.ParentID == id && len(ts.Signature) > 0 {
+			signed[ts.PublicKeyIndex] = true
+		}
+	}
+	remaining := int(uc.SignaturesRequired) - len(signed)
+	own := w.keyIndices()
+	for i, uk := range uc.PublicKeys {
+		if remaining <= 0 {
+			break
+		} else if signed[uint64(i)] || len(uk.Key) != len(types.PublicKey{}) {
+			continue
+		}
+		index, ok := own[types.PublicKey(uk.Key)]
+		if !ok {
+			continue
+		}
+		var h types.Hash256
+		if cf.WholeTransaction {
+			h = cs.WholeSigHash(*txn, id, uint64(i), 0, cf.Signatures)
+		} else {
+			h = cs.PartialSigHash(*txn, cf)
+		}
+		sig := sign(types.PublicKey(uk.Key), index, h)
+		txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+			ParentID:       id,
+			PublicKeyIndex: uint64(i),
+			CoveredFields:  cf,
+			Signature:      sig[:],
+		})
+		remaining--
+	}
+	return max(remaining, 0)
+}
+
+// signMultisigV2 adds the wallet's signatures, made with sign, to sp, which
+// satisfies the policy of the multisig address ms, until it has as many
+// signatures as the policy requires. The signatures already in sp are kept
+// if they are valid for sigHash. It returns the number still required. It
+// must be called with w.mu held.
+//
+// Signatures are ordered by the position of their public key in the policy.
+// The keys of a partially signed threshold policy that have not signed are
+// opaque, so that the policy is satisfied once enough keys have signed.
+func (w *Wallet) signMultisigV2(ms MultisigAddress, sigHash types.Hash256, sp *types.SatisfiedPolicy, sign signFunc) int {
+	// match the existing signatures to the keys that made them. Each key
+	// that is not opaque in a threshold policy has a signature, while the
+	// signatures of unlock conditions are those of a subset of their keys.
+	sigs := make(map[int]types.Signature)
+	next := sp.Signatures
+	switch p := sp.Policy.Type.(type) {
+	case types.PolicyTypeThreshold:
+		if len(p.Of) != len(ms.PublicKeys) {
+			break
+		}
+		for i, sub := range p.Of {
+			if _, ok := sub.Type.(types.PolicyTypePublicKey); !ok {
+				continue
+			} else if len(next) == 0 {
+				break
+			}
+			if ms.PublicKeys[i].VerifyHash(sigHash, next[0]) {
+				sigs[i] = next[0]
+			}
+			next = next[1:]
+		}
+	case types.PolicyTypeUnlockConditions:
+		for i, pk := range ms.PublicKeys {
+			if len(next) > 0 && pk.VerifyHash(sigHash, next[0]) {
+				sigs[i], next = next[0], next[1:]
+			}
+		}
+	}
+
+	own := w.keyIndices()
+	for i, pk := range ms.PublicKeys {
+		if len(sigs) >= ms.Threshold {
+			break
+		} else if _, ok := sigs[i]; ok {
+			continue
+		} else if index, ok := own[pk]; ok {
+			sigs[i] = sign(pk, index, sigHash)
+		}
+	}
+
+	*sp = types.SatisfiedPolicy{Policy: ms.SpendPolicy}
+	var of []types.SpendPolicy
+	for i, pk := range ms.PublicKeys {
+		if sig, ok := sigs[i]; ok {
+			sp.Signatures = append(sp.Signatures, sig)
+			of = append(of, types.PolicyPublicKey(pk))
+		} else {
+			of = append(of, types.PolicyOpaque(types.PolicyPublicKey(pk)))
+		}
+	}
+	if ms.V2 {
+		sp.Policy = types.PolicyThreshold(uint8(ms.Threshold), of)
+	}
+	return ms.Threshold - len(sigs)
+}
@@ -0,0 +1,164 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// ErrInvalidSignature is returned when a submitted transaction is missing a
+// valid signature for one of the wallet's inputs.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// A SigningInput describes how to sign an input of an unsigned transaction:
+// the key derived from the wallet's seed at KeyIndex, whose public key is
+// PublicKey, signs SigHash. The signature of a v1 input replaces that of the
+// transaction signature with the input's ParentID; the signature of a v2
+// input is the first of its satisfied policy's signatures.
+type SigningInput struct {
+	ParentID  types.Hash256   `json:"parentID"`
+	Address   types.Address   `json:"address"`
+	KeyIndex  uint64          `json:"keyIndex"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	SigHash   types.Hash256   `json:"sigHash"`
+}
+
+// An UnsignedTransaction is a transaction constructed by the wallet to be
+// signed elsewhere, along with the information needed to sign each of its
+// inputs. Its elements are reserved until Expires.
+type UnsignedTransaction struct {
+	Transaction
+	Inputs  []SigningInput
+	Expires time.Time
+}
+
+// ConstructTransaction constructs a transaction sending outputs, like
+// SendSiacoins, but leaves its signatures empty, so that it can be signed
+// offline by a device holding the wallet's seed. The spent elements are
+// reserved for d.
+func (w *Wallet) ConstructTransaction(outputs []types.SiacoinOutput, strategy string, version int, force bool, d time.Duration) (UnsignedTransaction, error) {
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return UnsignedTransaction{}, err
+	}
+	var amount types.Currency
+	for _, sco := range outputs {
+		amount = amount.Add(sco.Value)
+	}
+	sp, err := versionSpend(cs, version)
+	if err != nil {
+		return UnsignedTransaction{}, err
+	}
+	sp.outputs = append([]types.SiacoinOutput(nil), outputs...)
+	if err := w.fund(&sp, cs, feeRate, amount, strategy, force); err != nil {
+		return UnsignedTransaction{}, err
+	}
+	txn, err := w.finish(sp, cs, false, d)
+	if err != nil {
+		return UnsignedTransaction{}, err
+	}
+
+	ut := UnsignedTransaction{
+		Transaction: txn,
+		Expires:     w.state.Reservations[txn.Reserved[0]],
+	}
+	var sigHash func(id types.Hash256) types.Hash256
+	if txn.V2Transaction != nil {
+		h := cs.InputSigHash(*txn.V2Transaction)
+		sigHash = func(types.Hash256) types.Hash256 { return h }
+	} else {
+		sigHash = func(id types.Hash256) types.Hash256 { return cs.WholeSigHash(*txn.Transaction, id, 0, 0, nil) }
+	}
+	for _, sce := range sp.inputs {
+		addr := sce.SiacoinOutput.Address
+		ut.Inputs = append(ut.Inputs, SigningInput{
+			ParentID:  types.Hash256(sce.ID),
+			Address:   addr,
+			KeyIndex:  w.state.Addresses[w.owned[addr]].Index,
+			PublicKey: w.publicKey(addr),
+			SigHash:   sigHash(types.Hash256(sce.ID)),
+		})
+	}
+	return ut, nil
+}
+
+// VerifyTransaction returns an error if any input of txn that the wallet
+// controls lacks a valid signature.
+func (w *Wallet) VerifyTransaction(txn types.Transaction) error {
+	cs := w.chain.TipState()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return err
+	}
+
+	verify := func(id types.Hash256, uc types.UnlockConditions) error {
+		if !w.owns(uc.UnlockHash()) {
+			return nil
+		}
+		pk := w.publicKey(uc.UnlockHash())
+		for _, ts := range txn.Signatures {
+			if ts.ParentID != id {
+				continue
+			}
+			var h types.Hash256
+			if ts.CoveredFields.WholeTransaction {
+				h = cs.WholeSigHash(txn, id, ts.PublicKeyIndex, ts.Timelock, ts.CoveredFields.Signatures)
+			} else {
+				h = cs.PartialSigHash(txn, ts.CoveredFields)
+			}
+			if len(ts.Signature) == len(types.Signature{}) && pk.VerifyHash(h, types.Signature(ts.Signature)) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w for input %v", ErrInvalidSignature, id)
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if err := verify(types.Hash256(sci.ParentID), sci.UnlockConditions); err != nil {
+			return err
+		}
+	}
+	for _, sfi := range txn.SiafundInputs {
+		if err := verify(types.Hash256(sfi.ParentID), sfi.UnlockConditions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyV2Transaction is like VerifyTransaction, but for v2 transactions.
+func (w *Wallet) VerifyV2Transaction(txn types.V2Transaction) error {
+	cs := w.chain.TipState()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return err
+	}
+
+	sigHash := cs.InputSigHash(txn)
+	verify := func(id types.Hash256, addr types.Address, sp types.SatisfiedPolicy) error {
+		if !w.owns(addr) {
+			return nil
+		} else if sp.Policy.Address() != addr || len(sp.Signatures) == 0 || !w.publicKey(addr).VerifyHash(sigHash, sp.Signatures[0]) {
+			return fmt.Errorf("%w for input %v", ErrInvalidSignature, id)
+		}
+		return nil
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if err := verify(types.Hash256(sci.Parent.ID), sci.Parent.SiacoinOutput.Address, sci.SatisfiedPolicy); err != nil {
+			return err
+		}
+	}
+	for _, sfi := range txn.SiafundInputs {
+		if err := verify(types.Hash256(sfi.Parent.ID), sfi.Parent.SiafundOutput.Address, sfi.SatisfiedPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
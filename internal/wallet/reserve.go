@@ -0,0 +1,151 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+var (
+	// ErrOutputNotFound is returned when reserving an element the wallet
+	// does not own.
+	ErrOutputNotFound = errors.New("output not found")
+	// ErrOutputReserved is returned when reserving an element that is
+	// already reserved.
+	ErrOutputReserved = errors.New("output is already reserved")
+	// ErrReservationLost is returned when an element reserved for a
+	// transaction is spent, or its reservation released, while an external
+	// signer signs the transaction.
+	ErrReservationLost = errors.New("reservation lost while signing")
+)
+
+// reserved reports whether the element with id is reserved. It must be
+// called with w.mu held.
+func (w *Wallet) reserved(id types.Hash256) bool {
+	return time.Now().Before(w.state.Reservations[id])
+}
+
+// allReserved reports whether every element in ids is reserved. It must be
+// called with w.mu held.
+func (w *Wallet) allReserved(ids []types.Hash256) bool {
+	for _, id := range ids {
+		if !w.reserved(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkReservations returns ErrReservationLost unless every element in
+// expiries is still reserved until its expiry. Spending an element removes
+// its reservation, so this also detects elements spent in the meantime. It
+// must be called with w.mu held.
+func (w *Wallet) checkReservations(expiries map[types.Hash256]time.Time) error {
+	for id, expiry := range expiries {
+		if !w.state.Reservations[id].Equal(expiry) {
+			return fmt.Errorf("%w: %v", ErrReservationLost, id)
+		}
+	}
+	return nil
+}
+
+// reserve reserves the elements with ids for d, removing any expired
+// reservations, and saves the wallet. It must be called with w.mu held.
+func (w *Wallet) reserve(ids []types.Hash256, d time.Duration) error {
+	now := time.Now()
+	for id, expiry := range w.state.Reservations {
+		if now.After(expiry) {
+			delete(w.state.Reservations, id)
+		}
+	}
+	for key, ft := range w.funded {
+		if !w.allReserved(ft.reserved) {
+			delete(w.funded, key)
+		}
+	}
+	for _, id := range ids {
+		w.state.Reservations[id] = now.Add(d)
+	}
+	if err := w.save(); err != nil {
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return nil
+}
+
+// Outputs returns the wallet's spendable siacoin and siafund elements: those
+// that are mature, not reserved, and not spent by a transaction in the pool.
+func (w *Wallet) Outputs() ([]types.SiacoinElement, []types.SiafundElement, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return nil, nil, ErrNotFound
+	}
+	return w.spendableSiacoins(), w.spendableSiafunds(), nil
+}
+
+// Reserve reserves the siacoin or siafund elements with ids for d, so that
+// they are not selected by the wallet. Reservations are saved with the
+// wallet, and end when they expire, are released, or the element is spent
+// in a block.
+func (w *Wallet) Reserve(ids []types.Hash256, d time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	}
+	for _, id := range ids {
+		_, sc := w.state.SiacoinElements[types.SiacoinOutputID(id)]
+		_, sf := w.state.SiafundElements[types.SiafundOutputID(id)]
+		if !sc && !sf {
+			return fmt.Errorf("%w: %v", ErrOutputNotFound, id)
+		} else if w.reserved(id) {
+			return fmt.Errorf("%w: %v", ErrOutputReserved, id)
+		}
+	}
+	return w.reserve(ids, d)
+}
+
+// Release releases the reservations of the elements with ids, e.g. after a
+// constructed transaction failed to broadcast.
+func (w *Wallet) Release(ids []types.Hash256) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	}
+	for _, id := range ids {
+		delete(w.state.Reservations, id)
+	}
+	if err := w.save(); err != nil {
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return nil
+}
+
+// ReleaseTransaction releases the reservations of the siacoin and siafund
+// elements spent by txn.
+func (w *Wallet) ReleaseTransaction(txn types.Transaction) error {
+	var ids []types.Hash256
+	for _, sci := range txn.SiacoinInputs {
+		ids = append(ids, types.Hash256(sci.ParentID))
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ids = append(ids, types.Hash256(sfi.ParentID))
+	}
+	return w.Release(ids)
+}
+
+// ReleaseV2Transaction releases the reservations of the siacoin and siafund
+// elements spent by txn.
+func (w *Wallet) ReleaseV2Transaction(txn types.V2Transaction) error {
+	var ids []types.Hash256
+	for _, sci := range txn.SiacoinInputs {
+		ids = append(ids, types.Hash256(sci.Parent.ID))
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ids = append(ids, types.Hash256(sfi.Parent.ID))
+	}
+	return w.Release(ids)
+}
@@ -0,0 +1,268 @@
+package wallet
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// A scanState tracks a scan of blocks the wallet has already applied, either
+// for addresses added after those blocks, or for all of its addresses, as
+// requested by Rescan. The scan applies the blocks to the addresses alone,
+// and merges them into the wallet once it reaches the wallet's tip. Start is
+// the height the scan began at. A Full scan covers every address, so its
+// events replace the wallet's from Start onward. Multisig are multisig
+// addresses being scanned, which are added to the wallet with the results.
+type scanState struct {
+	Start    uint64            `json:"start"`
+	Full     bool              `json:"full,omitempty"`
+	State    persistWallet     `json:"state"`
+	Multisig []MultisigAddress `json:"multisig,omitempty"`
+}
+
+// A ScanStatus reports the progress of a wallet scan. Height is the height
+// of the last block the scan applied, and Remaining is the number of blocks
+// until it reaches the wallet's tip. ETA estimates the time until the scan
+// finishes from its rate since it started or resumed; it is zero until the
+// rate is known.
+type ScanStatus struct {
+	Active    bool          `json:"active"`
+	Full      bool          `json:"full"`
+	Start     uint64        `json:"start"`
+	Height    uint64        `json:"height"`
+	Remaining uint64        `json:"remaining"`
+	ETA       time.Duration `json:"eta"`
+}
+
+func newScan(tip types.ChainIndex, start uint64, addrs []Address, full bool) *scanState {
+	sc := &scanState{
+		Start: start,
+		Full:  full,
+		State: persistWallet{
+			Tip:             tip,
+			SiacoinElements: make(map[types.SiacoinOutputID]types.SiacoinElement),
+			SiafundElements: make(map[types.SiafundOutputID]types.SiafundElement),
+			Reservations:    make(map[types.Hash256]time.Time),
+		},
+	}
+	for i, addr := range addrs {
+		sc.State.Addresses = append(sc.State.Addresses, Address{Index: uint64(i), Address: addr.Address})
+	}
+	return sc
+}
+
+// startScan starts scanning addrs from height. It must be called with w.mu
+// held.
+func (w *Wallet) startScan(height uint64, addrs []Address, full bool) error {
+	w.state.Scan = newScan(w.scanStart(height), height, addrs, full)
+	if err := w.save(); err != nil {
+		w.state.Scan = nil
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	w.scanResumed, w.scanResumedHeight = time.Now(), w.state.Scan.State.Tip.Height
+	w.log.Info("started wallet scan", zap.Int("addresses", len(addrs)), zap.Uint64("height", height), zap.Bool("full", full))
+	w.trigger()
+	return nil
+}
+
+// Rescan scans every block from height onward for the elements of all of the
+// wallet's addresses, in the background. The wallet continues to apply new
+// blocks during the scan, and the results are merged once it finishes. The
+// scan is saved as it progresses, and resumes after a restart.
+func (w *Wallet) Rescan(height uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	} else if w.state.Scan != nil {
+		return ErrScanInProgress
+	} else if height > w.state.Tip.Height {
+		return nil // the wallet has not applied any of those blocks yet
+	}
+	// lookahead addresses awaiting a scan are scanned for from the wallet's
+	// birth instead
+	addrs := slices.Clone(w.state.Addresses[:len(w.state.Addresses)-w.state.Unscanned])
+	for _, ms := range w.state.Multisig {
+		addrs = append(addrs, Address{Address: ms.Address})
+	}
+	return w.startScan(height, addrs, true)
+}
+
+// ScanStatus returns the progress of the wallet's scan, if any.
+func (w *Wallet) ScanStatus() (ScanStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ScanStatus{}, ErrNotFound
+	}
+	sc := w.state.Scan
+	if sc == nil {
+		return ScanStatus{}, nil
+	}
+	status := ScanStatus{
+		Active: true,
+		Full:   sc.Full,
+		Start:  sc.Start,
+		Height: sc.State.Tip.Height,
+	}
+	if w.state.Tip.Height > sc.State.Tip.Height {
+		status.Remaining = w.state.Tip.Height - sc.State.Tip.Height
+	}
+	if scanned := sc.State.Tip.Height - min(w.scanResumedHeight, sc.State.Tip.Height); scanned > 0 && !w.scanResumed.IsZero() {
+		perBlock := time.Since(w.scanResumed) / time.Duration(scanned)
+		status.ETA = perBlock * time.Duration(status.Remaining)
+	}
+	return status, nil
+}
+
+// scanStart returns the index from which the wallet must apply blocks to
+// see every block from height onward. It must be called with w.mu held.
+func (w *Wallet) scanStart(height uint64) types.ChainIndex {
+	if height == 0 {
+		return types.ChainIndex{}
+	} else if index, ok := w.chain.BestIndex(height - 1); ok {
+		return index
+	}
+	return w.chain.Tip()
+}
+
+// scanner returns a Wallet that applies updates to the scan's state. It must
+// be called with w.mu held.
+func (w *Wallet) scanner() *Wallet {
+	sw := &Wallet{
+		state: w.state.Scan.State,
+		owned: make(map[types.Address]int),
+	}
+	for i, addr := range sw.state.Addresses {
+		sw.owned[addr.Address] = i
+	}
+	return sw
+}
+
+// scan applies a batch of blocks to the wallet's scan, if any, merging it
+// into the wallet once it reaches the wallet's tip. It reports whether the
+// scan made progress and has more blocks to apply.
+func (w *Wallet) scan() (bool, error) {
+	w.mu.Lock()
+	if w.state.Scan == nil {
+		w.mu.Unlock()
+		return false, nil
+	}
+	tip := w.state.Scan.State.Tip
+	w.mu.Unlock()
+
+	reverted, applied, err := w.chain.UpdatesSince(tip, maxSyncBlocks)
+	if err != nil {
+		return false, fmt.Errorf("failed to get updates since %v: %w", tip, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.state.Scan == nil || w.state.Scan.State.Tip != tip {
+		return false, nil
+	} else if w.scanResumed.IsZero() {
+		// resuming a scan saved before a restart
+		w.scanResumed, w.scanResumedHeight = time.Now(), tip.Height
+	}
+	sw := w.scanner()
+	for _, cru := range reverted {
+		sw.revertUpdate(cru)
+	}
+	// the scan stops at the wallet's tip, which applies any later blocks to
+	// the addresses once they are merged. A scan from the genesis block
+	// starts before it, at the zero index.
+	for _, cau := range applied {
+		if sw.state.Tip != (types.ChainIndex{}) && sw.state.Tip.Height >= w.state.Tip.Height {
+			break
+		}
+		sw.applyUpdate(cau)
+	}
+	w.state.Scan.State = sw.state
+	done := sw.state.Tip == w.state.Tip
+	if done {
+		w.mergeScan(w.state.Scan, sw)
+		w.state.Scan = nil
+		w.scanResumed = time.Time{}
+		// outputs found at lookahead addresses slide the window forward,
+		// and the addresses it adds are scanned for next
+		w.extendLookahead()
+		if err := w.scanLookahead(); err != nil {
+			return false, err
+		}
+	}
+	if err := w.save(); err != nil {
+		return false, fmt.Errorf("failed to save wallet: %w", err)
+	} else if done {
+		w.log.Info("finished wallet scan", zap.Int("addresses", len(sw.state.Addresses)))
+	}
+	return !done && sw.state.Tip != tip, nil
+}
+
+// mergeScan adds the addresses, elements, and events of a finished scan to
+// the wallet. It must be called with w.mu held.
+func (w *Wallet) mergeScan(sc *scanState, sw *Wallet) {
+	for _, ms := range sc.Multisig {
+		if _, ok := w.multisig[ms.Address]; !ok {
+			w.addMultisig(ms)
+		}
+	}
+	// lookahead addresses are scanned for in order, so those the scan
+	// covered are the first of the ones awaiting a scan
+	unscanned := make(map[types.Address]int)
+	for i := len(w.state.Addresses) - w.state.Unscanned; i < len(w.state.Addresses); i++ {
+		unscanned[w.state.Addresses[i].Address] = i
+	}
+	for _, addr := range sw.state.Addresses {
+		if i, ok := w.owned[addr.Address]; ok {
+			w.state.Addresses[i].Used = w.state.Addresses[i].Used || addr.Used
+			continue
+		} else if i, ok := unscanned[addr.Address]; ok {
+			w.owned[addr.Address] = i
+			w.state.Addresses[i].Used = w.state.Addresses[i].Used || addr.Used
+			w.state.Unscanned--
+			continue
+		} else if i, ok := w.multisig[addr.Address]; ok {
+			w.state.Multisig[i].Used = w.state.Multisig[i].Used || addr.Used
+			continue
+		}
+		addr.Index = uint64(len(w.state.Addresses))
+		w.owned[addr.Address] = len(w.state.Addresses)
+		w.state.Addresses = append(w.state.Addresses, addr)
+	}
+	maps.Copy(w.state.SiacoinElements, sw.state.SiacoinElements)
+	maps.Copy(w.state.SiafundElements, sw.state.SiafundElements)
+
+	if sc.Full {
+		w.state.Events = slices.DeleteFunc(w.state.Events, func(ev Event) bool {
+			return ev.Index.Height >= sc.Start
+		})
+	}
+	// a transaction that involves both old and new addresses has an event in
+	// each, whose flows are combined
+	pos := make(map[types.Hash256]int, len(w.state.Events))
+	for i, ev := range w.state.Events {
+		pos[ev.ID] = i
+	}
+	for _, ev := range sw.state.Events {
+		if i, ok := pos[ev.ID]; ok && w.state.Events[i].Index == ev.Index {
+			e := &w.state.Events[i]
+			e.SiacoinInflow = e.SiacoinInflow.Add(ev.SiacoinInflow)
+			e.SiacoinOutflow = e.SiacoinOutflow.Add(ev.SiacoinOutflow)
+			e.SiafundInflow += ev.SiafundInflow
+			e.SiafundOutflow += ev.SiafundOutflow
+			for _, addr := range ev.Addresses {
+				e.involve(addr)
+			}
+			continue
+		}
+		w.state.Events = append(w.state.Events, ev)
+	}
+	slices.SortStableFunc(w.state.Events, func(a, b Event) int {
+		return cmp.Compare(a.Index.Height, b.Index.Height)
+	})
+}
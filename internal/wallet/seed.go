@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/frand"
+)
+
+// Parameters of the argon2id key derivation used to encrypt the seed.
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024 // KiB
+	kdfThreads = 4
+)
+
+var (
+	// ErrWrongPassword is returned when the seed file cannot be decrypted
+	// with the supplied password.
+	ErrWrongPassword = errors.New("wrong wallet password")
+
+	// errSeedPassphrase is returned when reading a seed file with the wallet
+	// password, if it is encrypted with the wallet's passphrase instead.
+	errSeedPassphrase = errors.New("seed is encrypted with a passphrase")
+)
+
+// A sealedFile is data encrypted with a key derived from a password: the
+// on-disk format of the seed phrase, and of wallet backups. Passphrase is set
+// on a seed file encrypted with the wallet's own passphrase, rather than the
+// wallet password, so that the file alone says how to decrypt it.
+type sealedFile struct {
+	Version    int    `json:"version"`
+	Passphrase bool   `json:"passphrase,omitempty"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func seedKey(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, kdfTime, kdfMemory, kdfThreads, chacha20poly1305.KeySize)
+}
+
+// seal encrypts data with password.
+func seal(data []byte, password string) (sealedFile, error) {
+	sf := sealedFile{
+		Version: 1,
+		Salt:    frand.Bytes(16),
+		Nonce:   frand.Bytes(chacha20poly1305.NonceSizeX),
+	}
+	aead, err := chacha20poly1305.NewX(seedKey(password, sf.Salt))
+	if err != nil {
+		return sealedFile{}, err
+	}
+	sf.Ciphertext = aead.Seal(nil, sf.Nonce, data, nil)
+	return sf, nil
+}
+
+// open decrypts sf with password. It reports false if the password is wrong
+// or the ciphertext has been modified.
+func (sf sealedFile) open(password string) ([]byte, bool, error) {
+	if sf.Version != 1 {
+		return nil, false, fmt.Errorf("unsupported version %d", sf.Version)
+	} else if len(sf.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, false, fmt.Errorf("invalid nonce length %d", len(sf.Nonce))
+	}
+	aead, err := chacha20poly1305.NewX(seedKey(password, sf.Salt))
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := aead.Open(nil, sf.Nonce, sf.Ciphertext, nil)
+	return data, err == nil, nil
+}
+
+// writeSeed encrypts phrase with password, which is the wallet's passphrase
+// if passphrase is set, and writes it to path.
+func writeSeed(path, phrase, password string, passphrase bool) error {
+	sf, err := seal([]byte(phrase), password)
+	if err != nil {
+		return err
+	}
+	sf.Passphrase = passphrase
+	js, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, js, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// openSeed decrypts the seed phrase at path with password, which must be the
+// wallet's passphrase if passphrase is set, and the wallet password
+// otherwise. The passphrase is never included in the errors it returns.
+func openSeed(path, password string, passphrase bool) ([]byte, error) {
+	js, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sf sealedFile
+	if err := json.Unmarshal(js, &sf); err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %w", path, err)
+	} else if sf.Passphrase && !passphrase {
+		return nil, errSeedPassphrase
+	} else if !sf.Passphrase && passphrase {
+		return nil, ErrNoPassphrase
+	}
+	phrase, ok, err := sf.open(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %v: %w", path, err)
+	} else if !ok && passphrase {
+		return nil, ErrWrongPassphrase
+	} else if !ok {
+		return nil, ErrWrongPassword
+	}
+	return phrase, nil
+}
+
+// readSeed decrypts the seed phrase at path with the wallet password. It
+// returns errSeedPassphrase if the seed is encrypted with a passphrase
+// instead.
+func readSeed(path, password string) (string, error) {
+	phrase, err := openSeed(path, password, false)
+	if err != nil {
+		return "", err
+	}
+	defer clear(phrase)
+	return string(phrase), nil
+}
+
+// bip39Index is the position of each word in bip39Words.
+var bip39Index = func() map[string]uint64 {
+	m := make(map[string]uint64, len(bip39Words))
+	for i, word := range bip39Words {
+		m[word] = uint64(i)
+	}
+	return m
+}()
+
+// CheckPhrase returns an error if phrase is not a valid seed phrase. Unlike
+// the error from decoding it, the error identifies the first word that is not
+// in the word list.
+func CheckPhrase(phrase string) error {
+	words := strings.Fields(phrase)
+	if len(words) != 12 {
+		return fmt.Errorf("%w: expected 12 words, got %d", ErrInvalidSeed, len(words))
+	}
+	indices := make([]uint64, len(words))
+	defer clear(indices)
+	for i, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			return fmt.Errorf("%w: word %d (%q) is not in the word list", ErrInvalidSeed, i+1, word)
+		}
+		indices[i] = index
+	}
+
+	// each word encodes 11 bits of the 128-bit entropy, except the last,
+	// whose low 4 bits are the checksum: the high bits of its hash
+	var entropy [16]byte
+	defer clear(entropy[:])
+	var hi, lo uint64
+	for _, index := range indices[:len(indices)-1] {
+		hi = hi<<11 | lo>>(64-11)
+		lo = lo<<11 | index
+	}
+	last := indices[len(indices)-1]
+	hi = hi<<7 | lo>>(64-7)
+	lo = lo<<7 | last>>4
+	binary.BigEndian.PutUint64(entropy[:8], hi)
+	binary.BigEndian.PutUint64(entropy[8:], lo)
+	h := sha256.Sum256(entropy[:])
+	defer clear(h[:])
+	if uint64(h[0]>>4) != last&0xF {
+		return fmt.Errorf("%w: checksum mismatch; a word may be misspelled as another listed word, or out of order", ErrInvalidSeed)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	cwallet "go.sia.tech/coreutils/wallet"
+	"lukechampine.com/frand"
+)
+
+func TestCheckPhrase(t *testing.T) {
+	phrase := cwallet.NewSeedPhrase()
+	words := strings.Fields(phrase)
+	replace := func(i int, word string) string {
+		ws := append([]string(nil), words...)
+		ws[i] = word
+		return strings.Join(ws, " ")
+	}
+	tests := []struct {
+		name   string
+		phrase string
+		msg    string
+	}{
+		{"valid", phrase, ""},
+		{"extra whitespace", "  " + strings.Join(words, "\t ") + "\n", ""},
+		{"too short", strings.Join(words[:11], " "), "expected 12 words, got 11"},
+		{"unlisted word", replace(3, "sia"), `word 4 ("sia") is not in the word list`},
+		{"unlisted last word", replace(11, "Zoo"), `word 12 ("Zoo") is not in the word list`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckPhrase(test.phrase)
+			if test.msg == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if !errors.Is(err, ErrInvalidSeed) {
+				t.Fatalf("expected %v, got %v", ErrInvalidSeed, err)
+			} else if !strings.Contains(err.Error(), test.msg) {
+				t.Fatalf("expected %q in %q", test.msg, err)
+			}
+		})
+	}
+
+	// CheckPhrase must agree with the decoder on the checksum of every
+	// phrase of listed words
+	var seed [32]byte
+	for range 1000 {
+		ws := strings.Fields(cwallet.NewSeedPhrase())
+		ws[frand.Intn(len(ws))] = bip39Words[frand.Intn(len(bip39Words))]
+		p := strings.Join(ws, " ")
+		err := CheckPhrase(p)
+		if decodeErr := cwallet.SeedFromPhrase(&seed, p); (err == nil) != (decodeErr == nil) {
+			t.Fatalf("%q: CheckPhrase returned %v, but decoding returned %v", p, err, decodeErr)
+		} else if err != nil && !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+
+	"go.sia.tech/core/types"
+)
+
+// Coin selection strategies, which choose the siacoin elements that fund a
+// transaction. StrategyLargest adds the largest elements first, minimizing
+// the number of inputs. StrategyBranchAndBound searches for elements that
+// cover the amount with as little change as possible, preferably none; if it
+// finds none within its search budget, it falls back to StrategyLargest.
+// StrategyRandom adds elements in a random order, so that the wallet's
+// transactions do not reveal the sizes of its other elements.
+const (
+	StrategyLargest        = "largest"
+	StrategyBranchAndBound = "branchAndBound"
+	StrategyRandom         = "random"
+)
+
+// maxBranchAndBoundTries is the number of selections that branch-and-bound
+// selection considers before giving up on finding a better one.
+const maxBranchAndBoundTries = 100000
+
+// ErrInvalidStrategy is returned when a coin selection strategy is unknown.
+var ErrInvalidStrategy = errors.New("invalid coin selection strategy")
+
+// checkStrategy returns an error if strategy is neither empty nor one of the
+// coin selection strategies.
+func checkStrategy(strategy string) error {
+	switch strategy {
+	case "", StrategyLargest, StrategyBranchAndBound, StrategyRandom:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidStrategy, strategy)
+	}
+}
+
+// strategy returns the coin selection strategy to use for a request for
+// strategy: the wallet's default if it is empty. It must be called with w.mu
+// held.
+func (w *Wallet) strategy(strategy string) (string, error) {
+	if err := checkStrategy(strategy); err != nil {
+		return "", err
+	} else if strategy == "" {
+		strategy = w.state.Strategy
+	}
+	if strategy == "" {
+		strategy = StrategyLargest
+	}
+	return strategy, nil
+}
+
+// orderCoins returns the spendable elements sces, which are ordered from
+// largest to smallest, in the order in which strategy adds them to a
+// transaction that needs target, plus inputCost for each element added.
+// Elements are added until they cover the target, so only a prefix of them
+// is spent. For branch-and-bound selection, the first exact elements are
+// worth between target and target plus tolerance, net of their input cost,
+// so that any change is not worth keeping; if no such elements are found,
+// exact is zero.
+func orderCoins(strategy string, sces []types.SiacoinElement, target, inputCost, tolerance types.Currency, rng *rand.Rand) (ordered []types.SiacoinElement, exact int) {
+	switch strategy {
+	case StrategyRandom:
+		ordered = slices.Clone(sces)
+		rng.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+		return ordered, 0
+	case StrategyBranchAndBound:
+		// elements that cost more to spend than they are worth are never
+		// selected
+		var values []types.Currency
+		for _, sce := range sces {
+			if sce.SiacoinOutput.Value.Cmp(inputCost) <= 0 {
+				break
+			}
+			values = append(values, sce.SiacoinOutput.Value.Sub(inputCost))
+		}
+		selected := branchAndBound(values, target, tolerance)
+		if len(selected) == 0 {
+			return sces, 0
+		}
+		chosen := make(map[int]bool, len(selected))
+		for _, i := range selected {
+			ordered = append(ordered, sces[i])
+			chosen[i] = true
+		}
+		for i, sce := range sces {
+			if !chosen[i] {
+				ordered = append(ordered, sce)
+			}
+		}
+		return ordered, len(selected)
+	default:
+		return sces, 0
+	}
+}
+
+// branchAndBound returns the indices of the values, which are ordered from
+// largest to smallest, whose sum is at least target and less than target
+// plus tolerance, preferring the smallest such sum. It returns nil if there
+// are none, or if none are found within maxBranchAndBoundTries.
+func branchAndBound(values []types.Currency, target, tolerance types.Currency) []int {
+	// remaining[i] is the sum of values[i:], which bounds what can still be
+	// added once the first i values have been considered
+	remaining := make([]types.Currency, len(values)+1)
+	for i := len(values) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1].Add(values[i])
+	}
+	upper := target.Add(tolerance)
+
+	var best, selected []int
+	var bestSum types.Currency
+	var tries int
+	// search considers including, then excluding, values[i], given the sum
+	// of those selected so far. It returns true once the search is over.
+	var search func(i int, sum types.Currency) bool
+	search = func(i int, sum types.Currency) bool {
+		if tries++; tries > maxBranchAndBoundTries {
+			return true
+		} else if sum.Cmp(upper) >= 0 {
+			return false
+		} else if sum.Cmp(target) >= 0 {
+			if best == nil || sum.Cmp(bestSum) < 0 {
+				best, bestSum = slices.Clone(selected), sum
+			}
+			return sum.Equals(target)
+		} else if i == len(values) || sum.Add(remaining[i]).Cmp(target) < 0 {
+			return false
+		}
+		// including a value equal to one just excluded would repeat the
+		// selections already considered
+		if i == 0 || !values[i].Equals(values[i-1]) || (len(selected) > 0 && selected[len(selected)-1] == i-1) {
+			selected = append(selected, i)
+			done := search(i+1, sum.Add(values[i]))
+			selected = selected[:len(selected)-1]
+			if done {
+				return true
+			}
+		}
+		return search(i+1, sum)
+	}
+	search(0, types.ZeroCurrency)
+	return best
+}
@@ -0,0 +1,155 @@
+package wallet
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/testutil"
+)
+
+// testElements returns elements worth each of values, in hastings.
+func testElements(values ...uint64) []types.SiacoinElement {
+	sces := make([]types.SiacoinElement, len(values))
+	for i, v := range values {
+		sces[i] = types.SiacoinElement{
+			ID:            types.SiacoinOutputID{byte(i + 1)},
+			SiacoinOutput: types.SiacoinOutput{Value: types.NewCurrency64(v)},
+		}
+	}
+	return sces
+}
+
+// elementValues returns the values of sces, in hastings.
+func elementValues(sces []types.SiacoinElement) []uint64 {
+	values := make([]uint64, len(sces))
+	for i, sce := range sces {
+		values[i] = sce.SiacoinOutput.Value.Lo
+	}
+	return values
+}
+
+func newTestRNG(seed byte) *rand.Rand {
+	return rand.New(rand.NewChaCha8([32]byte{seed}))
+}
+
+func TestOrderCoins(t *testing.T) {
+	sces := testElements(50, 40, 30, 20, 10)
+	tests := []struct {
+		name      string
+		strategy  string
+		target    uint64
+		inputCost uint64
+		tolerance uint64
+		want      []uint64
+		exact     int
+	}{
+		{"largest", StrategyLargest, 60, 0, 1, []uint64{50, 40, 30, 20, 10}, 0},
+		{"branch-and-bound exact", StrategyBranchAndBound, 60, 0, 1, []uint64{50, 10, 40, 30, 20}, 2},
+		{"branch-and-bound within tolerance", StrategyBranchAndBound, 58, 0, 3, []uint64{50, 10, 40, 30, 20}, 2},
+		{"branch-and-bound fewest change", StrategyBranchAndBound, 25, 0, 10, []uint64{30, 50, 40, 20, 10}, 1},
+		{"branch-and-bound net of input cost", StrategyBranchAndBound, 50, 5, 1, []uint64{50, 10, 40, 30, 20}, 2},
+		// no sum of the elements is within the tolerance of the target
+		{"branch-and-bound no match", StrategyBranchAndBound, 15, 0, 1, []uint64{50, 40, 30, 20, 10}, 0},
+		{"branch-and-bound fallback", StrategyBranchAndBound, 1000, 0, 1, []uint64{50, 40, 30, 20, 10}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ordered, exact := orderCoins(test.strategy, sces, types.NewCurrency64(test.target), types.NewCurrency64(test.inputCost), types.NewCurrency64(test.tolerance), newTestRNG(0))
+			if got := elementValues(ordered); !slices.Equal(got, test.want) {
+				t.Fatalf("expected order %v, got %v", test.want, got)
+			} else if exact != test.exact {
+				t.Fatalf("expected %v exact elements, got %v", test.exact, exact)
+			}
+		})
+	}
+
+	// random selection is a permutation determined by the RNG's seed
+	random := func(seed byte) []uint64 {
+		ordered, exact := orderCoins(StrategyRandom, sces, types.NewCurrency64(60), types.ZeroCurrency, types.NewCurrency64(1), newTestRNG(seed))
+		if exact != 0 {
+			t.Fatalf("expected no exact elements, got %v", exact)
+		}
+		return elementValues(ordered)
+	}
+	sorted := elementValues(sces)
+	var shuffled bool
+	for seed := range byte(10) {
+		got := random(seed)
+		if !slices.Equal(got, random(seed)) {
+			t.Fatalf("seed %v: expected the same order for the same seed", seed)
+		} else if !slices.Equal(slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(sorted))) {
+			t.Fatalf("seed %v: expected a permutation of %v, got %v", seed, sorted, got)
+		}
+		shuffled = shuffled || !slices.Equal(got, sorted)
+	}
+	if !shuffled {
+		t.Fatal("expected random selection to reorder the elements")
+	} else if !slices.Equal(elementValues(sces), sorted) {
+		t.Fatal("random selection modified its input")
+	}
+}
+
+func TestStrategiesRespectReservationsAndMaturity(t *testing.T) {
+	cm, w := newTestWallet(t)
+	w.rng = newTestRNG(1)
+	n := cm.TipState().Network
+
+	// miner payouts, the later of which are immature
+	testutil.MineBlocks(t, cm, w.state.Addresses[0].Address, int(n.MaturityDelay)+4)
+	waitSynced(t, cm, w)
+
+	// the genesis element and one payout are reserved
+	sces, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := []types.Hash256{types.Hash256(sces[0].ID), types.Hash256(sces[len(sces)-1].ID)}
+	if err := w.Reserve(reserved, reservationDuration); err != nil {
+		t.Fatal(err)
+	}
+
+	w.mu.Lock()
+	eligible := make(map[types.Hash256]bool)
+	var eligibleSum types.Currency
+	var immature int
+	for id, sce := range w.state.SiacoinElements {
+		if sce.MaturityHeight > w.state.Tip.Height+1 {
+			immature++
+		} else if !slices.Contains(reserved, types.Hash256(id)) {
+			eligible[types.Hash256(id)] = true
+			eligibleSum = eligibleSum.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	w.mu.Unlock()
+	if len(eligible) < 3 || immature == 0 {
+		t.Fatalf("expected several eligible elements and some immature ones, got %v and %v", len(eligible), immature)
+	}
+
+	for _, strategy := range []string{StrategyLargest, StrategyBranchAndBound, StrategyRandom} {
+		t.Run(strategy, func(t *testing.T) {
+			// an amount needing most of the eligible elements
+			amount := eligibleSum.Sub(eligibleSum.Div64(4))
+			txn, err := w.PreviewSendSiacoins(types.VoidAddress, amount, nil, strategy, VersionAuto, true)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(txn.Reserved) < 2 {
+				t.Fatalf("expected several inputs, got %v", len(txn.Reserved))
+			}
+			for _, id := range txn.Reserved {
+				if !eligible[id] {
+					t.Fatalf("selected %v, which is reserved or immature", id)
+				}
+			}
+
+			// the reserved and immature elements would cover more, but
+			// are not spendable
+			_, err = w.PreviewSendSiacoins(types.VoidAddress, eligibleSum, nil, strategy, VersionAuto, true)
+			if !errors.Is(err, ErrNotEnoughFunds) {
+				t.Fatalf("expected %v, got %v", ErrNotEnoughFunds, err)
+			}
+		})
+	}
+}
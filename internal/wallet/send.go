@@ -0,0 +1,551 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	cwallet "go.sia.tech/coreutils/wallet"
+)
+
+// reservationDuration is how long the elements spent by a constructed
+// transaction are reserved. Once the transaction is in the pool, its inputs
+// are excluded as spent, so the reservation only has to outlive the
+// broadcast.
+const reservationDuration = time.Hour
+
+var (
+	// ErrNotEnoughFunds is returned when the wallet's spendable elements
+	// cannot cover a transaction.
+	ErrNotEnoughFunds = errors.New("not enough funds")
+	// ErrDustChange is returned when a transaction's change output would be
+	// worth less than the fee required to spend it.
+	ErrDustChange = errors.New("change output would be dust")
+	// ErrDataTooLarge is returned when a transaction's arbitrary data would
+	// make it heavier than a block.
+	ErrDataTooLarge = errors.New("arbitrary data is too large")
+	// ErrInvalidVersion is returned when a transaction of the requested
+	// version is not valid at the current height.
+	ErrInvalidVersion = errors.New("invalid transaction version")
+)
+
+// Transaction versions that can be requested from the wallet's constructors.
+// VersionAuto constructs v2 transactions once the v2 hardfork allows them,
+// and v1 transactions before.
+const (
+	VersionAuto = 0
+	Version1    = 1
+	Version2    = 2
+)
+
+// A Transaction is a signed transaction constructed by the wallet. Exactly
+// one of Transaction or V2Transaction is set. Basis is the index at which the
+// v2 transaction's proofs are valid. Reserved are the IDs of the elements it
+// spends, which are not selected again until they are released or the
+// reservation expires. FeeRate is the fee rate, per unit of weight, from
+// which Fee was calculated, and Change is the value of the siacoins the
+// transaction returns to the wallet's first address.
+type Transaction struct {
+	ID            types.TransactionID
+	Basis         types.ChainIndex
+	Transaction   *types.Transaction
+	V2Transaction *types.V2Transaction
+	Fee           types.Currency
+	FeeRate       types.Currency
+	Change        types.Currency
+	Reserved      []types.Hash256
+}
+
+// spendableSiacoins returns the wallet's siacoin elements that are mature,
+// not reserved, and not spent by a transaction in the pool, ordered from
+// largest to smallest, then by ID, so that the same elements are selected
+// for a preview and the transaction that follows it. The elements of
+// multisig addresses are excluded. It must be called with w.mu held.
+func (w *Wallet) spendableSiacoins() []types.SiacoinElement {
+	inPool := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range w.chain.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.ParentID] = true
+		}
+	}
+	for _, txn := range w.chain.V2PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.Parent.ID] = true
+		}
+	}
+	var sces []types.SiacoinElement
+	for id, sce := range w.state.SiacoinElements {
+		if inPool[id] || w.reserved(types.Hash256(id)) || sce.MaturityHeight > w.state.Tip.Height+1 || !w.owns(sce.SiacoinOutput.Address) {
+			continue
+		}
+		sces = append(sces, sce)
+	}
+	sort.Slice(sces, func(i, j int) bool {
+		if c := sces[i].SiacoinOutput.Value.Cmp(sces[j].SiacoinOutput.Value); c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(sces[i].ID[:], sces[j].ID[:]) < 0
+	})
+	return sces
+}
+
+// spendableSiafunds returns the wallet's siafund elements that are not
+// reserved or spent by a transaction in the pool, ordered from largest to
+// smallest, then by ID, excluding those of multisig addresses. It must be
+// called with w.mu held.
+func (w *Wallet) spendableSiafunds() []types.SiafundElement {
+	inPool := make(map[types.SiafundOutputID]bool)
+	for _, txn := range w.chain.PoolTransactions() {
+		for _, sfi := range txn.SiafundInputs {
+			inPool[sfi.ParentID] = true
+		}
+	}
+	for _, txn := range w.chain.V2PoolTransactions() {
+		for _, sfi := range txn.SiafundInputs {
+			inPool[sfi.Parent.ID] = true
+		}
+	}
+	var sfes []types.SiafundElement
+	for id, sfe := range w.state.SiafundElements {
+		if inPool[id] || w.reserved(types.Hash256(id)) || !w.owns(sfe.SiafundOutput.Address) {
+			continue
+		}
+		sfes = append(sfes, sfe)
+	}
+	sort.Slice(sfes, func(i, j int) bool {
+		if sfes[i].SiafundOutput.Value != sfes[j].SiafundOutput.Value {
+			return sfes[i].SiafundOutput.Value > sfes[j].SiafundOutput.Value
+		}
+		return bytes.Compare(sfes[i].ID[:], sfes[j].ID[:]) < 0
+	})
+	return sfes
+}
+
+// keyAt returns the private key derived from the wallet's seed at index. It
+// must be called with w.mu held.
+func (w *Wallet) keyAt(index uint64) types.PrivateKey {
+	return cwallet.KeyFromSeed(w.seed, index)
+}
+
+// policy returns the spend policy of addr, which must be owned by the
+// wallet. It must be called with w.mu held.
+func (w *Wallet) policy(addr types.Address) types.SpendPolicy {
+	return *w.state.Addresses[w.owned[addr]].SpendPolicy
+}
+
+// publicKey returns the public key of addr, which must be owned by the
+// wallet. It must be called with w.mu held.
+func (w *Wallet) publicKey(addr types.Address) types.PublicKey {
+	return types.PublicKey(w.unlockConditions(addr).PublicKeys[0].Key)
+}
+
+// unlockConditions returns the unlock conditions of addr, which must be
+// owned by the wallet. It must be called with w.mu held.
+func (w *Wallet) unlockConditions(addr types.Address) types.UnlockConditions {
+	return types.UnlockConditions(w.policy(addr).Type.(types.PolicyTypeUnlockConditions))
+}
+
+// A spend is a transaction under construction, spending a set of the
+// wallet's elements. v1 and v2 transactions are built from the same
+// selection.
+type spend struct {
+	v2        bool
+	inputs    []types.SiacoinElement
+	outputs   []types.SiacoinOutput
+	sfInputs  []types.SiafundElement
+	sfOutputs []types.SiafundOutput
+	claimAddr types.Address
+	data      []byte
+	feeRate   types.Currency
+	fee       types.Currency
+	change    types.Currency
+}
+
+// v1Transaction returns the spend as an unsigned v1 transaction. Its
+// signatures are empty, which does not change its weight.
+func (sp spend) v1Transaction(w *Wallet) types.Transaction {
+	txn := types.Transaction{
+		SiacoinOutputs: sp.outputs,
+		SiafundOutputs: sp.sfOutputs,
+		MinerFees:      []types.Currency{sp.fee},
+	}
+	if len(sp.data) > 0 {
+		txn.ArbitraryData = [][]byte{sp.data}
+	}
+	addSig := func(id types.Hash256) {
+		txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+			ParentID:      id,
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+			Signature:     make([]byte, len(types.Signature{})),
+		})
+	}
+	for _, sce := range sp.inputs {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			ParentID:         sce.ID,
+			UnlockConditions: w.unlockConditions(sce.SiacoinOutput.Address),
+		})
+		addSig(types.Hash256(sce.ID))
+	}
+	for _, sfe := range sp.sfInputs {
+		txn.SiafundInputs = append(txn.SiafundInputs, types.SiafundInput{
+			ParentID:         sfe.ID,
+			UnlockConditions: w.unlockConditions(sfe.SiafundOutput.Address),
+			ClaimAddress:     sp.claimAddr,
+		})
+		addSig(types.Hash256(sfe.ID))
+	}
+	return txn
+}
+
+// v2Transaction returns the spend as an unsigned v2 transaction. Its
+// signatures are empty, which does not change its weight.
+func (sp spend) v2Transaction(w *Wallet) types.V2Transaction {
+	txn := types.V2Transaction{
+		SiacoinOutputs: sp.outputs,
+		SiafundOutputs: sp.sfOutputs,
+		ArbitraryData:  sp.data,
+		MinerFee:       sp.fee,
+	}
+	satisfied := func(addr types.Address) types.SatisfiedPolicy {
+		return types.SatisfiedPolicy{
+			Policy:     w.policy(addr),
+			Signatures: make([]types.Signature, 1),
+		}
+	}
+	for _, sce := range sp.inputs {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+			Parent:          sce.Copy(),
+			SatisfiedPolicy: satisfied(sce.SiacoinOutput.Address),
+		})
+	}
+	for _, sfe := range sp.sfInputs {
+		txn.SiafundInputs = append(txn.SiafundInputs, types.V2SiafundInput{
+			Parent:          sfe.Copy(),
+			ClaimAddress:    sp.claimAddr,
+			SatisfiedPolicy: satisfied(sfe.SiafundOutput.Address),
+		})
+	}
+	return txn
+}
+
+// sigRequests returns the signatures that txn, the spend's unsigned
+// transaction, needs from the wallet's keys: one for each input, siacoin
+// inputs first.
+func (sp spend) sigRequests(w *Wallet, cs consensus.State, txn Transaction) []sigRequest {
+	var addrs []types.Address
+	for _, sce := range sp.inputs {
+		addrs = append(addrs, sce.SiacoinOutput.Address)
+	}
+	for _, sfe := range sp.sfInputs {
+		addrs = append(addrs, sfe.SiafundOutput.Address)
+	}
+	reqs := make([]sigRequest, len(addrs))
+	for i, addr := range addrs {
+		reqs[i] = sigRequest{pk: w.publicKey(addr), index: w.state.Addresses[w.owned[addr]].Index}
+		if txn.V2Transaction != nil {
+			reqs[i].hash = cs.InputSigHash(*txn.V2Transaction)
+		} else {
+			reqs[i].hash = cs.WholeSigHash(*txn.Transaction, txn.Transaction.Signatures[i].ParentID, 0, 0, nil)
+		}
+	}
+	return reqs
+}
+
+// addSignatures adds sigs, the signatures requested by sigRequests, to txn.
+func (sp spend) addSignatures(txn Transaction, sigs []types.Signature) {
+	if txn.V2Transaction != nil {
+		for i := range txn.V2Transaction.SiacoinInputs {
+			txn.V2Transaction.SiacoinInputs[i].SatisfiedPolicy.Signatures[0] = sigs[i]
+		}
+		for i := range txn.V2Transaction.SiafundInputs {
+			txn.V2Transaction.SiafundInputs[i].SatisfiedPolicy.Signatures[0] = sigs[len(sp.inputs)+i]
+		}
+		return
+	}
+	for i := range txn.Transaction.Signatures {
+		txn.Transaction.Signatures[i].Signature = sigs[i][:]
+	}
+}
+
+// weight returns the weight of the spend's transaction.
+func (sp spend) weight(w *Wallet, cs consensus.State) uint64 {
+	if sp.v2 {
+		return cs.V2TransactionWeight(sp.v2Transaction(w))
+	}
+	return cs.TransactionWeight(sp.v1Transaction(w))
+}
+
+// newSpend returns an empty spend, constructing a v2 transaction once the v2
+// hardfork allows them.
+func newSpend(cs consensus.State) spend {
+	return spend{v2: cs.Index.Height+1 >= cs.Network.HardforkV2.AllowHeight}
+}
+
+// versionSpend returns an empty spend constructing a transaction of the
+// given version, which must be valid in the next block.
+func versionSpend(cs consensus.State, version int) (spend, error) {
+	height := cs.Index.Height + 1
+	switch version {
+	case VersionAuto:
+		return newSpend(cs), nil
+	case Version1:
+		if height >= cs.Network.HardforkV2.RequireHeight {
+			return spend{}, fmt.Errorf("%w: v1 transactions are not allowed after height %d", ErrInvalidVersion, cs.Network.HardforkV2.RequireHeight)
+		}
+		return spend{}, nil
+	case Version2:
+		if height < cs.Network.HardforkV2.AllowHeight {
+			return spend{}, fmt.Errorf("%w: v2 transactions are not allowed until height %d", ErrInvalidVersion, cs.Network.HardforkV2.AllowHeight)
+		}
+		return spend{v2: true}, nil
+	default:
+		return spend{}, fmt.Errorf("%w: %d", ErrInvalidVersion, version)
+	}
+}
+
+// checkData returns ErrDataTooLarge if the arbitrary data of sp makes its
+// transaction heavier than a block, even before any inputs are added.
+func (sp spend) checkData(w *Wallet, cs consensus.State) error {
+	if len(sp.data) == 0 {
+		return nil
+	} else if weight := sp.weight(w, cs); weight > cs.MaxBlockWeight() {
+		return fmt.Errorf("%w: transaction weight of %d exceeds the maximum of %d", ErrDataTooLarge, weight, cs.MaxBlockWeight())
+	}
+	return nil
+}
+
+// fund adds spendable siacoin elements to sp, in the order chosen by
+// strategy, until they cover amount plus the fee at feeRate, returning any
+// change to the wallet's first address. If the change would be dust,
+// ErrDustChange is returned, unless force is set, in which case it is added
+// to the fee instead. Dust change is always added to the fee of a
+// branch-and-bound selection, which only finds elements whose change is not
+// worth keeping. It must be called with w.mu held.
+func (w *Wallet) fund(sp *spend, cs consensus.State, feeRate, amount types.Currency, strategy string, force bool) error {
+	strategy, err := w.strategy(strategy)
+	if err != nil {
+		return err
+	}
+	sp.feeRate = feeRate
+	sp.outputs = append(sp.outputs, types.SiacoinOutput{Address: w.state.Addresses[0].Address})
+	change := &sp.outputs[len(sp.outputs)-1]
+	sces := w.spendableSiacoins()
+	var exact int
+	if len(sces) > 0 {
+		// every input adds the same weight, since the wallet's addresses
+		// have the same policy
+		one := *sp
+		one.inputs = sces[:1]
+		base, withInput := sp.weight(w, cs), one.weight(w, cs)
+		dust := feeRate.Mul64(spend{v2: sp.v2, inputs: sces[:1]}.weight(w, cs))
+		sces, exact = orderCoins(strategy, sces, amount.Add(feeRate.Mul64(base)), feeRate.Mul64(withInput-base), dust, w.rng)
+	}
+	var inputSum types.Currency
+	for i := 0; ; i++ {
+		change.Value = inputSum // v1 currencies are variable-length
+		sp.fee = feeRate.Mul64(sp.weight(w, cs))
+		if inputSum.Cmp(amount.Add(sp.fee)) >= 0 {
+			break
+		} else if i == len(sces) {
+			return fmt.Errorf("%w: %v spendable, %v needed", ErrNotEnoughFunds, inputSum, amount.Add(sp.fee))
+		}
+		sp.inputs = append(sp.inputs, sces[i])
+		inputSum = inputSum.Add(sces[i].SiacoinOutput.Value)
+	}
+
+	// change is dust if it would cost more to spend than it is worth
+	change.Value = inputSum.Sub(amount).Sub(sp.fee)
+	if change.Value.IsZero() {
+		sp.outputs = sp.outputs[:len(sp.outputs)-1]
+	} else if dust := feeRate.Mul64(spend{v2: sp.v2, inputs: sp.inputs[:1]}.weight(w, cs)); change.Value.Cmp(dust) < 0 {
+		if !force && (exact == 0 || len(sp.inputs) > exact) {
+			return fmt.Errorf("%w: change of %v is less than %v", ErrDustChange, change.Value, dust)
+		}
+		sp.fee = sp.fee.Add(change.Value)
+		sp.outputs = sp.outputs[:len(sp.outputs)-1]
+	} else {
+		sp.change = change.Value
+	}
+	return nil
+}
+
+// build returns sp as an unsigned transaction. It must be called with w.mu
+// held.
+func (w *Wallet) build(sp spend) Transaction {
+	txn := Transaction{
+		Basis:   w.state.Tip,
+		Fee:     sp.fee,
+		FeeRate: sp.feeRate,
+		Change:  sp.change,
+	}
+	for _, sce := range sp.inputs {
+		txn.Reserved = append(txn.Reserved, types.Hash256(sce.ID))
+	}
+	for _, sfe := range sp.sfInputs {
+		txn.Reserved = append(txn.Reserved, types.Hash256(sfe.ID))
+	}
+	if sp.v2 {
+		v2txn := sp.v2Transaction(w)
+		txn.ID, txn.V2Transaction = v2txn.ID(), &v2txn
+	} else {
+		v1txn := sp.v1Transaction(w)
+		txn.ID, txn.Transaction = v1txn.ID(), &v1txn
+	}
+	return txn
+}
+
+// finish reserves the elements sp spends for d and, unless sign is false,
+// signs it. The elements are reserved before the transaction is signed,
+// since w.mu is released while an external signer signs; if they are
+// spent or released in the meantime, ErrReservationLost is returned. It
+// must be called with w.mu held.
+func (w *Wallet) finish(sp spend, cs consensus.State, sign bool, d time.Duration) (Transaction, error) {
+	txn := w.build(sp)
+	if err := w.reserve(txn.Reserved, d); err != nil {
+		return Transaction{}, err
+	} else if !sign {
+		return txn, nil
+	}
+	expiries := make(map[types.Hash256]time.Time, len(txn.Reserved))
+	for _, id := range txn.Reserved {
+		expiries[id] = w.state.Reservations[id]
+	}
+	sigs, err := w.signAll(sp.sigRequests(w, cs, txn))
+	if !w.exists {
+		return Transaction{}, ErrNotFound
+	} else if err == nil {
+		err = w.checkReservations(expiries)
+	}
+	if err != nil {
+		// the released reservations are saved with the wallet's next update
+		for id, expiry := range expiries {
+			if w.state.Reservations[id].Equal(expiry) {
+				delete(w.state.Reservations, id)
+			}
+		}
+		return Transaction{}, err
+	}
+	sp.addSignatures(txn, sigs)
+	return txn, nil
+}
+
+// SendSiacoins constructs and signs a transaction sending amount to addr,
+// paying the chain's recommended fee. If data is not empty, it is embedded in
+// the transaction's arbitrary data. Inputs are selected from the spendable
+// elements by strategy, or the wallet's default strategy if it is empty, and
+// any change is returned to the wallet's first address. If the change would
+// be dust, ErrDustChange is returned, unless force is set, in which case it
+// is added to the fee instead. The spent elements are reserved; if the
+// transaction is not broadcast, they should be released with Release.
+//
+// The transaction's version is either version or, for VersionAuto, v2 once
+// the v2 hardfork allows them. Either version can spend any of the wallet's
+// elements, whenever they were created.
+func (w *Wallet) SendSiacoins(addr types.Address, amount types.Currency, data []byte, strategy string, version int, force bool) (Transaction, error) {
+	return w.sendSiacoins(addr, amount, data, strategy, version, force, false)
+}
+
+// PreviewSendSiacoins returns the transaction that SendSiacoins would
+// construct with the same arguments, as of the current tip and fee rate,
+// without signing it or reserving its inputs. Its signatures are empty,
+// which does not change its weight or fee. Previews have no effect on the
+// wallet, so they can be repeated freely.
+func (w *Wallet) PreviewSendSiacoins(addr types.Address, amount types.Currency, data []byte, strategy string, version int, force bool) (Transaction, error) {
+	return w.sendSiacoins(addr, amount, data, strategy, version, force, true)
+}
+
+// sendSiacoins implements SendSiacoins and, if preview is set,
+// PreviewSendSiacoins.
+func (w *Wallet) sendSiacoins(addr types.Address, amount types.Currency, data []byte, strategy string, version int, force, preview bool) (Transaction, error) {
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return Transaction{}, err
+	}
+	sp, err := versionSpend(cs, version)
+	if err != nil {
+		return Transaction{}, err
+	}
+	sp.outputs = []types.SiacoinOutput{{Address: addr, Value: amount}}
+	sp.data = data
+	if err := sp.checkData(w, cs); err != nil {
+		return Transaction{}, err
+	} else if err := w.fund(&sp, cs, feeRate, amount, strategy, force); err != nil {
+		return Transaction{}, err
+	} else if preview {
+		return w.build(sp), nil
+	}
+	return w.finish(sp, cs, true, reservationDuration)
+}
+
+// Anchor constructs and signs a transaction of the given version embedding
+// data in its arbitrary data, which sends the wallet's own funds back to it
+// and pays the chain's recommended fee. Its only output is the change, if it
+// is not dust; dust change is added to the fee.
+func (w *Wallet) Anchor(data []byte, version int) (Transaction, error) {
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return Transaction{}, err
+	}
+	sp, err := versionSpend(cs, version)
+	if err != nil {
+		return Transaction{}, err
+	}
+	sp.data = data
+	if err := sp.checkData(w, cs); err != nil {
+		return Transaction{}, err
+	} else if err := w.fund(&sp, cs, feeRate, types.ZeroCurrency, "", true); err != nil {
+		return Transaction{}, err
+	}
+	return w.finish(sp, cs, true, reservationDuration)
+}
+
+// SendSiafunds constructs and signs a transaction sending amount siafunds to
+// addr, like SendSiacoins. The siacoins claimed by the spent siafund
+// elements are sent to the wallet's first address, and mature after the
+// network's maturity delay; the fee is paid from the wallet's siacoins.
+func (w *Wallet) SendSiafunds(addr types.Address, amount uint64, version int, force bool) (Transaction, error) {
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return Transaction{}, err
+	}
+	sp, err := versionSpend(cs, version)
+	if err != nil {
+		return Transaction{}, err
+	}
+	sp.claimAddr = w.state.Addresses[0].Address
+	var inputSum uint64
+	for _, sfe := range w.spendableSiafunds() {
+		if inputSum >= amount {
+			break
+		}
+		sp.sfInputs = append(sp.sfInputs, sfe)
+		inputSum += sfe.SiafundOutput.Value
+	}
+	if inputSum < amount {
+		return Transaction{}, fmt.Errorf("%w: %d siafunds spendable, %d needed", ErrNotEnoughFunds, inputSum, amount)
+	}
+	sp.sfOutputs = []types.SiafundOutput{{Address: addr, Value: amount}}
+	if inputSum > amount {
+		sp.sfOutputs = append(sp.sfOutputs, types.SiafundOutput{Address: sp.claimAddr, Value: inputSum - amount})
+	}
+	if err := w.fund(&sp, cs, feeRate, types.ZeroCurrency, "", force); err != nil {
+		return Transaction{}, err
+	}
+	return w.finish(sp, cs, true, reservationDuration)
+}
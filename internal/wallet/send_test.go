@@ -0,0 +1,202 @@
+package wallet
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+)
+
+// spendable reports whether the siacoin element with id is one of the
+// wallet's spendable elements.
+func spendable(t *testing.T, w *Wallet, id types.SiacoinOutputID) bool {
+	t.Helper()
+	sces, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return slices.ContainsFunc(sces, func(sce types.SiacoinElement) bool { return sce.ID == id })
+}
+
+func TestSiafundClaimMaturity(t *testing.T) {
+	cm, w := newTestWallet(t)
+	before, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	} else if before.Siafunds != 10000 {
+		t.Fatalf("expected 10000 siafunds, got %v", before.Siafunds)
+	} else if before.Claimable.IsZero() {
+		t.Fatal("expected the genesis contract's tax to be claimable")
+	}
+
+	txn, err := w.SendSiafunds(types.VoidAddress, 100, VersionAuto, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, cm, txn)
+	mineBlocks(t, cm, w, 1)
+
+	// the claim goes to the wallet, but is not spendable until it matures
+	claimID := txn.V2Transaction.SiafundInputs[0].Parent.ID.V2ClaimOutputID()
+	w.mu.Lock()
+	claim, ok := w.state.SiacoinElements[claimID]
+	w.mu.Unlock()
+	if !ok {
+		t.Fatal("expected the wallet to track the claim output")
+	} else if !claim.SiacoinOutput.Value.Equals(before.Claimable) {
+		t.Fatalf("expected a claim of %v, got %v", before.Claimable, claim.SiacoinOutput.Value)
+	} else if claim.MaturityHeight <= cm.Tip().Height+1 {
+		t.Fatalf("expected the claim to mature after %v, got %v", cm.Tip().Height+1, claim.MaturityHeight)
+	}
+	bal, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	} else if bal.Siafunds != 9900 {
+		t.Fatalf("expected 9900 siafunds, got %v", bal.Siafunds)
+	} else if !bal.Immature.Equals(claim.SiacoinOutput.Value) {
+		t.Fatalf("expected %v immature, got %v", claim.SiacoinOutput.Value, bal.Immature)
+	} else if !bal.Claimable.IsZero() {
+		// the remaining siafunds were created after the tax was paid
+		t.Fatalf("expected nothing claimable, got %v", bal.Claimable)
+	}
+
+	for cm.Tip().Height+1 < claim.MaturityHeight {
+		if spendable(t, w, claimID) {
+			t.Fatalf("claim maturing at %v is spendable at %v", claim.MaturityHeight, cm.Tip().Height)
+		}
+		mineBlocks(t, cm, w, 1)
+	}
+	if !spendable(t, w, claimID) {
+		t.Fatalf("claim maturing at %v is not spendable at %v", claim.MaturityHeight, cm.Tip().Height)
+	} else if bal, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if !bal.Immature.IsZero() {
+		t.Fatalf("expected nothing immature, got %v", bal.Immature)
+	}
+}
+
+// A gatedChain withholds chain updates from the wallets following it while
+// its gate is held.
+type gatedChain struct {
+	*chain.Manager
+	gate sync.RWMutex
+}
+
+func (gc *gatedChain) UpdatesSince(index types.ChainIndex, maxBlocks int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	gc.gate.RLock()
+	defer gc.gate.RUnlock()
+	return gc.Manager.UpdatesSince(index, maxBlocks)
+}
+
+// formContract mines a v2 file contract funded by w, adding its tax to the
+// siafund revenue.
+func formContract(t *testing.T, cm *chain.Manager, w *Wallet) {
+	t.Helper()
+	cs := cm.TipState()
+	key := types.GeneratePrivateKey()
+	fc := types.V2FileContract{
+		ProofHeight:      cs.Index.Height + 100,
+		ExpirationHeight: cs.Index.Height + 200,
+		RenterOutput:     types.SiacoinOutput{Address: types.VoidAddress, Value: types.Siacoins(1000)},
+		RenterPublicKey:  key.PublicKey(),
+		HostPublicKey:    key.PublicKey(),
+	}
+	sig := key.SignHash(cs.ContractSigHash(fc))
+	fc.RenterSignature, fc.HostSignature = sig, sig
+	basis, txn, err := w.FundV2Transaction(types.V2Transaction{FileContracts: []types.V2FileContract{fc}}, fc.RenterOutput.Value.Add(cs.V2FileContractTax(fc)), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn, _, _, err = w.SignV2Transaction(txn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, cm, Transaction{V2Transaction: &txn, Basis: basis})
+	mineBlocks(t, cm, w, 1)
+}
+
+func TestSiafundTransferReorg(t *testing.T) {
+	var gc *gatedChain
+	cm, w := newTestWalletWith(t, func(cm *chain.Manager) ChainManager {
+		gc = &gatedChain{Manager: cm}
+		return gc
+	})
+	// a second chain from the same genesis block, which is not sent the
+	// transfer, nor the contract whose tax precedes it
+	_, _, fork := newTestChain(t, w.state.Addresses[0].Address)
+	formContract(t, cm, w)
+
+	txn, err := w.SendSiafunds(types.VoidAddress, 100, VersionAuto, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfi := txn.V2Transaction.SiafundInputs[0]
+	claimID := sfi.Parent.ID.V2ClaimOutputID()
+	broadcast(t, cm, txn)
+	mineBlocks(t, cm, w, 1)
+	if bal, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if bal.Siafunds != 9900 {
+		t.Fatalf("expected 9900 siafunds, got %v", bal.Siafunds)
+	}
+	before, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a longer chain without the transfer reverts it
+	testutil.MineBlocks(t, fork, types.VoidAddress, 3)
+	var blocks []types.Block
+	for height := uint64(1); height <= fork.Tip().Height; height++ {
+		index, _ := fork.BestIndex(height)
+		b, _ := fork.Block(index.ID)
+		blocks = append(blocks, b)
+	}
+	gc.gate.Lock()
+	if err := cm.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != fork.Tip() {
+		t.Fatal("expected a reorg")
+	}
+
+	// until the wallet applies the reorg, the transfer's change, whose
+	// claim starts above the new tip's revenue, is valued at the wallet's
+	// tip
+	if bal, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if bal.Index == cm.Tip() {
+		t.Fatal("expected the wallet to lag the chain")
+	} else if !bal.Claimable.Equals(before.Claimable) {
+		t.Fatalf("expected %v claimable, got %v", before.Claimable, bal.Claimable)
+	}
+	gc.gate.Unlock()
+	waitSynced(t, cm, w)
+
+	w.mu.Lock()
+	_, spent := w.state.SiafundElements[sfi.Parent.ID]
+	_, claimed := w.state.SiacoinElements[claimID]
+	var created int
+	for id := range w.state.SiafundElements {
+		if id != sfi.Parent.ID {
+			created++
+		}
+	}
+	w.mu.Unlock()
+	if !spent {
+		t.Fatal("expected the spent siafund element to be restored")
+	} else if claimed {
+		t.Fatal("expected the claim output to be reverted")
+	} else if created != 0 {
+		t.Fatalf("expected the transfer's change to be reverted, got %v other elements", created)
+	}
+	if bal, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if bal.Siafunds != 10000 {
+		t.Fatalf("expected 10000 siafunds, got %v", bal.Siafunds)
+	} else if !bal.Immature.IsZero() {
+		t.Fatalf("expected nothing immature, got %v", bal.Immature)
+	}
+}
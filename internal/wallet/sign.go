@@ -0,0 +1,142 @@
+package wallet
+
+import (
+	"slices"
+
+	"go.sia.tech/core/types"
+)
+
+// wanted returns a function reporting whether the input with id should be
+// signed: every input if toSign is empty, and otherwise those in toSign.
+func wanted(toSign []types.Hash256) func(types.Hash256) bool {
+	if len(toSign) == 0 {
+		return func(types.Hash256) bool { return true }
+	}
+	m := make(map[types.Hash256]bool, len(toSign))
+	for _, id := range toSign {
+		m[id] = true
+	}
+	return func(id types.Hash256) bool { return m[id] }
+}
+
+// SignTransaction adds a signature with the covered fields cf to each input
+// of txn in toSign, or every input if toSign is empty, that the wallet
+// controls. Inputs that already have a signature are left untouched, except
+// those of multisig addresses, to which the wallet adds its signatures until
+// they have as many as they require. It returns the signed transaction, the
+// IDs of the requested inputs the wallet does not control, and the number
+// of signatures each multisig input still requires.
+func (w *Wallet) SignTransaction(txn types.Transaction, toSign []types.Hash256, cf types.CoveredFields) (types.Transaction, []types.Hash256, map[types.Hash256]int, error) {
+	cs := w.chain.TipState()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return types.Transaction{}, nil, nil, err
+	}
+
+	signed := make(map[types.Hash256]bool)
+	for _, sig := range txn.Signatures {
+		if len(sig.Signature) > 0 {
+			signed[sig.ParentID] = true
+		}
+	}
+	want := wanted(toSign)
+	var result types.Transaction
+	var missing []types.Hash256
+	var remaining map[types.Hash256]int
+	err := w.signWith(func(sign signFunc) {
+		result = txn
+		result.Signatures = slices.Clone(txn.Signatures)
+		missing, remaining = nil, make(map[types.Hash256]int)
+		var sigs []types.TransactionSignature
+		signInput := func(id types.Hash256, uc types.UnlockConditions) {
+			addr := uc.UnlockHash()
+			if !want(id) {
+				return
+			} else if _, ok := w.multisig[addr]; ok {
+				remaining[id] = w.signMultisigV1(cs, &result, id, uc, cf, sign)
+				return
+			} else if signed[id] {
+				return
+			} else if !w.owns(addr) {
+				missing = append(missing, id)
+				return
+			}
+			var h types.Hash256
+			if cf.WholeTransaction {
+				h = cs.WholeSigHash(result, id, 0, 0, cf.Signatures)
+			} else {
+				h = cs.PartialSigHash(result, cf)
+			}
+			sig := sign(w.publicKey(addr), w.state.Addresses[w.owned[addr]].Index, h)
+			sigs = append(sigs, types.TransactionSignature{
+				ParentID:      id,
+				CoveredFields: cf,
+				Signature:     sig[:],
+			})
+		}
+		for _, sci := range result.SiacoinInputs {
+			signInput(types.Hash256(sci.ParentID), sci.UnlockConditions)
+		}
+		for _, sfi := range result.SiafundInputs {
+			signInput(types.Hash256(sfi.ParentID), sfi.UnlockConditions)
+		}
+		result.Signatures = append(result.Signatures, sigs...)
+	})
+	if err != nil {
+		return types.Transaction{}, nil, nil, err
+	}
+	return result, missing, remaining, nil
+}
+
+// SignV2Transaction satisfies the spend policy of each input of txn in
+// toSign, or every input if toSign is empty, that the wallet controls, and
+// adds the wallet's signatures to those of multisig addresses. It returns
+// the signed transaction, the IDs of the requested inputs the wallet does
+// not control, and the number of signatures each multisig input still
+// requires.
+func (w *Wallet) SignV2Transaction(txn types.V2Transaction, toSign []types.Hash256) (types.V2Transaction, []types.Hash256, map[types.Hash256]int, error) {
+	cs := w.chain.TipState()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return types.V2Transaction{}, nil, nil, err
+	}
+
+	sigHash := cs.InputSigHash(txn)
+	want := wanted(toSign)
+	var result types.V2Transaction
+	var missing []types.Hash256
+	var remaining map[types.Hash256]int
+	err := w.signWith(func(sign signFunc) {
+		result = txn.DeepCopy()
+		missing, remaining = nil, make(map[types.Hash256]int)
+		signInput := func(id types.Hash256, addr types.Address, sp *types.SatisfiedPolicy) {
+			if !want(id) {
+				return
+			} else if i, ok := w.multisig[addr]; ok {
+				remaining[id] = w.signMultisigV2(w.state.Multisig[i], sigHash, sp, sign)
+				return
+			} else if !w.owns(addr) {
+				missing = append(missing, id)
+				return
+			}
+			*sp = types.SatisfiedPolicy{
+				Policy:     w.policy(addr),
+				Signatures: []types.Signature{sign(w.publicKey(addr), w.state.Addresses[w.owned[addr]].Index, sigHash)},
+			}
+		}
+		for i := range result.SiacoinInputs {
+			sci := &result.SiacoinInputs[i]
+			signInput(types.Hash256(sci.Parent.ID), sci.Parent.SiacoinOutput.Address, &sci.SatisfiedPolicy)
+		}
+		for i := range result.SiafundInputs {
+			sfi := &result.SiafundInputs[i]
+			signInput(types.Hash256(sfi.Parent.ID), sfi.Parent.SiafundOutput.Address, &sfi.SatisfiedPolicy)
+		}
+	})
+	if err != nil {
+		return types.V2Transaction{}, nil, nil, err
+	}
+	return result, missing, remaining, nil
+}
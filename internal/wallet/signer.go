@@ -0,0 +1,280 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// DefaultSignerTimeout is the time a reference signer waits for each
+// signature if no timeout is given. Hardware wallets may wait for a person to
+// confirm, so it is generous.
+const DefaultSignerTimeout = 30 * time.Second
+
+// maxSignerOutput is the maximum size of a reference signer's response.
+const maxSignerOutput = 64 << 10
+
+// ErrSigner is returned when a wallet's external signer fails to sign, or
+// returns a signature that does not verify. Unlike the errors returned for
+// invalid requests or transactions, it indicates a problem with the signer,
+// and the request may succeed once it is resolved.
+var ErrSigner = errors.New("external signer failed")
+
+// A Signer signs on behalf of the node's seed wallets, in place of the keys
+// they derive from their seeds, so that the keys can be kept elsewhere, such
+// as in a hardware wallet or HSM. SignHash returns the signature of sigHash
+// by the key at index of the named wallet: the key derived from its seed at
+// that index, whose public key is in the wallet's address with that index.
+// Since the wallets still derive their addresses from their seeds, the
+// signer must hold the same seeds.
+//
+// The wallet's inputs stay reserved while a transaction it constructs waits
+// for its signatures, but SignHash should still return an error, rather than
+// block, if the signer is unavailable.
+type Signer interface {
+	SignHash(wallet string, index uint64, sigHash types.Hash256) (types.Signature, error)
+}
+
+// externalSign returns the signature requested by req from signer, on behalf
+// of the named wallet.
+func externalSign(signer Signer, wallet string, log *zap.Logger, req sigRequest) (types.Signature, error) {
+	start := time.Now()
+	sig, err := signer.SignHash(wallet, req.index, req.hash)
+	if err != nil {
+		return types.Signature{}, fmt.Errorf("%w: key %d: %w", ErrSigner, req.index, err)
+	} else if !req.pk.VerifyHash(req.hash, sig) {
+		// caught here, rather than when the transaction is rejected
+		return types.Signature{}, fmt.Errorf("%w: signature by key %d does not verify", ErrSigner, req.index)
+	}
+	log.Debug("signed with external signer", zap.Uint64("index", req.index), zap.Duration("elapsed", time.Since(start)))
+	return sig, nil
+}
+
+// A sigRequest is a hash to be signed by the key at index, whose public key
+// is pk.
+type sigRequest struct {
+	pk    types.PublicKey
+	index uint64
+	hash  types.Hash256
+}
+
+// signAll returns the signatures requested by reqs, signing each distinct
+// request once. An external signer may wait for a person to confirm, so
+// w.mu is released while it signs, and the caller must check that the
+// wallet has not changed in a way that matters once signAll returns. It must
+// be called with w.mu held.
+func (w *Wallet) signAll(reqs []sigRequest) ([]types.Signature, error) {
+	sigs := make([]types.Signature, len(reqs))
+	if w.signer == nil {
+		for i, req := range reqs {
+			sigs[i] = w.keyAt(req.index).SignHash(req.hash)
+		}
+		return sigs, nil
+	}
+	signer, name, log := w.signer, w.name, w.log
+	w.mu.Unlock()
+	defer w.mu.Lock()
+	signed := make(map[sigRequest]types.Signature)
+	for i, req := range reqs {
+		sig, ok := signed[req]
+		if !ok {
+			var err error
+			if sig, err = externalSign(signer, name, log, req); err != nil {
+				return nil, err
+			}
+			signed[req] = sig
+		}
+		sigs[i] = sig
+	}
+	return sigs, nil
+}
+
+// A signFunc returns the signature of h by the key at index, whose public
+// key is pk.
+type signFunc func(pk types.PublicKey, index uint64, h types.Hash256) types.Signature
+
+// signWith runs sign until every signature it asks for has been signed. The
+// first run collects the requests, which are signed with signAll, and the
+// next runs again with their signatures. Since signAll may release w.mu,
+// each run sees the wallet's state as it is then, and any signatures it newly
+// requires, such as for an address added in the meantime, are requested in
+// turn. It must be called with w.mu held.
+func (w *Wallet) signWith(sign func(signFunc)) error {
+	signed := make(map[sigRequest]types.Signature)
+	for {
+		var pending []sigRequest
+		sign(func(pk types.PublicKey, index uint64, h types.Hash256) types.Signature {
+			req := sigRequest{pk: pk, index: index, hash: h}
+			sig, ok := signed[req]
+			if !ok {
+				pending = append(pending, req)
+			}
+			return sig
+		})
+		if len(pending) == 0 {
+			return nil
+		}
+		sigs, err := w.signAll(pending)
+		if err != nil {
+			return err
+		} else if !w.exists {
+			return ErrNotFound
+		}
+		for i, req := range pending {
+			signed[req] = sigs[i]
+		}
+	}
+}
+
+// A SignRequest is the request sent to a reference signer: the hex-encoded
+// hash to sign, and the wallet and derivation index of the key to sign it
+// with.
+type SignRequest struct {
+	Wallet  string        `json:"wallet"`
+	Index   uint64        `json:"index"`
+	SigHash types.Hash256 `json:"sigHash"`
+}
+
+// A SignResponse is the response of a reference signer.
+type SignResponse struct {
+	Signature types.Signature `json:"signature"`
+}
+
+// A CommandSigner is a Signer that runs an external command for each
+// signature. The command is given a SignRequest as JSON on its standard
+// input, and must write a SignResponse as JSON to its standard output and
+// exit successfully. If it fails, the end of its standard error is included
+// in the error.
+type CommandSigner struct {
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+// SignHash implements Signer.
+func (cs *CommandSigner) SignHash(wallet string, index uint64, sigHash types.Hash256) (types.Signature, error) {
+	req, err := json.Marshal(SignRequest{Wallet: wallet, Index: index, SigHash: sigHash})
+	if err != nil {
+		return types.Signature{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cs.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cs.path, cs.args...)
+	cmd.Stdin = bytes.NewReader(req)
+	var stdout, stderr limitedBuffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); ctx.Err() != nil {
+		return types.Signature{}, fmt.Errorf("timed out after %v", cs.timeout)
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return types.Signature{}, fmt.Errorf("%w: %s", err, msg)
+		}
+		return types.Signature{}, err
+	}
+	var resp SignResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return types.Signature{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp.Signature, nil
+}
+
+// NewCommandSigner returns a Signer that runs the command at path with args,
+// waiting at most timeout for each signature, or DefaultSignerTimeout if it
+// is zero.
+func NewCommandSigner(path string, args []string, timeout time.Duration) *CommandSigner {
+	if timeout == 0 {
+		timeout = DefaultSignerTimeout
+	}
+	return &CommandSigner{path: path, args: args, timeout: timeout}
+}
+
+// An HTTPSigner is a Signer that posts a SignRequest, as JSON, to a signer
+// listening on the local machine, which must respond with 200 and a
+// SignResponse. Any other status is an error, whose message is the end of
+// the response body.
+type HTTPSigner struct {
+	url    string
+	client *http.Client
+}
+
+// SignHash implements Signer.
+func (hs *HTTPSigner) SignHash(wallet string, index uint64, sigHash types.Hash256) (types.Signature, error) {
+	req, err := json.Marshal(SignRequest{Wallet: wallet, Index: index, SigHash: sigHash})
+	if err != nil {
+		return types.Signature{}, err
+	}
+	r, err := hs.client.Post(hs.url, "application/json", bytes.NewReader(req))
+	if err != nil {
+		return types.Signature{}, err
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignerOutput))
+	if err != nil {
+		return types.Signature{}, fmt.Errorf("failed to read response: %w", err)
+	} else if r.StatusCode != http.StatusOK {
+		return types.Signature{}, fmt.Errorf("%v: %s", r.Status, strings.TrimSpace(tail(body)))
+	}
+	var resp SignResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return types.Signature{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp.Signature, nil
+}
+
+// NewHTTPSigner returns a Signer that posts to rawURL, waiting at most
+// timeout for each signature, or DefaultSignerTimeout if it is zero. Since
+// the signer signs whatever it is sent, rawURL must be an http URL on a
+// loopback address.
+func NewHTTPSigner(rawURL string, timeout time.Duration) (*HTTPSigner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer URL: %w", err)
+	} else if u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid signer URL %q: must use http", rawURL)
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("invalid signer URL %q: host must be a loopback address", rawURL)
+	}
+	if timeout == 0 {
+		timeout = DefaultSignerTimeout
+	}
+	return &HTTPSigner{url: u.String(), client: &http.Client{Timeout: timeout}}, nil
+}
+
+// A limitedBuffer is a bytes.Buffer that keeps only the first
+// maxSignerOutput bytes written to it, so that a misbehaving signer cannot
+// exhaust the node's memory.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if n := maxSignerOutput - lb.Len(); n > 0 {
+		lb.Buffer.Write(p[:min(len(p), n)])
+	}
+	return len(p), nil
+}
+
+func (lb *limitedBuffer) String() string { return tail(lb.Bytes()) }
+
+// tail returns the last few hundred bytes of b, for error messages.
+func tail(b []byte) string {
+	const n = 512
+	if len(b) > n {
+		b = b[len(b)-n:]
+	}
+	return string(b)
+}
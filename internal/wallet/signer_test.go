@@ -0,0 +1,169 @@
+package wallet
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/testutil"
+	cwallet "go.sia.tech/coreutils/wallet"
+)
+
+// A gatedSigner signs with the keys of seed, but waits for release to be
+// closed first, closing started once the first signature is requested. If
+// bad is set, it returns signatures that do not verify.
+type gatedSigner struct {
+	seed    [32]byte
+	bad     bool
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+	calls   int
+}
+
+func (gs *gatedSigner) SignHash(wallet string, index uint64, sigHash types.Hash256) (types.Signature, error) {
+	gs.once.Do(func() { close(gs.started) })
+	<-gs.release
+	gs.calls++
+	if gs.bad {
+		return types.Signature{}, nil
+	}
+	return cwallet.KeyFromSeed(&gs.seed, index).SignHash(sigHash), nil
+}
+
+// withGatedSigner makes w sign with a new gatedSigner holding its seed.
+func withGatedSigner(w *Wallet, bad bool) *gatedSigner {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	gs := &gatedSigner{
+		seed:    *w.seed,
+		bad:     bad,
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w.signer = gs
+	return gs
+}
+
+type sendResult struct {
+	txn Transaction
+	err error
+}
+
+// sendAsync sends amount from w to the void address in the background.
+func sendAsync(w *Wallet, amount types.Currency) <-chan sendResult {
+	ch := make(chan sendResult, 1)
+	go func() {
+		txn, err := w.SendSiacoins(types.VoidAddress, amount, nil, StrategyLargest, VersionAuto, false)
+		ch <- sendResult{txn, err}
+	}()
+	return ch
+}
+
+// waitStarted waits for gs to be asked for a signature.
+func waitStarted(t *testing.T, gs *gatedSigner) {
+	t.Helper()
+	select {
+	case <-gs.started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("signer was not called")
+	}
+}
+
+func TestSignerSend(t *testing.T) {
+	cm, w := newTestWallet(t)
+	n := cm.TipState().Network
+	testutil.MineBlocks(t, cm, w.state.Addresses[0].Address, int(n.MaturityDelay)+3)
+	waitSynced(t, cm, w)
+	gs := withGatedSigner(w, false)
+
+	// an amount needing several inputs, all from the first address
+	sces, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var largest types.Currency
+	for _, sce := range sces {
+		if sce.SiacoinOutput.Value.Cmp(largest) > 0 {
+			largest = sce.SiacoinOutput.Value
+		}
+	}
+	amount := largest.Add(types.Siacoins(1))
+	res := sendAsync(w, amount)
+	waitStarted(t, gs)
+
+	// the wallet is not locked while the signer waits, and the inputs are
+	// already reserved
+	if _, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, cm, w, 1)
+	during, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(during) >= len(sces) {
+		t.Fatalf("expected the inputs to be reserved while signing, got %v spendable elements of %v", len(during), len(sces))
+	}
+
+	close(gs.release)
+	r := <-res
+	if r.err != nil {
+		t.Fatal(r.err)
+	} else if len(r.txn.Reserved) < 2 {
+		t.Fatalf("expected several inputs, got %v", len(r.txn.Reserved))
+	} else if gs.calls != 1 {
+		t.Fatalf("expected the inputs' shared sighash to be signed once, got %v calls", gs.calls)
+	}
+	broadcast(t, cm, r.txn)
+	mineBlocks(t, cm, w, 1)
+	ev, err := w.Event(types.Hash256(r.txn.ID))
+	if err != nil {
+		t.Fatal(err)
+	} else if ev.Index != cm.Tip() {
+		t.Fatalf("expected the transaction to be confirmed at %v, got %v", cm.Tip(), ev.Index)
+	}
+}
+
+func TestSignerReservationLost(t *testing.T) {
+	cm, w := newTestWallet(t)
+	n := cm.TipState().Network
+	testutil.MineBlocks(t, cm, w.state.Addresses[0].Address, int(n.MaturityDelay)+1)
+	waitSynced(t, cm, w)
+	before, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a reservation released while the signer waits invalidates the
+	// transaction
+	gs := withGatedSigner(w, false)
+	res := sendAsync(w, types.Siacoins(1))
+	waitStarted(t, gs)
+	w.mu.Lock()
+	reserved := slices.Collect(maps.Keys(w.state.Reservations))
+	w.mu.Unlock()
+	if err := w.Release(reserved); err != nil {
+		t.Fatal(err)
+	}
+	close(gs.release)
+	if r := <-res; !errors.Is(r.err, ErrReservationLost) {
+		t.Fatalf("expected %v, got %v", ErrReservationLost, r.err)
+	}
+
+	// a signature that does not verify fails, releasing the inputs
+	gs = withGatedSigner(w, true)
+	close(gs.release)
+	if r := <-sendAsync(w, types.Siacoins(1)); !errors.Is(r.err, ErrSigner) {
+		t.Fatalf("expected %v, got %v", ErrSigner, r.err)
+	}
+	after, _, err := w.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(after) != len(before) {
+		t.Fatalf("expected %v spendable elements after the failures, got %v", len(before), len(after))
+	}
+}
@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.sia.tech/core/types"
+	cwallet "go.sia.tech/coreutils/wallet"
+)
+
+const (
+	// DefaultSweepLookahead is the number of addresses derived from a swept
+	// seed if none is given.
+	DefaultSweepLookahead = 100
+	// maxSweepInputs is the maximum number of siacoin inputs in each sweep
+	// transaction.
+	maxSweepInputs = 100
+)
+
+// A SweepResult describes the transactions that sweep the elements of a seed
+// into the wallet. Siacoins and Siafunds are the value found, including that
+// of skipped elements; Fee is the total fee paid. Skipped are the siacoin
+// elements worth no more than the fee required to spend them, and
+// SkippedSiafunds the siafund elements left behind if there were no siacoins
+// to pay their fee.
+type SweepResult struct {
+	Siacoins        types.Currency
+	Siafunds        uint64
+	Fee             types.Currency
+	Transactions    []Transaction
+	Skipped         []types.SiacoinElement
+	SkippedSiafunds []types.SiafundElement
+}
+
+// Sweep scans the chain from height for the spendable elements of the first
+// lookahead addresses derived from the seed phrase, and constructs and signs
+// transactions sending them to the wallet's first address. The seed is only
+// held in memory for the duration of the call. The scan runs until the
+// chain's tip, or until ctx is canceled.
+//
+// Unlike the wallet's own transactions, the spent elements are not reserved,
+// since they do not belong to the wallet.
+func (w *Wallet) Sweep(ctx context.Context, phrase string, lookahead int, height uint64) (SweepResult, error) {
+	if err := CheckPhrase(phrase); err != nil {
+		return SweepResult{}, err
+	} else if lookahead <= 0 {
+		lookahead = DefaultSweepLookahead
+	}
+	w.mu.Lock()
+	if !w.exists {
+		w.mu.Unlock()
+		return SweepResult{}, ErrNotFound
+	}
+	dest := w.state.Addresses[0].Address
+	start := w.scanStart(height)
+	w.mu.Unlock()
+
+	sw := &Wallet{
+		chain: w.chain,
+		seed:  new([32]byte),
+		state: persistWallet{
+			Tip:             start,
+			SiacoinElements: make(map[types.SiacoinOutputID]types.SiacoinElement),
+			SiafundElements: make(map[types.SiafundOutputID]types.SiafundElement),
+			Reservations:    make(map[types.Hash256]time.Time),
+		},
+		owned: make(map[types.Address]int),
+	}
+	defer clear(sw.seed[:])
+	if err := cwallet.SeedFromPhrase(sw.seed, phrase); err != nil {
+		return SweepResult{}, fmt.Errorf("%w: %w", ErrInvalidSeed, err)
+	}
+	for range lookahead {
+		sw.addAddress()
+	}
+	for sw.state.Tip != w.chain.Tip() {
+		if err := ctx.Err(); err != nil {
+			return SweepResult{}, err
+		}
+		reverted, applied, err := w.chain.UpdatesSince(sw.state.Tip, maxSyncBlocks)
+		if err != nil {
+			return SweepResult{}, fmt.Errorf("failed to get updates since %v: %w", sw.state.Tip, err)
+		}
+		for _, cru := range reverted {
+			sw.revertUpdate(cru)
+		}
+		for _, cau := range applied {
+			sw.applyUpdate(cau)
+		}
+	}
+
+	cs := w.chain.TipState()
+	feeRate := w.chain.RecommendedFee()
+	var res SweepResult
+	// elements worth less than the fee to spend them are left behind
+	var sces []types.SiacoinElement
+	for _, sce := range sw.spendableSiacoins() {
+		res.Siacoins = res.Siacoins.Add(sce.SiacoinOutput.Value)
+		dust := feeRate.Mul64(spend{v2: newSpend(cs).v2, inputs: []types.SiacoinElement{sce}}.weight(sw, cs))
+		if sce.SiacoinOutput.Value.Cmp(dust) <= 0 {
+			res.Skipped = append(res.Skipped, sce)
+			continue
+		}
+		sces = append(sces, sce)
+	}
+	sfes := sw.spendableSiafunds()
+	for _, sfe := range sfes {
+		res.Siafunds += sfe.SiafundOutput.Value
+	}
+	if len(sces) == 0 {
+		res.SkippedSiafunds = sfes
+		return res, nil
+	}
+
+	for i := 0; i < len(sces); i += maxSweepInputs {
+		sp := newSpend(cs)
+		sp.inputs = sces[i:min(i+maxSweepInputs, len(sces))]
+		// the siafunds are swept by the first transaction, which claims
+		// their siacoins to the wallet
+		if i == 0 && len(sfes) > 0 {
+			sp.sfInputs, sp.claimAddr = sfes, dest
+			sp.sfOutputs = []types.SiafundOutput{{Address: dest, Value: res.Siafunds}}
+		}
+		var inputSum types.Currency
+		for _, sce := range sp.inputs {
+			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+		}
+		// v1 currencies are variable-length, so the weight is computed with
+		// the output's value set
+		sp.outputs = []types.SiacoinOutput{{Address: dest, Value: inputSum}}
+		sp.fee = feeRate.Mul64(sp.weight(sw, cs))
+		if inputSum.Cmp(sp.fee) <= 0 {
+			res.Skipped = append(res.Skipped, sp.inputs...)
+			if len(sp.sfInputs) > 0 {
+				res.SkippedSiafunds = sfes
+			}
+			continue
+		}
+		sp.outputs[0].Value = inputSum.Sub(sp.fee)
+
+		// the swept elements are not the wallet's, so they are not reserved
+		txn := sw.build(sp)
+		txn.Reserved = nil
+		sigs, err := sw.signAll(sp.sigRequests(sw, cs, txn))
+		if err != nil {
+			return SweepResult{}, err
+		}
+		sp.addSignatures(txn, sigs)
+		res.Transactions = append(res.Transactions, txn)
+		res.Fee = res.Fee.Add(sp.fee)
+	}
+	return res, nil
+}
@@ -0,0 +1,877 @@
+// Package wallet implements a seed-based hot wallet that tracks the siacoin
+// and siafund elements of addresses derived from its seed, or a watch-only
+// wallet that tracks a list of addresses without any keys.
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.uber.org/zap"
+)
+
+// maxSyncBlocks is the maximum number of blocks applied to the wallet at a
+// time. The wallet is saved after each batch.
+const maxSyncBlocks = 100
+
+var (
+	// ErrNotFound is returned when the wallet has not been created.
+	ErrNotFound = errors.New("wallet not found")
+	// ErrExists is returned when creating a wallet that already exists.
+	ErrExists = errors.New("wallet already exists")
+	// ErrInvalidSeed is returned when a seed phrase cannot be decoded.
+	ErrInvalidSeed = errors.New("invalid seed phrase")
+	// ErrWatchOnly is returned when spending from, signing with, deriving
+	// addresses for, or locking a watch-only wallet.
+	ErrWatchOnly = errors.New("watch-only wallet")
+	// ErrNotWatchOnly is returned when watching addresses with a seed wallet,
+	// whose addresses are derived from its seed.
+	ErrNotWatchOnly = errors.New("wallet is not watch-only")
+	// ErrScanInProgress is returned when starting a scan, or watching
+	// addresses, while a previous scan has not finished.
+	ErrScanInProgress = errors.New("wallet scan in progress")
+)
+
+// Wallet types.
+const (
+	TypeSeed  = "seed"
+	TypeWatch = "watch"
+)
+
+// A ChainManager provides the chain updates applied to the wallet.
+type ChainManager interface {
+	Tip() types.ChainIndex
+	TipState() consensus.State
+	State(id types.BlockID) (consensus.State, bool)
+	BestIndex(height uint64) (types.ChainIndex, bool)
+	RecommendedFee() types.Currency
+	OnReorg(fn func(types.ChainIndex)) (cancel func())
+	UpdatesSince(index types.ChainIndex, maxBlocks int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error)
+	PoolTransactions() []types.Transaction
+	V2PoolTransactions() []types.V2Transaction
+}
+
+// An Address is an address derived from the wallet's seed, or watched by a
+// watch-only wallet, whose addresses have no SpendPolicy. It is used once an
+// output has been sent to it. A Lookahead address was derived to watch for
+// outputs beyond the highest used address, and has not been returned by
+// AddAddresses.
+type Address struct {
+	Index       uint64             `json:"index"`
+	Address     types.Address      `json:"address"`
+	SpendPolicy *types.SpendPolicy `json:"spendPolicy,omitempty"`
+	Used        bool               `json:"used"`
+	Lookahead   bool               `json:"lookahead,omitempty"`
+}
+
+// AddressInfo is an Address along with its label and the value of its
+// confirmed elements. The addresses of a watch-only wallet are WatchOnly;
+// their Index is their position in the wallet, rather than a derivation
+// index.
+type AddressInfo struct {
+	Address
+	WatchOnly bool           `json:"watchOnly,omitempty"`
+	Label     string         `json:"label,omitempty"`
+	Siacoins  types.Currency `json:"siacoins"`
+	Siafunds  uint64         `json:"siafunds"`
+}
+
+// Status describes the state of the wallet. The wallet is synced once it has
+// applied every block up to the chain's tip. A seed wallet whose seed is
+// encrypted with a Passphrase is Locked until it is unlocked; if it was
+// unlocked with a timeout, it locks itself again at LockAt.
+type Status struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Tip        types.ChainIndex `json:"tip"`
+	Synced     bool             `json:"synced"`
+	Addresses  int              `json:"addresses"`
+	Passphrase bool             `json:"passphrase,omitempty"`
+	Locked     bool             `json:"locked,omitempty"`
+	LockAt     *time.Time       `json:"lockAt,omitempty"`
+}
+
+// A Balance is the value of the wallet's elements as of Index. Siacoins are
+// spendable in the next block, while Immature siacoins, such as miner
+// payouts, are not yet. Unconfirmed values are those of outputs created and
+// spent by transactions in the txpool. Claimable siacoins are those accrued
+// by the wallet's siafunds, which are claimed when the siafunds are spent.
+type Balance struct {
+	Index               types.ChainIndex `json:"index"`
+	Siacoins            types.Currency   `json:"siacoins"`
+	Immature            types.Currency   `json:"immature"`
+	UnconfirmedIncoming types.Currency   `json:"unconfirmedIncoming"`
+	UnconfirmedOutgoing types.Currency   `json:"unconfirmedOutgoing"`
+	Siafunds            uint64           `json:"siafunds"`
+	Claimable           types.Currency   `json:"claimable"`
+}
+
+// persistWallet is the on-disk format of the wallet's state.
+type persistWallet struct {
+	Type            string                                         `json:"type"`
+	Tip             types.ChainIndex                               `json:"tip"`
+	Addresses       []Address                                      `json:"addresses"`
+	SiacoinElements map[types.SiacoinOutputID]types.SiacoinElement `json:"siacoinElements"`
+	SiafundElements map[types.SiafundOutputID]types.SiafundElement `json:"siafundElements"`
+	Reservations    map[types.Hash256]time.Time                    `json:"reservations"`
+	Events          []Event                                        `json:"events"`
+	Multisig        []MultisigAddress                              `json:"multisig,omitempty"`
+	Scan            *scanState                                     `json:"scan,omitempty"`
+	GapLimit        int                                            `json:"gapLimit,omitempty"`
+	Strategy        string                                         `json:"strategy,omitempty"`
+	// Labels and Memos are keyed by address and transaction ID, rather than
+	// by element or event, so that they survive rescans.
+	Labels map[types.Address]string       `json:"labels,omitempty"`
+	Memos  map[types.TransactionID]string `json:"memos,omitempty"`
+	// Birth is the height of the first block that may contain outputs to
+	// the wallet's addresses, from which lookahead addresses are scanned
+	// for.
+	// The last Unscanned addresses are lookahead addresses that are tracked
+	// once they have been scanned for.
+	Birth     uint64 `json:"birth,omitempty"`
+	Unscanned int    `json:"unscanned,omitempty"`
+	// Fresh is set on a wallet generated by Init, which has no chain to
+	// take the tip from. It starts at the chain's tip when it is loaded.
+	Fresh bool `json:"fresh,omitempty"`
+}
+
+// A Wallet derives addresses from a seed, or watches a list of addresses,
+// and tracks the elements they own, applying the chain updates provided by
+// its Manager. The seed phrase is stored encrypted with the wallet password,
+// or with the wallet's own passphrase, in which case the wallet is locked
+// until it is unlocked with it.
+type Wallet struct {
+	chain    ChainManager
+	dir      string
+	password string
+	log      *zap.Logger
+	name     string
+	trigger  func() // wakes the manager to apply chain updates
+
+	mu     sync.Mutex
+	exists bool
+	seed   *[32]byte // nil until the wallet is created, if it is watch-only, or while it is locked
+	state  persistWallet
+	owned  map[types.Address]int // index of each address in state.Addresses
+	// index of each multisig address in state.Multisig
+	multisig map[types.Address]int
+	synced   bool
+
+	// the time and height at which the scan started or resumed, from which
+	// its rate is estimated
+	scanResumed       time.Time
+	scanResumedHeight uint64
+
+	funded map[fundKey]fundedTransaction
+	rng    *rand.Rand // orders elements for random coin selection
+	signer Signer     // signs in place of the seed's keys, if set
+
+	// passphrase is set if the seed is encrypted with a passphrase, in which
+	// case the decrypted phrase is kept while the wallet is unlocked, for
+	// backups
+	passphrase bool
+	phrase     []byte
+	lockTimer  *time.Timer
+	lockAt     time.Time
+
+	// unlockMu serializes unlock attempts, and guards the count of wrong
+	// passphrases
+	unlockMu         sync.Mutex
+	failedUnlocks    int
+	lastFailedUnlock time.Time
+}
+
+func (w *Wallet) seedPath() string  { return filepath.Join(w.dir, "seed.json") }
+func (w *Wallet) statePath() string { return filepath.Join(w.dir, "wallet.json") }
+
+// save writes the wallet's state to disk. It must be called with w.mu held.
+func (w *Wallet) save() error {
+	js, err := json.Marshal(w.state)
+	if err != nil {
+		return err
+	}
+	tmp := w.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, js, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.statePath())
+}
+
+func (w *Wallet) load() error {
+	js, err := os.ReadFile(w.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var p persistWallet
+	if err := json.Unmarshal(js, &p); err != nil {
+		return fmt.Errorf("failed to decode %v: %w", w.statePath(), err)
+	}
+	if p.Type == "" {
+		p.Type = TypeSeed // created before watch-only wallets
+	}
+	if p.Reservations == nil {
+		p.Reservations = make(map[types.Hash256]time.Time) // created before reservations were saved
+	}
+
+	if p.Type == TypeSeed {
+		phrase, err := readSeed(w.seedPath(), w.password)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // creation was interrupted before the seed was written
+		} else if errors.Is(err, errSeedPassphrase) {
+			w.passphrase = true // locked until unlocked
+		} else if err != nil {
+			return err
+		} else {
+			w.seed = new([32]byte)
+			if err := cwallet.SeedFromPhrase(w.seed, phrase); err != nil {
+				return fmt.Errorf("failed to decode seed phrase: %w", err)
+			}
+		}
+	}
+	w.exists, w.state = true, p
+	for i, addr := range p.Addresses[:len(p.Addresses)-p.Unscanned] {
+		w.owned[addr.Address] = i
+	}
+	w.multisig = make(map[types.Address]int)
+	for i, ms := range p.Multisig {
+		w.multisig[ms.Address] = i
+	}
+	if p.Fresh {
+		w.state.Tip, w.state.Fresh = w.chain.Tip(), false
+		w.state.Birth = w.state.Tip.Height + 1
+		return w.save()
+	}
+	return nil
+}
+
+// deriveAddress derives the address at index from seed.
+func deriveAddress(seed *[32]byte, index uint64) Address {
+	pk := cwallet.KeyFromSeed(seed, index).PublicKey()
+	policy := types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(types.StandardUnlockConditions(pk))}
+	return Address{
+		Index:       index,
+		Address:     policy.Address(),
+		SpendPolicy: &policy,
+	}
+}
+
+// addAddress derives the next address and starts tracking it. Outputs sent
+// to it are detected in any block the wallet has not yet applied. It must be
+// called with w.mu held.
+func (w *Wallet) addAddress() Address {
+	addr := deriveAddress(w.seed, uint64(len(w.state.Addresses)))
+	w.owned[addr.Address] = len(w.state.Addresses)
+	w.state.Addresses = append(w.state.Addresses, addr)
+	return addr
+}
+
+// watchAddress starts tracking addr in a watch-only wallet. It must be called
+// with w.mu held.
+func (w *Wallet) watchAddress(addr types.Address) {
+	w.owned[addr] = len(w.state.Addresses)
+	w.state.Addresses = append(w.state.Addresses, Address{
+		Index:   uint64(len(w.state.Addresses)),
+		Address: addr,
+	})
+}
+
+// canSign returns an error if the wallet cannot sign transactions. It must
+// be called with w.mu held.
+func (w *Wallet) canSign() error {
+	if !w.exists {
+		return ErrNotFound
+	} else if w.state.Type == TypeWatch {
+		return ErrWatchOnly
+	} else if w.seed == nil && w.signer == nil {
+		return ErrLocked
+	}
+	return nil
+}
+
+// markUsed marks addr as used. Addresses remain used even if the output is
+// later reverted. It must be called with w.mu held.
+func (w *Wallet) markUsed(addr types.Address) {
+	if i, ok := w.multisig[addr]; ok {
+		w.state.Multisig[i].Used = true
+		return
+	}
+	w.state.Addresses[w.owned[addr]].Used = true
+}
+
+// owns reports whether addr is one of the wallet's addresses, not counting
+// its multisig addresses. It must be called with w.mu held.
+func (w *Wallet) owns(addr types.Address) bool {
+	_, ok := w.owned[addr]
+	return ok
+}
+
+// tracks reports whether the wallet tracks the elements of addr: one of its
+// addresses, or one of its multisig addresses. It must be called with w.mu
+// held.
+func (w *Wallet) tracks(addr types.Address) bool {
+	_, ok := w.multisig[addr]
+	return ok || w.owns(addr)
+}
+
+// revertUpdate reverts a block from the wallet's state. It must be called
+// with w.mu held.
+func (w *Wallet) revertUpdate(cru chain.RevertUpdate) {
+	for _, sced := range cru.SiacoinElementDiffs() {
+		sce := sced.SiacoinElement
+		switch {
+		case sced.Created && sced.Spent:
+			continue // ephemeral
+		case !w.tracks(sce.SiacoinOutput.Address):
+			continue
+		case sced.Created:
+			delete(w.state.SiacoinElements, sce.ID)
+		case sced.Spent:
+			w.state.SiacoinElements[sce.ID] = sce.Copy()
+		}
+	}
+	for _, sfed := range cru.SiafundElementDiffs() {
+		sfe := sfed.SiafundElement
+		switch {
+		case sfed.Created && sfed.Spent:
+			continue
+		case !w.tracks(sfe.SiafundOutput.Address):
+			continue
+		case sfed.Created:
+			delete(w.state.SiafundElements, sfe.ID)
+		case sfed.Spent:
+			w.state.SiafundElements[sfe.ID] = sfe.Copy()
+		}
+	}
+	// proofs are updated after the block's own elements are removed, since
+	// they no longer exist in the reverted accumulator
+	for id, sce := range w.state.SiacoinElements {
+		cru.UpdateElementProof(&sce.StateElement)
+		w.state.SiacoinElements[id] = sce
+	}
+	for id, sfe := range w.state.SiafundElements {
+		cru.UpdateElementProof(&sfe.StateElement)
+		w.state.SiafundElements[id] = sfe
+	}
+	w.revertEvents(cru)
+	w.state.Tip = cru.State.Index
+}
+
+// applyUpdate applies a block to the wallet's state. It must be called with
+// w.mu held.
+func (w *Wallet) applyUpdate(cau chain.ApplyUpdate) {
+	// proofs are updated before the block's own elements are added, since
+	// theirs are already current
+	for id, sce := range w.state.SiacoinElements {
+		cau.UpdateElementProof(&sce.StateElement)
+		w.state.SiacoinElements[id] = sce
+	}
+	for id, sfe := range w.state.SiafundElements {
+		cau.UpdateElementProof(&sfe.StateElement)
+		w.state.SiafundElements[id] = sfe
+	}
+	for _, sced := range cau.SiacoinElementDiffs() {
+		sce := sced.SiacoinElement
+		switch {
+		case sced.Created && sced.Spent:
+			continue
+		case !w.tracks(sce.SiacoinOutput.Address):
+			continue
+		case sced.Created:
+			w.state.SiacoinElements[sce.ID] = sce.Copy()
+			w.markUsed(sce.SiacoinOutput.Address)
+		case sced.Spent:
+			delete(w.state.SiacoinElements, sce.ID)
+			delete(w.state.Reservations, types.Hash256(sce.ID))
+		}
+	}
+	for _, sfed := range cau.SiafundElementDiffs() {
+		sfe := sfed.SiafundElement
+		switch {
+		case sfed.Created && sfed.Spent:
+			continue
+		case !w.tracks(sfe.SiafundOutput.Address):
+			continue
+		case sfed.Created:
+			w.state.SiafundElements[sfe.ID] = sfe.Copy()
+			w.markUsed(sfe.SiafundOutput.Address)
+		case sfed.Spent:
+			delete(w.state.SiafundElements, sfe.ID)
+			delete(w.state.Reservations, types.Hash256(sfe.ID))
+		}
+	}
+	w.state.Events = append(w.state.Events, w.appliedEvents(cau)...)
+	w.state.Tip = cau.State.Index
+}
+
+// syncTo applies updates fetched since tip. It reports whether the wallet is
+// done syncing: it has reached the chain's tip, or it no longer exists.
+func (w *Wallet) syncTo(tip types.ChainIndex, reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return true, nil
+	} else if w.state.Tip != tip {
+		return false, nil // the wallet changed since the updates were fetched
+	}
+	for _, cru := range reverted {
+		w.revertUpdate(cru)
+	}
+	for _, cau := range applied {
+		w.applyUpdate(cau)
+	}
+	// outputs sent to the lookahead addresses slide the window forward
+	w.extendLookahead()
+	if err := w.scanLookahead(); err != nil {
+		return false, fmt.Errorf("failed to scan lookahead addresses of wallet %q: %w", w.name, err)
+	}
+	// the chain may have moved on while the updates were applied
+	w.synced = (len(reverted) == 0 && len(applied) == 0) || w.state.Tip == w.chain.Tip()
+	if err := w.save(); err != nil {
+		return false, fmt.Errorf("failed to save wallet %q: %w", w.name, err)
+	}
+	return w.synced, nil
+}
+
+// create creates the wallet from phrase. If phrase is empty, a new one is
+// generated and returned; since a new seed cannot own any existing outputs,
+// the wallet starts at the current tip, or is marked fresh if it has no
+// chain. Otherwise, the wallet is scanned from the genesis block. If
+// passphrase is set, the seed is encrypted with it instead of the wallet
+// password, and the wallet starts out locked.
+func (w *Wallet) create(phrase, passphrase string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exists {
+		return "", ErrExists
+	}
+
+	var tip types.ChainIndex
+	var birth uint64
+	var fresh bool
+	generated := phrase == ""
+	if generated {
+		phrase = cwallet.NewSeedPhrase()
+		if w.chain != nil {
+			tip = w.chain.Tip()
+			birth = tip.Height + 1
+		} else {
+			fresh = true
+		}
+	}
+	if err := CheckPhrase(phrase); err != nil {
+		return "", err
+	}
+	seed := new([32]byte)
+	if err := cwallet.SeedFromPhrase(seed, phrase); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSeed, err)
+	}
+
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return "", err
+	}
+	w.seed = seed
+	w.state = persistWallet{
+		Type:            TypeSeed,
+		Tip:             tip,
+		Birth:           birth,
+		Fresh:           fresh,
+		SiacoinElements: make(map[types.SiacoinOutputID]types.SiacoinElement),
+		SiafundElements: make(map[types.SiafundOutputID]types.SiafundElement),
+		Reservations:    make(map[types.Hash256]time.Time),
+	}
+	w.owned = make(map[types.Address]int)
+	w.synced = false
+	addr := w.addAddress()
+	// the state is written first, so that a seed file never exists without
+	// one
+	key := w.password
+	if passphrase != "" {
+		key = passphrase
+	}
+	if err := w.save(); err != nil {
+		w.seed = nil
+		return "", fmt.Errorf("failed to save wallet: %w", err)
+	} else if err := writeSeed(w.seedPath(), phrase, key, passphrase != ""); err != nil {
+		w.seed = nil
+		return "", fmt.Errorf("failed to save seed: %w", err)
+	}
+	w.exists = true
+	if passphrase != "" {
+		w.passphrase = true
+		w.lock()
+	}
+	w.log.Info("created wallet", zap.Stringer("address", addr.Address), zap.Bool("generated", generated))
+	w.trigger()
+	if !generated {
+		return "", nil
+	}
+	return phrase, nil
+}
+
+// createWatch creates a watch-only wallet that tracks addrs. The chain is
+// scanned for their elements from height.
+func (w *Wallet) createWatch(addrs []types.Address, height uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exists {
+		return ErrExists
+	}
+
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return err
+	}
+	w.state = persistWallet{
+		Type:            TypeWatch,
+		Tip:             w.scanStart(height),
+		Birth:           height,
+		SiacoinElements: make(map[types.SiacoinOutputID]types.SiacoinElement),
+		SiafundElements: make(map[types.SiafundOutputID]types.SiafundElement),
+		Reservations:    make(map[types.Hash256]time.Time),
+	}
+	w.owned = make(map[types.Address]int)
+	w.synced = false
+	for _, addr := range addrs {
+		if !w.owns(addr) {
+			w.watchAddress(addr)
+		}
+	}
+	if err := w.save(); err != nil {
+		return fmt.Errorf("failed to save wallet: %w", err)
+	}
+	w.exists = true
+	w.log.Info("created watch-only wallet", zap.Int("addresses", len(w.state.Addresses)), zap.Uint64("height", height))
+	w.trigger()
+	return nil
+}
+
+// Watch adds addrs to a watch-only wallet. If the wallet has already applied
+// blocks from height onward, they are scanned for the addresses' elements in
+// the background; the addresses are tracked once the scan finishes.
+func (w *Wallet) Watch(addrs []types.Address, height uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return ErrNotFound
+	} else if w.state.Type != TypeWatch {
+		return ErrNotWatchOnly
+	} else if w.state.Scan != nil {
+		return ErrScanInProgress
+	}
+
+	var added []types.Address
+	for _, addr := range addrs {
+		if !w.owns(addr) && !slices.Contains(added, addr) {
+			added = append(added, addr)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	birth := w.state.Birth
+	w.state.Birth = min(birth, height)
+	if height > w.state.Tip.Height {
+		for _, addr := range added {
+			w.watchAddress(addr)
+		}
+		if err := w.save(); err != nil {
+			w.state.Birth = birth
+			w.state.Addresses = w.state.Addresses[:len(w.state.Addresses)-len(added)]
+			for _, addr := range added {
+				delete(w.owned, addr)
+			}
+			return fmt.Errorf("failed to save wallet: %w", err)
+		}
+		return nil
+	}
+
+	scanned := make([]Address, len(added))
+	for i, addr := range added {
+		scanned[i] = Address{Address: addr}
+	}
+	if err := w.startScan(height, scanned, false); err != nil {
+		w.state.Birth = birth
+		return err
+	}
+	return nil
+}
+
+// Balance returns the wallet's balance, not counting its multisig addresses,
+// whose elements it cannot spend alone.
+func (w *Wallet) Balance() (Balance, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return Balance{}, ErrNotFound
+	}
+	// claims are valued at the wallet's tip, which may lag the chain's: a
+	// siafund element created after the chain's tip would claim from
+	// revenue the chain has not collected
+	cs, ok := w.chain.State(w.state.Tip.ID)
+
+	b := Balance{Index: w.state.Tip}
+	for _, sce := range w.state.SiacoinElements {
+		if !w.owns(sce.SiacoinOutput.Address) {
+			continue
+		} else if sce.MaturityHeight > w.state.Tip.Height+1 {
+			b.Immature = b.Immature.Add(sce.SiacoinOutput.Value)
+		} else {
+			b.Siacoins = b.Siacoins.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	for _, sfe := range w.state.SiafundElements {
+		if !w.owns(sfe.SiafundOutput.Address) {
+			continue
+		}
+		b.Siafunds += sfe.SiafundOutput.Value
+		if ok {
+			b.Claimable = b.Claimable.Add(claimValue(cs, sfe))
+		}
+	}
+
+	// v1 inputs do not include the value of their parent, so outputs created
+	// in the pool are recorded as well
+	poolOutputs := make(map[types.SiacoinOutputID]types.SiacoinOutput)
+	for _, txn := range w.chain.PoolTransactions() {
+		for i, sco := range txn.SiacoinOutputs {
+			poolOutputs[txn.SiacoinOutputID(i)] = sco
+			if w.owns(sco.Address) {
+				b.UnconfirmedIncoming = b.UnconfirmedIncoming.Add(sco.Value)
+			}
+		}
+	}
+	for _, txn := range w.chain.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			if sce, ok := w.state.SiacoinElements[sci.ParentID]; ok && w.owns(sce.SiacoinOutput.Address) {
+				b.UnconfirmedOutgoing = b.UnconfirmedOutgoing.Add(sce.SiacoinOutput.Value)
+			} else if sco, ok := poolOutputs[sci.ParentID]; ok && w.owns(sco.Address) {
+				b.UnconfirmedOutgoing = b.UnconfirmedOutgoing.Add(sco.Value)
+			}
+		}
+	}
+	for _, txn := range w.chain.V2PoolTransactions() {
+		for _, sco := range txn.SiacoinOutputs {
+			if w.owns(sco.Address) {
+				b.UnconfirmedIncoming = b.UnconfirmedIncoming.Add(sco.Value)
+			}
+		}
+		for _, sci := range txn.SiacoinInputs {
+			if w.owns(sci.Parent.SiacoinOutput.Address) {
+				b.UnconfirmedOutgoing = b.UnconfirmedOutgoing.Add(sci.Parent.SiacoinOutput.Value)
+			}
+		}
+	}
+	return b, nil
+}
+
+// claimValue returns the siacoins that spending sfe would claim as of cs.
+func claimValue(cs consensus.State, sfe types.SiafundElement) types.Currency {
+	return cs.SiafundTaxRevenue.Sub(sfe.ClaimStart).Div64(cs.SiafundCount()).Mul64(sfe.SiafundOutput.Value)
+}
+
+// AddAddresses returns n new addresses: the wallet's lookahead addresses,
+// lowest first, followed by newly derived ones, which it starts watching.
+func (w *Wallet) AddAddresses(n int) ([]Address, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.canSign(); err != nil {
+		return nil, err
+	} else if w.seed == nil {
+		return nil, ErrLocked // addresses are derived from the seed, even with a signer
+	}
+	prev, unscanned := slices.Clone(w.state.Addresses), w.state.Unscanned
+	addrs := make([]Address, 0, n)
+	for i := range w.state.Addresses {
+		if len(addrs) == n {
+			break
+		} else if w.state.Addresses[i].Lookahead {
+			w.state.Addresses[i].Lookahead = false
+			addrs = append(addrs, w.state.Addresses[i])
+		}
+	}
+	var derived []Address
+	for len(addrs) < n {
+		// addresses after ones awaiting a scan are scanned for with them
+		var addr Address
+		if w.state.Unscanned > 0 {
+			addr = w.deriveLookahead()
+			w.state.Addresses[addr.Index].Lookahead, addr.Lookahead = false, false
+		} else {
+			addr = w.addAddress()
+			derived = append(derived, addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	// a full scan replaces the wallet's events once it finishes, so it must
+	// cover the new addresses too
+	sc := w.state.Scan
+	if sc != nil && sc.Full {
+		for _, addr := range derived {
+			sc.State.Addresses = append(sc.State.Addresses, Address{Index: uint64(len(sc.State.Addresses)), Address: addr.Address})
+		}
+	}
+	if err := w.save(); err != nil {
+		for _, addr := range w.state.Addresses[len(prev):] {
+			delete(w.owned, addr.Address)
+		}
+		w.state.Addresses, w.state.Unscanned = prev, unscanned
+		if sc != nil && sc.Full {
+			sc.State.Addresses = sc.State.Addresses[:len(sc.State.Addresses)-len(derived)]
+		}
+		return nil, fmt.Errorf("failed to save wallet: %w", err)
+	}
+	return addrs, nil
+}
+
+// addressInfos returns the AddressInfo of the wallet's address at each of
+// the positions in state.Addresses. It must be called with w.mu held.
+func (w *Wallet) addressInfos(positions []int) []AddressInfo {
+	infos := make([]AddressInfo, len(positions))
+	index := make(map[types.Address]int, len(positions))
+	for i, pos := range positions {
+		addr := w.state.Addresses[pos]
+		infos[i] = AddressInfo{
+			Address:   addr,
+			WatchOnly: w.state.Type == TypeWatch,
+			Label:     w.state.Labels[addr.Address],
+		}
+		index[addr.Address] = i
+	}
+	for _, sce := range w.state.SiacoinElements {
+		if i, ok := index[sce.SiacoinOutput.Address]; ok {
+			infos[i].Siacoins = infos[i].Siacoins.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	for _, sfe := range w.state.SiafundElements {
+		if i, ok := index[sfe.SiafundOutput.Address]; ok {
+			infos[i].Siafunds += sfe.SiafundOutput.Value
+		}
+	}
+	return infos
+}
+
+// position returns the position of addr in state.Addresses, including the
+// lookahead addresses awaiting a scan. It must be called with w.mu held.
+func (w *Wallet) position(addr types.Address) (int, bool) {
+	if i, ok := w.owned[addr]; ok {
+		return i, true
+	}
+	for i := len(w.state.Addresses) - w.state.Unscanned; i < len(w.state.Addresses); i++ {
+		if w.state.Addresses[i].Address == addr {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Addresses returns the derived addresses, ordered by index, along with
+// their labels and confirmed balances.
+func (w *Wallet) Addresses() ([]AddressInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return nil, ErrNotFound
+	}
+	positions := make([]int, len(w.state.Addresses))
+	for i := range positions {
+		positions[i] = i
+	}
+	return w.addressInfos(positions), nil
+}
+
+// Address returns the AddressInfo of addr, or ErrAddressNotFound if it is
+// not one of the wallet's addresses. Multisig addresses, which the wallet
+// cannot spend from alone, are not included.
+func (w *Wallet) Address(addr types.Address) (AddressInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return AddressInfo{}, ErrNotFound
+	}
+	pos, ok := w.position(addr)
+	if !ok {
+		return AddressInfo{}, ErrAddressNotFound
+	}
+	return w.addressInfos([]int{pos})[0], nil
+}
+
+// An AddressCheck reports whether Address is one of the wallet's addresses,
+// and if so, its AddressInfo.
+type AddressCheck struct {
+	Address types.Address `json:"address"`
+	Owned   bool          `json:"owned"`
+	Info    *AddressInfo  `json:"info,omitempty"`
+}
+
+// CheckAddresses reports whether each of addrs is one of the wallet's
+// addresses, like Address, in the same order.
+func (w *Wallet) CheckAddresses(addrs []types.Address) ([]AddressCheck, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return nil, ErrNotFound
+	}
+	var positions []int
+	checks := make([]AddressCheck, len(addrs))
+	for i, addr := range addrs {
+		checks[i].Address = addr
+		if pos, ok := w.position(addr); ok {
+			checks[i].Owned = true
+			positions = append(positions, pos)
+		}
+	}
+	infos := w.addressInfos(positions)
+	for i := range checks {
+		if checks[i].Owned {
+			checks[i].Info, infos = &infos[0], infos[1:]
+		}
+	}
+	return checks, nil
+}
+
+// Status returns the wallet's status.
+func (w *Wallet) Status() (Status, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.exists {
+		return Status{}, ErrNotFound
+	}
+	s := Status{
+		Name:       w.name,
+		Type:       w.state.Type,
+		Tip:        w.state.Tip,
+		Synced:     w.synced,
+		Addresses:  len(w.state.Addresses),
+		Passphrase: w.passphrase,
+		Locked:     w.passphrase && w.seed == nil,
+	}
+	if !w.lockAt.IsZero() {
+		lockAt := w.lockAt
+		s.LockAt = &lockAt
+	}
+	return s, nil
+}
+
+// remove deletes the wallet's seed and state. Any later calls return
+// ErrNotFound.
+func (w *Wallet) remove() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lock()
+	w.exists, w.passphrase, w.seed, w.state = false, false, nil, persistWallet{}
+	w.owned, w.multisig = make(map[types.Address]int), nil
+	w.funded = make(map[fundKey]fundedTransaction)
+	return os.RemoveAll(w.dir)
+}
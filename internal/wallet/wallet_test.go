@@ -0,0 +1,109 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	cwallet "go.sia.tech/coreutils/wallet"
+	"go.uber.org/zap"
+)
+
+// genesisContractPayout is the payout of the file contract in the genesis
+// block of a test chain, whose tax accrues to the genesis siafunds.
+var genesisContractPayout = types.Siacoins(1000)
+
+// newTestChain returns a chain whose genesis siacoins and siafunds are sent
+// to addr. Its genesis block also forms a file contract, so that the
+// siafunds have siacoins to claim.
+func newTestChain(t testing.TB, addr types.Address) (*consensus.Network, types.Block, *chain.Manager) {
+	t.Helper()
+	n, genesis := testutil.V2Network()
+	txn := &genesis.Transactions[0]
+	txn.SiacoinOutputs[0].Address = addr
+	txn.SiafundOutputs[0].Address = addr
+	txn.FileContracts = []types.FileContract{{
+		Payout:      genesisContractPayout,
+		WindowStart: 1e6, // never resolved
+		WindowEnd:   1e6 + 1,
+	}}
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, genesis, chain.NewManager(store, tipState)
+}
+
+// newTestWallet returns a seed wallet that owns the genesis elements of a
+// new test chain, once it has applied the genesis block.
+func newTestWallet(t testing.TB) (*chain.Manager, *Wallet) {
+	t.Helper()
+	return newTestWalletWith(t, func(cm *chain.Manager) ChainManager { return cm })
+}
+
+// newTestWalletWith is like newTestWallet, but the wallet follows the chain
+// returned by wrap.
+func newTestWalletWith(t testing.TB, wrap func(*chain.Manager) ChainManager) (*chain.Manager, *Wallet) {
+	t.Helper()
+	phrase := cwallet.NewSeedPhrase()
+	var seed [32]byte
+	if err := cwallet.SeedFromPhrase(&seed, phrase); err != nil {
+		t.Fatal(err)
+	}
+	_, _, cm := newTestChain(t, deriveAddress(&seed, 0).Address)
+
+	m, err := NewManager(wrap(cm), t.TempDir(), "password", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	if _, err := m.Create("test", phrase, ""); err != nil {
+		t.Fatal(err)
+	}
+	w, err := m.Wallet("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(t, cm, w)
+	return cm, w
+}
+
+// waitSynced waits for w to apply every block up to the chain's tip, and to
+// finish any scan.
+func waitSynced(t testing.TB, cm *chain.Manager, w *Wallet) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		w.mu.Lock()
+		synced := w.state.Tip == cm.Tip() && w.state.Scan == nil
+		w.mu.Unlock()
+		if synced {
+			return
+		}
+	}
+	t.Fatal("wallet did not sync")
+}
+
+// mineBlocks mines n blocks, including the txpool's transactions, and waits
+// for w to apply them.
+func mineBlocks(t testing.TB, cm *chain.Manager, w *Wallet, n int) {
+	t.Helper()
+	testutil.MineBlocks(t, cm, types.VoidAddress, n)
+	waitSynced(t, cm, w)
+}
+
+// broadcast adds txn to the txpool.
+func broadcast(t testing.TB, cm *chain.Manager, txn Transaction) {
+	t.Helper()
+	var err error
+	if txn.V2Transaction != nil {
+		_, err = cm.AddV2PoolTransactions(txn.Basis, []types.V2Transaction{*txn.V2Transaction})
+	} else {
+		_, err = cm.AddPoolTransactions([]types.Transaction{*txn.Transaction})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}